@@ -2,31 +2,103 @@ package main
 
 import (
 	"context"
+	"github.com/prometheus/client_golang/prometheus"
 	"image-processing-system/internal/config"
+	"image-processing-system/internal/middleware"
+	"image-processing-system/internal/service/cancellation"
+	"image-processing-system/internal/service/processor"
+	"image-processing-system/internal/service/storage"
 	"image-processing-system/internal/worker"
+	"image-processing-system/pkg/metrics"
 	"image-processing-system/pkg/rabbitmq"
 	"image-processing-system/pkg/tracing"
 	"log"
+	"net/http"
 )
 
 func main() {
-	// Load configuration
+	// Load configuration; CONFIG_FILE values apply where no env var is set
+	if err := config.LoadConfigFile(); err != nil {
+		log.Fatalf("Failed to load config file: %v", err)
+	}
 	cfg := config.LoadImageFetcherConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	// Register metrics under the service's namespace before anything records them
+	if err := metrics.Register(prometheus.DefaultRegisterer, cfg.Metrics.Namespace, cfg.Metrics.Subsystem); err != nil {
+		log.Fatalf("Failed to register metrics: %v", err)
+	}
 
 	// Initialize tracing
-	tracer := tracing.Init("image-fetcher")
+	tracer := tracing.Init(config.ImageFetcherService)
 	defer tracer.Shutdown(context.Background())
 
+	// Initialize OTLP metrics export if enabled
+	if cfg.Metrics.OTLPEnabled {
+		meterProvider, err := metrics.InitOTLP(config.ImageFetcherService, cfg.Metrics.OTLPEndpoint, cfg.Metrics.OTLPInterval)
+		if err != nil {
+			log.Printf("OTLP metrics export disabled: %v", err)
+		} else {
+			defer meterProvider.Shutdown(context.Background())
+		}
+	}
+
+	// Start metrics server if enabled, with /scaler for external autoscalers,
+	// /config, and /ready and /readyz failing until the worker is created.
+	// /ready then fails while most jobs fail, /readyz while RabbitMQ is lost.
+	readiness := middleware.NewReadiness(config.ImageFetcherService)
+	var imageWorker *worker.ImageWorker
+	var inspector *rabbitmq.Inspector
+	if cfg.Metrics.Enabled {
+		metricsServer := middleware.StartMetricsServer(config.ImageFetcherService, cfg.Metrics, readiness.Gate(func() http.Handler {
+			return imageWorker.ReadyHandler()
+		}), readiness, map[string]http.Handler{
+			"/scaler": readiness.Gate(func() http.Handler { return imageWorker.ScalerHandler(inspector) }),
+			"/config": middleware.ConfigHandler(config.ImageFetcherService, cfg),
+		})
+		defer metricsServer.Close()
+	}
+
 	// Connect to RabbitMQ
-	conn, ch := rabbitmq.Connect()
+	conn, ch := rabbitmq.Connect(cfg.RabbitMQ.URL, cfg.RabbitMQ.DialOptionsFor(config.ImageFetcherService), append(cfg.RabbitMQ.JobQueueSpecs(), cfg.RabbitMQ.ResultQueueSpec())...)
 	defer conn.Close()
 	defer ch.Close()
+	readiness.AddCheck("rabbitmq", rabbitmq.ConnectionCheck(conn, ch))
 
-	// Create and start worker
-	imageWorker, err := worker.NewImageWorker(cfg, ch)
+	// Production dependencies
+	proc := processor.NewImageProcessorWithConfig(cfg.Download)
+	store, err := storage.New(cfg.Storage, cfg.Minio)
 	if err != nil {
-		log.Fatalf("Failed to create image worker: %v", err)
+		log.Fatalf("Failed to create storage: %v", err)
 	}
+	// minio:// sources are read with the storage backend's credentials
+	if objects, ok := store.(processor.ObjectReader); ok {
+		proc.ReadObjectsFrom(objects)
+	}
+	cancelStore, err := cancellation.NewStore(cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to create cancellation store: %v", err)
+	}
+	var cancellations worker.CancellationChecker = cancelStore
+	if cfg.Worker.CancelCacheTTL > 0 {
+		cancellations = cancellation.NewCache(cancelStore, cfg.Worker.CancelCacheTTL)
+	}
+
+	// Export job queue and DLQ depths so dead-lettered jobs can be alerted on
+	inspector = rabbitmq.NewInspector(conn)
+	if cfg.Worker.QueueDepthInterval > 0 {
+		var queues []string
+		for _, q := range cfg.RabbitMQ.JobQueueNames() {
+			queues = append(queues, q, rabbitmq.DeadLetterQueue(q))
+		}
+		go worker.MonitorQueueDepths(context.Background(), inspector, queues, cfg.Worker.QueueDepthInterval)
+	}
+
+	// Create and start worker
+	imageWorker = worker.NewImageWorker(cfg, ch, proc, proc, store, cancellations)
+	readiness.Started()
 
 	log.Println("image-fetcher service starting...")
 	imageWorker.Start()
@@ -2,44 +2,64 @@ package main
 
 import (
 	"context"
+	"github.com/prometheus/client_golang/prometheus"
 	"image-processing-system/internal/config"
+	"image-processing-system/internal/handler"
+	"image-processing-system/internal/middleware"
 	"image-processing-system/internal/service/metadata"
+	"image-processing-system/internal/service/storage"
+	"image-processing-system/pkg/metrics"
 	"image-processing-system/pkg/rabbitmq"
 	"image-processing-system/pkg/tracing"
 	"log"
 	"net/http"
-
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"os"
 )
 
 func main() {
-	// Load configuration
+	// Load configuration; CONFIG_FILE values apply where no env var is set
+	if err := config.LoadConfigFile(); err != nil {
+		log.Fatalf("Failed to load config file: %v", err)
+	}
 	cfg := config.LoadImageMetadataConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	// One-off admin command: image-metadata replay-dlq [flags]
+	if len(os.Args) > 1 && os.Args[1] == "replay-dlq" {
+		replayDLQ(cfg, os.Args[2:])
+		return
+	}
+
+	// Register metrics under the service's namespace before anything records them
+	if err := metrics.Register(prometheus.DefaultRegisterer, cfg.Metrics.Namespace, cfg.Metrics.Subsystem); err != nil {
+		log.Fatalf("Failed to register metrics: %v", err)
+	}
 
 	// Initialize tracing
-	tracer := tracing.Init("image-metadata")
+	tracer := tracing.Init(config.ImageMetadataService)
 	defer tracer.Shutdown(context.Background())
 
-	// Start metrics server if enabled
+	// Initialize OTLP metrics export if enabled
+	if cfg.Metrics.OTLPEnabled {
+		meterProvider, err := metrics.InitOTLP(config.ImageMetadataService, cfg.Metrics.OTLPEndpoint, cfg.Metrics.OTLPInterval)
+		if err != nil {
+			log.Printf("OTLP metrics export disabled: %v", err)
+		} else {
+			defer meterProvider.Shutdown(context.Background())
+		}
+	}
+
+	// Start metrics server if enabled, with the loaded config on /config and
+	// /readyz failing until the database and RabbitMQ are connected, then
+	// while either is lost
+	readiness := middleware.NewReadiness(config.ImageMetadataService)
 	if cfg.Metrics.Enabled {
-		go func() {
-			mux := http.NewServeMux()
-			mux.Handle(cfg.Metrics.Path, promhttp.Handler())
-			mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-				w.Header().Set("Content-Type", "application/json")
-				w.Write([]byte(`{"status":"healthy","service":"image-metadata"}`))
-			})
-
-			metricsServer := &http.Server{
-				Addr:    ":" + cfg.Metrics.Port,
-				Handler: mux,
-			}
-
-			log.Printf("Metrics server listening on :%s", cfg.Metrics.Port)
-			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Printf("Metrics server error: %v", err)
-			}
-		}()
+		metricsServer := middleware.StartMetricsServer(config.ImageMetadataService, cfg.Metrics, nil, readiness, map[string]http.Handler{
+			"/config": middleware.ConfigHandler(config.ImageMetadataService, cfg),
+		})
+		defer metricsServer.Close()
 	}
 
 	// Create metadata service
@@ -47,15 +67,49 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create metadata service: %v", err)
 	}
+	readiness.AddCheck("database", metadataSvc.Ping)
 
 	// Connect to RabbitMQ
-	conn, ch := rabbitmq.Connect()
+	// The job queues are declared too so reprocessing can publish to them
+	conn, ch := rabbitmq.Connect(cfg.RabbitMQ.URL, cfg.RabbitMQ.DialOptionsFor(config.ImageMetadataService), append([]rabbitmq.Queue{cfg.RabbitMQ.ResultQueueSpec()}, cfg.RabbitMQ.JobQueueSpecs()...)...)
 	defer conn.Close()
 	defer ch.Close()
+	readiness.AddCheck("rabbitmq", rabbitmq.ConnectionCheck(conn, ch))
+
+	// Serve the read API alongside the consumer
+	routerOpts := []handler.MetadataRouterOption{
+		handler.WithReprocessing(metadataSvc, ch, cfg.RabbitMQ, cfg.ReprocessMaxJobs, cfg.Admin),
+		handler.WithOwners(cfg.Owners, cfg.Admin),
+	}
+	// Stored outputs are served inline once clients or owners are
+	// configured; without MinIO the endpoint reports 503 rather than keeping
+	// the service down
+	if len(cfg.Content.APIKeys) > 0 || len(cfg.Owners) > 0 {
+		var objects handler.ObjectOpener
+		if minioSvc, err := storage.NewMinioService(cfg.Minio); err != nil {
+			log.Printf("Image content unavailable: %v", err)
+		} else {
+			objects = minioSvc
+		}
+		routerOpts = append(routerOpts, handler.WithImageContent(metadataSvc, objects, cfg.Content))
+	}
+	router := handler.NewMetadataRouter(metadataSvc, routerOpts...)
+	srv := &http.Server{
+		Addr:    ":" + cfg.Server.Port,
+		Handler: middleware.RequestIDMiddleware(middleware.LoggingMiddleware(router)),
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("API server failed: %v", err)
+		}
+	}()
+	defer srv.Close()
+	log.Printf("image-metadata API listening on :%s (GET /images, GET /images/{id}/content, POST /jobs/status, POST /reprocess, GET /health)", cfg.Server.Port)
 
+	readiness.Started()
 	log.Println("image-metadata service consuming processed image queue")
 	if cfg.Metrics.Enabled {
 		log.Printf("Metrics server available on :%s%s", cfg.Metrics.Port, cfg.Metrics.Path)
 	}
-	metadataSvc.ConsumeAndStore(ch)
+	metadataSvc.ConsumeAndStore(ch, cfg.RabbitMQ.ResultQueue, cfg.RabbitMQ.ConsumerTagFor(config.ImageMetadataService), cfg.Store)
 }
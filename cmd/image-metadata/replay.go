@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"image-processing-system/internal/config"
+	"image-processing-system/pkg/rabbitmq"
+	"log"
+)
+
+// replayDLQ republishes dead-lettered messages to their main queue once the
+// cause of the failures has been fixed
+func replayDLQ(cfg *config.ImageMetadataConfig, args []string) {
+	fs := flag.NewFlagSet("replay-dlq", flag.ExitOnError)
+	queue := fs.String("queue", cfg.RabbitMQ.ResultQueue, "queue whose dead-letter queue is replayed")
+	maxReplays := fs.Int("max-replays", cfg.RabbitMQ.MaxReplays, "leave messages replayed this many times in the DLQ (0 = no limit)")
+	fs.Parse(args)
+
+	conn, ch := rabbitmq.Connect(cfg.RabbitMQ.URL, cfg.RabbitMQ.DialOptionsFor(config.ImageMetadataService+"-replay"), cfg.RabbitMQ.ResultQueueSpec())
+	defer conn.Close()
+	defer ch.Close()
+
+	result, err := rabbitmq.ReplayDeadLetters(ch, *queue, *maxReplays)
+	log.Printf("Replayed %d messages from %s (%d over the replay limit left in place)",
+		result.Replayed, rabbitmq.DeadLetterQueue(*queue), result.Skipped)
+	if err != nil {
+		log.Fatalf("DLQ replay failed: %v", err)
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"image-processing-system/internal/config"
 	"image-processing-system/internal/handler"
 	"image-processing-system/internal/middleware"
+	"image-processing-system/internal/service/cancellation"
+	"image-processing-system/internal/service/quota"
+	"image-processing-system/pkg/metrics"
 	"image-processing-system/pkg/rabbitmq"
 	"image-processing-system/pkg/tracing"
 	"log"
 	"net/http"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -24,48 +27,96 @@ func (a *AMQPChannelAdapter) IsClosed() bool {
 }
 
 func main() {
-	// Load configuration
+	// Load configuration; CONFIG_FILE values apply where no env var is set
+	if err := config.LoadConfigFile(); err != nil {
+		log.Fatalf("Failed to load config file: %v", err)
+	}
 	cfg := config.LoadURLIngestorConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	// Register metrics under the service's namespace before anything records them
+	if err := metrics.Register(prometheus.DefaultRegisterer, cfg.Metrics.Namespace, cfg.Metrics.Subsystem); err != nil {
+		log.Fatalf("Failed to register metrics: %v", err)
+	}
 
 	// Initialize tracing
-	tracer := tracing.Init("url-ingestor")
+	tracer := tracing.Init(config.URLIngestorService)
 	defer tracer.Shutdown(context.Background())
 
+	// Initialize OTLP metrics export if enabled
+	if cfg.Metrics.OTLPEnabled {
+		meterProvider, err := metrics.InitOTLP(config.URLIngestorService, cfg.Metrics.OTLPEndpoint, cfg.Metrics.OTLPInterval)
+		if err != nil {
+			log.Printf("OTLP metrics export disabled: %v", err)
+		} else {
+			defer meterProvider.Shutdown(context.Background())
+		}
+	}
+
+	// Start metrics server if enabled, with the loaded config on /config and
+	// /readyz failing until the ingestor is serving, then while RabbitMQ is lost
+	readiness := middleware.NewReadiness(config.URLIngestorService)
+	if cfg.Metrics.Enabled {
+		metricsServer := middleware.StartMetricsServer(config.URLIngestorService, cfg.Metrics, nil, readiness, map[string]http.Handler{
+			"/config": middleware.ConfigHandler(config.URLIngestorService, cfg),
+		})
+		defer metricsServer.Close()
+	}
+
 	// Connect to RabbitMQ
-	conn, ch := rabbitmq.Connect()
+	conn, ch := rabbitmq.Connect(cfg.RabbitMQ.URL, cfg.RabbitMQ.DialOptionsFor(config.URLIngestorService), cfg.RabbitMQ.JobQueueSpecs()...)
 	defer conn.Close()
 	defer ch.Close()
+	readiness.AddCheck("rabbitmq", rabbitmq.ConnectionCheck(conn, ch))
 
 	// Create adapter for the channel
 	channelAdapter := &AMQPChannelAdapter{Channel: ch}
 
-	// Start metrics server if enabled
-	if cfg.Metrics.Enabled {
-		go func() {
-			mux := http.NewServeMux()
-			mux.Handle(cfg.Metrics.Path, promhttp.Handler())
-			mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-				w.Header().Set("Content-Type", "application/json")
-				w.Write([]byte(`{"status":"healthy","service":"url-ingestor"}`))
-			})
-
-			metricsServer := &http.Server{
-				Addr:    ":" + cfg.Metrics.Port,
-				Handler: mux,
-			}
-
-			log.Printf("Metrics server listening on :%s", cfg.Metrics.Port)
-			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Printf("Metrics server error: %v", err)
-			}
-		}()
+	// Job cancellation needs the database; the ingestor still serves submissions without it
+	var routerOpts []handler.RouterOption
+	cancels, err := cancellation.NewStore(cfg.Database)
+	if err != nil {
+		log.Printf("Job cancellation disabled: %v", err)
+	} else {
+		routerOpts = append(routerOpts, handler.WithCancelStore(cancels))
+	}
+
+	// Quotas read the usage image-metadata records; without the database
+	// submissions go through unchecked
+	if len(cfg.Submit.APIKeys) > 0 {
+		quotas, err := quota.NewStore(cfg.Database)
+		if err != nil {
+			log.Printf("Storage quotas disabled: %v", err)
+		} else {
+			routerOpts = append(routerOpts, handler.WithQuotas(quotas))
+		}
+	}
+
+	// Backpressure reads the job queue depth on its own channel
+	routerOpts = append(routerOpts, handler.WithBackpressure(rabbitmq.NewInspector(conn)))
+
+	// Operators can purge the job queues through the publishing channel
+	routerOpts = append(routerOpts, handler.WithQueueAdmin(channelAdapter))
+
+	// Synchronous submissions receive results on a private reply queue
+	replyCh, err := conn.Channel()
+	if err != nil {
+		log.Printf("Synchronous submission disabled: %v", err)
+	} else if replies, err := rabbitmq.ConsumeReplies(replyCh, cfg.RabbitMQ.ConsumerTagFor(config.URLIngestorService)); err != nil {
+		log.Printf("Synchronous submission disabled: %v", err)
+	} else {
+		defer replyCh.Close()
+		routerOpts = append(routerOpts, handler.WithSyncWait(replies))
 	}
 
 	// Create router with middleware
-	router := handler.NewRouter(channelAdapter)
+	router := handler.NewRouter(channelAdapter, cfg, routerOpts...)
 
 	// Add middleware - ensure metrics endpoint is accessible
 	handler := middleware.LoggingMiddleware(router)
+	handler = middleware.RequestIDMiddleware(handler)
 	handler = middleware.CORSMiddleware(handler)
 
 	// Create server
@@ -76,7 +127,8 @@ func main() {
 
 	log.Printf("url-ingestor listening on :%s", cfg.Server.Port)
 	log.Printf("Available endpoints:")
-	log.Printf("  - POST /submit (submit images)")
+	log.Printf("  - POST /submit (submit images; ?wait=true for one image synchronously)")
+	log.Printf("  - POST /jobs/{traceID}/cancel (cancel pending jobs)")
 	log.Printf("  - GET /health (health check)")
 	log.Printf("  - GET /status (service status)")
 	log.Printf("  - GET /queue/status (queue status)")
@@ -87,5 +139,6 @@ func main() {
 		log.Printf("Metrics server available on :%s%s", cfg.Metrics.Port, cfg.Metrics.Path)
 	}
 
+	readiness.Started()
 	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"image-processing-system/internal/models"
+)
+
+// defaultAutoRules gives large images a thumbnail and a medium size and
+// stores smaller ones as they are
+const defaultAutoRules = "1200=thumbnail:200x0|medium:800x0,0=original"
+
+// autoPresetName restricts preset names to characters safe for object keys,
+// like presets given with a submission
+var autoPresetName = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// AutoRule picks the derivatives the "auto" processing type produces for
+// images whose longest side is at least MinSide pixels
+type AutoRule struct {
+	MinSide int
+	// Presets are the resize outputs; none means the image is stored as-is
+	Presets []models.ResizePreset
+}
+
+// ParseAutoRules parses rules of the form "1200=thumbnail:200x0|medium:800x0,
+// 0=original": a minimum longest side, then "|"-separated name:WxH resize
+// presets or "original". The result is ordered by descending MinSide, so the
+// first rule an image reaches applies.
+func ParseAutoRules(spec string) ([]AutoRule, error) {
+	var rules []AutoRule
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		side, outputs, ok := strings.Cut(entry, "=")
+		minSide, err := strconv.Atoi(strings.TrimSpace(side))
+		if !ok || err != nil || minSide < 0 {
+			return nil, fmt.Errorf("rule %q must start with a minimum side in pixels", entry)
+		}
+
+		rule := AutoRule{MinSide: minSide}
+		for _, out := range strings.Split(outputs, "|") {
+			out = strings.ToLower(strings.TrimSpace(out))
+			if out == "original" {
+				continue
+			}
+			preset, err := parseAutoPreset(out)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", entry, err)
+			}
+			rule.Presets = append(rule.Presets, preset)
+		}
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].MinSide > rules[j].MinSide })
+	return rules, nil
+}
+
+// parseAutoPreset parses a "name:WxH" resize preset
+func parseAutoPreset(s string) (models.ResizePreset, error) {
+	name, size, ok := strings.Cut(s, ":")
+	w, h, okSize := strings.Cut(size, "x")
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if !ok || !autoPresetName.MatchString(name) || !okSize || errW != nil || errH != nil || width < 0 || height < 0 || width+height == 0 {
+		return models.ResizePreset{}, fmt.Errorf("output %q must be original or name:WxH", s)
+	}
+	return models.ResizePreset{Name: name, Width: width, Height: height}, nil
+}
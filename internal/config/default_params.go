@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"image-processing-system/internal/models"
+)
+
+// defaultParamKeys lists the params each processing type may be given a
+// server-side default for. Types whose params submissions must give, such
+// as convert, have none.
+var defaultParamKeys = map[string][]string{
+	"resize":  {"w", "h"},
+	"blur":    {"sigma"},
+	"sharpen": {"sigma"},
+}
+
+// ParseDefaultParams parses server-side default params of the form
+// "resize=w:256|h:256,blur=sigma:3": a processing type, then "|"-separated
+// key:value params using the names submissions use.
+func ParseDefaultParams(spec string) (map[string]models.ProcessingParams, error) {
+	defaults := make(map[string]models.ProcessingParams)
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, values, ok := strings.Cut(entry, "=")
+		processingType := models.NormalizeProcessingType(name)
+		keys, known := defaultParamKeys[processingType]
+		if !ok || !known {
+			return nil, fmt.Errorf("entry %q must start with resize, blur or sharpen", entry)
+		}
+		if _, dup := defaults[processingType]; dup {
+			return nil, fmt.Errorf("%s is given twice", processingType)
+		}
+
+		var p models.ProcessingParams
+		for _, kv := range strings.Split(values, "|") {
+			key, value, _ := strings.Cut(kv, ":")
+			key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+			if !slices.Contains(keys, key) {
+				return nil, fmt.Errorf("%s takes only %s, got %q", processingType, strings.Join(keys, " and "), kv)
+			}
+			if err := setDefaultParam(&p, key, value); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", processingType, key, err)
+			}
+		}
+		if processingType == "resize" && p.Width == 0 && p.Height == 0 {
+			return nil, fmt.Errorf("resize needs a positive w or h")
+		}
+		defaults[processingType] = p
+	}
+	return defaults, nil
+}
+
+// setDefaultParam sets one param parsed from a default params spec
+func setDefaultParam(p *models.ProcessingParams, key, value string) error {
+	switch key {
+	case "w", "h":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("must be a positive integer, got %q", value)
+		}
+		if key == "w" {
+			p.Width = n
+		} else {
+			p.Height = n
+		}
+	case "sigma":
+		sigma, err := strconv.ParseFloat(value, 64)
+		if err != nil || sigma <= 0 {
+			return fmt.Errorf("must be a positive number, got %q", value)
+		}
+		p.Sigma = sigma
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileValues holds settings read from CONFIG_FILE, keyed by environment
+// variable name. Environment variables take precedence over these.
+var fileValues map[string]string
+
+// LoadConfigFile reads the YAML or JSON file named by CONFIG_FILE, if set.
+// The file maps the same setting names as the environment variables to their
+// values, e.g. "WORKER_JOB_TIMEOUT: 2m". Call it before the Load*Config
+// functions.
+func LoadConfigFile() error {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return nil
+	}
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	fileValues = values
+	return nil
+}
+
+// readConfigFile parses a config file into flat setting values. JSON is
+// valid YAML, so one parser handles both.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		s, err := flattenValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+		values[strings.ToUpper(key)] = s
+	}
+	return values, nil
+}
+
+// flattenValue renders a file value the way it would be written in an
+// environment variable: lists become "a,b" and maps become "a=1,b=2"
+func flattenValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := scalarValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for k, item := range v {
+			s, err := scalarValue(item)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, k+"="+s)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return scalarValue(v)
+	}
+}
+
+// scalarValue renders a single file value, rejecting nested structures
+func scalarValue(value interface{}) (string, error) {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return "", fmt.Errorf("nested values are not supported")
+	}
+	return fmt.Sprint(value), nil
+}
+
+// lookup returns the environment variable if set, otherwise the value from
+// the config file
+func lookup(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fileValues[key]
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+MINIO_BUCKET: from-file
+WORKER_JOB_TIMEOUT: 45s
+DOWNLOAD_ALLOWED_FORMATS: [jpeg, png]
+MINIO_QUALITY_BY_TYPE:
+  resize: 60
+DB_HOST: file-db
+`,
+		"config.json": `{
+  "MINIO_BUCKET": "from-file",
+  "WORKER_JOB_TIMEOUT": "45s",
+  "DOWNLOAD_ALLOWED_FORMATS": ["jpeg", "png"],
+  "MINIO_QUALITY_BY_TYPE": {"resize": 60},
+  "DB_HOST": "file-db"
+}`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			t.Cleanup(func() { fileValues = nil })
+			t.Setenv("CONFIG_FILE", writeConfigFile(t, name, content))
+			t.Setenv("DB_HOST", "env-db")
+
+			if err := LoadConfigFile(); err != nil {
+				t.Fatal(err)
+			}
+			cfg := LoadImageFetcherConfig()
+
+			if cfg.Minio.Bucket != "from-file" {
+				t.Errorf("bucket = %q, want from-file", cfg.Minio.Bucket)
+			}
+			if cfg.Worker.JobTimeout != 45*time.Second {
+				t.Errorf("job timeout = %s, want 45s", cfg.Worker.JobTimeout)
+			}
+			if len(cfg.Download.AllowedFormats) != 2 || cfg.Download.AllowedFormats[1] != "png" {
+				t.Errorf("allowed formats = %v, want [jpeg png]", cfg.Download.AllowedFormats)
+			}
+			if cfg.Minio.QualityByType["resize"] != 60 {
+				t.Errorf("quality by type = %v, want resize=60", cfg.Minio.QualityByType)
+			}
+			if cfg.Database.Host != "env-db" {
+				t.Errorf("expected the env var to override the file, got %q", cfg.Database.Host)
+			}
+			if cfg.Minio.Endpoint != "minio:9000" {
+				t.Errorf("expected unset settings to keep their defaults, got %q", cfg.Minio.Endpoint)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	t.Cleanup(func() { fileValues = nil })
+
+	t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	if err := LoadConfigFile(); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "bad.yaml", "MINIO_BUCKET: [unclosed"))
+	if err := LoadConfigFile(); err == nil {
+		t.Error("expected an error for an unparsable file")
+	}
+
+	t.Setenv("CONFIG_FILE", "")
+	if err := LoadConfigFile(); err != nil || fileValues != nil {
+		t.Errorf("expected no file to leave config env-only, got err=%v values=%v", err, fileValues)
+	}
+}
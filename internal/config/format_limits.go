@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FormatLimit bounds source images of one format before they are fully
+// decoded. A zero field leaves that bound to the general download limits.
+type FormatLimit struct {
+	Format string
+	// MaxSide is the longest width or height allowed, in pixels
+	MaxSide int
+	// MaxBytes is the largest encoded size allowed
+	MaxBytes int64
+}
+
+// ParseFormatLimits parses limits of the form "gif=4096:10485760,tiff=8192:0":
+// a decoder format name, the longest side in pixels and the encoded size in
+// bytes
+func ParseFormatLimits(spec string) ([]FormatLimit, error) {
+	var limits []FormatLimit
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		format, bounds, ok := strings.Cut(entry, "=")
+		side, size, okBounds := strings.Cut(bounds, ":")
+		maxSide, errSide := strconv.Atoi(strings.TrimSpace(side))
+		maxBytes, errBytes := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+		format = strings.ToLower(strings.TrimSpace(format))
+		if !ok || format == "" || !okBounds || errSide != nil || errBytes != nil || maxSide < 0 || maxBytes < 0 {
+			return nil, fmt.Errorf("limit %q must be format=max_side:max_bytes", entry)
+		}
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		limits = append(limits, FormatLimit{Format: format, MaxSide: maxSide, MaxBytes: maxBytes})
+	}
+	return limits, nil
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"image-processing-system/pkg/rabbitmq"
+)
+
+// JobLane is one of the queues jobs are split across. A job goes to the first
+// lane whose MinPriority its priority reaches.
+type JobLane struct {
+	Queue       string
+	MinPriority int
+	// Weight is the lane's share of deliveries while several lanes have jobs
+	// waiting, so busy low-priority lanes still make progress
+	Weight int
+	// Draining marks the job queue used before lanes were configured. It is
+	// still consumed so jobs published to it before the upgrade aren't
+	// stranded, but no new jobs are routed to it.
+	Draining bool
+}
+
+// ParseJobLanes parses lanes of the form "image.urls.fast:5:3,
+// image.urls.bulk:0:1": a queue name, the lowest job priority it takes and its
+// weight. The result is ordered by descending MinPriority; an empty spec
+// yields no lanes.
+func ParseJobLanes(spec string) ([]JobLane, error) {
+	var lanes []JobLane
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("lane %q must be queue:min_priority:weight", entry)
+		}
+		minPriority, errP := strconv.Atoi(parts[1])
+		weight, errW := strconv.Atoi(parts[2])
+		if errP != nil || minPriority < 0 {
+			return nil, fmt.Errorf("lane %q: min priority must be a non-negative integer", entry)
+		}
+		if errW != nil || weight < 1 {
+			return nil, fmt.Errorf("lane %q: weight must be a positive integer", entry)
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("queue %s is listed twice", parts[0])
+		}
+		seen[parts[0]] = true
+		lanes = append(lanes, JobLane{Queue: parts[0], MinPriority: minPriority, Weight: weight})
+	}
+
+	sort.SliceStable(lanes, func(i, j int) bool { return lanes[i].MinPriority > lanes[j].MinPriority })
+	if len(lanes) > 0 && lanes[len(lanes)-1].MinPriority != 0 {
+		return nil, fmt.Errorf("one lane must take priority 0 jobs")
+	}
+	return lanes, nil
+}
+
+// JobLanes returns the configured lanes, or a single lane for JobQueue when
+// none are set. When JobQueue isn't one of the configured lanes it follows
+// them as a draining lane.
+func (c RabbitMQConfig) JobLanes() []JobLane {
+	lanes, err := ParseJobLanes(c.Lanes)
+	if err != nil || len(lanes) == 0 {
+		return []JobLane{{Queue: c.JobQueue, Weight: 1}}
+	}
+	if c.JobQueue != "" && !slices.ContainsFunc(lanes, func(l JobLane) bool { return l.Queue == c.JobQueue }) {
+		lanes = append(lanes, JobLane{Queue: c.JobQueue, Weight: 1, Draining: true})
+	}
+	return lanes
+}
+
+// QueueForPriority returns the job queue a job with this priority goes to.
+// Draining lanes are never chosen.
+func (c RabbitMQConfig) QueueForPriority(priority int) string {
+	var last string
+	for _, lane := range c.JobLanes() {
+		if lane.Draining {
+			continue
+		}
+		if priority >= lane.MinPriority {
+			return lane.Queue
+		}
+		last = lane.Queue
+	}
+	return last
+}
+
+// JobQueueNames returns the queue of every lane
+func (c RabbitMQConfig) JobQueueNames() []string {
+	var names []string
+	for _, lane := range c.JobLanes() {
+		names = append(names, lane.Queue)
+	}
+	return names
+}
+
+// JobQueueSpecs describes every lane's queue for declaration
+func (c RabbitMQConfig) JobQueueSpecs() []rabbitmq.Queue {
+	var specs []rabbitmq.Queue
+	for _, name := range c.JobQueueNames() {
+		specs = append(specs, rabbitmq.Queue{Name: name, MaxPriority: uint8(c.MaxPriority), Delayed: true})
+	}
+	return specs
+}
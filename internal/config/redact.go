@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// redactedValue replaces secrets in Redacted's output
+const redactedValue = "[redacted]"
+
+// Redacted returns cfg, a service config struct, as JSON-ready maps with
+// secrets hidden, for the /config endpoint. Fields tagged `secret:"value"`
+// are replaced when set, `secret:"url"` keeps the URL but shows its password
+// as "xxxxx", and on maps `secret:"values"` hides the values. Durations are rendered as strings such as "1m30s".
+func Redacted(cfg interface{}) interface{} {
+	return redact(reflect.ValueOf(cfg), "")
+}
+
+// redact renders v, treating it as a secret of the given kind
+func redact(v reflect.Value, secret string) interface{} {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		out := make(map[string]interface{})
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			value := redact(v.Field(i), f.Tag.Get("secret"))
+			// Embedded structs contribute their fields directly
+			if inner, ok := value.(map[string]interface{}); ok && f.Anonymous {
+				for k, fv := range inner {
+					out[k] = fv
+				}
+				continue
+			}
+			out[f.Name] = value
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]interface{}, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			var value interface{} = redactedValue
+			if secret != "values" {
+				value = redact(iter.Value(), "")
+			}
+			out[fmt.Sprint(iter.Key().Interface())] = value
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = redact(v.Index(i), "")
+		}
+		return out
+	case reflect.String:
+		s := v.String()
+		switch {
+		case s == "" || secret == "":
+			return s
+		case secret == "url":
+			u, err := url.Parse(s)
+			if err != nil {
+				return redactedValue
+			}
+			return u.Redacted()
+		default:
+			return redactedValue
+		}
+	}
+	return v.Interface()
+}
@@ -0,0 +1,380 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"image-processing-system/pkg/hostpolicy"
+)
+
+// Multipart part size limits enforced by minio-go
+const (
+	minioMinPartSize = 5 << 20
+	minioMaxPartSize = 5 << 30
+)
+
+// validator collects every invalid setting so they can be reported together
+type validator struct {
+	errs []error
+}
+
+// require records an error when a required setting is empty
+func (v *validator) require(name, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.errs = append(v.errs, fmt.Errorf("%s is required", name))
+	}
+}
+
+// check records an error when a condition on a setting doesn't hold
+func (v *validator) check(ok bool, format string, args ...interface{}) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf(format, args...))
+	}
+}
+
+// port records an error unless value is a valid TCP port
+func (v *validator) port(name, value string) {
+	if value == "" {
+		v.require(name, value)
+		return
+	}
+	p, err := strconv.Atoi(value)
+	v.check(err == nil && p > 0 && p <= 65535, "%s must be a port between 1 and 65535, got %q", name, value)
+}
+
+// add merges the errors of a nested Validate call
+func (v *validator) add(err error) {
+	if err != nil {
+		v.errs = append(v.errs, err)
+	}
+}
+
+// err returns the collected errors, one per line, or nil
+func (v *validator) err() error {
+	return errors.Join(v.errs...)
+}
+
+// Validate checks the server settings
+func (c ServerConfig) Validate() error {
+	var v validator
+	v.port("SERVER_PORT", c.Port)
+	return v.err()
+}
+
+// Validate checks that the database connection settings are present
+func (c DatabaseConfig) Validate() error {
+	var v validator
+	v.require("DB_HOST", c.Host)
+	v.port("DB_PORT", c.Port)
+	v.require("DB_USER", c.User)
+	v.require("DB_PASSWORD", c.Password)
+	v.require("DB_NAME", c.DBName)
+	return v.err()
+}
+
+// Validate checks the MinIO credentials, retry and encoding settings
+func (c MinioConfig) Validate() error {
+	var v validator
+	v.require("MINIO_ENDPOINT", c.Endpoint)
+	v.require("MINIO_ACCESS_KEY", c.AccessKey)
+	v.require("MINIO_SECRET_KEY", c.SecretKey)
+	v.require("MINIO_BUCKET", c.Bucket)
+	v.check(c.StartupTimeout > 0, "MINIO_STARTUP_TIMEOUT must be positive, got %s", c.StartupTimeout)
+	v.check(c.UploadMaxRetries >= 0, "MINIO_UPLOAD_MAX_RETRIES must not be negative, got %d", c.UploadMaxRetries)
+	v.check(c.UploadRetryBackoff >= 0, "MINIO_UPLOAD_RETRY_BACKOFF must not be negative, got %s", c.UploadRetryBackoff)
+	v.check(c.UploadPartSize == 0 || (c.UploadPartSize >= minioMinPartSize && c.UploadPartSize <= minioMaxPartSize),
+		"MINIO_UPLOAD_PART_SIZE must be 0 or between %d (5 MiB) and %d (5 GiB) bytes, got %d", minioMinPartSize, minioMaxPartSize, c.UploadPartSize)
+	v.check(validFilenameTemplate(c.DownloadFilename),
+		"MINIO_DOWNLOAD_FILENAME may only use the {name}, {type}, {variant} and {ext} placeholders and no path separators, got %q", c.DownloadFilename)
+	v.check(validKeyPrefix(c.KeyPrefix),
+		"MINIO_KEY_PREFIX may only use the {yyyy}, {mm}, {dd} and {hh} placeholders and relative path segments, got %q", c.KeyPrefix)
+	v.add(c.EncodingConfig.Validate())
+	return v.err()
+}
+
+// validKeyPrefix reports whether an object key prefix template only uses
+// known placeholders and expands to relative, non-empty path segments
+func validKeyPrefix(template string) bool {
+	rest := strings.NewReplacer("{yyyy}", "0", "{mm}", "0", "{dd}", "0", "{hh}", "0").Replace(template)
+	if strings.ContainsAny(rest, "{}\\\"") {
+		return false
+	}
+	segments := strings.Split(rest, "/")
+	for i, segment := range segments {
+		// Only the segment after a trailing slash may be empty
+		if (segment == "" && i != len(segments)-1) || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// validFilenameTemplate reports whether a download filename template only
+// uses known placeholders and names a single file
+func validFilenameTemplate(template string) bool {
+	rest := strings.NewReplacer("{name}", "", "{type}", "", "{variant}", "", "{ext}", "").Replace(template)
+	return !strings.ContainsAny(rest, "{}/\\\"")
+}
+
+// bucketNamePattern matches S3 bucket names: 3-63 lowercase letters, digits,
+// dots and hyphens, starting and ending with a letter or digit
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// validBucketName reports whether name is usable as an S3 bucket
+func validBucketName(name string) bool {
+	return bucketNamePattern.MatchString(name) && !strings.Contains(name, "..")
+}
+
+// Validate checks that encoding qualities are in range
+func (c EncodingConfig) Validate() error {
+	var v validator
+	v.check(c.Quality >= 1 && c.Quality <= 100, "MINIO_JPEG_QUALITY must be between 1 and 100, got %d", c.Quality)
+	for processingType, quality := range c.QualityByType {
+		v.check(quality >= 1 && quality <= 100, "MINIO_QUALITY_BY_TYPE %s must be between 1 and 100, got %d", processingType, quality)
+	}
+	return v.err()
+}
+
+// Validate checks the storage backend selection
+func (c StorageConfig) Validate() error {
+	var v validator
+	switch c.Backend {
+	case "", "minio":
+	case "fs":
+		v.require("STORAGE_FS_ROOT", c.FSRoot)
+	default:
+		v.check(false, "STORAGE_BACKEND must be minio or fs, got %q", c.Backend)
+	}
+	return v.err()
+}
+
+// Validate checks the broker URL, queue names and limits
+func (c RabbitMQConfig) Validate() error {
+	var v validator
+	v.require("RABBITMQ_URL", c.URL)
+	if c.URL != "" {
+		v.check(strings.HasPrefix(c.URL, "amqp://") || strings.HasPrefix(c.URL, "amqps://"),
+			"RABBITMQ_URL must start with amqp:// or amqps://")
+	}
+	v.require("RABBITMQ_JOB_QUEUE", c.JobQueue)
+	if _, err := ParseJobLanes(c.Lanes); err != nil {
+		v.check(false, "RABBITMQ_JOB_LANES is invalid: %v", err)
+	}
+	v.require("RABBITMQ_RESULT_QUEUE", c.ResultQueue)
+	v.check(c.MaxPriority >= 1 && c.MaxPriority <= 255,
+		"RABBITMQ_MAX_PRIORITY must be between 1 and 255, got %d", c.MaxPriority)
+	v.check(c.MaxDelay > 0, "RABBITMQ_MAX_DELAY must be positive, got %s", c.MaxDelay)
+	v.check(c.MaxReplays >= 0, "RABBITMQ_DLQ_MAX_REPLAYS must not be negative, got %d", c.MaxReplays)
+	v.check(c.Heartbeat >= 0, "RABBITMQ_HEARTBEAT must not be negative, got %s", c.Heartbeat)
+	v.require("RABBITMQ_LOCALE", c.Locale)
+	return v.err()
+}
+
+// metricNamePart matches the namespaces and subsystems Prometheus accepts
+var metricNamePart = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Validate checks the metrics endpoint, naming and OTLP export settings
+func (c MetricsConfig) Validate() error {
+	var v validator
+	v.check(c.Namespace == "" || metricNamePart.MatchString(c.Namespace),
+		"METRICS_NAMESPACE must be letters, digits and underscores, got %q", c.Namespace)
+	v.check(c.Subsystem == "" || metricNamePart.MatchString(c.Subsystem),
+		"METRICS_SUBSYSTEM must be letters, digits and underscores, got %q", c.Subsystem)
+	if c.Enabled {
+		v.port("METRICS_PORT", c.Port)
+		v.check(strings.HasPrefix(c.Path, "/"), "METRICS_PATH must start with /, got %q", c.Path)
+	}
+	v.check((c.AuthUsername == "") == (c.AuthPassword == ""),
+		"METRICS_AUTH_USERNAME and METRICS_AUTH_PASSWORD must be set together")
+	if c.OTLPEnabled {
+		v.require("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", c.OTLPEndpoint)
+		v.check(c.OTLPInterval > 0, "OTEL_METRICS_EXPORT_INTERVAL must be positive, got %s", c.OTLPInterval)
+	}
+	return v.err()
+}
+
+// Validate checks the job timeout and retry settings
+func (c WorkerConfig) Validate() error {
+	var v validator
+	v.check(c.JobTimeout > 0, "WORKER_JOB_TIMEOUT must be positive, got %s", c.JobTimeout)
+	v.check(c.MaxRetries >= 0, "WORKER_MAX_RETRIES must not be negative, got %d", c.MaxRetries)
+	v.check(c.RetryBackoff >= 0, "WORKER_RETRY_BACKOFF must not be negative, got %s", c.RetryBackoff)
+	v.check(c.PaletteSize > 0, "WORKER_PALETTE_SIZE must be positive, got %d", c.PaletteSize)
+	v.check(c.QueueDepthInterval >= 0, "WORKER_QUEUE_DEPTH_INTERVAL must not be negative, got %s", c.QueueDepthInterval)
+	v.check(c.Concurrency > 0, "WORKER_CONCURRENCY must be positive, got %d", c.Concurrency)
+	v.check(c.DecodeConcurrency > 0, "WORKER_DECODE_CONCURRENCY must be positive, got %d", c.DecodeConcurrency)
+	v.check(c.ShutdownGrace >= 0, "WORKER_SHUTDOWN_GRACE must not be negative, got %s", c.ShutdownGrace)
+	v.check(c.CancelCacheTTL >= 0, "WORKER_CANCEL_CACHE_TTL must not be negative, got %s", c.CancelCacheTTL)
+	v.check(c.AckMode == AckModeMessage || c.AckMode == AckModeBatch,
+		"WORKER_ACK_MODE must be %q or %q, got %q", AckModeMessage, AckModeBatch, c.AckMode)
+	if c.AckMode == AckModeBatch {
+		v.check(c.AckBatchSize > 0, "WORKER_ACK_BATCH_SIZE must be positive, got %d", c.AckBatchSize)
+		v.check(c.AckFlushInterval > 0, "WORKER_ACK_FLUSH_INTERVAL must be positive, got %s", c.AckFlushInterval)
+	}
+	v.check(c.FailureThreshold >= 0 && c.FailureThreshold <= 100, "WORKER_FAILURE_THRESHOLD must be a percentage between 0 and 100, got %d", c.FailureThreshold)
+	if c.FailureThreshold > 0 {
+		v.check(c.FailureWindow >= time.Second, "WORKER_FAILURE_WINDOW must be at least 1s, got %s", c.FailureWindow)
+		v.check(c.FailureMinJobs > 0, "WORKER_FAILURE_MIN_JOBS must be positive, got %d", c.FailureMinJobs)
+	}
+	if _, err := ParseAutoRules(c.AutoRules); err != nil {
+		v.check(false, "WORKER_AUTO_RULES is invalid: %v", err)
+	}
+	if _, err := ParseDefaultParams(c.DefaultParams); err != nil {
+		v.check(false, "WORKER_DEFAULT_PARAMS is invalid: %v", err)
+	}
+	return v.err()
+}
+
+// Validate checks the download limits
+func (c DownloadConfig) Validate() error {
+	var v validator
+	v.check(c.MaxBytes > 0, "DOWNLOAD_MAX_BYTES must be positive, got %d", c.MaxBytes)
+	v.check(c.MaxRetries >= 0, "DOWNLOAD_MAX_RETRIES must not be negative, got %d", c.MaxRetries)
+	v.check(c.RetryBackoff >= 0, "DOWNLOAD_RETRY_BACKOFF must not be negative, got %s", c.RetryBackoff)
+	v.check(c.MaxPerHost >= 0, "DOWNLOAD_MAX_PER_HOST must not be negative, got %d", c.MaxPerHost)
+	v.check(c.MaxIdleConns >= 0, "DOWNLOAD_MAX_IDLE_CONNS must not be negative, got %d", c.MaxIdleConns)
+	v.check(c.MaxIdleConnsPerHost >= 0, "DOWNLOAD_MAX_IDLE_CONNS_PER_HOST must not be negative, got %d", c.MaxIdleConnsPerHost)
+	v.check(c.IdleConnTimeout >= 0, "DOWNLOAD_IDLE_CONN_TIMEOUT must not be negative, got %s", c.IdleConnTimeout)
+	if _, err := ParseFormatLimits(c.FormatLimits); err != nil {
+		v.check(false, "DOWNLOAD_FORMAT_LIMITS is invalid: %v", err)
+	}
+	v.add(c.SourceHosts.Validate())
+	return v.err()
+}
+
+// Validate checks that every source host pattern is a hostname or wildcard
+func (c SourceHostsConfig) Validate() error {
+	var v validator
+	for _, p := range c.Allow {
+		v.check(hostpolicy.ValidPattern(p), "SOURCE_HOSTS_ALLOW entry %q must be a hostname or *.domain", p)
+	}
+	for _, p := range c.Deny {
+		v.check(hostpolicy.ValidPattern(p), "SOURCE_HOSTS_DENY entry %q must be a hostname or *.domain", p)
+	}
+	for _, b := range c.Buckets {
+		v.check(validBucketName(b), "SOURCE_BUCKETS entry %q is not a valid bucket name", b)
+	}
+	return v.err()
+}
+
+// Validate checks the submission backpressure settings
+func (c SubmitConfig) Validate() error {
+	var v validator
+	v.check(c.MaxQueueDepth >= 0, "SUBMIT_MAX_QUEUE_DEPTH must not be negative, got %d", c.MaxQueueDepth)
+	v.check(c.RetryAfter >= time.Second, "SUBMIT_RETRY_AFTER must be at least 1s, got %s", c.RetryAfter)
+	v.check(c.DepthCheckInterval >= 0, "SUBMIT_DEPTH_CHECK_INTERVAL must not be negative, got %s", c.DepthCheckInterval)
+	v.check(c.WaitTimeout > 0, "SUBMIT_WAIT_TIMEOUT must be positive, got %s", c.WaitTimeout)
+	v.check(c.MaxTypesPerURL >= 0, "SUBMIT_MAX_TYPES_PER_URL must not be negative, got %d", c.MaxTypesPerURL)
+	owners := make([]string, 0, len(c.BucketsByOwner))
+	for owner := range c.BucketsByOwner {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		_, known := c.APIKeys[owner]
+		v.check(known, "SUBMIT_BUCKETS_BY_API_KEY entry %q must name an owner in SUBMIT_API_KEYS", owner)
+		for _, b := range c.BucketsByOwner[owner] {
+			v.check(validBucketName(b), "SUBMIT_BUCKETS_BY_API_KEY bucket %q must be a valid S3 bucket name", b)
+		}
+	}
+	return v.err()
+}
+
+// Validate checks every setting url-ingestor uses
+func (c *URLIngestorConfig) Validate() error {
+	var v validator
+	v.add(c.Server.Validate())
+	v.add(c.RabbitMQ.Validate())
+	v.add(c.Database.Validate())
+	v.add(c.Metrics.Validate())
+	v.add(c.Submit.Validate())
+	v.add(c.SourceHosts.Validate())
+	v.add(c.Params.Validate())
+	v.add(c.RateLimit.Validate())
+	v.add(c.Quota.Validate(c.Submit.APIKeys))
+	return v.err()
+}
+
+// Validate checks that every quota is positive and belongs to one of owners
+func (c QuotaConfig) Validate(owners map[string]string) error {
+	var v validator
+	names := make([]string, 0, len(c.Bytes))
+	for name := range c.Bytes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, known := owners[name]
+		v.check(known, "QUOTA_BYTES entry %q must name an owner in SUBMIT_API_KEYS", name)
+		v.check(c.Bytes[name] > 0, "QUOTA_BYTES for %q must be positive, got %d", name, c.Bytes[name])
+	}
+	return v.err()
+}
+
+// Validate checks that the param bounds are positive
+func (c ParamLimitsConfig) Validate() error {
+	var v validator
+	v.check(c.MaxSigma > 0, "PARAMS_MAX_SIGMA must be positive, got %g", c.MaxSigma)
+	v.check(c.MaxDimension > 0, "PARAMS_MAX_DIMENSION must be positive, got %d", c.MaxDimension)
+	return v.err()
+}
+
+// Validate checks the rate limit
+func (c RateLimitConfig) Validate() error {
+	var v validator
+	v.check(c.Requests > 0, "RATE_LIMIT_REQUESTS must be positive, got %d", c.Requests)
+	v.check(c.Window >= time.Second, "RATE_LIMIT_WINDOW must be at least 1s, got %s", c.Window)
+	return v.err()
+}
+
+// Validate checks every setting image-fetcher uses. MinIO settings are only
+// required when MinIO is the storage backend.
+func (c *ImageFetcherConfig) Validate() error {
+	var v validator
+	v.add(c.RabbitMQ.Validate())
+	v.add(c.Storage.Validate())
+	if c.Storage.Backend == "" || c.Storage.Backend == "minio" {
+		v.add(c.Minio.Validate())
+	} else {
+		v.add(c.Minio.EncodingConfig.Validate())
+	}
+	v.add(c.Database.Validate())
+	v.add(c.Metrics.Validate())
+	v.add(c.Worker.Validate())
+	v.add(c.Download.Validate())
+	v.add(c.Params.Validate())
+	return v.err()
+}
+
+// Validate checks every setting image-metadata uses
+func (c *ImageMetadataConfig) Validate() error {
+	var v validator
+	v.add(c.Server.Validate())
+	v.add(c.RabbitMQ.Validate())
+	v.add(c.Database.Validate())
+	v.add(c.Metrics.Validate())
+	v.check(c.ReprocessMaxJobs > 0, "REPROCESS_MAX_JOBS must be positive, got %d", c.ReprocessMaxJobs)
+	v.add(c.Store.Validate())
+	// MinIO is only needed to serve content
+	if len(c.Content.APIKeys) > 0 {
+		v.add(c.Minio.Validate())
+		v.check(c.Content.MaxInlineBytes > 0, "CONTENT_MAX_INLINE_BYTES must be positive, got %d", c.Content.MaxInlineBytes)
+	}
+	return v.err()
+}
+
+// Validate checks the metadata store retry settings
+func (c StoreConfig) Validate() error {
+	var v validator
+	v.check(c.MaxAttempts > 0, "METADATA_STORE_MAX_ATTEMPTS must be positive, got %d", c.MaxAttempts)
+	v.check(c.MaxRequeues >= 0, "METADATA_STORE_MAX_REQUEUES must not be negative, got %d", c.MaxRequeues)
+	v.check(c.RetryBackoff >= 0, "METADATA_STORE_RETRY_BACKOFF must not be negative, got %s", c.RetryBackoff)
+	v.check(c.DBCheckInterval > 0, "METADATA_DB_CHECK_INTERVAL must be positive, got %s", c.DBCheckInterval)
+	v.check(c.Prefetch > 0, "METADATA_PREFETCH must be positive, got %d", c.Prefetch)
+	return v.err()
+}
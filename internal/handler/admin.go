@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/pkg/rabbitmq"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// QueuePurger drops every ready message from a queue
+type QueuePurger interface {
+	QueuePurge(name string, noWait bool) (int, error)
+}
+
+// WithQueueAdmin enables POST /admin/queue/{name}/purge for the operators
+// listed in ADMIN_API_KEYS
+func WithQueueAdmin(purger QueuePurger) RouterOption {
+	return func(d *routerDeps) {
+		d.purger = purger
+	}
+}
+
+// keyOwner returns the name of the operator or client apiKey belongs to
+func keyOwner(keys map[string]string, apiKey string) (string, bool) {
+	if apiKey == "" {
+		return "", false
+	}
+	for name, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// purgeableQueues lists the queues the ingestor declared: each job lane with
+// its delay queue and DLQ. Purging a queue that doesn't exist would close the
+// channel submissions publish on, so other names are refused.
+func purgeableQueues(cfg config.RabbitMQConfig) map[string]bool {
+	queues := make(map[string]bool)
+	for _, q := range cfg.JobQueueNames() {
+		queues[q] = true
+		queues[rabbitmq.DelayedQueue(q)] = true
+		queues[rabbitmq.DeadLetterQueue(q)] = true
+	}
+	return queues
+}
+
+// purgeQueueHandler serves POST /admin/queue/{name}/purge, which drops the
+// queue's ready messages and reports how many there were
+func purgeQueueHandler(cfg *config.URLIngestorConfig, purger QueuePurger) http.HandlerFunc {
+	queues := purgeableQueues(cfg.RabbitMQ)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		traceID := requestTraceID(r.Context(), r)
+		caller, ok := keyOwner(cfg.Admin.APIKeys, r.Header.Get("X-API-Key"))
+		if !ok {
+			writeError(w, http.StatusUnauthorized, traceID, ErrCodeUnauthorized, "a valid admin X-API-Key is required", nil)
+			return
+		}
+		if purger == nil {
+			writeError(w, http.StatusServiceUnavailable, traceID, ErrCodePurgeUnavailable, "queue purge not available", nil)
+			return
+		}
+
+		name := chi.URLParam(r, "name")
+		if !queues[name] {
+			writeError(w, http.StatusNotFound, traceID, ErrCodeNotFound, "unknown queue", map[string]interface{}{
+				"queue": name,
+			})
+			return
+		}
+
+		purged, err := purger.QueuePurge(name, false)
+		if err != nil {
+			log.Printf("Admin %s failed to purge %s: %v", caller, name, err)
+			writeError(w, http.StatusInternalServerError, traceID, ErrCodePurgeFailed, "purge failed", nil)
+			return
+		}
+		log.Printf("Admin %s purged %d messages from %s", caller, purged, name)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"queue":  name,
+			"purged": purged,
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/handler/testutil"
+	"image-processing-system/pkg/rabbitmq"
+)
+
+// fakePurger records purged queues and reports a fixed count
+type fakePurger struct {
+	purged []string
+}
+
+func (f *fakePurger) QueuePurge(name string, noWait bool) (int, error) {
+	f.purged = append(f.purged, name)
+	return 7, nil
+}
+
+func TestPurgeQueue(t *testing.T) {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.Admin.APIKeys = map[string]string{"alice": "admin-secret"}
+	dlq := rabbitmq.DeadLetterQueue(cfg.RabbitMQ.JobQueue)
+
+	tests := []struct {
+		name       string
+		queue      string
+		apiKey     string
+		wantStatus int
+	}{
+		{"purges a job queue", cfg.RabbitMQ.JobQueue, "admin-secret", http.StatusOK},
+		{"purges a DLQ", dlq, "admin-secret", http.StatusOK},
+		{"missing key", cfg.RabbitMQ.JobQueue, "", http.StatusUnauthorized},
+		{"wrong key", cfg.RabbitMQ.JobQueue, "guess", http.StatusUnauthorized},
+		{"unknown queue", "image.processed", "admin-secret", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			purger := &fakePurger{}
+			router := NewRouter(&testutil.Channel{}, cfg, WithQueueAdmin(purger))
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/queue/"+tt.queue+"/purge", nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if len(purger.purged) != 0 {
+					t.Errorf("expected nothing to be purged, got %v", purger.purged)
+				}
+				return
+			}
+
+			var body struct {
+				Queue  string `json:"queue"`
+				Purged int    `json:"purged"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Queue != tt.queue || body.Purged != 7 || len(purger.purged) != 1 {
+				t.Errorf("unexpected response %+v, purged %v", body, purger.purged)
+			}
+		})
+	}
+}
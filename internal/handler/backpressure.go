@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// QueueInspector reports how many messages are waiting in a queue
+type QueueInspector interface {
+	QueueDepth(queue string) (int, error)
+}
+
+// WithBackpressure enables rejecting submissions with 429 while the job queue
+// is deeper than the configured SUBMIT_MAX_QUEUE_DEPTH
+func WithBackpressure(inspector QueueInspector) RouterOption {
+	return func(d *routerDeps) {
+		d.inspector = inspector
+	}
+}
+
+// depthGuard caches the total depth of the job queues so the broker is asked
+// at most once per interval, however many submissions arrive
+type depthGuard struct {
+	inspector QueueInspector
+	queues    []string
+	maxDepth  int
+	interval  time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	depth   int
+}
+
+// backlogged reports whether the queues are over the limit, with the depth
+// seen. Queues that can't be inspected count as empty.
+func (g *depthGuard) backlogged() (int, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if time.Since(g.checked) >= g.interval {
+		total := 0
+		for _, q := range g.queues {
+			depth, err := g.inspector.QueueDepth(q)
+			if err != nil {
+				log.Printf("Failed to inspect %s depth: %v", q, err)
+				continue
+			}
+			total += depth
+		}
+		g.depth, g.checked = total, time.Now()
+	}
+	return g.depth, g.depth > g.maxDepth
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/handler/testutil"
+)
+
+// fakeInspector reports a fixed queue depth and counts lookups
+type fakeInspector struct {
+	depth int
+	err   error
+	calls int
+}
+
+func (f *fakeInspector) QueueDepth(queue string) (int, error) {
+	f.calls++
+	return f.depth, f.err
+}
+
+func backpressureConfig() *config.URLIngestorConfig {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.Submit.MaxQueueDepth = 100
+	cfg.Submit.RetryAfter = 45 * time.Second
+	cfg.Submit.DepthCheckInterval = time.Minute
+	return cfg
+}
+
+func submit(router http.Handler) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader(`{"urls": ["http://example.com/a.jpg"]}`))
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSubmitBackpressure(t *testing.T) {
+	ch := &testutil.Channel{}
+	inspector := &fakeInspector{depth: 101}
+	router := NewRouter(ch, backpressureConfig(), WithBackpressure(inspector))
+
+	rr := submit(router)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "45" {
+		t.Errorf("Retry-After = %q, want 45", got)
+	}
+	var body map[string]APIError
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"].Code != ErrCodeQueueBacklogged {
+		t.Errorf("expected code %s, got %s", ErrCodeQueueBacklogged, body["error"].Code)
+	}
+	if len(ch.Published()) != 0 {
+		t.Error("expected nothing to be published while backlogged")
+	}
+
+	// The depth reading is reused within the check interval
+	submit(router)
+	if inspector.calls != 1 {
+		t.Errorf("expected one depth lookup per interval, got %d", inspector.calls)
+	}
+}
+
+func TestSubmitBackpressureAllows(t *testing.T) {
+	tests := map[string]struct {
+		inspector *fakeInspector
+		cfg       func() *config.URLIngestorConfig
+	}{
+		"under the limit":   {&fakeInspector{depth: 100}, backpressureConfig},
+		"inspection failed": {&fakeInspector{err: errors.New("channel closed")}, backpressureConfig},
+		"disabled":          {&fakeInspector{depth: 1000}, config.LoadURLIngestorConfig},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := NewRouter(&testutil.Channel{}, tt.cfg(), WithBackpressure(tt.inspector))
+			if rr := submit(router); rr.Code != http.StatusAccepted {
+				t.Errorf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -3,7 +3,11 @@ package handler
 import (
 	"bytes"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/handler/testutil"
 )
 
 const requestBody = `{
@@ -33,3 +37,19 @@ func BenchmarkSubmitAPI(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkSubmitHandler measures /submit in-process, without RabbitMQ
+func BenchmarkSubmitHandler(b *testing.B) {
+	ch := &testutil.Channel{}
+	cfg := config.LoadURLIngestorConfig()
+	cfg.RateLimit.Requests = 1 << 30 // measure the handler, not 429s
+	router := NewRouter(ch, cfg)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("POST", "/submit", bytes.NewBufferString(requestBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		ch.Reset()
+	}
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/models"
+	"image-processing-system/internal/service/metadata"
+	"image-processing-system/internal/service/storage"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ImageRecordGetter looks up one stored image record, returning an error
+// wrapping metadata.ErrRecordNotFound when there is none
+type ImageRecordGetter interface {
+	GetImageRecordByID(id uint) (*models.ImageRecord, error)
+}
+
+// ObjectOpener opens stored objects for reading
+type ObjectOpener interface {
+	OpenObject(ctx context.Context, bucket, key string) (*storage.StoredObject, error)
+}
+
+// WithImageContent enables GET /images/{id}/content for the clients listed in
+// cfg.APIKeys. objects may be nil while object storage is unreachable, in
+// which case the endpoint returns 503.
+func WithImageContent(records ImageRecordGetter, objects ObjectOpener, cfg config.ContentConfig) MetadataRouterOption {
+	return func(d *metadataDeps) {
+		d.records = records
+		d.objects = objects
+		d.content = cfg
+	}
+}
+
+// rangeLength returns how many bytes a response to a Range header will carry
+// for an object of size bytes. Multiple ranges and headers that don't parse
+// count as the whole object.
+func rangeLength(header string, size int64) int64 {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return size
+	}
+	first, last, _ := strings.Cut(strings.TrimSpace(spec), "-")
+	if first == "" {
+		// A suffix range: the final n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return size
+		}
+		return min(n, size)
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return size
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return size
+		}
+		end = min(end, size-1)
+	}
+	return end - start + 1
+}
+
+// imageContentHandler serves GET /images/{id}/content: the stored output of
+// an image record, streamed from object storage with range support. Clients
+// in CONTENT_API_KEYS may read any record, owners only their own. Responses
+// over cfg.MaxInlineBytes are refused, so large objects must be read in
+// ranges.
+func imageContentHandler(deps *metadataDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		traceID := requestTraceID(r.Context(), r)
+		apiKey := r.Header.Get("X-API-Key")
+		client, ok := keyOwner(deps.content.APIKeys, apiKey)
+		owner, isOwner := "", false
+		if !ok {
+			if owner, isOwner = keyOwner(deps.owners, apiKey); !isOwner {
+				writeError(w, http.StatusUnauthorized, traceID, ErrCodeUnauthorized, "a valid X-API-Key is required", nil)
+				return
+			}
+			client = "owner " + owner
+		}
+		if deps.objects == nil {
+			writeError(w, http.StatusServiceUnavailable, traceID, ErrCodeContentUnavailable, "object storage not available", nil)
+			return
+		}
+
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 0)
+		if err != nil {
+			writeError(w, http.StatusNotFound, traceID, ErrCodeNotFound, "no such image", nil)
+			return
+		}
+		record, err := deps.records.GetImageRecordByID(uint(id))
+		if errors.Is(err, metadata.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, traceID, ErrCodeNotFound, "no such image", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to look up image %d: %v", id, err)
+			writeError(w, http.StatusInternalServerError, traceID, ErrCodeQueryFailed, "failed to look up image", nil)
+			return
+		}
+		// Other owners' images are reported missing, so their IDs can't be
+		// probed
+		if isOwner && record.OwnerID != owner {
+			writeError(w, http.StatusNotFound, traceID, ErrCodeNotFound, "no such image", nil)
+			return
+		}
+		bucket, key, ok := storage.ParseImageURL(record.S3Path)
+		if !ok {
+			writeError(w, http.StatusNotFound, traceID, ErrCodeNotFound, "image has no stored object", nil)
+			return
+		}
+
+		obj, err := deps.objects.OpenObject(r.Context(), bucket, key)
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			writeError(w, http.StatusNotFound, traceID, ErrCodeNotFound, "stored object is gone", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to open %s/%s: %v", bucket, key, err)
+			writeError(w, http.StatusBadGateway, traceID, ErrCodeContentFailed, "failed to read stored object", nil)
+			return
+		}
+		defer obj.Close()
+
+		if n := rangeLength(r.Header.Get("Range"), obj.Size); n > deps.content.MaxInlineBytes {
+			writeError(w, http.StatusRequestEntityTooLarge, traceID, ErrCodeContentTooLarge, "object too large to serve inline; request a range", map[string]interface{}{
+				"size":             obj.Size,
+				"max_inline_bytes": deps.content.MaxInlineBytes,
+			})
+			return
+		}
+		log.Printf("Serving %s/%s to %s", bucket, key, client)
+
+		if obj.ContentType != "" {
+			w.Header().Set("Content-Type", obj.ContentType)
+		}
+		w.Header().Set("Cache-Control", "private")
+		http.ServeContent(w, r, path.Base(key), obj.ModTime, obj)
+	}
+}
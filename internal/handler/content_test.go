@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/models"
+	"image-processing-system/internal/service/metadata"
+	"image-processing-system/internal/service/storage"
+)
+
+// fakeRecords serves image records by ID
+type fakeRecords map[uint]models.ImageRecord
+
+func (f fakeRecords) GetImageRecordByID(id uint) (*models.ImageRecord, error) {
+	record, ok := f[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", metadata.ErrRecordNotFound, id)
+	}
+	return &record, nil
+}
+
+// fakeObjects serves objects by bucket/key
+type fakeObjects map[string][]byte
+
+func (f fakeObjects) OpenObject(ctx context.Context, bucket, key string) (*storage.StoredObject, error) {
+	data, ok := f[bucket+"/"+key]
+	if !ok {
+		return nil, storage.ErrObjectNotFound
+	}
+	return &storage.StoredObject{
+		ReadSeekCloser: struct {
+			io.ReadSeeker
+			io.Closer
+		}{bytes.NewReader(data), io.NopCloser(nil)},
+		Size:        int64(len(data)),
+		ContentType: "image/jpeg",
+		ModTime:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, nil
+}
+
+func TestImageContentEndpoint(t *testing.T) {
+	records := fakeRecords{
+		1: {ID: 1, S3Path: "s3://images/thumb.jpg", OwnerID: "acme"},
+		2: {ID: 2, S3Path: "s3://images/large.jpg"},
+		3: {ID: 3, ProcessingType: "palette"},
+		4: {ID: 4, S3Path: "s3://images/deleted.jpg"},
+	}
+	objects := fakeObjects{
+		"images/thumb.jpg": []byte("thumbnail"),
+		"images/large.jpg": bytes.Repeat([]byte("x"), 100),
+	}
+	cfg := config.ContentConfig{APIKeys: map[string]string{"ui": "secret"}, MaxInlineBytes: 10}
+
+	tests := []struct {
+		name       string
+		objects    ObjectOpener
+		path       string
+		apiKey     string
+		rangeSpec  string
+		wantStatus int
+		wantBody   string
+	}{
+		{"missing key", objects, "/images/1/content", "", "", http.StatusUnauthorized, ""},
+		{"wrong key", objects, "/images/1/content", "nope", "", http.StatusUnauthorized, ""},
+		{"storage unavailable", nil, "/images/1/content", "secret", "", http.StatusServiceUnavailable, ""},
+		{"inline", objects, "/images/1/content", "secret", "", http.StatusOK, "thumbnail"},
+		{"range", objects, "/images/1/content", "secret", "bytes=0-4", http.StatusPartialContent, "thumb"},
+		{"too large", objects, "/images/2/content", "secret", "", http.StatusRequestEntityTooLarge, ""},
+		{"large in ranges", objects, "/images/2/content", "secret", "bytes=90-99", http.StatusPartialContent, "xxxxxxxxxx"},
+		{"range too large", objects, "/images/2/content", "secret", "bytes=50-", http.StatusRequestEntityTooLarge, ""},
+		{"unknown image", objects, "/images/9/content", "secret", "", http.StatusNotFound, ""},
+		{"invalid id", objects, "/images/abc/content", "secret", "", http.StatusNotFound, ""},
+		{"nothing stored", objects, "/images/3/content", "secret", "", http.StatusNotFound, ""},
+		{"object gone", objects, "/images/4/content", "secret", "", http.StatusNotFound, ""},
+		{"owner's image", objects, "/images/1/content", "acme-key", "", http.StatusOK, "thumbnail"},
+		{"another owner's image", objects, "/images/1/content", "globex-key", "", http.StatusNotFound, ""},
+		{"unowned image", objects, "/images/2/content", "acme-key", "bytes=0-1", http.StatusNotFound, ""},
+	}
+	owners := map[string]string{"acme": "acme-key", "globex": "globex-key"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewMetadataRouter(&fakeImageStore{}, WithImageContent(records, tt.objects, cfg), WithOwners(owners, config.AdminConfig{}))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			if tt.rangeSpec != "" {
+				req.Header.Set("Range", tt.rangeSpec)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.wantBody != "" {
+				if rr.Body.String() != tt.wantBody {
+					t.Errorf("body = %q, want %q", rr.Body.String(), tt.wantBody)
+				}
+				if ct := rr.Header().Get("Content-Type"); ct != "image/jpeg" {
+					t.Errorf("Content-Type = %q, want image/jpeg", ct)
+				}
+			}
+		})
+	}
+}
+
+func TestRangeLength(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{"", 100},
+		{"bytes=0-9", 10},
+		{"bytes=90-200", 10},
+		{"bytes=50-", 50},
+		{"bytes=-20", 20},
+		{"bytes=-500", 100},
+		{"bytes=0-1,5-6", 100},
+		{"bytes=9-0", 100},
+		{"items=0-1", 100},
+	}
+	for _, tt := range tests {
+		if got := rangeLength(tt.header, 100); got != tt.want {
+			t.Errorf("rangeLength(%q) = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
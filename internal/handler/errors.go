@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Stable error codes returned in the error envelope. Clients may switch on
+// these, so existing codes must not be renamed.
+const (
+	ErrCodeInvalidJSON            = "INVALID_JSON"
+	ErrCodeInvalidBody            = "INVALID_BODY"
+	ErrCodeInvalidProcessingTypes = "INVALID_PROCESSING_TYPES"
+	ErrCodeTooManyTypes           = "TOO_MANY_PROCESSING_TYPES"
+	ErrCodeInvalidPriority        = "INVALID_PRIORITY"
+	ErrCodeInvalidFormat          = "INVALID_FORMAT"
+	ErrCodeInvalidSchedule        = "INVALID_SCHEDULE"
+	ErrCodeInvalidResizePresets   = "INVALID_RESIZE_PRESETS"
+	ErrCodeInvalidParams          = "INVALID_PARAMS"
+	ErrCodeHostNotAllowed         = "HOST_NOT_ALLOWED"
+	ErrCodeUnauthorized           = "UNAUTHORIZED"
+	ErrCodeBucketNotAllowed       = "BUCKET_NOT_ALLOWED"
+	ErrCodeOwnerNotAllowed        = "OWNER_NOT_ALLOWED"
+	ErrCodeInvalidWait            = "INVALID_WAIT"
+	ErrCodeWaitUnavailable        = "WAIT_UNAVAILABLE"
+	ErrCodeWaitTimeout            = "WAIT_TIMEOUT"
+	ErrCodeJobFailed              = "JOB_FAILED"
+	ErrCodePublishFailed          = "PUBLISH_FAILED"
+	ErrCodeQueueUnavailable       = "QUEUE_UNAVAILABLE"
+	ErrCodeQueueBacklogged        = "QUEUE_BACKLOGGED"
+	ErrCodeCancelUnavailable      = "CANCEL_UNAVAILABLE"
+	ErrCodeCancelFailed           = "CANCEL_FAILED"
+	ErrCodePurgeUnavailable       = "PURGE_UNAVAILABLE"
+	ErrCodePurgeFailed            = "PURGE_FAILED"
+	ErrCodeQuotaExceeded          = "QUOTA_EXCEEDED"
+	ErrCodeQuotaUnavailable       = "QUOTA_UNAVAILABLE"
+	ErrCodeInvalidLimit           = "INVALID_LIMIT"
+	ErrCodeInvalidTraceIDs        = "INVALID_TRACE_IDS"
+	ErrCodeInvalidReprocess       = "INVALID_REPROCESS"
+	ErrCodeReprocessUnavailable   = "REPROCESS_UNAVAILABLE"
+	ErrCodeQueryFailed            = "QUERY_FAILED"
+	ErrCodeContentUnavailable     = "CONTENT_UNAVAILABLE"
+	ErrCodeContentFailed          = "CONTENT_FAILED"
+	ErrCodeContentTooLarge        = "CONTENT_TOO_LARGE"
+	ErrCodeRateLimited            = "RATE_LIMITED"
+	ErrCodeNotFound               = "NOT_FOUND"
+	ErrCodeMethodNotAllowed       = "METHOD_NOT_ALLOWED"
+)
+
+// APIError is the body of every error response:
+// {"error":{"code":"...","message":"...","trace_id":"..."}}
+type APIError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	TraceID string      `json:"trace_id,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// writeError writes an error envelope with the given status
+func writeError(w http.ResponseWriter, status int, traceID, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]APIError{
+		"error": {Code: code, Message: message, TraceID: traceID, Details: details},
+	})
+}
+
+// requestTraceID returns the client's X-Trace-ID, falling back to the trace
+// ID of the span in ctx or of the request's traceparent header
+func requestTraceID(ctx context.Context, r *http.Request) string {
+	if id := r.Header.Get("X-Trace-ID"); id != "" {
+		return id
+	}
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		incoming := propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(r.Header))
+		sc = trace.SpanContextFromContext(incoming)
+	}
+	if sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
@@ -0,0 +1,489 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/middleware"
+	"image-processing-system/internal/models"
+	"image-processing-system/pkg/message"
+	"image-processing-system/pkg/tracing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Page sizes for GET /images
+const (
+	defaultImagesLimit = 50
+	maxImagesLimit     = 500
+)
+
+// maxStatusTraceIDs caps the trace IDs accepted by POST /jobs/status
+const maxStatusTraceIDs = 500
+
+// ImageRecordStore reads stored image metadata
+type ImageRecordStore interface {
+	GetImageRecords(limit int, ownerID string) ([]models.ImageRecord, error)
+	SourceCounts(ctx context.Context, limit, offset int, ownerID string) ([]models.SourceCount, int64, error)
+	TraceStatuses(ctx context.Context, traceIDs []string, ownerID string) (map[string]models.TraceStatus, error)
+}
+
+// ReprocessStore selects the source images to re-run a processing type on
+type ReprocessStore interface {
+	ReprocessCandidates(ctx context.Context, processingType string, since, until time.Time, after *models.ReprocessCandidate, limit int) ([]models.ReprocessCandidate, int64, error)
+}
+
+// MetadataRouterOption configures optional metadata router features
+type MetadataRouterOption func(*metadataDeps)
+
+type metadataDeps struct {
+	reprocess      ReprocessStore
+	jobs           ChannelInterface
+	rabbitMQ       config.RabbitMQConfig
+	maxReprocessed int
+	admin          config.AdminConfig
+
+	records ImageRecordGetter
+	objects ObjectOpener
+	content config.ContentConfig
+
+	owners map[string]string
+}
+
+// WithOwners scopes GET /images, GET /images/sources, POST /jobs/status and
+// GET /images/{id}/content to the records of the owner whose key a request
+// carries, keys as in SUBMIT_API_KEYS, and lets the operators in admin read
+// any owner's records
+func WithOwners(owners map[string]string, admin config.AdminConfig) MetadataRouterOption {
+	return func(d *metadataDeps) {
+		d.owners = owners
+		d.admin = admin
+	}
+}
+
+// WithReprocessing enables POST /reprocess for the operators listed in
+// admin, publishing up to maxJobs jobs per call to the job lane of their
+// priority
+func WithReprocessing(store ReprocessStore, ch ChannelInterface, rabbitMQ config.RabbitMQConfig, maxJobs int, admin config.AdminConfig) MetadataRouterOption {
+	return func(d *metadataDeps) {
+		d.reprocess = store
+		d.jobs = ch
+		d.rabbitMQ = rabbitMQ
+		d.maxReprocessed = maxJobs
+		d.admin = admin
+	}
+}
+
+// reprocessCursor is the position a next_cursor continues after
+type reprocessCursor struct {
+	ProcessedAt time.Time `json:"t"`
+	SourceURL   string    `json:"u"`
+	Bucket      string    `json:"b,omitempty"`
+	OwnerID     string    `json:"o,omitempty"`
+	Params      string    `json:"p,omitempty"`
+}
+
+// encodeReprocessCursor makes the next_cursor continuing after c
+func encodeReprocessCursor(c models.ReprocessCandidate) string {
+	raw, _ := json.Marshal(reprocessCursor{ProcessedAt: c.ProcessedAt.UTC(), SourceURL: c.SourceURL, Bucket: c.Bucket, OwnerID: c.OwnerID, Params: c.Params})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeReprocessCursor reads a cursor made by encodeReprocessCursor
+func decodeReprocessCursor(cursor string) (*models.ReprocessCandidate, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var c reprocessCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	if c.ProcessedAt.IsZero() || c.SourceURL == "" {
+		return nil, errors.New("malformed cursor")
+	}
+	return &models.ReprocessCandidate{SourceURL: c.SourceURL, ProcessedAt: c.ProcessedAt, Bucket: c.Bucket, OwnerID: c.OwnerID, Params: c.Params}, nil
+}
+
+// Sources reprocess jobs can read their image from
+const (
+	reprocessFromOriginal = "original"
+	reprocessFromURL      = "url"
+)
+
+// ReprocessResponse is the body of POST /reprocess
+type ReprocessResponse struct {
+	TraceID        string    `json:"trace_id,omitempty"`
+	ProcessingType string    `json:"processing_type"`
+	Since          time.Time `json:"since"`
+	Until          time.Time `json:"until"`
+	DryRun         bool      `json:"dry_run"`
+	// Priority is the jobs' priority, which also picks their job lane
+	Priority int `json:"priority"`
+	// Source is where the jobs read their image: "original" for the stored
+	// original where there is one, or "url" to download every source again
+	Source string `json:"source"`
+	// FromOriginal counts the selected images with a stored original the
+	// jobs read instead of their source URL
+	FromOriginal int `json:"from_original"`
+	// MissingParams counts the selected images not enqueued because their
+	// processing type needs params and their records have none stored
+	MissingParams int `json:"missing_params,omitempty"`
+	// Matched counts every source image that matches, Queued those enqueued
+	// by this call (0 for a dry run)
+	Matched int64 `json:"matched"`
+	Queued  int   `json:"queued"`
+	// NextCursor is set when more matches remain; pass it as cursor (with
+	// the same since and until) to continue
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ImagesResponse is the body of GET /images
+type ImagesResponse struct {
+	Images []models.ImageRecord `json:"images"`
+	Count  int                  `json:"count"`
+}
+
+// SourcesResponse is the body of GET /images/sources
+type SourcesResponse struct {
+	Sources []models.SourceCount `json:"sources"`
+	Count   int                  `json:"count"`
+	// Total is the number of distinct source URLs across all pages
+	Total  int64 `json:"total"`
+	Offset int   `json:"offset"`
+}
+
+// NewMetadataRouter serves the image-metadata API
+func NewMetadataRouter(store ImageRecordStore, opts ...MetadataRouterOption) http.Handler {
+	var deps metadataDeps
+	for _, opt := range opts {
+		opt(&deps)
+	}
+
+	r := chi.NewRouter()
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, requestTraceID(r.Context(), r), ErrCodeNotFound, "no such endpoint", nil)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, requestTraceID(r.Context(), r), ErrCodeMethodNotAllowed, "method not allowed", nil)
+	})
+
+	r.Use(middleware.MetricsMiddleware)
+
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "healthy",
+			"timestamp": time.Now().UTC(),
+			"service":   config.ImageMetadataService,
+			"tracing":   tracing.Status(),
+		})
+	})
+
+	// Most recently processed images first, optionally only one owner's
+	r.Get("/images", func(w http.ResponseWriter, r *http.Request) {
+		traceID := requestTraceID(r.Context(), r)
+
+		limit := defaultImagesLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxImagesLimit {
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidLimit,
+					fmt.Sprintf("limit must be between 1 and %d", maxImagesLimit), nil)
+				return
+			}
+			limit = n
+		}
+
+		owner, ok := readOwner(w, r, traceID, &deps)
+		if !ok {
+			return
+		}
+
+		records, err := store.GetImageRecords(limit, owner)
+		if err != nil {
+			log.Printf("Failed to list image records: %v", err)
+			writeError(w, http.StatusInternalServerError, traceID, ErrCodeQueryFailed, "failed to list images", nil)
+			return
+		}
+
+		if records == nil {
+			records = []models.ImageRecord{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ImagesResponse{Images: records, Count: len(records)})
+	})
+
+	// Source URLs by how many records they have, for analytics
+	r.Get("/images/sources", func(w http.ResponseWriter, r *http.Request) {
+		traceID := requestTraceID(r.Context(), r)
+		query := r.URL.Query()
+
+		limit := defaultImagesLimit
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxImagesLimit {
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidLimit,
+					fmt.Sprintf("limit must be between 1 and %d", maxImagesLimit), nil)
+				return
+			}
+			limit = n
+		}
+		offset := 0
+		if v := query.Get("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidLimit, "offset must be a non-negative integer", nil)
+				return
+			}
+			offset = n
+		}
+
+		owner, ok := readOwner(w, r, traceID, &deps)
+		if !ok {
+			return
+		}
+
+		sources, total, err := store.SourceCounts(r.Context(), limit, offset, owner)
+		if err != nil {
+			log.Printf("Failed to count source URLs: %v", err)
+			writeError(w, http.StatusInternalServerError, traceID, ErrCodeQueryFailed, "failed to list sources", nil)
+			return
+		}
+
+		if sources == nil {
+			sources = []models.SourceCount{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SourcesResponse{Sources: sources, Count: len(sources), Total: total, Offset: offset})
+	})
+
+	// Stored outputs served inline to authenticated clients
+	if deps.records != nil {
+		r.Get("/images/{id}/content", imageContentHandler(&deps))
+	}
+
+	// Aggregated status for many submissions in one call
+	r.Post("/jobs/status", func(w http.ResponseWriter, r *http.Request) {
+		traceID := requestTraceID(r.Context(), r)
+
+		var ids []string
+		if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidJSON, "body must be a JSON array of trace IDs", nil)
+			return
+		}
+		ids = uniqueTraceIDs(ids)
+		if len(ids) == 0 || len(ids) > maxStatusTraceIDs {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidTraceIDs,
+				fmt.Sprintf("between 1 and %d trace IDs are required", maxStatusTraceIDs), nil)
+			return
+		}
+
+		owner, ok := readOwner(w, r, traceID, &deps)
+		if !ok {
+			return
+		}
+
+		statuses, err := store.TraceStatuses(r.Context(), ids, owner)
+		if err != nil {
+			log.Printf("Failed to load trace statuses: %v", err)
+			writeError(w, http.StatusInternalServerError, traceID, ErrCodeQueryFailed, "failed to load job statuses", nil)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	})
+
+	// Re-run a processing type across stored images, e.g. after a bug fix
+	r.Post("/reprocess", func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(config.ImageMetadataService).Start(ctx, "Reprocess")
+		defer span.End()
+		traceID := requestTraceID(ctx, r)
+		w.Header().Set("X-Trace-ID", traceID)
+
+		if deps.reprocess == nil || deps.jobs == nil || deps.jobs.IsClosed() {
+			writeError(w, http.StatusServiceUnavailable, traceID, ErrCodeReprocessUnavailable, "reprocessing is not available", nil)
+			return
+		}
+		caller, ok := keyOwner(deps.admin.APIKeys, r.Header.Get("X-API-Key"))
+		if !ok {
+			writeError(w, http.StatusUnauthorized, traceID, ErrCodeUnauthorized, "a valid admin X-API-Key is required", nil)
+			return
+		}
+
+		q := r.URL.Query()
+		processingType := models.NormalizeProcessingType(q.Get("processing_type"))
+		if _, ok := allowedProcessingTypes[processingType]; !ok {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidReprocess, "processing_type must be one of the allowed types",
+				map[string]interface{}{"allowed_types": getAllowedProcessingTypes()})
+			return
+		}
+		since, err := time.Parse(time.RFC3339, q.Get("since"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidReprocess, "since must be an RFC 3339 timestamp", nil)
+			return
+		}
+		until := time.Now().UTC()
+		if v := q.Get("until"); v != "" {
+			if until, err = time.Parse(time.RFC3339, v); err != nil {
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidReprocess, "until must be an RFC 3339 timestamp", nil)
+				return
+			}
+		}
+		dryRun := q.Get("dry_run") == "true"
+		source := q.Get("source")
+		if source == "" {
+			source = reprocessFromOriginal
+		}
+		if source != reprocessFromOriginal && source != reprocessFromURL {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidReprocess, `source must be "original" or "url"`, nil)
+			return
+		}
+		// Re-storing the original from its own stored copy would gain nothing
+		if processingType == "original" {
+			source = reprocessFromURL
+		}
+		priority := 0
+		if v := q.Get("priority"); v != "" {
+			if priority, err = strconv.Atoi(v); err != nil || priority < 0 || priority > deps.rabbitMQ.MaxPriority {
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidReprocess,
+					fmt.Sprintf("priority must be between 0 and %d", deps.rabbitMQ.MaxPriority), nil)
+				return
+			}
+		}
+		var after *models.ReprocessCandidate
+		if v := q.Get("cursor"); v != "" {
+			if after, err = decodeReprocessCursor(v); err != nil {
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidReprocess, "cursor must be a next_cursor from an earlier response", nil)
+				return
+			}
+		}
+
+		// One extra candidate tells whether another page follows
+		candidates, matched, err := deps.reprocess.ReprocessCandidates(ctx, processingType, since, until, after, deps.maxReprocessed+1)
+		if err != nil {
+			log.Printf("Failed to select images to reprocess: %v", err)
+			writeError(w, http.StatusInternalServerError, traceID, ErrCodeQueryFailed, "failed to select images", nil)
+			return
+		}
+		more := len(candidates) > deps.maxReprocessed
+		if more {
+			candidates = candidates[:deps.maxReprocessed]
+		}
+
+		resp := ReprocessResponse{
+			TraceID:        traceID,
+			ProcessingType: processingType,
+			Since:          since,
+			Until:          until,
+			DryRun:         dryRun,
+			Priority:       priority,
+			Source:         source,
+			Matched:        matched,
+		}
+		if source == reprocessFromOriginal {
+			for _, c := range candidates {
+				if c.Original != "" {
+					resp.FromOriginal++
+				}
+			}
+		}
+		if !dryRun {
+			for _, c := range candidates {
+				// Outputs go back to the bucket the records were stored in,
+				// owned, and charged to, the records' owner
+				job := models.ImageJob{URLs: []string{c.SourceURL}, ProcessingTypes: []string{processingType}, Priority: priority, Bucket: c.Bucket, OwnerID: c.OwnerID}
+				// and are made with the params the records were made with
+				if c.Params != "" {
+					var params models.ProcessingParams
+					if err := json.Unmarshal([]byte(c.Params), &params); err != nil {
+						log.Printf("Skipping reprocess of %s with unreadable params %s: %v", c.SourceURL, c.Params, err)
+						resp.MissingParams++
+						continue
+					}
+					job.Params = map[string]models.ProcessingParams{processingType: params}
+				} else if _, ok := requiredParams[processingType]; ok {
+					resp.MissingParams++
+					continue
+				}
+				if source == reprocessFromOriginal {
+					job.Original = c.Original
+				}
+				if err := publishJob(ctx, deps.jobs, deps.rabbitMQ.QueueForPriority(priority), traceID, job, message.Reply{}); err != nil {
+					log.Printf("Failed to publish reprocess job for %s: %v", c.SourceURL, err)
+					writeError(w, http.StatusInternalServerError, traceID, ErrCodePublishFailed, "failed to enqueue jobs",
+						map[string]interface{}{"queued": resp.Queued})
+					return
+				}
+				resp.Queued++
+			}
+			log.Printf("Reprocessing %d of %d %s images for %s [%s]", resp.Queued, matched, processingType, caller, traceID)
+		}
+		if more {
+			resp.NextCursor = encodeReprocessCursor(candidates[len(candidates)-1])
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !dryRun {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	return r
+}
+
+// readOwner picks the owner whose records a read may see, "" for all of them,
+// from the owner_id query parameter and the X-API-Key. Owner names are
+// lowercased like the SUBMIT_API_KEYS they come from. Owners only see their
+// own records; anyone else needs an admin key to pick an owner, and any key
+// at all once owners are configured. It writes the error and returns false
+// when the caller may not read.
+func readOwner(w http.ResponseWriter, r *http.Request, traceID string, deps *metadataDeps) (string, bool) {
+	owner := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("owner_id")))
+	apiKey := r.Header.Get("X-API-Key")
+	if caller, ok := keyOwner(deps.owners, apiKey); ok {
+		if owner != "" && owner != caller {
+			writeError(w, http.StatusForbidden, traceID, ErrCodeOwnerNotAllowed, "owners may only read their own images", nil)
+			return "", false
+		}
+		return caller, true
+	}
+	if _, admin := keyOwner(deps.admin.APIKeys, apiKey); !admin && (owner != "" || len(deps.owners) > 0) {
+		writeError(w, http.StatusUnauthorized, traceID, ErrCodeUnauthorized, "a valid X-API-Key is required", nil)
+		return "", false
+	}
+	return owner, true
+}
+
+// uniqueTraceIDs trims trace IDs and drops blanks and repeats, preserving order
+func uniqueTraceIDs(ids []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		unique = append(unique, id)
+	}
+	return unique
+}
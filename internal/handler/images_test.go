@@ -0,0 +1,693 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/handler/testutil"
+	"image-processing-system/internal/models"
+)
+
+// fakeImageStore serves fixed records and remembers what was requested
+type fakeImageStore struct {
+	records  []models.ImageRecord
+	sources  []models.SourceCount
+	statuses map[string]models.TraceStatus
+	err      error
+	limit    int
+	offset   int
+	owner    string
+	traceIDs []string
+	// ownedBy names the owner of source URLs and trace IDs, whose rows
+	// other owners don't see
+	ownedBy map[string]string
+}
+
+func (f *fakeImageStore) SourceCounts(ctx context.Context, limit, offset int, ownerID string) ([]models.SourceCount, int64, error) {
+	f.limit, f.offset, f.owner = limit, offset, ownerID
+	var sources []models.SourceCount
+	for _, s := range f.sources {
+		if ownerID == "" || f.ownedBy[s.SourceURL] == ownerID {
+			sources = append(sources, s)
+		}
+	}
+	return sources, int64(len(sources)), f.err
+}
+
+func (f *fakeImageStore) GetImageRecords(limit int, ownerID string) ([]models.ImageRecord, error) {
+	f.limit, f.owner = limit, ownerID
+	return f.records, f.err
+}
+
+func (f *fakeImageStore) TraceStatuses(ctx context.Context, traceIDs []string, ownerID string) (map[string]models.TraceStatus, error) {
+	f.traceIDs, f.owner = traceIDs, ownerID
+	if f.statuses == nil {
+		return nil, f.err
+	}
+	statuses := make(map[string]models.TraceStatus, len(f.statuses))
+	for id, s := range f.statuses {
+		if ownerID != "" && f.ownedBy[id] != ownerID {
+			s = models.TraceStatus{Status: models.TraceStatusNotFound}
+		}
+		statuses[id] = s
+	}
+	return statuses, f.err
+}
+
+func TestListImagesIncludesPalette(t *testing.T) {
+	store := &fakeImageStore{records: []models.ImageRecord{{
+		ID:             1,
+		SourceURL:      "http://example.com/a.jpg",
+		ProcessingType: "palette",
+		Palette:        json.RawMessage(`["#c80a0a","#0a0ac8"]`),
+	}}}
+	router := NewMetadataRouter(store)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images?limit=10", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if store.limit != 10 {
+		t.Errorf("expected limit 10 to reach the store, got %d", store.limit)
+	}
+	var resp struct {
+		Images []struct {
+			ProcessingType string   `json:"processing_type"`
+			Palette        []string `json:"palette"`
+		} `json:"images"`
+		Count int `json:"count"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Count != 1 || len(resp.Images[0].Palette) != 2 || resp.Images[0].Palette[0] != "#c80a0a" {
+		t.Errorf("unexpected response: %s", rr.Body.String())
+	}
+}
+
+func TestListImagesByOwner(t *testing.T) {
+	owners := map[string]string{"acme": "acme-key", "globex": "globex-key"}
+	admin := config.AdminConfig{APIKeys: map[string]string{"alice": "admin-key"}}
+	tests := []struct {
+		name       string
+		owners     map[string]string
+		query      string
+		apiKey     string
+		wantStatus int
+		wantOwner  string
+	}{
+		{"no owners configured", nil, "", "", http.StatusOK, ""},
+		{"owner filter needs a key", nil, "?owner_id=acme", "", http.StatusUnauthorized, ""},
+		{"admin picks an owner", nil, "?owner_id=Acme", "admin-key", http.StatusOK, "acme"},
+		{"owners configured need a key", owners, "", "", http.StatusUnauthorized, ""},
+		{"unknown key", owners, "", "nope", http.StatusUnauthorized, ""},
+		{"owner sees its own", owners, "", "acme-key", http.StatusOK, "acme"},
+		{"owner names itself", owners, "?owner_id=acme", "acme-key", http.StatusOK, "acme"},
+		{"owner names another", owners, "?owner_id=globex", "acme-key", http.StatusForbidden, ""},
+		{"admin lists everyone", owners, "", "admin-key", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeImageStore{}
+			req := httptest.NewRequest(http.MethodGet, "/images"+tt.query, nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			NewMetadataRouter(store, WithOwners(tt.owners, admin)).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if store.owner != tt.wantOwner {
+				t.Errorf("expected owner %q to reach the store, got %q", tt.wantOwner, store.owner)
+			}
+		})
+	}
+}
+
+func TestListImagesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		store  *fakeImageStore
+		query  string
+		status int
+		code   string
+	}{
+		{"limit too large", &fakeImageStore{}, "?limit=1000", http.StatusBadRequest, ErrCodeInvalidLimit},
+		{"limit not a number", &fakeImageStore{}, "?limit=abc", http.StatusBadRequest, ErrCodeInvalidLimit},
+		{"store failure", &fakeImageStore{err: errors.New("db down")}, "", http.StatusInternalServerError, ErrCodeQueryFailed},
+		{"sources limit too small", &fakeImageStore{}, "/sources?limit=0", http.StatusBadRequest, ErrCodeInvalidLimit},
+		{"sources negative offset", &fakeImageStore{}, "/sources?offset=-1", http.StatusBadRequest, ErrCodeInvalidLimit},
+		{"sources store failure", &fakeImageStore{err: errors.New("db down")}, "/sources", http.StatusInternalServerError, ErrCodeQueryFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			NewMetadataRouter(tt.store).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images"+tt.query, nil))
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, rr.Code)
+			}
+			var body map[string]APIError
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["error"].Code != tt.code {
+				t.Errorf("expected code %s, got %s", tt.code, body["error"].Code)
+			}
+		})
+	}
+}
+
+func TestListSources(t *testing.T) {
+	store := &fakeImageStore{sources: []models.SourceCount{
+		{SourceURL: "http://example.com/a.jpg", Count: 7},
+		{SourceURL: "http://example.com/b.jpg", Count: 2},
+	}}
+
+	rr := httptest.NewRecorder()
+	NewMetadataRouter(store).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/sources?limit=2&offset=4", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if store.limit != 2 || store.offset != 4 {
+		t.Errorf("expected limit 2 and offset 4 to reach the store, got %d and %d", store.limit, store.offset)
+	}
+	var resp SourcesResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Count != 2 || resp.Total != 2 || resp.Offset != 4 || resp.Sources[0].Count != 7 || resp.Sources[0].SourceURL != "http://example.com/a.jpg" {
+		t.Errorf("unexpected response: %s", rr.Body.String())
+	}
+}
+
+func TestListSourcesByOwner(t *testing.T) {
+	owners := map[string]string{"acme": "acme-key", "globex": "globex-key"}
+	admin := config.AdminConfig{APIKeys: map[string]string{"alice": "admin-key"}}
+	tests := []struct {
+		name       string
+		query      string
+		apiKey     string
+		wantStatus int
+		wantURLs   []string
+	}{
+		{"no key", "", "", http.StatusUnauthorized, nil},
+		{"unknown key", "", "nope", http.StatusUnauthorized, nil},
+		{"owner sees its own", "", "acme-key", http.StatusOK, []string{"http://example.com/a.jpg"}},
+		{"owner names another", "?owner_id=globex", "acme-key", http.StatusForbidden, nil},
+		{"admin sees everyone", "", "admin-key", http.StatusOK, []string{"http://example.com/a.jpg", "http://example.com/b.jpg"}},
+		{"admin picks an owner", "?owner_id=globex", "admin-key", http.StatusOK, []string{"http://example.com/b.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeImageStore{
+				sources: []models.SourceCount{
+					{SourceURL: "http://example.com/a.jpg", Count: 3},
+					{SourceURL: "http://example.com/b.jpg", Count: 2},
+				},
+				ownedBy: map[string]string{"http://example.com/a.jpg": "acme", "http://example.com/b.jpg": "globex"},
+			}
+			req := httptest.NewRequest(http.MethodGet, "/images/sources"+tt.query, nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			NewMetadataRouter(store, WithOwners(owners, admin)).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp SourcesResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var urls []string
+			for _, s := range resp.Sources {
+				urls = append(urls, s.SourceURL)
+			}
+			if fmt.Sprint(urls) != fmt.Sprint(tt.wantURLs) || resp.Total != int64(len(tt.wantURLs)) {
+				t.Errorf("expected sources %q, got %s", tt.wantURLs, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestBulkJobStatus(t *testing.T) {
+	store := &fakeImageStore{statuses: map[string]models.TraceStatus{
+		"a": {Status: models.TraceStatusSucceeded, Total: 2, Succeeded: 2},
+		"b": {Status: models.TraceStatusNotFound},
+	}}
+
+	rr := httptest.NewRecorder()
+	NewMetadataRouter(store).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/jobs/status", strings.NewReader(`["a", " b ", "a", ""]`)))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(store.traceIDs) != 2 || store.traceIDs[0] != "a" || store.traceIDs[1] != "b" {
+		t.Errorf("expected trimmed, deduplicated IDs, got %q", store.traceIDs)
+	}
+	var resp map[string]models.TraceStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["a"].Succeeded != 2 || resp["b"].Status != models.TraceStatusNotFound {
+		t.Errorf("unexpected response: %s", rr.Body.String())
+	}
+}
+
+func TestBulkJobStatusByOwner(t *testing.T) {
+	owners := map[string]string{"acme": "acme-key", "globex": "globex-key"}
+	admin := config.AdminConfig{APIKeys: map[string]string{"alice": "admin-key"}}
+	tests := []struct {
+		name       string
+		apiKey     string
+		wantStatus int
+		wantA      string
+		wantB      string
+	}{
+		{"no key", "", http.StatusUnauthorized, "", ""},
+		{"owner sees only its own traces", "acme-key", http.StatusOK, models.TraceStatusSucceeded, models.TraceStatusNotFound},
+		{"other owner", "globex-key", http.StatusOK, models.TraceStatusNotFound, models.TraceStatusFailed},
+		{"admin sees every trace", "admin-key", http.StatusOK, models.TraceStatusSucceeded, models.TraceStatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeImageStore{
+				statuses: map[string]models.TraceStatus{
+					"a": {Status: models.TraceStatusSucceeded, Total: 1, Succeeded: 1},
+					"b": {Status: models.TraceStatusFailed, Total: 1, Failed: 1},
+				},
+				ownedBy: map[string]string{"a": "acme", "b": "globex"},
+			}
+			req := httptest.NewRequest(http.MethodPost, "/jobs/status", strings.NewReader(`["a", "b"]`))
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			NewMetadataRouter(store, WithOwners(owners, admin)).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if store.traceIDs != nil {
+					t.Error("expected the store not to be queried")
+				}
+				return
+			}
+			var resp map[string]models.TraceStatus
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp["a"].Status != tt.wantA || resp["b"].Status != tt.wantB {
+				t.Errorf("expected a %s and b %s, got %s", tt.wantA, tt.wantB, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestBulkJobStatusRejectsBadInput(t *testing.T) {
+	tooMany := make([]string, maxStatusTraceIDs+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("%q", fmt.Sprint("trace-", i))
+	}
+
+	tests := map[string]struct {
+		body string
+		code string
+	}{
+		"not an array": {`{"ids": ["a"]}`, ErrCodeInvalidJSON},
+		"empty":        {`[]`, ErrCodeInvalidTraceIDs},
+		"too many":     {"[" + strings.Join(tooMany, ",") + "]", ErrCodeInvalidTraceIDs},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := &fakeImageStore{}
+			rr := httptest.NewRecorder()
+			NewMetadataRouter(store).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/jobs/status", strings.NewReader(tt.body)))
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d", rr.Code)
+			}
+			var body map[string]APIError
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["error"].Code != tt.code {
+				t.Errorf("expected code %s, got %s", tt.code, body["error"].Code)
+			}
+			if store.traceIDs != nil {
+				t.Error("expected the store not to be queried")
+			}
+		})
+	}
+}
+
+// fakeReprocessStore returns fixed candidates, already in cursor order, and
+// records the query
+type fakeReprocessStore struct {
+	candidates     []models.ReprocessCandidate
+	matched        int64
+	processingType string
+	limit          int
+}
+
+func (f *fakeReprocessStore) ReprocessCandidates(ctx context.Context, processingType string, since, until time.Time, after *models.ReprocessCandidate, limit int) ([]models.ReprocessCandidate, int64, error) {
+	f.processingType = processingType
+	f.limit = limit
+	candidates := f.candidates
+	if after != nil {
+		for i, c := range candidates {
+			if candidateAfter(c, *after) {
+				candidates = candidates[i:]
+				break
+			}
+			if i == len(candidates)-1 {
+				candidates = nil
+			}
+		}
+	}
+	if len(candidates) > limit {
+		return candidates[:limit], f.matched, nil
+	}
+	return candidates, f.matched, nil
+}
+
+// candidateAfter reports whether c sorts after cursor, as the store orders
+// candidates
+func candidateAfter(c, cursor models.ReprocessCandidate) bool {
+	if !c.ProcessedAt.Equal(cursor.ProcessedAt) {
+		return c.ProcessedAt.After(cursor.ProcessedAt)
+	}
+	if c.SourceURL != cursor.SourceURL {
+		return c.SourceURL > cursor.SourceURL
+	}
+	if c.Bucket != cursor.Bucket {
+		return c.Bucket > cursor.Bucket
+	}
+	if c.OwnerID != cursor.OwnerID {
+		return c.OwnerID > cursor.OwnerID
+	}
+	return c.Params > cursor.Params
+}
+
+// reprocessQueues routes reprocess jobs to "jobs" unless lanes are set
+var reprocessQueues = config.RabbitMQConfig{JobQueue: "jobs", MaxPriority: 10}
+
+// reprocessAdmin is the operator key the reprocess tests authenticate with
+var reprocessAdmin = config.AdminConfig{APIKeys: map[string]string{"alice": "admin-key"}}
+
+// reprocessRequest builds a POST /reprocess request with the admin key
+func reprocessRequest(query string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/reprocess"+query, nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	return req
+}
+
+func newReprocessFixture() *fakeReprocessStore {
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	return &fakeReprocessStore{
+		candidates: []models.ReprocessCandidate{
+			{SourceURL: "http://example.com/a.jpg", ProcessedAt: base, Bucket: "images", Original: "s3://images/original/a.jpg"},
+			{SourceURL: "http://example.com/b.jpg", ProcessedAt: base.Add(time.Hour), Bucket: "images"},
+			{SourceURL: "http://example.com/c.jpg", ProcessedAt: base.Add(2 * time.Hour), Bucket: "tenant-a", OwnerID: "acme"},
+		},
+		matched: 3,
+	}
+}
+
+func TestReprocessEnqueuesUpToCap(t *testing.T) {
+	store := newReprocessFixture()
+	ch := &testutil.Channel{}
+	router := NewMetadataRouter(&fakeImageStore{}, WithReprocessing(store, ch, reprocessQueues, 2, reprocessAdmin))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, reprocessRequest("?processing_type=Blur&since=2024-05-01T00:00:00Z"))
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if store.processingType != "blur" || store.limit != 3 {
+		t.Errorf("unexpected query: type %q, limit %d", store.processingType, store.limit)
+	}
+
+	_, jobs := ch.Jobs(t)
+	if len(jobs) != 2 || jobs[0].URLs[0] != "http://example.com/a.jpg" || jobs[1].ProcessingTypes[0] != "blur" {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+
+	var resp ReprocessResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Matched != 3 || resp.Queued != 2 || resp.NextCursor == "" {
+		t.Fatalf("unexpected response: %s", rr.Body.String())
+	}
+
+	// The cursor continues after the last job, so nothing is enqueued twice
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, reprocessRequest("?processing_type=blur&since=2024-05-01T00:00:00Z&cursor="+resp.NextCursor))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	_, jobs = ch.Jobs(t)
+	if len(jobs) != 3 || jobs[2].URLs[0] != "http://example.com/c.jpg" {
+		t.Fatalf("expected the second page to enqueue only c.jpg, got %+v", jobs)
+	}
+	resp = ReprocessResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Queued != 1 || resp.NextCursor != "" {
+		t.Errorf("unexpected last page: %s", rr.Body.String())
+	}
+}
+
+func TestReprocessCursorSharesTimestamps(t *testing.T) {
+	// Candidates first processed at the same time are told apart by URL,
+	// then bucket, then owner
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeReprocessStore{
+		candidates: []models.ReprocessCandidate{
+			{SourceURL: "http://example.com/a.jpg", ProcessedAt: base},
+			{SourceURL: "http://example.com/b.jpg", ProcessedAt: base, Bucket: "images"},
+			{SourceURL: "http://example.com/b.jpg", ProcessedAt: base, Bucket: "images", OwnerID: "acme"},
+			{SourceURL: "http://example.com/b.jpg", ProcessedAt: base, Bucket: "tenant-a"},
+		},
+		matched: 4,
+	}
+	ch := &testutil.Channel{}
+	router := NewMetadataRouter(&fakeImageStore{}, WithReprocessing(store, ch, reprocessQueues, 1, reprocessAdmin))
+
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, reprocessRequest("?processing_type=blur&since=2024-05-01T00:00:00Z&cursor="+cursor))
+		var resp ReprocessResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if cursor = resp.NextCursor; cursor == "" {
+			break
+		}
+	}
+	_, jobs := ch.Jobs(t)
+	if len(jobs) != 4 || jobs[0].URLs[0] != "http://example.com/a.jpg" || jobs[1].OwnerID != "" || jobs[2].OwnerID != "acme" || jobs[3].Bucket != "tenant-a" {
+		t.Errorf("expected each URL, bucket and owner enqueued once, got %+v", jobs)
+	}
+}
+
+func TestReprocessCarriesParams(t *testing.T) {
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeReprocessStore{
+		candidates: []models.ReprocessCandidate{
+			{SourceURL: "http://example.com/a.jpg", ProcessedAt: base, Params: `{"format":"webp"}`},
+			{SourceURL: "http://example.com/a.jpg", ProcessedAt: base, Params: `{"format":"png"}`},
+			// Made before params were stored, so there is nothing to convert to
+			{SourceURL: "http://example.com/b.jpg", ProcessedAt: base.Add(time.Hour)},
+		},
+		matched: 3,
+	}
+	ch := &testutil.Channel{}
+	router := NewMetadataRouter(&fakeImageStore{}, WithReprocessing(store, ch, reprocessQueues, 10, reprocessAdmin))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, reprocessRequest("?processing_type=convert&since=2024-05-01T00:00:00Z"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	_, jobs := ch.Jobs(t)
+	if len(jobs) != 2 || jobs[0].Params["convert"].Format != "webp" || jobs[1].Params["convert"].Format != "png" {
+		t.Errorf("expected one job per stored params, got %+v", jobs)
+	}
+	var resp ReprocessResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Queued != 2 || resp.MissingParams != 1 {
+		t.Errorf("expected 2 queued and 1 missing params, got %+v", resp)
+	}
+}
+
+func TestReprocessPriorityPicksLane(t *testing.T) {
+	queues := config.RabbitMQConfig{JobQueue: "image.urls", Lanes: "image.urls.fast:5:3,image.urls.bulk:0:1", MaxPriority: 10}
+	for priority, want := range map[string]string{"": "image.urls.bulk", "7": "image.urls.fast"} {
+		ch := &testutil.Channel{}
+		router := NewMetadataRouter(&fakeImageStore{}, WithReprocessing(newReprocessFixture(), ch, queues, 10, reprocessAdmin))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, reprocessRequest("?processing_type=blur&since=2024-05-01T00:00:00Z&priority="+priority))
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("priority %q: expected 202, got %d: %s", priority, rr.Code, rr.Body.String())
+		}
+		for _, pub := range ch.Published() {
+			if pub.Key != want {
+				t.Errorf("priority %q: expected jobs on %s, got %s", priority, want, pub.Key)
+			}
+		}
+	}
+}
+
+func TestReprocessRequiresAdminKey(t *testing.T) {
+	for _, key := range []string{"", "wrong-key"} {
+		ch := &testutil.Channel{}
+		router := NewMetadataRouter(&fakeImageStore{}, WithReprocessing(newReprocessFixture(), ch, reprocessQueues, 10, reprocessAdmin))
+
+		req := httptest.NewRequest(http.MethodPost, "/reprocess?processing_type=blur&since=2024-05-01T00:00:00Z", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("key %q: expected 401, got %d", key, rr.Code)
+		}
+		if len(ch.Published()) != 0 {
+			t.Errorf("key %q: expected nothing enqueued", key)
+		}
+	}
+}
+
+func TestReprocessSource(t *testing.T) {
+	tests := []struct {
+		query            string
+		wantOriginal     string
+		wantFromOriginal int
+	}{
+		{"processing_type=blur", "s3://images/original/a.jpg", 1},
+		{"processing_type=blur&source=url", "", 0},
+		{"processing_type=original", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			ch := &testutil.Channel{}
+			router := NewMetadataRouter(&fakeImageStore{}, WithReprocessing(newReprocessFixture(), ch, reprocessQueues, 10, reprocessAdmin))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, reprocessRequest("?since=2024-05-01T00:00:00Z&"+tt.query))
+			if rr.Code != http.StatusAccepted {
+				t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			_, jobs := ch.Jobs(t)
+			if len(jobs) != 3 || jobs[0].Original != tt.wantOriginal || jobs[1].Original != "" {
+				t.Errorf("unexpected jobs: %+v", jobs)
+			}
+			// Outputs go back to the records' buckets, where the worker can
+			// also read the original from
+			if jobs[0].Bucket != "images" || jobs[2].Bucket != "tenant-a" {
+				t.Errorf("expected the records' buckets, got %q and %q", jobs[0].Bucket, jobs[2].Bucket)
+			}
+			// and are owned by, and charged to, the records' owner
+			if jobs[0].OwnerID != "" || jobs[2].OwnerID != "acme" {
+				t.Errorf("expected the records' owners, got %q and %q", jobs[0].OwnerID, jobs[2].OwnerID)
+			}
+			var resp ReprocessResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.FromOriginal != tt.wantFromOriginal {
+				t.Errorf("from_original = %d, want %d", resp.FromOriginal, tt.wantFromOriginal)
+			}
+		})
+	}
+}
+
+func TestReprocessDryRun(t *testing.T) {
+	ch := &testutil.Channel{}
+	router := NewMetadataRouter(&fakeImageStore{}, WithReprocessing(newReprocessFixture(), ch, reprocessQueues, 10, reprocessAdmin))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, reprocessRequest("?processing_type=blur&since=2024-05-01T00:00:00Z&dry_run=true"))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(ch.Published()) != 0 {
+		t.Errorf("expected a dry run to publish nothing, got %d", len(ch.Published()))
+	}
+	var resp ReprocessResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.DryRun || resp.Matched != 3 || resp.Queued != 0 || resp.NextCursor != "" {
+		t.Errorf("unexpected response: %s", rr.Body.String())
+	}
+}
+
+func TestReprocessRejectsBadRequests(t *testing.T) {
+	tests := map[string]struct {
+		query  string
+		opts   []MetadataRouterOption
+		status int
+		code   string
+	}{
+		"unknown type":   {"?processing_type=sepia&since=2024-05-01T00:00:00Z", nil, http.StatusBadRequest, ErrCodeInvalidReprocess},
+		"missing since":  {"?processing_type=blur", nil, http.StatusBadRequest, ErrCodeInvalidReprocess},
+		"bad until":      {"?processing_type=blur&since=2024-05-01T00:00:00Z&until=yesterday", nil, http.StatusBadRequest, ErrCodeInvalidReprocess},
+		"bad source":     {"?processing_type=blur&since=2024-05-01T00:00:00Z&source=cache", nil, http.StatusBadRequest, ErrCodeInvalidReprocess},
+		"bad priority":   {"?processing_type=blur&since=2024-05-01T00:00:00Z&priority=11", nil, http.StatusBadRequest, ErrCodeInvalidReprocess},
+		"bad cursor":     {"?processing_type=blur&since=2024-05-01T00:00:00Z&cursor=not-a-cursor", nil, http.StatusBadRequest, ErrCodeInvalidReprocess},
+		"not configured": {"?processing_type=blur&since=2024-05-01T00:00:00Z", []MetadataRouterOption{}, http.StatusServiceUnavailable, ErrCodeReprocessUnavailable},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := tt.opts
+			if opts == nil {
+				opts = []MetadataRouterOption{WithReprocessing(newReprocessFixture(), &testutil.Channel{}, reprocessQueues, 10, reprocessAdmin)}
+			}
+			rr := httptest.NewRecorder()
+			NewMetadataRouter(&fakeImageStore{}, opts...).ServeHTTP(rr, reprocessRequest(tt.query))
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, rr.Code)
+			}
+			var body map[string]APIError
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["error"].Code != tt.code {
+				t.Errorf("expected code %s, got %s", tt.code, body["error"].Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/service/quota"
+)
+
+// QuotaStore reports the bytes of stored outputs each owner holds
+type QuotaStore interface {
+	BytesUsed(ctx context.Context, ownerID string) (int64, error)
+}
+
+// WithQuotas enforces the storage quotas in QUOTA_BYTES at /submit and
+// enables GET /quota for the owners listed in SUBMIT_API_KEYS
+func WithQuotas(store QuotaStore) RouterOption {
+	return func(d *routerDeps) {
+		d.quotas = store
+	}
+}
+
+// quotaExceeded reports whether an owner has used up its quota, with the
+// bytes it holds and its limit. Owners without a limit are never over, and
+// a failed lookup lets the submission through.
+func quotaExceeded(ctx context.Context, store QuotaStore, cfg config.QuotaConfig, owner string) (used, limit int64, exceeded bool) {
+	limit = int64(cfg.Bytes[owner])
+	if store == nil || limit <= 0 {
+		return 0, limit, false
+	}
+	used, err := store.BytesUsed(ctx, owner)
+	if err != nil {
+		log.Printf("Failed to look up the storage used by %s, allowing the submission: %v", owner, err)
+		return 0, limit, false
+	}
+	return used, limit, quota.Exceeded(used, limit)
+}
+
+// quotaHandler serves GET /quota, which reports the bytes of stored outputs
+// the caller's owner holds against its limit, 0 meaning unlimited
+func quotaHandler(cfg *config.URLIngestorConfig, store QuotaStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		traceID := requestTraceID(r.Context(), r)
+		owner, ok := keyOwner(cfg.Submit.APIKeys, r.Header.Get("X-API-Key"))
+		if !ok {
+			writeError(w, http.StatusUnauthorized, traceID, ErrCodeUnauthorized, "a valid X-API-Key is required", nil)
+			return
+		}
+		if store == nil {
+			writeError(w, http.StatusServiceUnavailable, traceID, ErrCodeQuotaUnavailable, "quotas not available", nil)
+			return
+		}
+
+		used, err := store.BytesUsed(r.Context(), owner)
+		if err != nil {
+			log.Printf("Failed to look up the storage used by %s: %v", owner, err)
+			writeError(w, http.StatusInternalServerError, traceID, ErrCodeQueryFailed, "quota lookup failed", nil)
+			return
+		}
+
+		limit := int64(cfg.Quota.Bytes[owner])
+		body := map[string]interface{}{
+			"owner_id":    owner,
+			"used_bytes":  used,
+			"limit_bytes": limit,
+		}
+		if remaining, limited := quota.Remaining(used, limit); limited {
+			body["remaining_bytes"] = remaining
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/handler/testutil"
+	"image-processing-system/internal/models"
+	"image-processing-system/pkg/message"
+)
+
+// fakeQuotas reports fixed usage by owner
+type fakeQuotas struct {
+	used map[string]int64
+	err  error
+}
+
+func (f fakeQuotas) BytesUsed(ctx context.Context, ownerID string) (int64, error) {
+	return f.used[ownerID], f.err
+}
+
+func quotaConfig() *config.URLIngestorConfig {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.Submit.APIKeys = map[string]string{"acme": "acme-secret", "free": "free-secret", "open": "open-secret"}
+	cfg.Quota = config.QuotaConfig{Bytes: map[string]int{"acme": 1000, "free": 1000}}
+	return cfg
+}
+
+func TestSubmitEnforcesQuota(t *testing.T) {
+	cfg := quotaConfig()
+
+	tests := []struct {
+		name       string
+		apiKey     string
+		store      QuotaStore
+		wantStatus int
+		wantOwner  string
+		wantCode   string
+	}{
+		{"under quota", "acme-secret", fakeQuotas{used: map[string]int64{"acme": 999}}, http.StatusAccepted, "acme", ""},
+		{"over quota", "free-secret", fakeQuotas{used: map[string]int64{"free": 1000}}, http.StatusForbidden, "", ErrCodeQuotaExceeded},
+		{"no limit", "open-secret", fakeQuotas{used: map[string]int64{"open": 1 << 40}}, http.StatusAccepted, "open", ""},
+		{"lookup fails open", "free-secret", fakeQuotas{err: errors.New("db down")}, http.StatusAccepted, "free", ""},
+		// Anonymous submissions would escape every quota
+		{"unknown key", "guess", fakeQuotas{}, http.StatusUnauthorized, "", ErrCodeUnauthorized},
+		{"no key", "", fakeQuotas{}, http.StatusUnauthorized, "", ErrCodeUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &testutil.Channel{}
+			router := NewRouter(ch, cfg, WithQuotas(tt.store))
+
+			// A client can't claim another owner through the body
+			body := `{"urls":["http://example.com/a.jpg"],"owner_id":"acme"}`
+			req := httptest.NewRequest(http.MethodPost, "/submit", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body)
+			}
+			if tt.wantStatus != http.StatusAccepted {
+				var body map[string]APIError
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				if body["error"].Code != tt.wantCode {
+					t.Errorf("expected code %s, got %s", tt.wantCode, body["error"].Code)
+				}
+				if len(ch.Published()) != 0 {
+					t.Errorf("expected nothing to be published, got %d jobs", len(ch.Published()))
+				}
+				return
+			}
+
+			for _, p := range ch.Published() {
+				env, job, err := message.Decode[models.ImageJob](p.Msg.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if job.OwnerID != tt.wantOwner || env.OwnerID != tt.wantOwner {
+					t.Errorf("expected the job and envelope to carry owner %q, got %q and %q", tt.wantOwner, job.OwnerID, env.OwnerID)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaEndpoint(t *testing.T) {
+	cfg := quotaConfig()
+	store := fakeQuotas{used: map[string]int64{"acme": 1200, "open": 50}}
+
+	tests := []struct {
+		name       string
+		apiKey     string
+		store      QuotaStore
+		wantStatus int
+		want       map[string]interface{}
+	}{
+		{"over quota", "acme-secret", store, http.StatusOK,
+			map[string]interface{}{"owner_id": "acme", "used_bytes": 1200.0, "limit_bytes": 1000.0, "remaining_bytes": 0.0}},
+		{"unlimited", "open-secret", store, http.StatusOK,
+			map[string]interface{}{"owner_id": "open", "used_bytes": 50.0, "limit_bytes": 0.0}},
+		{"missing key", "", store, http.StatusUnauthorized, nil},
+		{"no store", "acme-secret", nil, http.StatusServiceUnavailable, nil},
+		{"lookup fails", "acme-secret", fakeQuotas{err: errors.New("db down")}, http.StatusInternalServerError, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []RouterOption
+			if tt.store != nil {
+				opts = append(opts, WithQuotas(tt.store))
+			}
+			router := NewRouter(&testutil.Channel{}, cfg, opts...)
+
+			req := httptest.NewRequest(http.MethodGet, "/quota", nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body)
+			}
+			if tt.want == nil {
+				return
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body) != len(tt.want) {
+				t.Errorf("got %v, want %v", body, tt.want)
+			}
+			for k, v := range tt.want {
+				if body[k] != v {
+					t.Errorf("%s = %v, want %v", k, body[k], v)
+				}
+			}
+		})
+	}
+}
@@ -3,13 +3,25 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"image-processing-system/internal/config"
 	"image-processing-system/internal/middleware"
 	"image-processing-system/internal/models"
+	"image-processing-system/pkg/hostpolicy"
 	"image-processing-system/pkg/message"
+	"image-processing-system/pkg/metrics"
+	"image-processing-system/pkg/rabbitmq"
+	"image-processing-system/pkg/tracing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/httprate"
@@ -27,6 +39,29 @@ type ChannelInterface interface {
 	Close() error
 }
 
+// CancelStore records trace IDs whose pending jobs should be skipped
+type CancelStore interface {
+	Cancel(ctx context.Context, traceID, ownerID string) error
+}
+
+// RouterOption configures optional router dependencies
+type RouterOption func(*routerDeps)
+
+type routerDeps struct {
+	cancels   CancelStore
+	inspector QueueInspector
+	replies   ReplyWaiter
+	purger    QueuePurger
+	quotas    QuotaStore
+}
+
+// WithCancelStore enables job cancellation via POST /jobs/{traceID}/cancel
+func WithCancelStore(s CancelStore) RouterOption {
+	return func(d *routerDeps) {
+		d.cancels = s
+	}
+}
+
 var (
 	imagesSubmitted = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -37,66 +72,375 @@ var (
 )
 
 func init() {
-	prometheus.MustRegister(imagesSubmitted)
+	metrics.Add(imagesSubmitted)
 }
 
 // Allowed processing types for image jobs
 var allowedProcessingTypes = map[string]struct{}{
-	"original":  {},
-	"grayscale": {},
-	"resize":    {},
-	"blur":      {},
-	"sharpen":   {},
+	"original":    {},
+	"grayscale":   {},
+	"resize":      {},
+	"blur":        {},
+	"sharpen":     {},
+	"convert":     {},
+	"compress_to": {},
+	"palette":     {},
+	"blurhash":    {},
+	"auto":        {},
+}
+
+// outputFormats are the formats outputs can be encoded in, both job-wide and
+// as convert's target
+var outputFormats = map[string]struct{}{
+	"jpeg": {},
+	"png":  {},
+	"webp": {},
+	"avif": {},
 }
 
 // getAllowedProcessingTypes returns a slice of allowed processing types
 func getAllowedProcessingTypes() []string {
-	return []string{"original", "grayscale", "resize", "blur", "sharpen"}
+	return []string{"original", "grayscale", "resize", "blur", "sharpen", "convert", "compress_to", "palette", "blurhash", "auto"}
+}
+
+// normalizeProcessingTypes returns the canonical form of each processing type
+func normalizeProcessingTypes(types []string) []string {
+	normalized := make([]string, len(types))
+	for i, t := range types {
+		normalized[i] = models.NormalizeProcessingType(t)
+	}
+	return normalized
 }
 
 // validateProcessingTypes checks if all provided types are allowed
 func validateProcessingTypes(types []string) (invalid []string) {
 	for _, t := range types {
-		if _, ok := allowedProcessingTypes[t]; !ok {
+		if _, ok := allowedProcessingTypes[models.NormalizeProcessingType(t)]; !ok {
 			invalid = append(invalid, t)
 		}
 	}
 	return
 }
 
-// publishJob publishes a single job to the queue
-func publishJob(ctx context.Context, ch ChannelInterface, traceID string, url string, processingType string) error {
-	job := models.ImageJob{
-		URLs:            []string{url},
-		ProcessingTypes: []string{processingType},
+// dedupeProcessingTypes removes repeated processing types and the implicit
+// "original" (which is always published), preserving the client's order
+func dedupeProcessingTypes(types []string) []string {
+	seen := map[string]struct{}{"original": {}}
+	unique := make([]string, 0, len(types))
+	for _, t := range types {
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		unique = append(unique, t)
 	}
-	encoded, _ := message.Encode(traceID, "url-ingestor", job)
+	return unique
+}
+
+// presetNamePattern restricts preset names to characters safe for object keys
+var presetNamePattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// validateResizePresets checks that presets have unique, key-safe names and
+// valid dimensions within maxDimension, returning a description of each
+// problem found
+func validateResizePresets(presets []models.ResizePreset, maxDimension int) (problems []string) {
+	seen := make(map[string]struct{})
+	for _, p := range presets {
+		if !presetNamePattern.MatchString(p.Name) {
+			problems = append(problems, fmt.Sprintf("preset name %q must match %s", p.Name, presetNamePattern))
+		}
+		if _, ok := seen[p.Name]; ok {
+			problems = append(problems, fmt.Sprintf("duplicate preset name %q", p.Name))
+		}
+		seen[p.Name] = struct{}{}
+		if p.Width < 0 || p.Height < 0 || (p.Width == 0 && p.Height == 0) {
+			problems = append(problems, fmt.Sprintf("preset %q needs a positive width or height", p.Name))
+		}
+		if p.Width > maxDimension || p.Height > maxDimension {
+			problems = append(problems, fmt.Sprintf("preset %q width and height must be at most %d, got %dx%d", p.Name, maxDimension, p.Width, p.Height))
+		}
+	}
+	return
+}
+
+// minCompressBytes is the smallest compress_to target; below it even tiny
+// JPEGs are mostly headers
+const minCompressBytes = 1024
 
-	// Inject trace context into headers
-	prop := propagation.TraceContext{}
-	headers := make(map[string]string)
-	prop.Inject(ctx, propagation.MapCarrier(headers))
-	if tp, ok := headers["traceparent"]; ok {
-		log.Printf("[ingestor] Injecting traceparent: %s", tp)
+// normalizeParams returns params keyed by canonical processing type
+func normalizeParams(params map[string]models.ProcessingParams) map[string]models.ProcessingParams {
+	if len(params) == 0 {
+		return nil
 	}
+	normalized := make(map[string]models.ProcessingParams, len(params))
+	for t, p := range params {
+		p.Format = strings.ToLower(strings.TrimSpace(p.Format))
+		normalized[models.NormalizeProcessingType(t)] = p
+	}
+	return normalized
+}
+
+// requiredParams lists the processing types that can't run without params,
+// with the problem reported when a submission leaves them out
+var requiredParams = map[string]string{
+	"convert":     "convert needs params.convert with format",
+	"compress_to": "compress_to needs params.compress_to with max_bytes",
+}
 
-	amqpHeaders := amqp.Table{}
-	for k, v := range headers {
-		amqpHeaders[k] = v
+// validateParams checks each processing type's params against what that type
+// takes, returning a description of each problem found. types are the
+// requested processing types; convert and compress_to need params, the
+// others have defaults. Sigma and resize dimensions must be within limits.
+func validateParams(params map[string]models.ProcessingParams, types []string, presets []models.ResizePreset, limits config.ParamLimitsConfig) (problems []string) {
+	requested := make(map[string]bool, len(types))
+	for _, t := range types {
+		requested[t] = true
+	}
+	for _, t := range types {
+		if problem, ok := requiredParams[t]; ok {
+			if _, given := params[t]; !given {
+				problems = append(problems, problem)
+			}
+		}
 	}
 
-	return ch.Publish("", "image.urls", false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        encoded,
-		Headers:     amqpHeaders,
-	})
+	names := make([]string, 0, len(params))
+	for t := range params {
+		names = append(names, t)
+	}
+	slices.Sort(names)
+	for _, t := range names {
+		p := params[t]
+		if !requested[t] {
+			problems = append(problems, fmt.Sprintf("params given for %q, which isn't in processing_types", t))
+			continue
+		}
+		switch t {
+		case "resize":
+			if !setsOnly(p, func(q *models.ProcessingParams) { q.Width, q.Height = 0, 0 }) {
+				problems = append(problems, "resize takes only w and h")
+			}
+			if p.Width < 0 || p.Height < 0 || (p.Width == 0 && p.Height == 0) {
+				problems = append(problems, "resize needs a positive w or h")
+			}
+			if p.Width > limits.MaxDimension || p.Height > limits.MaxDimension {
+				problems = append(problems, fmt.Sprintf("resize w and h must be at most %d, got %dx%d", limits.MaxDimension, p.Width, p.Height))
+			}
+			if len(presets) > 0 {
+				problems = append(problems, "resize params can't be combined with resize presets")
+			}
+		case "blur", "sharpen":
+			if !setsOnly(p, func(q *models.ProcessingParams) { q.Sigma = 0 }) {
+				problems = append(problems, fmt.Sprintf("%s takes only sigma", t))
+			}
+			if p.Sigma < 0 || p.Sigma > limits.MaxSigma {
+				problems = append(problems, fmt.Sprintf("%s sigma must be between 0 and %g, got %g", t, limits.MaxSigma, p.Sigma))
+			}
+		case "convert":
+			if !setsOnly(p, func(q *models.ProcessingParams) { q.Format = "" }) {
+				problems = append(problems, "convert takes only format")
+			}
+			if _, ok := outputFormats[p.Format]; !ok {
+				problems = append(problems, fmt.Sprintf("convert format must be jpeg, png, webp or avif, got %q", p.Format))
+			}
+		case "compress_to":
+			if !setsOnly(p, func(q *models.ProcessingParams) { q.MaxBytes, q.Downscale = 0, false }) {
+				problems = append(problems, "compress_to takes only max_bytes and downscale")
+			}
+			if p.MaxBytes < minCompressBytes {
+				problems = append(problems, fmt.Sprintf("compress_to max_bytes must be at least %d, got %d", minCompressBytes, p.MaxBytes))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s takes no params", t))
+		}
+	}
+	return
 }
 
-func NewRouter(ch ChannelInterface) http.Handler {
+// setsOnly reports whether p sets no fields besides the ones unset zeroes
+func setsOnly(p models.ProcessingParams, unset func(*models.ProcessingParams)) bool {
+	unset(&p)
+	return p == models.ProcessingParams{}
+}
+
+// rejectedURLs returns the URLs that aren't http(s), those whose host the
+// policy doesn't allow, and the minio:// sources that don't name an object in
+// one of sources' buckets under owner's prefix
+func rejectedURLs(policy *hostpolicy.Policy, sources config.SourceHostsConfig, owner string, urls []string) (rejected []string) {
+	for _, u := range urls {
+		if bucket, key, ok := config.ParseObjectSource(u); ok {
+			if key == "" || !sources.BucketAllowed(bucket) || !config.ObjectKeyOwned(key, owner) {
+				rejected = append(rejected, u)
+			}
+			continue
+		}
+		if scheme, _, _ := strings.Cut(u, "://"); !strings.EqualFold(scheme, "http") && !strings.EqualFold(scheme, "https") {
+			rejected = append(rejected, u)
+			continue
+		}
+		if err := policy.CheckURL(u); err != nil {
+			rejected = append(rejected, u)
+		}
+	}
+	return
+}
+
+// expandJobs fans a submission out into single-output jobs for one URL: the
+// implicit original, then each processing type. When presets are given,
+// resize produces one job per preset. Every job inherits the submission's
+// scheduling (priority, process_after), output format, bucket,
+// skip_existing and API key name, and the params of its processing types. Combined
+// submissions get a single job listing every output instead.
+func expandJobs(url string, submission models.ImageJob, processingTypes []string) []models.ImageJob {
+	newJob := func(pTypes ...string) models.ImageJob {
+		j := models.ImageJob{
+			URLs:            []string{url},
+			ProcessingTypes: pTypes,
+			Priority:        submission.Priority,
+			ProcessAfter:    submission.ProcessAfter,
+			Format:          submission.Format,
+			Bucket:          submission.Bucket,
+			SkipExisting:    submission.SkipExisting,
+			OwnerID:         submission.OwnerID,
+		}
+		for _, pType := range pTypes {
+			if p, ok := submission.Params[pType]; ok {
+				if j.Params == nil {
+					j.Params = make(map[string]models.ProcessingParams)
+				}
+				j.Params[pType] = p
+			}
+		}
+		return j
+	}
+
+	if submission.Combine {
+		pTypes := []string{"original"}
+		for _, pType := range processingTypes {
+			if pType == "resize" && len(submission.Resize) > 0 {
+				continue
+			}
+			pTypes = append(pTypes, pType)
+		}
+		if len(submission.Resize) > 0 {
+			pTypes = append(pTypes, "resize")
+		}
+		j := newJob(pTypes...)
+		j.Resize = submission.Resize
+		return []models.ImageJob{j}
+	}
+
+	jobs := []models.ImageJob{newJob("original")}
+	for _, pType := range processingTypes {
+		if pType == "resize" && len(submission.Resize) > 0 {
+			continue
+		}
+		jobs = append(jobs, newJob(pType))
+	}
+	for _, preset := range submission.Resize {
+		j := newJob("resize")
+		j.Resize = []models.ResizePreset{preset}
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+// SubmitURLSummary lists the work queued for one submitted URL
+type SubmitURLSummary struct {
+	URL             string   `json:"url"`
+	ProcessingTypes []string `json:"processing_types"`
+	ResizePresets   []string `json:"resize_presets,omitempty"`
+}
+
+// SubmitResponse is the body of a successful /submit
+type SubmitResponse struct {
+	TraceID    string             `json:"trace_id,omitempty"`
+	JobsQueued int                `json:"jobs_queued"`
+	URLs       []SubmitURLSummary `json:"urls"`
+}
+
+// summarizeJobs describes the jobs expanded from one URL, including the
+// implicit original, listing each processing type once
+func summarizeJobs(url string, jobs []models.ImageJob) SubmitURLSummary {
+	summary := SubmitURLSummary{URL: url, ProcessingTypes: []string{}}
+	seen := make(map[string]struct{})
+	for _, j := range jobs {
+		for _, pType := range j.ProcessingTypes {
+			if _, ok := seen[pType]; !ok {
+				seen[pType] = struct{}{}
+				summary.ProcessingTypes = append(summary.ProcessingTypes, pType)
+			}
+		}
+		for _, preset := range j.Resize {
+			summary.ResizePresets = append(summary.ResizePresets, preset.Name)
+		}
+	}
+	return summary
+}
+
+// publishJob publishes a single job to the queue. Jobs scheduled in the
+// future go to the queue's delay queue with a TTL matching the delay. A
+// non-zero reply address asks the worker to also send results there.
+func publishJob(ctx context.Context, ch ChannelInterface, queue string, traceID string, job models.ImageJob, reply message.Reply) error {
+	opts := rabbitmq.PublishOptions{
+		SubmittedAt: time.Now().UTC(),
+		Reply:       reply,
+		Priority:    uint8(job.Priority),
+		OwnerID:     job.OwnerID,
+	}
+	target := queue
+	if job.ProcessAfter != nil {
+		if delay := time.Until(*job.ProcessAfter); delay > 0 {
+			target = rabbitmq.DelayedQueue(queue)
+			opts.Expiration = strconv.FormatInt(delay.Milliseconds(), 10)
+		}
+	}
+	return rabbitmq.NewPublisher(ch).PublishWith(ctx, "", target, traceID, config.URLIngestorService, job, opts)
+}
+
+func NewRouter(ch ChannelInterface, cfg *config.URLIngestorConfig, opts ...RouterOption) http.Handler {
+	var deps routerDeps
+	for _, opt := range opts {
+		opt(&deps)
+	}
+
+	var guard *depthGuard
+	if deps.inspector != nil && cfg.Submit.MaxQueueDepth > 0 {
+		guard = &depthGuard{
+			inspector: deps.inspector,
+			queues:    cfg.RabbitMQ.JobQueueNames(),
+			maxDepth:  cfg.Submit.MaxQueueDepth,
+			interval:  cfg.Submit.DepthCheckInterval,
+		}
+	}
+
+	hostPolicy := cfg.SourceHosts.Policy()
+
 	r := chi.NewRouter()
 
-	// Add rate limiting middleware
-	r.Use(httprate.LimitByIP(50, 1)) // 50 req/sec
+	// Limit each client IP; the window has passed by the time Retry-After
+	// does, so a client that waits that long is let through
+	retryAfter := int(math.Ceil(cfg.RateLimit.Window.Seconds()))
+	r.Use(httprate.Limit(cfg.RateLimit.Requests, cfg.RateLimit.Window,
+		httprate.WithKeyFuncs(httprate.KeyByIP),
+		httprate.WithLimitHandler(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeError(w, http.StatusTooManyRequests, requestTraceID(r.Context(), r), ErrCodeRateLimited,
+				fmt.Sprintf("rate limit of %d requests per %s exceeded", cfg.RateLimit.Requests, cfg.RateLimit.Window),
+				map[string]interface{}{
+					"limit":               cfg.RateLimit.Requests,
+					"window":              cfg.RateLimit.Window.String(),
+					"retry_after_seconds": retryAfter,
+				})
+		}),
+	))
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, requestTraceID(r.Context(), r), ErrCodeNotFound, "no such endpoint", nil)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, requestTraceID(r.Context(), r), ErrCodeMethodNotAllowed, "method not allowed", nil)
+	})
 
 	// Add Prometheus metrics middleware
 	r.Use(middleware.MetricsMiddleware)
@@ -107,14 +451,13 @@ func NewRouter(ch ChannelInterface) http.Handler {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":    "healthy",
 			"timestamp": time.Now().UTC(),
-			"service":   "url-ingestor",
+			"service":   config.URLIngestorService,
+			"tracing":   tracing.Status(),
 		})
 	})
 
 	// Metrics endpoint - no middleware applied to avoid conflicts
-	r.Get("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		promhttp.Handler().ServeHTTP(w, r)
-	})
+	r.Handle("/metrics", middleware.MetricsAuth(cfg.Metrics, promhttp.Handler()))
 
 	// Status endpoint
 	r.Get("/status", func(w http.ResponseWriter, r *http.Request) {
@@ -126,7 +469,7 @@ func NewRouter(ch ChannelInterface) http.Handler {
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"service":   "url-ingestor",
+			"service":   config.URLIngestorService,
 			"status":    "running",
 			"timestamp": time.Now().UTC(),
 			"dependencies": map[string]string{
@@ -138,7 +481,7 @@ func NewRouter(ch ChannelInterface) http.Handler {
 	// Queue status endpoint
 	r.Get("/queue/status", func(w http.ResponseWriter, r *http.Request) {
 		if ch == nil || ch.IsClosed() {
-			http.Error(w, "RabbitMQ connection not available", http.StatusServiceUnavailable)
+			writeError(w, http.StatusServiceUnavailable, requestTraceID(r.Context(), r), ErrCodeQueueUnavailable, "RabbitMQ connection not available", nil)
 			return
 		}
 
@@ -146,7 +489,8 @@ func NewRouter(ch ChannelInterface) http.Handler {
 		// For now, we'll skip this in tests
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"queue_name": "image.urls",
+			"queue_name": cfg.RabbitMQ.JobQueue,
+			"queues":     cfg.RabbitMQ.JobQueueNames(),
 			"messages":   0,
 			"consumers":  0,
 			"timestamp":  time.Now().UTC(),
@@ -157,7 +501,7 @@ func NewRouter(ch ChannelInterface) http.Handler {
 	r.Get("/stats", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"service":   "url-ingestor",
+			"service":   config.URLIngestorService,
 			"timestamp": time.Now().UTC(),
 			"metrics": map[string]interface{}{
 				"endpoints": map[string]string{
@@ -166,68 +510,292 @@ func NewRouter(ch ChannelInterface) http.Handler {
 					"queue":   "/queue/status",
 					"metrics": "/metrics",
 					"submit":  "/submit",
+					"cancel":  "/jobs/{traceID}/cancel",
+					"quota":   "/quota",
 				},
 			},
 		})
 	})
 
 	r.Post("/submit", func(w http.ResponseWriter, r *http.Request) {
+		// Extract traceparent header if present
+		prop := propagation.TraceContext{}
+		ctx := r.Context()
+		ctx = prop.Extract(ctx, propagation.HeaderCarrier(r.Header))
+		tracer := otel.Tracer(config.URLIngestorService)
+		ctx, span := tracer.Start(ctx, "SubmitImageJob")
+		defer span.End()
+
+		// Fall back to the span's trace ID so every submission can be cancelled
+		traceID := requestTraceID(ctx, r)
+		w.Header().Set("X-Trace-ID", traceID)
+
+		// Shed load while the job queue is backlogged so queued jobs can drain
+		if guard != nil {
+			if depth, over := guard.backlogged(); over {
+				w.Header().Set("Retry-After", strconv.Itoa(int(cfg.Submit.RetryAfter.Seconds())))
+				writeError(w, http.StatusTooManyRequests, traceID, ErrCodeQueueBacklogged, "job queue is backlogged, retry later",
+					map[string]interface{}{"queue_depth": depth, "max_queue_depth": cfg.Submit.MaxQueueDepth})
+				return
+			}
+		}
+
+		// JSON is the primary format; text/plain bodies list one URL per line
+		// and take the default processing types, or ?processing_types=a,b
 		var job models.ImageJob
-		if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+		if isTextSubmission(r) {
+			processingTypes := cfg.Submit.DefaultProcessingTypes
+			if v := r.URL.Query().Get("processing_types"); v != "" {
+				processingTypes = strings.Split(v, ",")
+			}
+			var err error
+			if job, err = decodeTextSubmission(r.Body, processingTypes); errors.Is(err, errNoURLs) {
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidBody, err.Error(), nil)
+				return
+			} else if err != nil {
+				log.Printf("Unreadable text submission [%s]: %v", traceID, err)
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidBody, "unreadable body", nil)
+				return
+			}
+		} else {
+			// The decoder's error can quote the body and Go type names, so
+			// it is only logged
+			var err error
+			if job, err = decodeJSONSubmission(r.Body); err != nil {
+				log.Printf("Invalid JSON submission [%s]: %v", traceID, err)
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidJSON, "invalid JSON body", nil)
+				return
+			}
 		}
 
-		// Validate processing types
+		// Normalize and validate processing types
+		job.ProcessingTypes = normalizeProcessingTypes(job.ProcessingTypes)
 		invalidTypes := validateProcessingTypes(job.ProcessingTypes)
 		if len(invalidTypes) > 0 {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error":         "invalid processing_types provided",
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidProcessingTypes, "invalid processing_types provided", map[string]interface{}{
 				"invalid_types": invalidTypes,
 				"allowed_types": getAllowedProcessingTypes(),
 			})
 			return
 		}
 
-		// Extract traceparent header if present
-		prop := propagation.TraceContext{}
-		ctx := r.Context()
-		ctx = prop.Extract(ctx, propagation.HeaderCarrier(r.Header))
-		tracer := otel.Tracer("url-ingestor")
-		ctx, span := tracer.Start(ctx, "SubmitImageJob")
-		defer span.End()
+		// Cap the jobs each URL fans out into
+		processingTypes := dedupeProcessingTypes(job.ProcessingTypes)
+		if max := cfg.Submit.MaxTypesPerURL; max > 0 && len(processingTypes) > max {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeTooManyTypes, "too many processing_types provided", map[string]interface{}{
+				"requested_types":   len(processingTypes),
+				"max_types_per_url": max,
+			})
+			return
+		}
 
-		traceID := r.Header.Get("X-Trace-ID")
-		totalJobs := 0
+		// Validate priority against what the job queue was declared with
+		if job.Priority < 0 || job.Priority > cfg.RabbitMQ.MaxPriority {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidPriority, "invalid priority provided", map[string]interface{}{
+				"max_priority": cfg.RabbitMQ.MaxPriority,
+			})
+			return
+		}
 
-		for _, url := range job.URLs {
-			// Always publish the original
-			if err := publishJob(ctx, ch, traceID, url, "original"); err != nil {
-				span.RecordError(err)
-				http.Error(w, "publish failed", http.StatusInternalServerError)
+		// Validate output format; empty means the default (jpeg)
+		job.Format = strings.ToLower(strings.TrimSpace(job.Format))
+		if _, ok := outputFormats[job.Format]; !ok && job.Format != "" {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidFormat, "invalid format provided", map[string]interface{}{
+				"allowed_formats": []string{"jpeg", "png", "webp", "avif"},
+			})
+			return
+		}
+
+		// Validate schedule
+		if job.ProcessAfter != nil && time.Until(*job.ProcessAfter) > cfg.RabbitMQ.MaxDelay {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidSchedule, "process_after is too far in the future", map[string]interface{}{
+				"max_delay": cfg.RabbitMQ.MaxDelay.String(),
+			})
+			return
+		}
+
+		// Validate resize presets
+		if problems := validateResizePresets(job.Resize, cfg.Params.MaxDimension); len(problems) > 0 {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidResizePresets, "invalid resize presets provided", problems)
+			return
+		}
+
+		// Validate per-type params
+		job.Params = normalizeParams(job.Params)
+		if problems := validateParams(job.Params, processingTypes, job.Resize, cfg.Params); len(problems) > 0 {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidParams, "invalid params provided", problems)
+			return
+		}
+
+		// Jobs are owned by the name of the key they were submitted with.
+		// Once owners are configured every submission needs one of their
+		// keys, so none escapes an owner's quota.
+		owner, known := keyOwner(cfg.Submit.APIKeys, r.Header.Get("X-API-Key"))
+		if !known && len(cfg.Submit.APIKeys) > 0 {
+			writeError(w, http.StatusUnauthorized, traceID, ErrCodeUnauthorized, "a valid X-API-Key is required", nil)
+			return
+		}
+
+		// Reject URLs from hosts outside the configured source policy, and
+		// object sources outside the owner's prefix
+		if rejected := rejectedURLs(hostPolicy, cfg.SourceHosts, owner, job.URLs); len(rejected) > 0 {
+			writeError(w, http.StatusBadRequest, traceID, ErrCodeHostNotAllowed, "source host not allowed", map[string]interface{}{
+				"urls": rejected,
+			})
+			return
+		}
+
+		// Only owners allowed to use a bucket may send outputs there
+		job.Bucket = strings.ToLower(strings.TrimSpace(job.Bucket))
+		if job.Bucket != "" {
+			if !known {
+				writeError(w, http.StatusUnauthorized, traceID, ErrCodeUnauthorized, "a valid X-API-Key is required to choose a bucket", nil)
+				return
+			}
+			if !slices.Contains(cfg.Submit.BucketsByOwner[owner], job.Bucket) {
+				writeError(w, http.StatusForbidden, traceID, ErrCodeBucketNotAllowed, "bucket not allowed for this API key", map[string]interface{}{
+					"bucket": job.Bucket,
+				})
 				return
 			}
-			totalJobs++
+		}
 
-			// Publish other processing types if specified (skip duplicate 'original')
-			for _, pType := range job.ProcessingTypes {
-				if pType == "original" {
-					continue
-				}
-				if err := publishJob(ctx, ch, traceID, url, pType); err != nil {
+		// An owner over its quota can't add more
+		job.OwnerID = owner
+		if known {
+			if used, limit, exceeded := quotaExceeded(ctx, deps.quotas, cfg.Quota, owner); exceeded {
+				writeError(w, http.StatusForbidden, traceID, ErrCodeQuotaExceeded, "storage quota exceeded for this API key", map[string]interface{}{
+					"used_bytes":  used,
+					"limit_bytes": limit,
+				})
+				return
+			}
+		}
+
+		// Waiting is limited to one immediate URL so a request holds one
+		// connection for one image
+		wait := false
+		if v := r.URL.Query().Get("wait"); v != "" {
+			var err error
+			if wait, err = strconv.ParseBool(v); err != nil {
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidWait, "wait must be true or false", nil)
+				return
+			}
+		}
+		var reply message.Reply
+		if wait {
+			if deps.replies == nil {
+				writeError(w, http.StatusServiceUnavailable, traceID, ErrCodeWaitUnavailable, "synchronous submission not available", nil)
+				return
+			}
+			if len(job.URLs) != 1 || job.ProcessAfter != nil {
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidWait, "wait requires exactly one URL and no process_after", nil)
+				return
+			}
+			// How many outputs auto produces isn't known until the image is
+			// downloaded, so there's no count of results to wait for
+			if slices.Contains(job.ProcessingTypes, "auto") {
+				writeError(w, http.StatusBadRequest, traceID, ErrCodeInvalidWait, "wait doesn't support the auto processing type", nil)
+				return
+			}
+			reply = message.Reply{To: deps.replies.Queue(), CorrelationID: newCorrelationID()}
+		}
+
+		resp := SubmitResponse{TraceID: traceID, URLs: []SubmitURLSummary{}}
+
+		var replies <-chan amqp.Delivery
+		expected := 0
+		if wait {
+			// Register before publishing so a fast reply isn't missed
+			expected = expectedOutputs(expandJobs(job.URLs[0], job, processingTypes))
+			var done func()
+			replies, done = deps.replies.Register(reply.CorrelationID, expected)
+			defer done()
+		}
+
+		for _, url := range job.URLs {
+			// The original is always published first, followed by the other types
+			jobs := expandJobs(url, job, processingTypes)
+			for _, j := range jobs {
+				if err := publishJob(ctx, ch, cfg.RabbitMQ.QueueForPriority(j.Priority), traceID, j, reply); err != nil {
 					span.RecordError(err)
-					http.Error(w, "publish failed", http.StatusInternalServerError)
+					writeError(w, http.StatusInternalServerError, traceID, ErrCodePublishFailed, "publish failed", nil)
 					return
 				}
-				totalJobs++
+				resp.JobsQueued++
 			}
+			resp.URLs = append(resp.URLs, summarizeJobs(url, jobs))
 		}
 
-		imagesSubmitted.Add(float64(totalJobs))
+		imagesSubmitted.Add(float64(resp.JobsQueued))
+
+		if wait {
+			results, complete := waitForResults(ctx, replies, expected, cfg.Submit.WaitTimeout)
+			if !complete {
+				writeError(w, http.StatusGatewayTimeout, traceID, ErrCodeWaitTimeout, "timed out waiting for results; the jobs are still queued", map[string]interface{}{
+					"received": len(results),
+					"expected": expected,
+				})
+				return
+			}
+			if failed, ok := failedResult(results); ok {
+				writeError(w, http.StatusBadGateway, traceID, ErrCodeJobFailed, failed.ErrorMsg, map[string]interface{}{
+					"results": results,
+				})
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(SyncSubmitResponse{TraceID: traceID, Results: results})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(resp)
 	})
 
+	// Cancel jobs submitted under a trace ID that haven't been processed yet.
+	// Callers need a submit or an admin key. A submit key only cancels its
+	// owner's jobs, so a trace ID shared with another owner leaves theirs
+	// running; an admin key cancels every job under it.
+	r.Post("/jobs/{traceID}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		traceID := chi.URLParam(r, "traceID")
+		apiKey := r.Header.Get("X-API-Key")
+		owner, client := keyOwner(cfg.Submit.APIKeys, apiKey)
+		_, admin := keyOwner(cfg.Admin.APIKeys, apiKey)
+		if !client && !admin {
+			writeError(w, http.StatusUnauthorized, traceID, ErrCodeUnauthorized, "a valid submit or admin X-API-Key is required", nil)
+			return
+		}
+		if deps.cancels == nil {
+			writeError(w, http.StatusServiceUnavailable, traceID, ErrCodeCancelUnavailable, "cancellation not available", nil)
+			return
+		}
+
+		if admin {
+			owner = ""
+		}
+		if err := deps.cancels.Cancel(r.Context(), traceID, owner); err != nil {
+			log.Printf("Failed to cancel jobs for %s: %v", traceID, err)
+			writeError(w, http.StatusInternalServerError, traceID, ErrCodeCancelFailed, "cancel failed", nil)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		resp := map[string]interface{}{
+			"trace_id": traceID,
+			"status":   "cancelled",
+		}
+		if owner != "" {
+			resp["owner_id"] = owner
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	r.Post("/admin/queue/{name}/purge", purgeQueueHandler(cfg, deps.purger))
+
+	r.Get("/quota", quotaHandler(cfg, deps.quotas))
+
 	return r
 }
@@ -2,42 +2,41 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/handler/testutil"
 	"image-processing-system/internal/models"
+	"image-processing-system/pkg/message"
 
-	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
-// MockChannel is a mock implementation of ChannelInterface for testing
-type MockChannel struct {
-	closed bool
+// MockCancelStore records cancelled trace IDs and the owners they were
+// cancelled for
+type MockCancelStore struct {
+	cancelled []string
+	owners    []string
 }
 
-func (m *MockChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
-	if m.closed {
-		return amqp.ErrClosed
-	}
-	return nil
-}
-
-func (m *MockChannel) IsClosed() bool {
-	return m.closed
-}
-
-func (m *MockChannel) Close() error {
-	m.closed = true
+func (m *MockCancelStore) Cancel(ctx context.Context, traceID, ownerID string) error {
+	m.cancelled = append(m.cancelled, traceID)
+	m.owners = append(m.owners, ownerID)
 	return nil
 }
 
 func TestHealthEndpoint(t *testing.T) {
 	// Create a mock channel
-	ch := &MockChannel{}
+	ch := &testutil.Channel{}
 
-	router := NewRouter(ch)
+	router := NewRouter(ch, config.LoadURLIngestorConfig())
 	req, err := http.NewRequest("GET", "/health", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -66,9 +65,9 @@ func TestHealthEndpoint(t *testing.T) {
 
 func TestSubmitEndpoint(t *testing.T) {
 	// Create a mock channel
-	ch := &MockChannel{}
+	ch := &testutil.Channel{}
 
-	router := NewRouter(ch)
+	router := NewRouter(ch, config.LoadURLIngestorConfig())
 
 	// Test valid request
 	job := models.ImageJob{
@@ -90,11 +89,56 @@ func TestSubmitEndpoint(t *testing.T) {
 	}
 }
 
+func TestSubmitEndpointDeduplicatesProcessingTypes(t *testing.T) {
+	tests := []struct {
+		name            string
+		processingTypes []string
+		wantStatus      int
+		wantPublished   int
+	}{
+		{"duplicates", []string{"grayscale", "grayscale", "original"}, http.StatusAccepted, 2},
+		{"explicit original only", []string{"original", "original"}, http.StatusAccepted, 1},
+		{"distinct types", []string{"blur", "resize", "blur"}, http.StatusAccepted, 3},
+		{"case variants", []string{"grayscale", "Grayscale", " GRAYSCALE "}, http.StatusAccepted, 2},
+		{"whitespace and case", []string{" blur ", "Original"}, http.StatusAccepted, 2},
+		{"invalid type", []string{"grayscale", "sepia"}, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &testutil.Channel{}
+			router := NewRouter(ch, config.LoadURLIngestorConfig())
+
+			job := models.ImageJob{
+				URLs:            []string{"http://example.com/image1.jpg"},
+				ProcessingTypes: tt.processingTypes,
+			}
+			jobBytes, _ := json.Marshal(job)
+
+			req, err := http.NewRequest("POST", "/submit", bytes.NewBuffer(jobBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+			if len(ch.Published()) != tt.wantPublished {
+				t.Errorf("expected %d published jobs, got %d", tt.wantPublished, len(ch.Published()))
+			}
+		})
+	}
+}
+
 func TestSubmitEndpointWithClosedChannel(t *testing.T) {
 	// Create a mock channel that is closed
-	ch := &MockChannel{closed: true}
+	ch := testutil.NewClosedChannel()
 
-	router := NewRouter(ch)
+	router := NewRouter(ch, config.LoadURLIngestorConfig())
 
 	// Test valid request
 	job := models.ImageJob{
@@ -119,9 +163,9 @@ func TestSubmitEndpointWithClosedChannel(t *testing.T) {
 
 func TestStatusEndpoint(t *testing.T) {
 	// Create a mock channel
-	ch := &MockChannel{}
+	ch := &testutil.Channel{}
 
-	router := NewRouter(ch)
+	router := NewRouter(ch, config.LoadURLIngestorConfig())
 	req, err := http.NewRequest("GET", "/status", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -150,9 +194,9 @@ func TestStatusEndpoint(t *testing.T) {
 
 func TestStatsEndpoint(t *testing.T) {
 	// Create a mock channel
-	ch := &MockChannel{}
+	ch := &testutil.Channel{}
 
-	router := NewRouter(ch)
+	router := NewRouter(ch, config.LoadURLIngestorConfig())
 	req, err := http.NewRequest("GET", "/stats", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -174,3 +218,845 @@ func TestStatsEndpoint(t *testing.T) {
 		t.Errorf("expected service 'url-ingestor', got %v", response["service"])
 	}
 }
+
+func TestMetricsEndpointAuth(t *testing.T) {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.Metrics.AuthToken = "secret"
+	router := NewRouter(&testutil.Channel{}, cfg)
+
+	tests := []struct {
+		name       string
+		auth       string
+		wantStatus int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"valid token", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/metrics", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSubmitEndpointResizePresets(t *testing.T) {
+	tests := []struct {
+		name          string
+		job           models.ImageJob
+		wantStatus    int
+		wantPublished int
+	}{
+		{
+			name: "one job per preset",
+			job: models.ImageJob{
+				ProcessingTypes: []string{"grayscale", "resize"},
+				Resize:          []models.ResizePreset{{Name: "sm", Width: 150, Height: 150}, {Name: "lg", Width: 1024}},
+			},
+			wantStatus:    http.StatusAccepted,
+			wantPublished: 4, // original, grayscale, sm, lg
+		},
+		{
+			name: "combined into one job",
+			job: models.ImageJob{
+				ProcessingTypes: []string{"grayscale", "resize"},
+				Resize:          []models.ResizePreset{{Name: "sm", Width: 150, Height: 150}, {Name: "lg", Width: 1024}},
+				Combine:         true,
+			},
+			wantStatus:    http.StatusAccepted,
+			wantPublished: 1,
+		},
+		{
+			name: "duplicate preset names",
+			job: models.ImageJob{
+				Resize: []models.ResizePreset{{Name: "sm", Width: 150}, {Name: "sm", Width: 300}},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "missing dimensions",
+			job: models.ImageJob{
+				Resize: []models.ResizePreset{{Name: "sm"}},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &testutil.Channel{}
+			router := NewRouter(ch, config.LoadURLIngestorConfig())
+
+			tt.job.URLs = []string{"http://example.com/image1.jpg"}
+			jobBytes, _ := json.Marshal(tt.job)
+
+			req, err := http.NewRequest("POST", "/submit", bytes.NewBuffer(jobBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+			if len(ch.Published()) != tt.wantPublished {
+				t.Errorf("expected %d published jobs, got %d", tt.wantPublished, len(ch.Published()))
+			}
+		})
+	}
+}
+
+func TestSubmitEndpointPriority(t *testing.T) {
+	tests := []struct {
+		name       string
+		priority   int
+		wantStatus int
+	}{
+		{"default", 0, http.StatusAccepted},
+		{"max", 10, http.StatusAccepted},
+		{"above max", 11, http.StatusBadRequest},
+		{"negative", -1, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.LoadURLIngestorConfig()
+			cfg.RabbitMQ.MaxPriority = 10
+			router := NewRouter(&testutil.Channel{}, cfg)
+
+			job := models.ImageJob{URLs: []string{"http://example.com/image1.jpg"}, Priority: tt.priority}
+			jobBytes, _ := json.Marshal(job)
+
+			req, err := http.NewRequest("POST", "/submit", bytes.NewBuffer(jobBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSubmitEndpointRoutesByPriorityLane(t *testing.T) {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.RabbitMQ.MaxPriority = 10
+	cfg.RabbitMQ.Lanes = "image.urls.fast:5:3,image.urls.bulk:0:1"
+
+	for priority, want := range map[int]string{2: "image.urls.bulk", 8: "image.urls.fast"} {
+		ch := &testutil.Channel{}
+		router := NewRouter(ch, cfg)
+
+		jobBytes, _ := json.Marshal(models.ImageJob{URLs: []string{"http://example.com/image1.jpg"}, Priority: priority})
+		req := httptest.NewRequest("POST", "/submit", bytes.NewBuffer(jobBytes))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("priority %d: expected 202, got %d", priority, rr.Code)
+		}
+		if published := ch.Published(); len(published) != 1 || published[0].Key != want {
+			t.Errorf("priority %d: expected the job on %s, got %+v", priority, want, published)
+		}
+	}
+}
+
+func TestSubmitEndpointProcessAfter(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	tooFar := time.Now().Add(48 * time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name         string
+		processAfter *time.Time
+		wantStatus   int
+		wantDelayed  bool
+	}{
+		{"unscheduled", nil, http.StatusAccepted, false},
+		{"future", &future, http.StatusAccepted, true},
+		{"past", &past, http.StatusAccepted, false},
+		{"beyond max delay", &tooFar, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.LoadURLIngestorConfig()
+			cfg.RabbitMQ.MaxDelay = 24 * time.Hour
+			ch := &testutil.Channel{}
+			router := NewRouter(ch, cfg)
+
+			job := models.ImageJob{URLs: []string{"http://example.com/image1.jpg"}, ProcessAfter: tt.processAfter}
+			jobBytes, _ := json.Marshal(job)
+
+			req, err := http.NewRequest("POST", "/submit", bytes.NewBuffer(jobBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusAccepted {
+				return
+			}
+
+			wantKey := cfg.RabbitMQ.JobQueue
+			if tt.wantDelayed {
+				wantKey = cfg.RabbitMQ.JobQueue + ".delayed"
+			}
+			for _, p := range ch.Published() {
+				if p.Key != wantKey {
+					t.Errorf("published to %q, want %q", p.Key, wantKey)
+				}
+				if hasTTL := p.Msg.Expiration != ""; hasTTL != tt.wantDelayed {
+					t.Errorf("expiration = %q, want delayed=%v", p.Msg.Expiration, tt.wantDelayed)
+				}
+			}
+		})
+	}
+}
+
+// cancelConfig gives one client and one operator keys that may cancel jobs
+func cancelConfig() *config.URLIngestorConfig {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.Submit.APIKeys = map[string]string{"acme": "client-key", "globex": "globex-key"}
+	cfg.Admin.APIKeys = map[string]string{"alice": "admin-key"}
+	return cfg
+}
+
+func TestCancelEndpoint(t *testing.T) {
+	store := &MockCancelStore{}
+	router := NewRouter(&testutil.Channel{}, cancelConfig(), WithCancelStore(store))
+
+	req, err := http.NewRequest("POST", "/jobs/abc123/cancel", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", "client-key")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+	}
+	if len(store.cancelled) != 1 || store.cancelled[0] != "abc123" {
+		t.Errorf("expected abc123 to be cancelled, got %v", store.cancelled)
+	}
+}
+
+func TestCancelEndpointScopesToOwner(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		wantOwner string
+	}{
+		{"owner cancels its own jobs", "client-key", "acme"},
+		{"another owner only cancels its own jobs", "globex-key", "globex"},
+		{"admin cancels every job", "admin-key", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &MockCancelStore{}
+			router := NewRouter(&testutil.Channel{}, cancelConfig(), WithCancelStore(store))
+
+			req := httptest.NewRequest("POST", "/jobs/abc123/cancel", nil)
+			req.Header.Set("X-API-Key", tt.key)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusAccepted {
+				t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if len(store.owners) != 1 || store.owners[0] != tt.wantOwner {
+				t.Errorf("expected abc123 cancelled for owner %q, got %q", tt.wantOwner, store.owners)
+			}
+		})
+	}
+}
+
+func TestCancelEndpointRequiresKey(t *testing.T) {
+	for _, key := range []string{"", "wrong-key", "admin-key"} {
+		store := &MockCancelStore{}
+		router := NewRouter(&testutil.Channel{}, cancelConfig(), WithCancelStore(store))
+
+		req := httptest.NewRequest("POST", "/jobs/abc123/cancel", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		want := http.StatusUnauthorized
+		if key == "admin-key" {
+			want = http.StatusAccepted
+		}
+		if rr.Code != want {
+			t.Errorf("key %q: expected %d, got %d", key, want, rr.Code)
+		}
+		if want == http.StatusUnauthorized && len(store.cancelled) != 0 {
+			t.Errorf("key %q: expected nothing cancelled, got %v", key, store.cancelled)
+		}
+	}
+}
+
+func TestCancelEndpointWithoutStore(t *testing.T) {
+	router := NewRouter(&testutil.Channel{}, cancelConfig())
+
+	req, err := http.NewRequest("POST", "/jobs/abc123/cancel", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", "admin-key")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestSubmitEndpointErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"invalid json", `{"urls":`, http.StatusBadRequest, ErrCodeInvalidJSON},
+		{"invalid type", `{"urls":["http://example.com/a.jpg"],"processing_types":["sepia"]}`, http.StatusBadRequest, ErrCodeInvalidProcessingTypes},
+		{"invalid priority", `{"urls":["http://example.com/a.jpg"],"priority":-1}`, http.StatusBadRequest, ErrCodeInvalidPriority},
+		{"invalid format", `{"urls":["http://example.com/a.jpg"],"format":"gif"}`, http.StatusBadRequest, ErrCodeInvalidFormat},
+		{"invalid params", `{"urls":["http://example.com/a.jpg"],"processing_types":["blur"],"params":{"blur":{"sigma":-1}}}`, http.StatusBadRequest, ErrCodeInvalidParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(&testutil.Channel{}, config.LoadURLIngestorConfig())
+
+			req, err := http.NewRequest("POST", "/submit", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Trace-ID", "trace-123")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+
+			var body map[string]APIError
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatalf("expected a JSON error envelope: %v", err)
+			}
+			if got := body["error"]; got.Code != tt.wantCode || got.TraceID != "trace-123" || got.Message == "" {
+				t.Errorf("unexpected error envelope: %+v", got)
+			}
+		})
+	}
+}
+
+func TestSubmitEndpointPublishFailureEnvelope(t *testing.T) {
+	router := NewRouter(testutil.NewClosedChannel(), config.LoadURLIngestorConfig())
+
+	req, err := http.NewRequest("POST", "/submit", bytes.NewBufferString(`{"urls":["http://example.com/a.jpg"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var body map[string]APIError
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("expected a JSON error envelope: %v", err)
+	}
+	if rr.Code != http.StatusInternalServerError || body["error"].Code != ErrCodePublishFailed {
+		t.Errorf("got %d %+v, want 500 %s", rr.Code, body["error"], ErrCodePublishFailed)
+	}
+}
+
+func TestSubmitEndpointPublishesExpectedJobs(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	cfg := config.LoadURLIngestorConfig()
+	ch := &testutil.Channel{}
+	router := NewRouter(ch, cfg)
+
+	body := `{"urls":["http://example.com/a.jpg","http://example.com/b.jpg"],"processing_types":["grayscale","blur"]}`
+	req, err := http.NewRequest("POST", "/submit", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Trace-ID", "trace-abc")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+
+	envs, jobs := ch.Jobs(t)
+	want := []struct{ url, pType string }{
+		{"http://example.com/a.jpg", "original"},
+		{"http://example.com/a.jpg", "grayscale"},
+		{"http://example.com/a.jpg", "blur"},
+		{"http://example.com/b.jpg", "original"},
+		{"http://example.com/b.jpg", "grayscale"},
+		{"http://example.com/b.jpg", "blur"},
+	}
+	if len(jobs) != len(want) {
+		t.Fatalf("expected %d jobs, got %d", len(want), len(jobs))
+	}
+	for i, w := range want {
+		if jobs[i].URLs[0] != w.url || jobs[i].ProcessingTypes[0] != w.pType {
+			t.Errorf("job %d = %v %v, want %s %s", i, jobs[i].URLs, jobs[i].ProcessingTypes, w.url, w.pType)
+		}
+		if envs[i].TraceID != "trace-abc" || envs[i].Source != config.URLIngestorService {
+			t.Errorf("job %d envelope = %+v", i, envs[i])
+		}
+	}
+
+	for i, p := range ch.Published() {
+		if p.Exchange != "" || p.Key != cfg.RabbitMQ.JobQueue {
+			t.Errorf("message %d published to %q/%q, want default exchange and %q", i, p.Exchange, p.Key, cfg.RabbitMQ.JobQueue)
+		}
+		if _, ok := p.Msg.Headers["traceparent"]; !ok {
+			t.Errorf("message %d missing traceparent header, got %v", i, p.Msg.Headers)
+		}
+	}
+}
+
+func TestSubmitEndpointResponseSummary(t *testing.T) {
+	router := NewRouter(&testutil.Channel{}, config.LoadURLIngestorConfig())
+
+	body := `{"urls":["http://example.com/a.jpg"],"processing_types":["original","grayscale","Grayscale"],"resize":[{"name":"sm","w":10,"h":10},{"name":"lg","w":100,"h":100}]}`
+	req, err := http.NewRequest("POST", "/submit", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Trace-ID", "trace-sum")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+
+	var resp SubmitResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.TraceID != "trace-sum" || resp.JobsQueued != 4 || len(resp.URLs) != 1 {
+		t.Fatalf("unexpected summary: %+v", resp)
+	}
+	got := resp.URLs[0]
+	if want := []string{"original", "grayscale", "resize"}; strings.Join(got.ProcessingTypes, ",") != strings.Join(want, ",") {
+		t.Errorf("processing_types = %v, want %v", got.ProcessingTypes, want)
+	}
+	if want := []string{"sm", "lg"}; strings.Join(got.ResizePresets, ",") != strings.Join(want, ",") {
+		t.Errorf("resize_presets = %v, want %v", got.ResizePresets, want)
+	}
+}
+
+func TestSubmitEndpointTextBody(t *testing.T) {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.Submit.DefaultProcessingTypes = []string{"grayscale"}
+	body := "# weekly import\nhttp://example.com/a.jpg\n\n  http://example.com/b.jpg  \n"
+
+	tests := []struct {
+		name        string
+		query       string
+		body        string
+		wantStatus  int
+		wantCode    string
+		wantSummary string
+	}{
+		{"default types", "", body, http.StatusAccepted, "", "http://example.com/a.jpg=original,grayscale http://example.com/b.jpg=original,grayscale"},
+		{"types from query", "?processing_types=blur,sharpen", body, http.StatusAccepted, "", "http://example.com/a.jpg=original,blur,sharpen http://example.com/b.jpg=original,blur,sharpen"},
+		{"invalid query type", "?processing_types=sepia", body, http.StatusBadRequest, ErrCodeInvalidProcessingTypes, ""},
+		{"only comments", "", "# nothing yet\n\n", http.StatusBadRequest, ErrCodeInvalidBody, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(&testutil.Channel{}, cfg)
+
+			req := httptest.NewRequest(http.MethodPost, "/submit"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.wantCode != "" {
+				var body map[string]APIError
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || body["error"].Code != tt.wantCode {
+					t.Errorf("expected %s, got %+v (%v)", tt.wantCode, body, err)
+				}
+				return
+			}
+
+			var resp SubmitResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			var summary []string
+			for _, u := range resp.URLs {
+				summary = append(summary, u.URL+"="+strings.Join(u.ProcessingTypes, ","))
+			}
+			if got := strings.Join(summary, " "); got != tt.wantSummary {
+				t.Errorf("queued %q, want %q", got, tt.wantSummary)
+			}
+		})
+	}
+}
+
+func TestSubmitEndpointInvalidJSON(t *testing.T) {
+	bodies := map[string]string{
+		"truncated":  `{"urls": ["http://example.com/a.jpg"], "processing_ty`,
+		"wrong type": `{"urls": "http://example.com/a.jpg"}`,
+		"not json":   `urls=http://example.com/a.jpg`,
+	}
+
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			ch := &testutil.Channel{}
+			router := NewRouter(ch, config.LoadURLIngestorConfig())
+
+			req := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var resp map[string]APIError
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("expected a structured error, got %v", err)
+			}
+			if got := resp["error"]; got.Code != ErrCodeInvalidJSON || got.Message != "invalid JSON body" {
+				t.Errorf("error = %+v, want %s with a fixed message", got, ErrCodeInvalidJSON)
+			}
+			if len(ch.Published()) != 0 {
+				t.Error("expected nothing to be published")
+			}
+		})
+	}
+}
+
+func TestSubmitEndpointSourceHostPolicy(t *testing.T) {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.SourceHosts = config.SourceHostsConfig{
+		Allow:   []string{"*.example.com"},
+		Deny:    []string{"private.example.com"},
+		Buckets: []string{"uploads"},
+	}
+
+	tests := []struct {
+		name          string
+		urls          []string
+		wantStatus    int
+		wantPublished int
+	}{
+		{"allowed host", []string{"http://cdn.example.com/a.jpg"}, http.StatusAccepted, 1},
+		{"host outside allow-list", []string{"http://cdn.example.com/a.jpg", "http://evil.com/b.jpg"}, http.StatusBadRequest, 0},
+		{"denied host", []string{"http://private.example.com/a.jpg"}, http.StatusBadRequest, 0},
+		{"object in a source bucket", []string{"minio://uploads/a.jpg"}, http.StatusAccepted, 1},
+		{"object in another bucket", []string{"minio://private/a.jpg"}, http.StatusBadRequest, 0},
+		{"bucket without a key", []string{"minio://uploads/"}, http.StatusBadRequest, 0},
+		{"file scheme", []string{"file:///etc/passwd"}, http.StatusBadRequest, 0},
+		{"ftp scheme", []string{"ftp://cdn.example.com/a.jpg"}, http.StatusBadRequest, 0},
+		{"no scheme", []string{"cdn.example.com/a.jpg"}, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &testutil.Channel{}
+			router := NewRouter(ch, cfg)
+
+			jobBytes, _ := json.Marshal(models.ImageJob{URLs: tt.urls})
+			req, err := http.NewRequest("POST", "/submit", bytes.NewBuffer(jobBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if len(ch.Published()) != tt.wantPublished {
+				t.Errorf("expected %d published jobs, got %d", tt.wantPublished, len(ch.Published()))
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				var body map[string]APIError
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				if body["error"].Code != ErrCodeHostNotAllowed {
+					t.Errorf("expected code %s, got %s", ErrCodeHostNotAllowed, body["error"].Code)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitEndpointObjectSourcesByOwner(t *testing.T) {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.SourceHosts = config.SourceHostsConfig{Buckets: []string{"uploads"}}
+	cfg.Submit.APIKeys = map[string]string{"acme": "acme-key"}
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+	}{
+		{"own prefix", "minio://uploads/acme/a.jpg", http.StatusAccepted},
+		{"another owner's prefix", "minio://uploads/globex/a.jpg", http.StatusBadRequest},
+		{"outside any prefix", "minio://uploads/a.jpg", http.StatusBadRequest},
+		{"owner name as a prefix of another", "minio://uploads/acmecorp/a.jpg", http.StatusBadRequest},
+		{"escaping the prefix", "minio://uploads/acme/../globex/a.jpg", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &testutil.Channel{}
+			router := NewRouter(ch, cfg)
+
+			jobBytes, _ := json.Marshal(models.ImageJob{URLs: []string{tt.url}})
+			req := httptest.NewRequest("POST", "/submit", bytes.NewBuffer(jobBytes))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-API-Key", "acme-key")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.wantStatus == http.StatusBadRequest && len(ch.Published()) != 0 {
+				t.Error("expected nothing to be published")
+			}
+		})
+	}
+}
+
+func TestSubmitEndpointMaxTypesPerURL(t *testing.T) {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.Submit.MaxTypesPerURL = 2
+
+	tests := []struct {
+		name          string
+		types         []string
+		wantStatus    int
+		wantPublished int
+	}{
+		{"at the limit", []string{"grayscale", "blur"}, http.StatusAccepted, 3},
+		{"original and duplicates not counted", []string{"original", "grayscale", "grayscale", "blur"}, http.StatusAccepted, 3},
+		{"over the limit", []string{"grayscale", "blur", "sharpen"}, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &testutil.Channel{}
+			router := NewRouter(ch, cfg)
+
+			jobBytes, _ := json.Marshal(models.ImageJob{URLs: []string{"http://example.com/a.jpg"}, ProcessingTypes: tt.types})
+			req, err := http.NewRequest("POST", "/submit", bytes.NewBuffer(jobBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if len(ch.Published()) != tt.wantPublished {
+				t.Errorf("expected %d published jobs, got %d", tt.wantPublished, len(ch.Published()))
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				var body map[string]APIError
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				if body["error"].Code != ErrCodeTooManyTypes {
+					t.Errorf("expected code %s, got %s", ErrCodeTooManyTypes, body["error"].Code)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitEndpointBucket(t *testing.T) {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.Submit.APIKeys = map[string]string{"acme": "key-a"}
+	cfg.Submit.BucketsByOwner = map[string][]string{"acme": {"tenant-a"}}
+
+	tests := []struct {
+		name       string
+		apiKey     string
+		bucket     string
+		wantStatus int
+		wantCode   string
+	}{
+		{"default bucket", "key-a", "", http.StatusAccepted, ""},
+		{"allowed bucket", "key-a", "Tenant-A", http.StatusAccepted, ""},
+		{"missing key", "", "tenant-a", http.StatusUnauthorized, ErrCodeUnauthorized},
+		{"unknown key", "key-b", "tenant-a", http.StatusUnauthorized, ErrCodeUnauthorized},
+		{"bucket outside the key's allow-list", "key-a", "tenant-b", http.StatusForbidden, ErrCodeBucketNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &testutil.Channel{}
+			router := NewRouter(ch, cfg)
+
+			jobBytes, _ := json.Marshal(models.ImageJob{URLs: []string{"http://example.com/a.jpg"}, Bucket: tt.bucket})
+			req, err := http.NewRequest("POST", "/submit", bytes.NewBuffer(jobBytes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if tt.wantCode != "" {
+				var body map[string]APIError
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				if body["error"].Code != tt.wantCode {
+					t.Errorf("expected code %s, got %s", tt.wantCode, body["error"].Code)
+				}
+				return
+			}
+			_, job, err := message.Decode[models.ImageJob](ch.Published()[0].Msg.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.Bucket != strings.ToLower(tt.bucket) {
+				t.Errorf("expected the job to carry bucket %q, got %q", strings.ToLower(tt.bucket), job.Bucket)
+			}
+		})
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	cfg := config.LoadURLIngestorConfig()
+	cfg.RateLimit = config.RateLimitConfig{Requests: 2, Window: 90 * time.Second}
+	router := NewRouter(&testutil.Channel{}, cfg)
+
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	}
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the third request to be limited, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "90" {
+		t.Errorf("Retry-After = %q, want 90", got)
+	}
+	var body map[string]APIError
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	details, _ := body["error"].Details.(map[string]interface{})
+	if body["error"].Code != ErrCodeRateLimited || details["limit"] != float64(2) || details["window"] != "1m30s" {
+		t.Errorf("unexpected error body %+v", body["error"])
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]models.ProcessingParams
+		types   []string
+		presets []models.ResizePreset
+		want    []string
+	}{
+		{"valid", map[string]models.ProcessingParams{
+			"resize":  {Width: 200},
+			"blur":    {Sigma: 3.5},
+			"sharpen": {},
+		}, []string{"resize", "blur", "sharpen"}, nil, nil},
+		{"defaults", nil, []string{"resize", "blur"}, nil, nil},
+		{"not requested", map[string]models.ProcessingParams{"blur": {Sigma: 1}}, []string{"grayscale"}, nil,
+			[]string{`params given for "blur", which isn't in processing_types`}},
+		{"resize dimensions", map[string]models.ProcessingParams{"resize": {Width: -1, Sigma: 2}}, []string{"resize"}, nil,
+			[]string{"resize takes only w and h", "resize needs a positive w or h"}},
+		{"resize with presets", map[string]models.ProcessingParams{"resize": {Width: 10}}, []string{"resize"}, []models.ResizePreset{{Name: "sm", Width: 10}},
+			[]string{"resize params can't be combined with resize presets"}},
+		{"resize bounds", map[string]models.ProcessingParams{"resize": {Width: 20000, Height: 100}}, []string{"resize"}, nil,
+			[]string{"resize w and h must be at most 8192, got 20000x100"}},
+		{"sigma range", map[string]models.ProcessingParams{"blur": {Sigma: 10000}, "sharpen": {Width: 3}}, []string{"blur", "sharpen"}, nil,
+			[]string{"blur sigma must be between 0 and 50, got 10000", "sharpen takes only sigma"}},
+		{"convert", map[string]models.ProcessingParams{"convert": {Format: "webp"}}, []string{"convert"}, nil, nil},
+		{"convert without params", nil, []string{"convert"}, nil, []string{"convert needs params.convert with format"}},
+		{"convert format", map[string]models.ProcessingParams{"convert": {Format: "gif", Width: 10}}, []string{"convert"}, nil,
+			[]string{"convert takes only format", `convert format must be jpeg, png, webp or avif, got "gif"`}},
+		{"compress_to", map[string]models.ProcessingParams{"compress_to": {MaxBytes: 200_000, Downscale: true}}, []string{"compress_to"}, nil, nil},
+		{"compress_to target", map[string]models.ProcessingParams{"compress_to": {MaxBytes: 10, Sigma: 1}}, []string{"compress_to"}, nil,
+			[]string{"compress_to takes only max_bytes and downscale", "compress_to max_bytes must be at least 1024, got 10"}},
+		{"format on another type", map[string]models.ProcessingParams{"blur": {Format: "png"}}, []string{"blur"}, nil,
+			[]string{"blur takes only sigma"}},
+		{"no params", map[string]models.ProcessingParams{"grayscale": {Width: 1}}, []string{"grayscale"}, nil,
+			[]string{"grayscale takes no params"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateParams(tt.params, tt.types, tt.presets, config.ParamLimitsConfig{MaxSigma: 50, MaxDimension: 8192})
+			if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("validateParams() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"image-processing-system/internal/models"
+)
+
+// errNoURLs rejects text submissions without a single URL
+var errNoURLs = errors.New("no URLs provided")
+
+// isTextSubmission reports whether a /submit body is a text/plain URL list
+// rather than the default JSON
+func isTextSubmission(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/plain"
+}
+
+// decodeJSONSubmission reads a JSON /submit body
+func decodeJSONSubmission(body io.Reader) (models.ImageJob, error) {
+	var job models.ImageJob
+	err := json.NewDecoder(body).Decode(&job)
+	return job, err
+}
+
+// decodeTextSubmission reads a text/plain /submit body: one URL per line,
+// skipping blank lines and lines starting with #. The jobs get
+// processingTypes, since a URL list has nowhere to name any.
+func decodeTextSubmission(body io.Reader, processingTypes []string) (models.ImageJob, error) {
+	job := models.ImageJob{ProcessingTypes: append([]string(nil), processingTypes...)}
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		job.URLs = append(job.URLs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return job, err
+	}
+	if len(job.URLs) == 0 {
+		return job, errNoURLs
+	}
+	return job, nil
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"image-processing-system/internal/models"
+	"image-processing-system/pkg/message"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ReplyWaiter receives the results workers publish to a reply queue and
+// routes them by correlation ID
+type ReplyWaiter interface {
+	Queue() string
+	Register(correlationID string, buffer int) (<-chan amqp.Delivery, func())
+}
+
+// WithSyncWait enables POST /submit?wait=true, which blocks until the
+// submitted image's results arrive on the waiter's reply queue
+func WithSyncWait(replies ReplyWaiter) RouterOption {
+	return func(d *routerDeps) {
+		d.replies = replies
+	}
+}
+
+// SyncSubmitResponse is the body of a /submit?wait=true that completed
+type SyncSubmitResponse struct {
+	TraceID string                         `json:"trace_id,omitempty"`
+	Results []models.ImageProcessedPayload `json:"results"`
+}
+
+// expectedOutputs counts the results the jobs will produce: one per
+// processing type, or one per preset for resize with presets
+func expectedOutputs(jobs []models.ImageJob) int {
+	n := 0
+	for _, j := range jobs {
+		for _, pType := range j.ProcessingTypes {
+			if pType == "resize" && len(j.Resize) > 0 {
+				n += len(j.Resize)
+			} else {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// waitForResults collects up to want results from replies. It returns early
+// when a job reports a failure, and reports false if the timeout or ctx ran
+// out first.
+func waitForResults(ctx context.Context, replies <-chan amqp.Delivery, want int, timeout time.Duration) ([]models.ImageProcessedPayload, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	results := make([]models.ImageProcessedPayload, 0, want)
+	for len(results) < want {
+		select {
+		case msg := <-replies:
+			_, result, err := message.Decode[models.ImageProcessedPayload](msg.Body)
+			if err != nil {
+				log.Printf("Failed to decode reply: %v", err)
+				continue
+			}
+			results = append(results, *result)
+			if result.Status != "success" {
+				return results, true
+			}
+		case <-timer.C:
+			return results, false
+		case <-ctx.Done():
+			return results, false
+		}
+	}
+	return results, true
+}
+
+// failedResult returns the first result that isn't a success, if any
+func failedResult(results []models.ImageProcessedPayload) (models.ImageProcessedPayload, bool) {
+	for _, r := range results {
+		if r.Status != "success" {
+			return r, true
+		}
+	}
+	return models.ImageProcessedPayload{}, false
+}
+
+// newCorrelationID returns a random ID that ties replies to one request
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/handler/testutil"
+	"image-processing-system/internal/models"
+	"image-processing-system/pkg/message"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeReplies answers every registration with canned results
+type fakeReplies struct {
+	results       []models.ImageProcessedPayload
+	correlationID string
+}
+
+func (f *fakeReplies) Queue() string { return "amq.gen-replies" }
+
+func (f *fakeReplies) Register(correlationID string, buffer int) (<-chan amqp.Delivery, func()) {
+	f.correlationID = correlationID
+	ch := make(chan amqp.Delivery, len(f.results))
+	for _, r := range f.results {
+		body, _ := message.Encode(r.TraceID, "image-fetcher", r)
+		ch <- amqp.Delivery{CorrelationId: correlationID, Body: body}
+	}
+	return ch, func() {}
+}
+
+func TestSubmitEndpointWait(t *testing.T) {
+	success := func(pType string) models.ImageProcessedPayload {
+		return models.ImageProcessedPayload{SourceURL: "http://example.com/a.jpg", Status: "success", ProcessingType: pType, S3Path: "s3://images/" + pType}
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		body       string
+		replies    ReplyWaiter
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "all results arrive",
+			query:      "?wait=true",
+			body:       `{"urls":["http://example.com/a.jpg"],"processing_types":["grayscale"]}`,
+			replies:    &fakeReplies{results: []models.ImageProcessedPayload{success("original"), success("grayscale")}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "job fails",
+			query:      "?wait=true",
+			body:       `{"urls":["http://example.com/a.jpg"],"processing_types":["grayscale"]}`,
+			replies:    &fakeReplies{results: []models.ImageProcessedPayload{{Status: "error", ErrorMsg: "HTTP error: 404"}}},
+			wantStatus: http.StatusBadGateway,
+			wantCode:   ErrCodeJobFailed,
+		},
+		{
+			name:       "timeout",
+			query:      "?wait=true",
+			body:       `{"urls":["http://example.com/a.jpg"],"processing_types":["grayscale"]}`,
+			replies:    &fakeReplies{results: []models.ImageProcessedPayload{success("original")}},
+			wantStatus: http.StatusGatewayTimeout,
+			wantCode:   ErrCodeWaitTimeout,
+		},
+		{
+			name:       "several URLs",
+			query:      "?wait=true",
+			body:       `{"urls":["http://example.com/a.jpg","http://example.com/b.jpg"]}`,
+			replies:    &fakeReplies{},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   ErrCodeInvalidWait,
+		},
+		{
+			name:       "invalid wait value",
+			query:      "?wait=soon",
+			body:       `{"urls":["http://example.com/a.jpg"]}`,
+			replies:    &fakeReplies{},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   ErrCodeInvalidWait,
+		},
+		{
+			name:       "no reply queue",
+			query:      "?wait=true",
+			body:       `{"urls":["http://example.com/a.jpg"]}`,
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   ErrCodeWaitUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.LoadURLIngestorConfig()
+			cfg.Submit.WaitTimeout = 50 * time.Millisecond
+			ch := &testutil.Channel{}
+			var opts []RouterOption
+			if tt.replies != nil {
+				opts = append(opts, WithSyncWait(tt.replies))
+			}
+			router := NewRouter(ch, cfg, opts...)
+
+			req, err := http.NewRequest("POST", "/submit"+tt.query, bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v (%s)", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if tt.wantCode != "" {
+				var body map[string]APIError
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				if body["error"].Code != tt.wantCode {
+					t.Errorf("expected code %s, got %s", tt.wantCode, body["error"].Code)
+				}
+				return
+			}
+
+			var resp SyncSubmitResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Results) != 2 || resp.Results[1].ProcessingType != "grayscale" {
+				t.Errorf("unexpected results: %+v", resp.Results)
+			}
+			replies := tt.replies.(*fakeReplies)
+			for _, p := range ch.Published() {
+				if p.Msg.ReplyTo != "amq.gen-replies" || p.Msg.CorrelationId != replies.correlationID {
+					t.Errorf("expected jobs to carry the reply address, got reply_to=%q correlation_id=%q", p.Msg.ReplyTo, p.Msg.CorrelationId)
+				}
+				env, _, err := message.Decode[models.ImageJob](p.Msg.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if env.Reply.To != "amq.gen-replies" || env.Reply.CorrelationID != replies.correlationID {
+					t.Errorf("expected the envelope to carry the reply address, got %+v", env.Reply)
+				}
+			}
+		})
+	}
+}
+
+func TestExpectedOutputs(t *testing.T) {
+	submission := models.ImageJob{Resize: []models.ResizePreset{{Name: "sm", Width: 10}, {Name: "lg", Width: 100}}}
+	for _, combine := range []bool{false, true} {
+		submission.Combine = combine
+		jobs := expandJobs("http://example.com/a.jpg", submission, []string{"grayscale", "resize"})
+		if got := expectedOutputs(jobs); got != 4 {
+			t.Errorf("combine=%v: expectedOutputs() = %d, want 4 (original, grayscale, sm, lg)", combine, got)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package testutil provides test doubles for the handler package.
+package testutil
+
+import (
+	"sync"
+	"testing"
+
+	"image-processing-system/internal/models"
+	"image-processing-system/pkg/message"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Published is a message captured by Channel
+type Published struct {
+	Exchange  string
+	Key       string
+	Mandatory bool
+	Immediate bool
+	Msg       amqp.Publishing
+}
+
+// Channel is an in-memory handler.ChannelInterface that records every
+// publish. It is safe for concurrent use, so it also works in benchmarks.
+type Channel struct {
+	mu        sync.Mutex
+	closed    bool
+	published []Published
+	// PublishErr, when set, is returned from Publish instead of recording
+	PublishErr error
+}
+
+// NewClosedChannel returns a Channel that rejects publishes like a dropped connection
+func NewClosedChannel() *Channel {
+	return &Channel{closed: true}
+}
+
+// Publish records the message
+func (c *Channel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	if c.PublishErr != nil {
+		return c.PublishErr
+	}
+	c.published = append(c.published, Published{
+		Exchange:  exchange,
+		Key:       key,
+		Mandatory: mandatory,
+		Immediate: immediate,
+		Msg:       msg,
+	})
+	return nil
+}
+
+// IsClosed reports whether Close was called
+func (c *Channel) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Close marks the channel closed
+func (c *Channel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// Published returns a copy of the messages published so far
+func (c *Channel) Published() []Published {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Published(nil), c.published...)
+}
+
+// Reset discards the recorded messages
+func (c *Channel) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = nil
+}
+
+// Jobs decodes the published messages into their envelopes and jobs, in
+// publish order, failing the test if any message isn't a valid job
+func (c *Channel) Jobs(t testing.TB) ([]message.Envelope, []models.ImageJob) {
+	t.Helper()
+	var envs []message.Envelope
+	var jobs []models.ImageJob
+	for _, p := range c.Published() {
+		env, job, err := message.Decode[models.ImageJob](p.Msg.Body)
+		if err != nil {
+			t.Fatalf("published message is not an image job: %v", err)
+		}
+		envs = append(envs, *env)
+		jobs = append(jobs, *job)
+	}
+	return envs, jobs
+}
@@ -6,7 +6,8 @@ import (
 	"time"
 )
 
-// LoggingMiddleware logs HTTP requests with timing information
+// LoggingMiddleware logs HTTP requests with timing information and, when
+// RequestIDMiddleware runs first, the request ID
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -18,12 +19,13 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 
 		duration := time.Since(start)
 		log.Printf(
-			"%s %s %s %d %v",
+			"%s %s %s %d %v request_id=%s",
 			r.Method,
 			r.RequestURI,
 			r.RemoteAddr,
 			wrapped.StatusCode(),
 			duration,
+			RequestIDFromContext(r.Context()),
 		)
 	})
 }
@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"time"
 
+	"image-processing-system/pkg/metrics"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -35,9 +37,11 @@ var (
 )
 
 func init() {
-	prometheus.MustRegister(httpRequestsTotal)
-	prometheus.MustRegister(httpRequestDuration)
-	prometheus.MustRegister(httpRequestsInFlight)
+	metrics.Add(
+		httpRequestsTotal,
+		httpRequestDuration,
+		httpRequestsInFlight,
+	)
 }
 
 // MetricsMiddleware collects Prometheus metrics for HTTP requests
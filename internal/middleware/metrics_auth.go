@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"image-processing-system/internal/config"
+)
+
+// MetricsAuth protects a metrics handler with an optional bearer token and/or
+// basic auth credentials. When neither is configured the handler stays open.
+func MetricsAuth(cfg config.MetricsConfig, next http.Handler) http.Handler {
+	if cfg.AuthToken == "" && cfg.AuthUsername == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.AuthToken != "" {
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureCompare(token, cfg.AuthToken) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if cfg.AuthUsername != "" {
+			if user, pass, ok := r.BasicAuth(); ok && secureCompare(user, cfg.AuthUsername) && secureCompare(pass, cfg.AuthPassword) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// secureCompare compares two strings in constant time
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
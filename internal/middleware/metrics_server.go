@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/pkg/tracing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NewMetricsServer builds the metrics server for a service: Prometheus
+// metrics on cfg.Path, a /health check that includes the tracing exporter's
+// status, a /ready check served by ready
+// (always ready when nil) and a /readyz check served by readiness (always
+// ready when nil), on cfg.Port. extra adds service-specific endpoints by
+// path; they share the metrics auth, while the probes stay open.
+func NewMetricsServer(service string, cfg config.MetricsConfig, ready http.Handler, readiness *Readiness, extra map[string]http.Handler) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, MetricsAuth(cfg, promhttp.Handler()))
+	for path, h := range extra {
+		mux.Handle(path, MetricsAuth(cfg, h))
+	}
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "healthy", "service": service, "tracing": tracing.Status()})
+	})
+	if ready == nil {
+		ready = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"status": "ready", "service": service})
+		})
+	}
+	mux.Handle("/ready", ready)
+	if readiness == nil {
+		readiness = NewReadiness(service)
+		readiness.Started()
+	}
+	mux.Handle("/readyz", readiness)
+
+	return &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: mux,
+	}
+}
+
+// ConfigHandler serves the configuration a service loaded, with secrets
+// redacted, so operators can check what a running pod is using
+func ConfigHandler(service string, cfg interface{}) http.Handler {
+	body := map[string]interface{}{"service": service, "config": config.Redacted(cfg)}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	})
+}
+
+// StartMetricsServer starts the service's metrics server in the background
+// and returns it so the caller can shut it down
+func StartMetricsServer(service string, cfg config.MetricsConfig, ready http.Handler, readiness *Readiness, extra map[string]http.Handler) *http.Server {
+	srv := NewMetricsServer(service, cfg, ready, readiness, extra)
+	go func() {
+		log.Printf("Metrics server listening on :%s", cfg.Port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+	return srv
+}
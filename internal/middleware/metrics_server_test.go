@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"image-processing-system/internal/config"
+)
+
+func TestNewMetricsServer(t *testing.T) {
+	srv := NewMetricsServer("test-service", config.MetricsConfig{Port: "9999", Path: "/metrics"}, nil, nil, nil)
+
+	if srv.Addr != ":9999" {
+		t.Errorf("Addr = %q, want :9999", srv.Addr)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"service":"test-service"`) {
+		t.Errorf("unexpected /health response: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected /ready to default to ready, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected /readyz to default to ready, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("unexpected /metrics status: %d", rr.Code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	readiness := NewReadiness("test-service")
+	var depErr error
+	readiness.AddCheck("rabbitmq", func(context.Context) error { return depErr })
+	srv := NewMetricsServer("test-service", config.MetricsConfig{Port: "9999", Path: "/metrics"}, readiness.Gate(func() http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	}), readiness, nil)
+
+	probe := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	// Not ready until startup completes, even though the dependency is fine
+	if rr := probe("/readyz"); rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), `"status":"starting"`) {
+		t.Errorf("expected /readyz to report starting, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := probe("/ready"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the gated /ready to fail while starting, got %d", rr.Code)
+	}
+
+	readiness.Started()
+	if rr := probe("/readyz"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"rabbitmq":"ok"`) {
+		t.Errorf("expected /readyz to be ready once started, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := probe("/ready"); rr.Code != http.StatusNoContent {
+		t.Errorf("expected the gated /ready to defer to its handler once started, got %d", rr.Code)
+	}
+
+	// A dependency lost at runtime makes the service unready again
+	depErr = errors.New("connection closed")
+	if rr := probe("/readyz"); rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), `"rabbitmq":"connection closed"`) {
+		t.Errorf("expected /readyz to report the failed check, got %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"image-processing-system/pkg/metrics"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -18,10 +20,10 @@ var (
 	ProcessingDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "image_processing_duration_seconds",
-			Help:    "Image processing duration in seconds",
+			Help:    "Image processing duration in seconds by step (download, transform, upload) and processing type",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"step", "service"},
+		[]string{"step", "processing_type", "service"},
 	)
 
 	// Queue metrics
@@ -58,14 +60,97 @@ var (
 		},
 		[]string{"service"},
 	)
+
+	JobTimeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "job_timeouts_total",
+			Help: "Total number of jobs that exceeded the per-job timeout",
+		},
+		[]string{"service"},
+	)
+
+	AbandonedTransforms = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "abandoned_transforms",
+			Help: "Transforms still running for jobs that already timed out, each holding a decode slot",
+		},
+		[]string{"service"},
+	)
+
+	JobRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "job_retries_total",
+			Help: "Total number of failed jobs requeued for another attempt",
+		},
+		[]string{"service"},
+	)
+
+	JobsDeadLettered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jobs_dead_lettered_total",
+			Help: "Total number of jobs rejected to the dead-letter queue, by failure reason",
+		},
+		[]string{"reason", "service"},
+	)
+
+	OutputsSkipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outputs_skipped_total",
+			Help: "Total number of skip_existing outputs found already stored, by processing type",
+		},
+		[]string{"processing_type", "service"},
+	)
+
+	OutputsDiscarded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outputs_discarded_total",
+			Help: "Total number of stored outputs removed because another output of their job failed, by processing type",
+		},
+		[]string{"processing_type", "service"},
+	)
+
+	ParamsClamped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "params_clamped_total",
+			Help: "Total number of outputs whose requested params were lowered to the configured limits, by processing type",
+		},
+		[]string{"processing_type", "service"},
+	)
+
+	StoredOriginalReads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stored_original_reads_total",
+			Help: "Total number of jobs sourced from a stored original, by whether it was read or the URL was downloaded instead",
+		},
+		[]string{"outcome", "service"},
+	)
+
+	SourceFormats = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "source_images_decoded_total",
+			Help: "Total number of source images decoded, by detected format",
+		},
+		[]string{"format", "service"},
+	)
 )
 
 func init() {
 	// Register all worker metrics
-	prometheus.MustRegister(ImagesProcessed)
-	prometheus.MustRegister(ProcessingDuration)
-	prometheus.MustRegister(QueueSize)
-	prometheus.MustRegister(ActiveWorkers)
-	prometheus.MustRegister(JobsProcessed)
-	prometheus.MustRegister(JobProcessingDuration)
+	metrics.Add(
+		ImagesProcessed,
+		ProcessingDuration,
+		QueueSize,
+		ActiveWorkers,
+		JobsProcessed,
+		JobProcessingDuration,
+		JobTimeouts,
+		AbandonedTransforms,
+		JobRetries,
+		JobsDeadLettered,
+		OutputsSkipped,
+		OutputsDiscarded,
+		ParamsClamped,
+		StoredOriginalReads,
+		SourceFormats,
+	)
 }
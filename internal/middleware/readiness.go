@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readinessCheckTimeout bounds each dependency check a /readyz request runs
+const readinessCheckTimeout = 2 * time.Second
+
+// Readiness backs /readyz. A service is not ready while it is starting, until
+// it calls Started once its initial connections have succeeded; from then on
+// it is ready while every dependency check passes.
+type Readiness struct {
+	service string
+	started atomic.Bool
+	mu      sync.Mutex
+	checks  []readinessCheck
+}
+
+// readinessCheck is a named dependency check
+type readinessCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+// NewReadiness returns the readiness of a service that is still starting
+func NewReadiness(service string) *Readiness {
+	return &Readiness{service: service}
+}
+
+// AddCheck registers a dependency check run by every /readyz request once the
+// service has started
+func (r *Readiness) AddCheck(name string, check func(context.Context) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, readinessCheck{name: name, check: check})
+}
+
+// Started marks the service's startup as complete
+func (r *Readiness) Started() {
+	r.started.Store(true)
+}
+
+// Gate serves 503 until the service has started, then defers to the handler
+// next returns, which may depend on what startup created
+func (r *Readiness) Gate(next func() http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.started.Load() {
+			r.write(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "starting"})
+			return
+		}
+		next().ServeHTTP(w, req)
+	})
+}
+
+// ServeHTTP reports 503 "starting" until the service has started, then 200
+// "ready" or 503 "unready" by the dependency checks, each listed by name
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.started.Load() {
+		r.write(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "starting"})
+		return
+	}
+
+	r.mu.Lock()
+	checks := append([]readinessCheck(nil), r.checks...)
+	r.mu.Unlock()
+
+	status, code := "ready", http.StatusOK
+	results := make(map[string]string, len(checks))
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(req.Context(), readinessCheckTimeout)
+		err := c.check(ctx)
+		cancel()
+		if err != nil {
+			results[c.name] = err.Error()
+			status, code = "unready", http.StatusServiceUnavailable
+			continue
+		}
+		results[c.name] = "ok"
+	}
+	r.write(w, code, map[string]interface{}{"status": status, "checks": results})
+}
+
+// write sends a readiness response body for the service
+func (r *Readiness) write(w http.ResponseWriter, code int, body map[string]interface{}) {
+	body["service"] = r.service
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
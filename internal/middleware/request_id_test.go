@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		wantSame bool
+	}{
+		{"generated when missing", "", false},
+		{"client id reused", "client-req-42", true},
+		{"too long replaced", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"control characters replaced", "bad\nid", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromContext string
+			handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromContext = RequestIDFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			got := rr.Header().Get(RequestIDHeader)
+			if got == "" {
+				t.Fatal("expected X-Request-ID on the response")
+			}
+			if got != fromContext {
+				t.Errorf("response ID %q differs from context ID %q", got, fromContext)
+			}
+			if (got == tt.incoming) != tt.wantSame {
+				t.Errorf("got ID %q for incoming %q, want reused=%v", got, tt.incoming, tt.wantSame)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// CancelledJob marks the jobs submitted under a trace ID as cancelled: only
+// OwnerID's jobs when it is set, every job when an operator cancelled them
+type CancelledJob struct {
+	TraceID     string    `gorm:"primaryKey" json:"trace_id"`
+	OwnerID     string    `gorm:"primaryKey" json:"owner_id,omitempty"`
+	CancelledAt time.Time `json:"cancelled_at"`
+}
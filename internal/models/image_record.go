@@ -1,20 +1,41 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type ImageRecord struct {
-	ID             uint `gorm:"primaryKey"`
-	SourceURL      string
-	S3Path         string
-	ProcessedAt    time.Time
-	Status         string // "success" / "error"
-	ErrorMsg       string // nullable
-	TraceID        string
-	Width          int    // image width in pixels
-	Height         int    // image height in pixels
-	Format         string // image format (e.g., jpeg, png)
-	FileSize       int64  // image file size in bytes
-	ProcessingType string // type of processing applied (e.g., grayscale, resize)
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	SourceURL      string    `gorm:"index" json:"source_url"`
+	S3Path         string    `json:"s3_path"`
+	ProcessedAt    time.Time `json:"processed_at"`        // producer's timestamp
+	ReceivedAt     time.Time `json:"received_at"`         // when image-metadata received it, by its own clock
+	Status         string    `json:"status"`              // "success" / "error"
+	ErrorMsg       string    `json:"error_msg,omitempty"` // nullable
+	TraceID        string    `gorm:"index" json:"trace_id"`
+	Width          int       `json:"width"`            // image width in pixels
+	Height         int       `json:"height"`           // image height in pixels
+	Format         string    `json:"format"`           // image format (e.g., jpeg, png)
+	FileSize       int64     `json:"file_size"`        // image file size in bytes
+	ProcessingType string    `json:"processing_type"`  // type of processing applied (e.g., grayscale, resize)
+	Preset         string    `json:"preset,omitempty"` // resize preset name, if any
+	// Palette is a JSON array of dominant colors ("#rrggbb"), palette jobs only
+	Palette json.RawMessage `gorm:"type:jsonb" json:"palette,omitempty"`
+	// BlurHash is the image's BlurHash placeholder string, blurhash jobs only
+	BlurHash string `json:"blurhash,omitempty"`
+	// Quality, OutputWidth and OutputHeight describe the stored JPEG,
+	// compress_to jobs only
+	Quality      int `json:"quality,omitempty"`
+	OutputWidth  int `json:"output_width,omitempty"`
+	OutputHeight int `json:"output_height,omitempty"`
+	// Params is a JSON object of the params applied, after any clamping;
+	// Clamped marks records whose requested params exceeded the limits
+	Params  json.RawMessage `gorm:"type:jsonb" json:"params,omitempty"`
+	Clamped bool            `json:"clamped,omitempty"`
+	// OwnerID names the owner of the API key the job was submitted with, if
+	// any
+	OwnerID string `gorm:"index" json:"owner_id,omitempty"`
 }
 
 // ImageProcessedPayload represents the payload for processed image messages
@@ -29,4 +50,53 @@ type ImageProcessedPayload struct {
 	Format         string `json:"format"`
 	FileSize       int64  `json:"file_size"`
 	ProcessingType string `json:"processing_type"`
+	Preset         string `json:"preset,omitempty"`
+	// Palette lists the dominant colors as "#rrggbb", most common first
+	Palette []string `json:"palette,omitempty"`
+	// BlurHash is the image's BlurHash placeholder string
+	BlurHash string `json:"blurhash,omitempty"`
+	// Quality is the JPEG quality compress_to settled on, and OutputWidth
+	// and OutputHeight the size it stored, downscaled or not
+	Quality      int `json:"quality,omitempty"`
+	OutputWidth  int `json:"output_width,omitempty"`
+	OutputHeight int `json:"output_height,omitempty"`
+	// Params are the params applied to produce the output, resize presets'
+	// width and height included; Clamped marks ones the worker lowered to
+	// its limits
+	Params  *ProcessingParams `json:"params,omitempty"`
+	Clamped bool              `json:"clamped,omitempty"`
+	// OwnerID names the owner of the API key the job was submitted with
+	OwnerID string `json:"owner_id,omitempty"`
+	// Skipped marks outputs that already existed, so nothing was downloaded
+	// or processed; Width, Height and Format are unknown for them
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// SourceCount is a source URL and how many records were stored for it
+type SourceCount struct {
+	SourceURL string `json:"source_url"`
+	Count     int64  `json:"count"`
+}
+
+// ReprocessCandidate is a source image selected for reprocessing, with the
+// time its oldest matching record was processed. Candidates are ordered by
+// (ProcessedAt, SourceURL, Bucket, OwnerID, Params), and the last one of a
+// page is the cursor for the next.
+type ReprocessCandidate struct {
+	SourceURL   string
+	ProcessedAt time.Time
+	// Bucket is the bucket the matching records were stored in, empty when
+	// they weren't stored in one. A source stored in several buckets is a
+	// candidate once per bucket.
+	Bucket string
+	// OwnerID is the owner of the matching records, empty for unowned ones.
+	// A source several owners processed is a candidate once per owner.
+	OwnerID string
+	// Params is the JSON of the params the matching records were made with,
+	// empty for records without any. Records made with different params are
+	// separate candidates.
+	Params string
+	// Original is the s3_path of the owner's newest stored original of the
+	// source in Bucket, empty if there is none
+	Original string
 }
@@ -1,6 +1,67 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
 type ImageJob struct {
 	URLs            []string `json:"urls"`
 	ProcessingTypes []string `json:"processing_types"`
+	// Resize lists named size presets; each produces its own resized output
+	Resize []ResizePreset `json:"resize,omitempty"`
+	// Priority orders jobs in the queue; higher runs first, 0 is the default
+	Priority int `json:"priority,omitempty"`
+	// ProcessAfter delays processing until the given time
+	ProcessAfter *time.Time `json:"process_after,omitempty"`
+	// Format selects the output encoding: "jpeg" (default), "png", "webp"
+	// or "avif"
+	Format string `json:"format,omitempty"`
+	// Combine queues one job per URL that produces every output from a
+	// single download, instead of one job per output
+	Combine bool `json:"combine,omitempty"`
+	// Bucket overrides the configured output bucket. Submissions must carry
+	// an API key that is allowed to write to it.
+	Bucket string `json:"bucket,omitempty"`
+	// SkipExisting stores outputs under keys derived from the source and the
+	// output, and skips outputs already stored there
+	SkipExisting bool `json:"skip_existing,omitempty"`
+	// Params tunes processing types, keyed by type, e.g.
+	// {"blur": {"sigma": 3}}; types without an entry use their defaults
+	Params map[string]ProcessingParams `json:"params,omitempty"`
+	// Original is the s3:// location of the source's stored original, set
+	// on reprocess jobs. image-fetcher reads it instead of downloading the
+	// URL, which it falls back to when the object is gone.
+	Original string `json:"original,omitempty"`
+	// OwnerID names the owner of the API key the job was submitted with,
+	// set by url-ingestor. Reprocess jobs carry the owner of the records
+	// they redo; jobs submitted without an owner's key have none
+	OwnerID string `json:"owner_id,omitempty"`
+}
+
+// ProcessingParams tunes one processing type: resize takes w and h (a zero
+// side preserves the aspect ratio), blur and sharpen take sigma, convert
+// takes the target format, and compress_to takes max_bytes and whether it may
+// downscale. Zero fields are unset.
+type ProcessingParams struct {
+	Width     int     `json:"w,omitempty"`
+	Height    int     `json:"h,omitempty"`
+	Sigma     float64 `json:"sigma,omitempty"`
+	Format    string  `json:"format,omitempty"`
+	MaxBytes  int     `json:"max_bytes,omitempty"`
+	Downscale bool    `json:"downscale,omitempty"`
+}
+
+// ResizePreset is a named target size for the resize processing type.
+// A zero width or height preserves the aspect ratio.
+type ResizePreset struct {
+	Name   string `json:"name"`
+	Width  int    `json:"w"`
+	Height int    `json:"h"`
+}
+
+// NormalizeProcessingType returns the canonical form of a processing type
+// (trimmed and lowercased) so minor formatting differences are accepted
+func NormalizeProcessingType(t string) string {
+	return strings.ToLower(strings.TrimSpace(t))
 }
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// OwnerUsage is the running total of bytes stored for an owner's outputs,
+// kept by image-metadata as it stores their records
+type OwnerUsage struct {
+	OwnerID   string    `gorm:"primaryKey" json:"owner_id"`
+	BytesUsed int64     `json:"bytes_used"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+// Aggregated trace statuses reported by the bulk status endpoint
+const (
+	TraceStatusNotFound  = "not_found"
+	TraceStatusSucceeded = "succeeded"
+	TraceStatusFailed    = "failed"
+	TraceStatusPartial   = "partial"
+)
+
+// TraceStatus summarises the stored records for one trace ID
+type TraceStatus struct {
+	Status    string `json:"status"`
+	Total     int    `json:"total"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+}
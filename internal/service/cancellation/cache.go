@@ -0,0 +1,70 @@
+package cancellation
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxCachedTraces bounds the cache; expired entries are swept when it fills
+const maxCachedTraces = 10000
+
+// Checker reports whether an owner's jobs for a trace ID were cancelled
+type Checker interface {
+	IsCancelled(ctx context.Context, traceID, ownerID string) (bool, error)
+}
+
+// Cache remembers a Checker's answers for a while, so a submission's jobs
+// share one lookup instead of querying the database per job. A cancellation
+// can take up to the TTL to reach a worker that has cached the trace ID.
+type Cache struct {
+	checker Checker
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	cancelled bool
+	expires   time.Time
+}
+
+// NewCache caches checker's answers for ttl
+func NewCache(checker Checker, ttl time.Duration) *Cache {
+	return &Cache{checker: checker, ttl: ttl, now: time.Now, entries: make(map[string]cacheEntry)}
+}
+
+// IsCancelled answers from the cache, looking the trace ID and owner up when
+// they aren't cached or have expired. Failed lookups aren't cached.
+func (c *Cache) IsCancelled(ctx context.Context, traceID, ownerID string) (bool, error) {
+	key := traceID + "\x00" + ownerID
+	now := c.now()
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.cancelled, nil
+	}
+
+	cancelled, err := c.checker.IsCancelled(ctx, traceID, ownerID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCachedTraces {
+		for id, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, id)
+			}
+		}
+		if len(c.entries) >= maxCachedTraces {
+			c.entries = make(map[string]cacheEntry)
+		}
+	}
+	c.entries[key] = cacheEntry{cancelled: cancelled, expires: now.Add(c.ttl)}
+	return cancelled, nil
+}
@@ -0,0 +1,64 @@
+package cancellation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeChecker struct {
+	cancelled map[string]bool
+	err       error
+	calls     int
+}
+
+func (f *fakeChecker) IsCancelled(_ context.Context, traceID, ownerID string) (bool, error) {
+	f.calls++
+	return f.cancelled[traceID+"/"+ownerID], f.err
+}
+
+func TestCache(t *testing.T) {
+	checker := &fakeChecker{cancelled: map[string]bool{"trace-a/acme": true}}
+	cache := NewCache(checker, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if cancelled, err := cache.IsCancelled(context.Background(), "trace-a", "acme"); err != nil || !cancelled {
+			t.Fatalf("expected trace-a cancelled, got %v, %v", cancelled, err)
+		}
+		if cancelled, err := cache.IsCancelled(context.Background(), "trace-b", "acme"); err != nil || cancelled {
+			t.Fatalf("expected trace-b not cancelled, got %v, %v", cancelled, err)
+		}
+	}
+	if checker.calls != 2 {
+		t.Errorf("expected one lookup per trace ID, got %d", checker.calls)
+	}
+
+	// Another owner's jobs under the same trace ID are looked up separately
+	if cancelled, _ := cache.IsCancelled(context.Background(), "trace-a", "globex"); cancelled {
+		t.Error("expected trace-a not cancelled for globex")
+	}
+
+	// Once the entry expires, a later cancellation is seen
+	checker.cancelled["trace-b/acme"] = true
+	now = now.Add(time.Minute)
+	if cancelled, _ := cache.IsCancelled(context.Background(), "trace-b", "acme"); !cancelled {
+		t.Error("expected trace-b to be looked up again after the TTL")
+	}
+}
+
+func TestCacheDoesNotCacheErrors(t *testing.T) {
+	checker := &fakeChecker{err: errors.New("db down")}
+	cache := NewCache(checker, time.Minute)
+
+	if _, err := cache.IsCancelled(context.Background(), "trace-a", "acme"); err == nil {
+		t.Fatal("expected the lookup error")
+	}
+	checker.err = nil
+	checker.cancelled = map[string]bool{"trace-a/acme": true}
+	if cancelled, err := cache.IsCancelled(context.Background(), "trace-a", "acme"); err != nil || !cancelled {
+		t.Errorf("expected a fresh lookup after the error, got %v, %v", cancelled, err)
+	}
+}
@@ -0,0 +1,69 @@
+package cancellation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Store records cancelled trace IDs in PostgreSQL so the ingestor and every
+// worker replica share the same view
+type Store struct {
+	db *gorm.DB
+}
+
+// NewStore connects to the database and ensures the cancellation table exists
+func NewStore(cfg config.DatabaseConfig) (*Store, error) {
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get underlying sql.DB: %w", err)
+	}
+	sqlDB.SetMaxIdleConns(2)
+	sqlDB.SetMaxOpenConns(10)
+	sqlDB.SetConnMaxLifetime(time.Hour)
+
+	if cfg.AutoMigrate {
+		log.Printf("Auto-migrating the cancelled jobs table (DB_AUTO_MIGRATE=true)")
+		if err := db.AutoMigrate(&models.CancelledJob{}); err != nil {
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+	} else {
+		log.Printf("Skipping auto-migration of the cancelled jobs table (DB_AUTO_MIGRATE=false); the schema must be managed externally")
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Cancel marks ownerID's jobs under a trace ID as cancelled, or every job
+// under it when ownerID is empty. Cancelling twice is not an error.
+func (s *Store) Cancel(ctx context.Context, traceID, ownerID string) error {
+	record := models.CancelledJob{TraceID: traceID, OwnerID: ownerID, CancelledAt: time.Now().UTC()}
+	return s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&record).Error
+}
+
+// IsCancelled reports whether ownerID's jobs under a trace ID have been
+// cancelled, by that owner or by an operator. Rows from before owners were
+// recorded have a NULL owner and cancel every job, as they did then.
+func (s *Store) IsCancelled(ctx context.Context, traceID, ownerID string) (bool, error) {
+	var count int64
+	err := s.db.WithContext(ctx).
+		Model(&models.CancelledJob{}).
+		Where("trace_id = ? AND COALESCE(owner_id, '') IN ('', ?)", traceID, ownerID).
+		Count(&count).Error
+	return count > 0, err
+}
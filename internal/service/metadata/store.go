@@ -2,24 +2,28 @@ package metadata
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"time"
 
 	"image-processing-system/internal/config"
 	"image-processing-system/internal/models"
+	"image-processing-system/internal/service/quota"
 	"image-processing-system/pkg/message"
+	"image-processing-system/pkg/metrics"
+	"image-processing-system/pkg/rabbitmq"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -39,33 +43,47 @@ var (
 		},
 	)
 
+	endToEndLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "end_to_end_latency_seconds",
+			Help:    "Time from submission to the stored record in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+		},
+	)
+
 	dbConnections = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "db_connections_active",
 			Help: "Number of active database connections",
 		},
 	)
+
+	dbAvailable = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "db_available",
+			Help: "Whether the metadata consumer can reach the database (1) or is holding results until it recovers (0)",
+		},
+	)
 )
 
 func init() {
-	prometheus.MustRegister(recordsStored)
-	prometheus.MustRegister(storageDuration)
-	prometheus.MustRegister(dbConnections)
+	metrics.Add(
+		recordsStored,
+		storageDuration,
+		endToEndLatency,
+		dbConnections,
+		dbAvailable,
+	)
 }
 
 // MetadataService handles metadata operations
 type MetadataService struct {
-	db            *gorm.DB
-	metricsServer *http.Server
+	db *gorm.DB
 }
 
 // NewMetadataService creates a new metadata service instance
 func NewMetadataService(cfg config.DatabaseConfig) (*MetadataService, error) {
-	// Use a more compatible connection string format for PostgreSQL 17
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s connect_timeout=10",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{
 		// Add connection pool settings for better stability
 		DisableForeignKeyConstraintWhenMigrating: true,
 	})
@@ -84,123 +102,442 @@ func NewMetadataService(cfg config.DatabaseConfig) (*MetadataService, error) {
 	sqlDB.SetMaxOpenConns(100)
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
-	// Auto migrate the schema
-	if err := db.AutoMigrate(&models.ImageRecord{}); err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	// Auto migrate the schema unless it is managed externally
+	if cfg.AutoMigrate {
+		log.Printf("Auto-migrating the image records and owner usage tables (DB_AUTO_MIGRATE=true)")
+		if err := db.AutoMigrate(&models.ImageRecord{}, &models.OwnerUsage{}); err != nil {
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+	} else {
+		log.Printf("Skipping auto-migration of the image records and owner usage tables (DB_AUTO_MIGRATE=false); the schema must be managed externally")
 	}
 
-	// Start metrics server
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"status":"healthy","service":"image-metadata"}`))
-	})
+	return &MetadataService{db: db}, nil
+}
+
+// ConsumeAndStore processes messages from the result queue and stores metadata.
+// A result is acked once stored. While the database is unreachable the
+// consumer stops and leaves its results unacked for RabbitMQ to hold; results
+// that still fail after cfg.MaxAttempts are requeued up to cfg.MaxRequeues
+// times, then dead-lettered.
+func (m *MetadataService) ConsumeAndStore(ch *amqp.Channel, queue, consumerTag string, cfg config.StoreConfig) {
+	if err := ch.Qos(cfg.Prefetch, 0, false); err != nil {
+		log.Printf("Failed to set prefetch: %v", err)
+		return
+	}
+	msgs, err := ch.Consume(queue, consumerTag, false, false, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to consume messages: %v", err)
+		return
+	}
+	log.Printf("Consuming %s as %s", queue, consumerTag)
+	dbAvailable.Set(1)
+
+	handler := func(ctx context.Context, msg amqp.Delivery) rabbitmq.Decision {
+		return m.storeResult(ctx, ch, queue, msg, cfg)
+	}
+	rabbitmq.NewConsumer(config.ImageMetadataService, handler, rabbitmq.ConsumerOptions{}).Run(msgs)
+}
 
-	metricsServer := &http.Server{
-		Addr:    ":8083",
-		Handler: mux,
+// storeResult stores the metadata of one result and decides how its
+// delivery is settled
+func (m *MetadataService) storeResult(ctx context.Context, ch publisher, queue string, msg amqp.Delivery, cfg config.StoreConfig) rabbitmq.Decision {
+	start := time.Now()
+	env, payload, err := message.DecodeReceived[models.ImageProcessedPayload](msg.Body, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to decode message: %v", err)
+		recordsStored.WithLabelValues("decode_error").Inc()
+		return rabbitmq.DeadLetter
 	}
+	defer func() { storageDuration.Observe(time.Since(start).Seconds()) }()
+
+	processingType := models.NormalizeProcessingType(payload.ProcessingType)
 
-	go func() {
-		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("Metrics server error: %v", err)
+	tracer := otel.Tracer(config.ImageMetadataService)
+	spanName := "StoreMetadata/" + processingType
+	ctx, span := tracer.Start(ctx, spanName, trace.WithSpanKind(trace.SpanKindConsumer))
+	span.SetAttributes(
+		attribute.String("processing_type", processingType),
+		attribute.String("status", payload.Status),
+		attribute.String("source_url", payload.SourceURL),
+		attribute.String("trace_id", payload.TraceID),
+		attribute.String("messaging.system", "rabbitmq"),
+		attribute.String("messaging.destination.name", queue),
+		attribute.String("messaging.operation", "process"),
+		attribute.Int64("messaging.clock_skew_ms", env.ClockSkew().Milliseconds()),
+	)
+	defer span.End()
+
+	record := models.ImageRecord{
+		SourceURL:      payload.SourceURL,
+		S3Path:         payload.S3Path,
+		ProcessedAt:    env.Timestamp,
+		ReceivedAt:     *env.ReceivedAt,
+		Status:         payload.Status,
+		ErrorMsg:       payload.ErrorMsg,
+		TraceID:        payload.TraceID,
+		Width:          payload.Width,
+		Height:         payload.Height,
+		Format:         payload.Format,
+		FileSize:       payload.FileSize,
+		ProcessingType: processingType,
+		Preset:         payload.Preset,
+		BlurHash:       payload.BlurHash,
+		Quality:        payload.Quality,
+		OutputWidth:    payload.OutputWidth,
+		OutputHeight:   payload.OutputHeight,
+		Clamped:        payload.Clamped,
+		OwnerID:        payload.OwnerID,
+	}
+	if record.OwnerID == "" {
+		record.OwnerID = env.OwnerID
+	}
+	if len(payload.Palette) > 0 {
+		record.Palette, _ = json.Marshal(payload.Palette)
+	}
+	if payload.Params != nil {
+		record.Params, _ = json.Marshal(payload.Params)
+	}
+
+	// Outputs count against their owner's quota
+	charged := quota.Charge(record.OwnerID, *payload)
+
+	if err := insertWithRetry(ctx, func() error { return m.createRecord(ctx, &record, charged) }, m.Ping, cfg); err != nil {
+		if requeueResult(ch, queue, msg, cfg.MaxRequeues, err) {
+			recordsStored.WithLabelValues("requeued").Inc()
+			return rabbitmq.Ack
 		}
-	}()
+		recordsStored.WithLabelValues("error").Inc()
+		return rabbitmq.DeadLetter
+	}
+
+	log.Printf("Saved image record: %s -> %s", payload.SourceURL, payload.S3Path)
+	recordsStored.WithLabelValues("success").Inc()
+	if env.SubmittedAt != nil {
+		endToEndLatency.Observe(time.Since(*env.SubmittedAt).Seconds())
+	}
+	return rabbitmq.Ack
+}
 
-	return &MetadataService{db: db, metricsServer: metricsServer}, nil
+// publisher is the part of *amqp.Channel used to requeue results
+type publisher interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
 }
 
-// ConsumeAndStore processes messages and stores metadata
-func (m *MetadataService) ConsumeAndStore(ch *amqp.Channel) {
-	msgs, err := ch.Consume("image.processed", "", true, false, false, false, nil)
+// requeueResult republishes a result that couldn't be stored to the back of
+// queue with its attempt header incremented. It reports false when the
+// result has been requeued maxRequeues times already and should be
+// dead-lettered instead.
+func requeueResult(ch publisher, queue string, msg amqp.Delivery, maxRequeues int, storeErr error) bool {
+	attempt := message.Attempt(msg.Headers)
+	if attempt >= maxRequeues {
+		log.Printf("Failed to save record after %d requeues, dead-lettering it: %v", attempt, storeErr)
+		return false
+	}
+	if err := ch.Publish("", queue, false, false, rabbitmq.Republish(msg, attempt+1)); err != nil {
+		log.Printf("Failed to requeue record, dead-lettering it: %v", err)
+		return false
+	}
+	log.Printf("Failed to save record, requeued for attempt %d: %v", attempt+1, storeErr)
+	return true
+}
+
+// insertWithRetry runs insert up to cfg.MaxAttempts times. When an attempt
+// fails because ping can't reach the database either, it waits for the
+// database to come back instead, without using up an attempt.
+func insertWithRetry(ctx context.Context, insert func() error, ping func(context.Context) error, cfg config.StoreConfig) error {
+	for attempt := 1; ; attempt++ {
+		err := insert()
+		if err == nil {
+			return nil
+		}
+		if pingErr := ping(ctx); pingErr != nil {
+			log.Printf("Database unreachable, holding results until it recovers: %v", pingErr)
+			dbAvailable.Set(0)
+			waitForDB(ctx, ping, cfg.DBCheckInterval)
+			dbAvailable.Set(1)
+			log.Printf("Database reachable again, resuming")
+			attempt--
+			continue
+		}
+		if attempt >= cfg.MaxAttempts {
+			return err
+		}
+		log.Printf("Failed to save record (attempt %d/%d), retrying in %s: %v", attempt, cfg.MaxAttempts, cfg.RetryBackoff, err)
+		time.Sleep(cfg.RetryBackoff)
+	}
+}
+
+// waitForDB blocks until ping succeeds, checking every interval
+func waitForDB(ctx context.Context, ping func(context.Context) error, interval time.Duration) {
+	for ping(ctx) != nil {
+		time.Sleep(interval)
+	}
+}
+
+// Ping checks that the database accepts connections
+func (m *MetadataService) Ping(ctx context.Context) error {
+	sqlDB, err := m.db.DB()
 	if err != nil {
-		log.Printf("Failed to consume messages: %v", err)
-		return
+		return err
 	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
 
-	for msg := range msgs {
-		start := time.Now()
+// createRecord inserts record inside a DBCreate span carrying the table,
+// processing type and, once inserted, the record ID
+func (m *MetadataService) createRecord(ctx context.Context, record *models.ImageRecord, charged int64) error {
+	ctx, span := otel.Tracer(config.ImageMetadataService).Start(ctx, "DBCreate", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", "INSERT"),
+		attribute.String("processing_type", record.ProcessingType),
+	)
 
-		// Extract trace context from AMQP headers (robust for string and []byte)
-		prop := propagation.TraceContext{}
-		headers := make(map[string]string)
-		for k, v := range msg.Headers {
-			switch val := v.(type) {
-			case string:
-				headers[k] = val
-			case []byte:
-				headers[k] = string(val)
+	// The record and its bytes are stored together, so a retried insert
+	// can't count them twice, and a result delivered again after it was
+	// stored adds neither
+	var table string
+	err := m.db.WithContext(ctx).Transaction(func(db *gorm.DB) error {
+		if record.S3Path != "" {
+			var existing models.ImageRecord
+			tx := db.Where("trace_id = ? AND s3_path = ? AND processing_type = ? AND preset = ?",
+				record.TraceID, record.S3Path, record.ProcessingType, record.Preset).Limit(1).Find(&existing)
+			table = tx.Statement.Table
+			if tx.Error != nil {
+				return tx.Error
+			}
+			if tx.RowsAffected > 0 {
+				log.Printf("Record for %s already stored as %d, skipping the duplicate", record.S3Path, existing.ID)
+				*record = existing
+				return nil
 			}
 		}
-		if tp, ok := headers["traceparent"]; ok {
-			log.Printf("[metadata] Consumed traceparent: %s", tp)
+		tx := db.Create(record)
+		table = tx.Statement.Table
+		if tx.Error != nil || charged <= 0 {
+			return tx.Error
 		}
-		ctx := context.Background()
-		ctx = prop.Extract(ctx, propagation.MapCarrier(headers))
+		return addUsage(db, record.OwnerID, charged)
+	})
+	span.SetAttributes(attribute.String("db.sql.table", table))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetAttributes(attribute.Int64("db.record_id", int64(record.ID)))
+	return nil
+}
+
+// addUsage adds bytes to the running total of an owner's stored outputs
+func addUsage(db *gorm.DB, ownerID string, bytes int64) error {
+	usage := models.OwnerUsage{OwnerID: ownerID, BytesUsed: bytes, UpdatedAt: time.Now().UTC()}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "owner_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"bytes_used": gorm.Expr("owner_usages.bytes_used + EXCLUDED.bytes_used"),
+			"updated_at": gorm.Expr("EXCLUDED.updated_at"),
+		}),
+	}).Create(&usage).Error
+}
 
-		env, payload, err := message.Decode[models.ImageProcessedPayload](msg.Body)
-		if err != nil {
-			log.Printf("Failed to decode message: %v", err)
-			recordsStored.WithLabelValues("decode_error").Inc()
+// GetImageRecords retrieves image records from the database, only ownerID's
+// when it is set
+func (m *MetadataService) GetImageRecords(limit int, ownerID string) ([]models.ImageRecord, error) {
+	var records []models.ImageRecord
+	query := m.db.Order("processed_at DESC").Limit(limit)
+	if ownerID != "" {
+		query = query.Where("owner_id = ?", ownerID)
+	}
+	err := query.Find(&records).Error
+	return records, err
+}
+
+// TraceStatuses aggregates the stored records of each trace ID with a single
+// grouped query, only ownerID's records when it is set. Every requested ID is
+// present in the result; IDs without such records are reported as not found.
+func (m *MetadataService) TraceStatuses(ctx context.Context, traceIDs []string, ownerID string) (map[string]models.TraceStatus, error) {
+	var rows []struct {
+		TraceID string
+		Status  string
+		Count   int
+	}
+	query := m.db.WithContext(ctx).Model(&models.ImageRecord{}).
+		Select("trace_id, status, COUNT(*) AS count").
+		Where("trace_id IN ?", traceIDs)
+	if ownerID != "" {
+		query = query.Where("owner_id = ?", ownerID)
+	}
+	err := query.
+		Group("trace_id, status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]models.TraceStatus, len(traceIDs))
+	for _, id := range traceIDs {
+		statuses[id] = models.TraceStatus{Status: models.TraceStatusNotFound}
+	}
+	for _, row := range rows {
+		s := statuses[row.TraceID]
+		s.Total += row.Count
+		if row.Status == "success" {
+			s.Succeeded += row.Count
+		} else {
+			s.Failed += row.Count
+		}
+		statuses[row.TraceID] = s
+	}
+	for id, s := range statuses {
+		switch {
+		case s.Total == 0:
 			continue
+		case s.Failed == 0:
+			s.Status = models.TraceStatusSucceeded
+		case s.Succeeded == 0:
+			s.Status = models.TraceStatusFailed
+		default:
+			s.Status = models.TraceStatusPartial
 		}
+		statuses[id] = s
+	}
+	return statuses, nil
+}
 
-		tracer := otel.Tracer("image-metadata")
-		spanName := "StoreMetadata/" + payload.ProcessingType
-		ctx, span := tracer.Start(ctx, spanName, trace.WithSpanKind(trace.SpanKindConsumer))
-		span.SetAttributes(
-			attribute.String("processing_type", payload.ProcessingType),
-			attribute.String("status", payload.Status),
-			attribute.String("source_url", payload.SourceURL),
-			attribute.String("trace_id", payload.TraceID),
-			attribute.String("messaging.system", "rabbitmq"),
-			attribute.String("messaging.destination.name", "image.processed"),
-			attribute.String("messaging.operation", "process"),
-		)
-		defer span.End()
-
-		record := models.ImageRecord{
-			SourceURL:      payload.SourceURL,
-			S3Path:         payload.S3Path,
-			ProcessedAt:    env.Timestamp,
-			Status:         payload.Status,
-			ErrorMsg:       payload.ErrorMsg,
-			TraceID:        payload.TraceID,
-			Width:          payload.Width,
-			Height:         payload.Height,
-			Format:         payload.Format,
-			FileSize:       payload.FileSize,
-			ProcessingType: payload.ProcessingType,
+// SourceCounts counts the records of each source URL with a grouped query,
+// most processed first, skipping offset URLs and returning at most limit. It
+// also returns the number of distinct source URLs. Only ownerID's records
+// are counted when it is set.
+func (m *MetadataService) SourceCounts(ctx context.Context, limit, offset int, ownerID string) ([]models.SourceCount, int64, error) {
+	query := func() *gorm.DB {
+		q := m.db.WithContext(ctx).Model(&models.ImageRecord{})
+		if ownerID != "" {
+			q = q.Where("owner_id = ?", ownerID)
 		}
+		return q
+	}
 
-		// Optional: wrap DB create in a child span
-		dbCtx, dbSpan := tracer.Start(ctx, "DBCreate")
-		if err := m.db.WithContext(dbCtx).Create(&record).Error; err != nil {
-			dbSpan.RecordError(err)
-			log.Printf("Failed to save record to database: %v", err)
-			recordsStored.WithLabelValues("error").Inc()
-		} else {
-			log.Printf("Saved image record: %s -> %s", payload.SourceURL, payload.S3Path)
-			recordsStored.WithLabelValues("success").Inc()
-		}
-		dbSpan.End()
+	var total int64
+	if err := query().Distinct("source_url").Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
 
-		storageDuration.Observe(time.Since(start).Seconds())
+	var counts []models.SourceCount
+	err := query().
+		Select("source_url, COUNT(*) AS count").
+		Group("source_url").
+		Order("count DESC, source_url").
+		Limit(limit).
+		Offset(offset).
+		Scan(&counts).Error
+	if err != nil {
+		return nil, 0, err
 	}
+	return counts, total, nil
 }
 
-// GetImageRecords retrieves image records from the database
-func (m *MetadataService) GetImageRecords(limit int) ([]models.ImageRecord, error) {
-	var records []models.ImageRecord
-	err := m.db.Order("processed_at DESC").Limit(limit).Find(&records).Error
-	return records, err
+// recordBucket is the SQL for the bucket of a record's s3://bucket/key
+// s3_path, or an empty string for records stored outside a bucket
+const recordBucket = "CASE WHEN s3_path LIKE 's3://%' THEN split_part(s3_path, '/', 3) ELSE '' END"
+
+// recordParams is the SQL for a record's params as text, empty for none, so
+// records made with the same params group together
+const recordParams = "COALESCE(params::text, '')"
+
+// ReprocessCandidates finds the distinct source URLs, buckets, owners and
+// params with a record of processingType processed in [since, until), oldest
+// first. It returns at most limit candidates ordered after the after cursor,
+// if given, along with the total number matched in the window. Resize records
+// made from a named preset are excluded, since reprocess jobs carry params
+// rather than presets. Each candidate carries its owner's newest original
+// stored in its bucket, if any.
+func (m *MetadataService) ReprocessCandidates(ctx context.Context, processingType string, since, until time.Time, after *models.ReprocessCandidate, limit int) ([]models.ReprocessCandidate, int64, error) {
+	query := func() *gorm.DB {
+		return m.db.WithContext(ctx).Model(&models.ImageRecord{}).
+			Where("processing_type = ? AND processed_at >= ? AND processed_at < ? AND preset = ''", processingType, since, until)
+	}
+
+	var total int64
+	if err := query().Select("COUNT(DISTINCT (source_url, " + recordBucket + ", owner_id, " + recordParams + "))").Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// The window stays fixed across pages and the cursor moves within it, so
+	// each candidate keeps the same MIN(processed_at) and sorts exactly once
+	page := query().
+		Select("source_url, " + recordBucket + " AS bucket, owner_id, " + recordParams + " AS params, MIN(processed_at) AS processed_at").
+		Group("source_url, bucket, owner_id, params")
+	if after != nil {
+		page = page.Having("(MIN(processed_at), source_url, "+recordBucket+", owner_id, "+recordParams+") > (?, ?, ?, ?, ?)",
+			after.ProcessedAt, after.SourceURL, after.Bucket, after.OwnerID, after.Params)
+	}
+	var candidates []models.ReprocessCandidate
+	err := page.
+		Order("MIN(processed_at), source_url, bucket, owner_id, params").
+		Limit(limit).
+		Scan(&candidates).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := m.attachOriginals(ctx, candidates); err != nil {
+		return nil, 0, err
+	}
+	return candidates, total, nil
 }
 
-// GetImageRecordByID retrieves a specific image record by ID
+// attachOriginals sets each candidate's Original to the s3_path of its owner's
+// newest successful original record of its source URL in its bucket
+func (m *MetadataService) attachOriginals(ctx context.Context, candidates []models.ReprocessCandidate) error {
+	if len(candidates) == 0 {
+		return nil
+	}
+	urls := make([]string, len(candidates))
+	for i, c := range candidates {
+		urls[i] = c.SourceURL
+	}
+
+	var originals []struct {
+		SourceURL string
+		Bucket    string
+		OwnerID   string
+		S3Path    string
+	}
+	err := m.db.WithContext(ctx).Model(&models.ImageRecord{}).
+		Select("DISTINCT ON (source_url, bucket, owner_id) source_url, "+recordBucket+" AS bucket, owner_id, s3_path").
+		Where("processing_type = 'original' AND status = 'success' AND s3_path LIKE 's3://%' AND source_url IN ?", urls).
+		Order("source_url, bucket, owner_id, processed_at DESC").
+		Scan(&originals).Error
+	if err != nil {
+		return err
+	}
+
+	type location struct{ url, bucket, owner string }
+	stored := make(map[location]string, len(originals))
+	for _, o := range originals {
+		stored[location{o.SourceURL, o.Bucket, o.OwnerID}] = o.S3Path
+	}
+	for i, c := range candidates {
+		candidates[i].Original = stored[location{c.SourceURL, c.Bucket, c.OwnerID}]
+	}
+	return nil
+}
+
+// ErrRecordNotFound is returned when no image record has the requested ID
+var ErrRecordNotFound = errors.New("image record not found")
+
+// GetImageRecordByID retrieves a specific image record by ID, returning
+// ErrRecordNotFound when there is none
 func (m *MetadataService) GetImageRecordByID(id uint) (*models.ImageRecord, error) {
 	var record models.ImageRecord
 	err := m.db.First(&record, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %d", ErrRecordNotFound, id)
+	}
 	if err != nil {
 		return nil, err
 	}
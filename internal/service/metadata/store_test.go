@@ -0,0 +1,105 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/pkg/message"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestInsertWithRetry(t *testing.T) {
+	cfg := config.StoreConfig{MaxAttempts: 2}
+	errInsert := errors.New("insert failed")
+
+	t.Run("waits out an outage without using attempts", func(t *testing.T) {
+		// The database is down for the first three pings, longer than the
+		// attempts allow
+		inserts, pings := 0, 0
+		down := func() bool { return pings < 4 }
+		insert := func() error {
+			inserts++
+			if down() {
+				return errInsert
+			}
+			return nil
+		}
+		ping := func(context.Context) error {
+			pings++
+			if down() {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+
+		if err := insertWithRetry(context.Background(), insert, ping, cfg); err != nil {
+			t.Fatalf("expected the insert to succeed once the database recovered, got %v", err)
+		}
+		if inserts != 2 {
+			t.Errorf("expected a retry after the outage, got %d inserts", inserts)
+		}
+	})
+
+	t.Run("gives up on a reachable database", func(t *testing.T) {
+		inserts := 0
+		insert := func() error { inserts++; return errInsert }
+		ping := func(context.Context) error { return nil }
+
+		if err := insertWithRetry(context.Background(), insert, ping, cfg); !errors.Is(err, errInsert) {
+			t.Fatalf("expected the insert error, got %v", err)
+		}
+		if inserts != cfg.MaxAttempts {
+			t.Errorf("expected %d inserts, got %d", cfg.MaxAttempts, inserts)
+		}
+	})
+}
+
+// recordingPublisher records what is published through it
+type recordingPublisher struct {
+	keys []string
+	pubs []amqp.Publishing
+	err  error
+}
+
+func (p *recordingPublisher) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.keys = append(p.keys, key)
+	p.pubs = append(p.pubs, msg)
+	return nil
+}
+
+func TestRequeueResult(t *testing.T) {
+	errStore := errors.New("constraint violated")
+	msg := amqp.Delivery{Body: []byte(`{"x":1}`), Headers: amqp.Table{message.AttemptHeader: int32(1)}}
+
+	pub := &recordingPublisher{}
+	if !requeueResult(pub, "image.processed", msg, 2, errStore) {
+		t.Fatal("expected the result to be requeued")
+	}
+	if len(pub.pubs) != 1 || pub.keys[0] != "image.processed" {
+		t.Fatalf("expected one publish to image.processed, got %v", pub.keys)
+	}
+	if got := message.Attempt(pub.pubs[0].Headers); got != 2 {
+		t.Errorf("requeued attempt = %d, want 2", got)
+	}
+	if string(pub.pubs[0].Body) != `{"x":1}` {
+		t.Errorf("requeued body = %s", pub.pubs[0].Body)
+	}
+
+	// Out of requeues
+	msg.Headers = amqp.Table{message.AttemptHeader: int32(2)}
+	if requeueResult(pub, "image.processed", msg, 2, errStore) {
+		t.Error("expected the result to be dead-lettered after 2 requeues")
+	}
+
+	// A failed publish dead-letters rather than losing the result
+	msg.Headers = nil
+	if requeueResult(&recordingPublisher{err: errors.New("channel closed")}, "image.processed", msg, 2, errStore) {
+		t.Error("expected a failed publish to dead-letter")
+	}
+}
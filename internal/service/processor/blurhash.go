@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// BlurHash component counts: enough detail for a placeholder while keeping
+// the string at 28 characters
+const (
+	blurHashXComponents = 4
+	blurHashYComponents = 3
+	// blurHashSampleSize bounds the image size the hash is computed from;
+	// the hash only keeps the lowest frequencies anyway
+	blurHashSampleSize = 64
+)
+
+// base83 is BlurHash's alphabet
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash returns the BlurHash (https://blurha.sh) of img, a short string
+// frontends decode into a blurred placeholder while the real image loads
+func (p *ImageProcessor) BlurHash(img image.Image) string {
+	if img == nil || img.Bounds().Empty() {
+		return ""
+	}
+	sample := imaging.Fit(img, blurHashSampleSize, blurHashSampleSize, imaging.Box)
+	bounds := sample.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Linear RGB of every pixel, converted once
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := sample.NRGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			linear[y*width+x] = [3]float64{sRGBToLinear(c.R), sRGBToLinear(c.G), sRGBToLinear(c.B)}
+		}
+	}
+
+	factors := make([][3]float64, 0, blurHashXComponents*blurHashYComponents)
+	for j := 0; j < blurHashYComponents; j++ {
+		for i := 0; i < blurHashXComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) * basisY
+					px := linear[y*width+x]
+					factor[0] += basis * px[0]
+					factor[1] += basis * px[1]
+					factor[2] += basis * px[2]
+				}
+			}
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	encode83(&hash, (blurHashXComponents-1)+(blurHashYComponents-1)*9, 1)
+
+	// The AC components are quantised relative to the largest of them
+	dc, ac := factors[0], factors[1:]
+	actualMax := 0.0
+	for _, f := range ac {
+		actualMax = max(actualMax, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+	}
+	quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+	maximumValue := float64(quantisedMax+1) / 166
+	encode83(&hash, quantisedMax, 1)
+
+	encode83(&hash, linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+		}
+		encode83(&hash, quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2)
+	}
+	return hash.String()
+}
+
+// encode83 appends value as length base83 digits
+func encode83(b *strings.Builder, value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		digit := value / int(math.Pow(83, float64(i))) % 83
+		b.WriteByte(base83[digit])
+	}
+}
+
+// sRGBToLinear converts an sRGB channel to linear light in [0, 1]
+func sRGBToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts linear light back to an sRGB channel
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// signPow raises |v| to exp, keeping v's sign
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
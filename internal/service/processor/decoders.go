@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+
+	"image-processing-system/internal/config"
+)
+
+// defaultFormatLimits guard the formats whose decoders allocate far more than
+// the encoded size suggests: a few megabytes of GIF or TIFF can describe
+// gigapixel frames
+var defaultFormatLimits = []config.FormatLimit{
+	{Format: "jpeg", MaxSide: 16384},
+	{Format: "png", MaxSide: 16384},
+	{Format: "gif", MaxSide: 4096, MaxBytes: 10 << 20},
+	{Format: "bmp", MaxSide: 8192},
+	{Format: "tiff", MaxSide: 8192},
+}
+
+// DecoderRegistry holds the limits each source format is checked against,
+// from its header, before the image is decoded. Formats without an entry
+// only get the general download limits.
+type DecoderRegistry struct {
+	limits map[string]config.FormatLimit
+}
+
+// NewDecoderRegistry returns a registry with the default limits, replaced
+// per format by overrides
+func NewDecoderRegistry(overrides ...config.FormatLimit) *DecoderRegistry {
+	r := &DecoderRegistry{limits: make(map[string]config.FormatLimit)}
+	for _, l := range defaultFormatLimits {
+		r.Register(l)
+	}
+	for _, l := range overrides {
+		r.Register(l)
+	}
+	return r
+}
+
+// Register sets the limits for l.Format
+func (r *DecoderRegistry) Register(l config.FormatLimit) {
+	r.limits[normalizeFormat(l.Format)] = l
+}
+
+// Limits returns the limits registered for format
+func (r *DecoderRegistry) Limits(format string) (config.FormatLimit, bool) {
+	l, ok := r.limits[normalizeFormat(format)]
+	return l, ok
+}
+
+// Check returns an error wrapping ErrImageTooLarge if an image of format with
+// the given dimensions and encoded size exceeds its format's limits
+func (r *DecoderRegistry) Check(format string, width, height int, size int64) error {
+	l, ok := r.Limits(format)
+	if !ok {
+		return nil
+	}
+	if l.MaxSide > 0 && max(width, height) > l.MaxSide {
+		return fmt.Errorf("%w: %dx%d %s exceeds %dpx", ErrImageTooLarge, width, height, format, l.MaxSide)
+	}
+	if l.MaxBytes > 0 && size > l.MaxBytes {
+		return fmt.Errorf("%w: %d byte %s exceeds %d bytes", ErrImageTooLarge, size, format, l.MaxBytes)
+	}
+	return nil
+}
+
+// loadFormatLimits parses the configured overrides, which config validation
+// has already checked
+func loadFormatLimits(spec string) []config.FormatLimit {
+	limits, err := config.ParseFormatLimits(spec)
+	if err != nil {
+		log.Printf("Ignoring invalid format limits: %v", err)
+	}
+	return limits
+}
@@ -0,0 +1,79 @@
+package processor
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"image-processing-system/internal/config"
+)
+
+// newTestProcessor returns a processor that doesn't retry, so error cases fail fast
+func newTestProcessor() *ImageProcessor {
+	return NewImageProcessorWithConfig(config.DownloadConfig{MaxBytes: DefaultMaxDownloadBytes})
+}
+
+// fixtureImage is a small image with a gradient so encoders don't collapse it
+func fixtureImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 8), 128, 255})
+		}
+	}
+	return img
+}
+
+// newFixtureServer serves fixtureHandler's fixtures over HTTP/1.1
+func newFixtureServer(t testing.TB) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(fixtureHandler(t))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// fixtureHandler serves known fixtures for download tests:
+//
+//	/image.jpg, /image.png, /image.gif  a 16x12 image in that format
+//	/error                              HTTP 500
+//	/text                               a non-image body
+//	/large                              a body larger than the test size limit
+func fixtureHandler(t testing.TB) http.Handler {
+	t.Helper()
+
+	encode := func(fn func(*bytes.Buffer, image.Image) error) []byte {
+		var buf bytes.Buffer
+		if err := fn(&buf, fixtureImage(16, 12)); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	fixtures := map[string][]byte{
+		"/image.jpg": encode(func(b *bytes.Buffer, img image.Image) error { return jpeg.Encode(b, img, nil) }),
+		"/image.png": encode(func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) }),
+		"/image.gif": encode(func(b *bytes.Buffer, img image.Image) error { return gif.Encode(b, img, nil) }),
+		"/text":      []byte("this is not an image"),
+		"/large":     bytes.Repeat([]byte{0xff}, 4096),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		body, ok := fixtures[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(body)
+	})
+	return mux
+}
@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// hostLimiter caps concurrent downloads per origin host so a batch from one
+// origin can't overwhelm it. Idle hosts are dropped from the map.
+type hostLimiter struct {
+	limit int
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+type hostSlot struct {
+	sem  chan struct{}
+	refs int
+}
+
+// newHostLimiter returns a limiter allowing limit concurrent requests per
+// host; limit <= 0 disables limiting
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, hosts: make(map[string]*hostSlot)}
+}
+
+// acquire waits for a slot for host, returning a func that releases it, or
+// ctx's error if ctx is done first
+func (l *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	if l == nil || l.limit <= 0 {
+		return func() {}, nil
+	}
+	host = strings.ToLower(host)
+
+	l.mu.Lock()
+	slot, ok := l.hosts[host]
+	if !ok {
+		slot = &hostSlot{sem: make(chan struct{}, l.limit)}
+		l.hosts[host] = slot
+	}
+	slot.refs++
+	l.mu.Unlock()
+
+	select {
+	case slot.sem <- struct{}{}:
+		return func() {
+			<-slot.sem
+			l.unref(host, slot)
+		}, nil
+	case <-ctx.Done():
+		l.unref(host, slot)
+		return nil, ctx.Err()
+	}
+}
+
+func (l *hostLimiter) unref(host string, slot *hostSlot) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot.refs--
+	if slot.refs == 0 {
+		delete(l.hosts, host)
+	}
+}
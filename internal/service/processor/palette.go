@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// paletteSampleSize bounds the image size sampled for dominant colors; the
+// palette of a thumbnail matches the full image closely enough
+const paletteSampleSize = 128
+
+// DominantColors returns up to n of the most common colors in img, most
+// common first. Colors are bucketed into a 4-bit-per-channel histogram and
+// each bucket is reported as the average of its pixels. Fully transparent
+// pixels are ignored.
+func (p *ImageProcessor) DominantColors(img image.Image, n int) []color.RGBA {
+	if img == nil || n <= 0 {
+		return nil
+	}
+	sample := imaging.Fit(img, paletteSampleSize, paletteSampleSize, imaging.Box)
+
+	type bucket struct {
+		key, r, g, b, count int
+	}
+	buckets := make(map[int]*bucket)
+	bounds := sample.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(sample.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			key := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			b, ok := buckets[key]
+			if !ok {
+				b = &bucket{key: key}
+				buckets[key] = b
+			}
+			b.r += int(c.R)
+			b.g += int(c.G)
+			b.b += int(c.B)
+			b.count++
+		}
+	}
+
+	ranked := make([]*bucket, 0, len(buckets))
+	for _, b := range buckets {
+		ranked = append(ranked, b)
+	}
+	// Break ties by bucket so the palette is deterministic
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].count != ranked[j].count {
+			return ranked[i].count > ranked[j].count
+		}
+		return ranked[i].key < ranked[j].key
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+
+	palette := make([]color.RGBA, len(ranked))
+	for i, b := range ranked {
+		palette[i] = color.RGBA{
+			R: uint8(b.r / b.count),
+			G: uint8(b.g / b.count),
+			B: uint8(b.b / b.count),
+			A: 255,
+		}
+	}
+	return palette
+}
+
+// HexColor formats a color as #rrggbb
+func HexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -1,52 +1,410 @@
 package processor
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
+	"io"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/service/storage"
+	"image-processing-system/pkg/hostpolicy"
+	"image-processing-system/pkg/metrics"
+
 	"github.com/disintegration/imaging"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Download defaults used by NewImageProcessor
+const (
+	// DefaultMaxDownloadBytes caps the size of a downloaded source image
+	DefaultMaxDownloadBytes = 20 << 20
+	// DefaultDownloadMaxRetries is how many times a failed download is retried
+	DefaultDownloadMaxRetries = 2
+	// DefaultDownloadRetryBackoff is the wait before the first retry; it doubles per attempt
+	DefaultDownloadRetryBackoff = 500 * time.Millisecond
+	// DefaultDownloadMaxPerHost caps concurrent downloads from one origin host
+	DefaultDownloadMaxPerHost = 4
+	// DefaultMaxIdleConns caps idle keep-alive connections across all origins
+	DefaultMaxIdleConns = 100
+	// DefaultMaxIdleConnsPerHost caps idle keep-alive connections per origin
+	DefaultMaxIdleConnsPerHost = 16
+	// DefaultIdleConnTimeout closes keep-alive connections left idle this long
+	DefaultIdleConnTimeout = 90 * time.Second
+)
+
+var (
+	// ErrImageTooLarge is returned when a source image exceeds the download limit
+	ErrImageTooLarge = errors.New("image exceeds download size limit")
+	// ErrFormatNotAllowed is returned when a source image's format isn't in the allow-list
+	ErrFormatNotAllowed = errors.New("image format not allowed")
+	// ErrUndecodable is returned when a downloaded source isn't a decodable image
+	ErrUndecodable = errors.New("failed to decode image")
+	// ErrBucketNotAllowed is returned for minio:// sources outside the
+	// configured source buckets
+	ErrBucketNotAllowed = errors.New("source bucket not allowed")
+	// ErrSchemeNotAllowed is returned for source URLs that are neither
+	// http(s) nor minio://, such as file:// or ftp://
+	ErrSchemeNotAllowed = errors.New("source URL scheme not allowed")
+	// ErrPermanent marks download failures that won't succeed on a retry,
+	// such as 4xx responses or undecodable images
+	ErrPermanent = errors.New("permanent download failure")
 )
 
+// decodeSlotWait shows how long decodes queue behind WORKER_DECODE_CONCURRENCY
+var decodeSlotWait = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "decode_slot_wait_seconds",
+		Help:    "Time source image decodes waited for a CPU slot",
+		Buckets: prometheus.DefBuckets,
+	},
+)
+
+func init() {
+	metrics.Add(decodeSlotWait)
+}
+
 // ImageProcessor handles image processing operations
 type ImageProcessor struct {
-	client *http.Client
+	client           *http.Client
+	maxDownloadBytes int64
+	maxRetries       int
+	retryBackoff     time.Duration
+	allowedFormats   map[string]struct{}
+	decoders         *DecoderRegistry
+	hosts            *hostLimiter
+	hostPolicy       *hostpolicy.Policy
+	// decodeSlots, when set, bounds how many decodes run at once
+	decodeSlots chan struct{}
+	// objects reads minio:// sources; nil rejects them
+	objects ObjectReader
+	sources config.SourceHostsConfig
 }
 
-// NewImageProcessor creates a new image processor instance
+// ObjectReader reads source images that are already in object storage
+type ObjectReader interface {
+	// ReadObject returns up to maxBytes+1 bytes of an object, or an error
+	// wrapping storage.ErrObjectNotFound
+	ReadObject(ctx context.Context, bucket, key string, maxBytes int64) ([]byte, error)
+}
+
+// NewImageProcessor creates a new image processor instance with the default
+// download settings
 func NewImageProcessor() *ImageProcessor {
+	return NewImageProcessorWithConfig(config.DownloadConfig{
+		MaxBytes:     DefaultMaxDownloadBytes,
+		MaxRetries:   DefaultDownloadMaxRetries,
+		RetryBackoff: DefaultDownloadRetryBackoff,
+		MaxPerHost:   DefaultDownloadMaxPerHost,
+
+		MaxIdleConns:        DefaultMaxIdleConns,
+		MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+		IdleConnTimeout:     DefaultIdleConnTimeout,
+	})
+}
+
+// NewImageProcessorWithConfig creates an image processor with the given
+// download settings
+func NewImageProcessorWithConfig(cfg config.DownloadConfig) *ImageProcessor {
+	var allowed map[string]struct{}
+	if len(cfg.AllowedFormats) > 0 {
+		allowed = make(map[string]struct{}, len(cfg.AllowedFormats))
+		for _, f := range cfg.AllowedFormats {
+			allowed[normalizeFormat(f)] = struct{}{}
+		}
+	}
+
+	policy := cfg.SourceHosts.Policy()
 	return &ImageProcessor{
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: newTransport(cfg),
+			// Redirects must not lead outside the host policy
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 10 {
+					return errors.New("stopped after 10 redirects")
+				}
+				if err := checkScheme(req.URL.Scheme); err != nil {
+					return err
+				}
+				return policy.CheckHost(req.URL.Hostname())
+			},
 		},
+		maxDownloadBytes: cfg.MaxBytes,
+		maxRetries:       cfg.MaxRetries,
+		retryBackoff:     cfg.RetryBackoff,
+		allowedFormats:   allowed,
+		decoders:         NewDecoderRegistry(loadFormatLimits(cfg.FormatLimits)...),
+		hosts:            newHostLimiter(cfg.MaxPerHost),
+		hostPolicy:       policy,
+		sources:          cfg.SourceHosts,
+	}
+}
+
+// newTransport builds the download transport. Keep-alive connections are
+// pooled per origin so batches hitting the same CDN reuse them instead of
+// paying for a new TCP and TLS handshake per image (http.DefaultTransport only
+// keeps 2 idle connections per host), and HTTPS origins are offered HTTP/2 so
+// concurrent downloads share one connection.
+func newTransport(cfg config.DownloadConfig) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
 	}
 }
 
-// DownloadImage downloads an image from a URL
-func (p *ImageProcessor) DownloadImage(ctx context.Context, url string) (image.Image, string, error) {
+// DownloadImage downloads an image from a URL, retrying transient failures
+// (network errors, 429 and 5xx) with exponential backoff. Every request uses
+// ctx, and cancellation stops the retries immediately.
+func (p *ImageProcessor) DownloadImage(ctx context.Context, url string) (img image.Image, format string, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := otel.Tracer("processor").Start(ctx, "DownloadImage")
+	span.SetAttributes(attribute.String("url.full", url))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			b := img.Bounds()
+			span.SetAttributes(
+				attribute.String("image.format", format),
+				attribute.Int("image.width", b.Dx()),
+				attribute.Int("image.height", b.Dy()),
+			)
+		}
+		span.End()
+	}()
+
+	var data []byte
+	for attempt := 0; ; attempt++ {
+		var retryable bool
+		data, retryable, err = p.fetch(ctx, url)
+		span.SetAttributes(attribute.Int("download.attempts", attempt+1))
+		if err == nil {
+			span.SetAttributes(attribute.Int("image.bytes", len(data)))
+			break
+		}
+		// Record every failed attempt so traces show why a download was slow
+		willRetry := retryable && attempt < p.maxRetries && ctx.Err() == nil
+		delay := p.retryBackoff << attempt
+		if !willRetry {
+			delay = 0
+		}
+		span.AddEvent("download.attempt_failed", trace.WithAttributes(
+			attribute.Int("download.attempt", attempt+1),
+			attribute.String("error.message", err.Error()),
+			attribute.Bool("download.retryable", retryable),
+			attribute.Int64("download.retry_delay_ms", delay.Milliseconds()),
+		))
+
+		if !retryable {
+			// Giving up on a done context says nothing about the source itself
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return nil, "", err
+			}
+			return nil, "", fmt.Errorf("%w: %w", ErrPermanent, err)
+		}
+		if !willRetry {
+			return nil, "", err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, "", fmt.Errorf("download cancelled after %d attempts: %w", attempt+1, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+	return p.decode(ctx, data)
+}
+
+// ReadStoredImage reads and decodes an image the pipeline stored itself, such
+// as a source's stored original. Unlike minio:// sources its bucket needn't
+// be in SOURCE_BUCKETS; the size, format and decode limits still apply. A
+// missing object returns an error wrapping storage.ErrObjectNotFound.
+func (p *ImageProcessor) ReadStoredImage(ctx context.Context, bucket, key string) (img image.Image, format string, err error) {
+	ctx, span := otel.Tracer("processor").Start(ctx, "ReadStoredImage")
+	span.SetAttributes(attribute.String("storage.bucket", bucket), attribute.String("storage.key", key))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	if p.objects == nil {
+		return nil, "", errors.New("stored images need the minio storage backend")
+	}
+	data, err := p.objects.ReadObject(ctx, bucket, key, p.maxDownloadBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > p.maxDownloadBytes {
+		return nil, "", fmt.Errorf("%w: %w: more than %d bytes", ErrPermanent, ErrImageTooLarge, p.maxDownloadBytes)
+	}
+	return p.decode(ctx, data)
+}
+
+// decode checks a fetched image against the format and size limits, then
+// decodes it under a decode slot
+func (p *ImageProcessor) decode(ctx context.Context, data []byte) (image.Image, string, error) {
+	// Check the format and its limits from the header before paying for a
+	// full decode
+	header, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w: %w", ErrPermanent, ErrUndecodable, err)
+	}
+	if p.allowedFormats != nil {
+		if _, ok := p.allowedFormats[format]; !ok {
+			return nil, "", fmt.Errorf("%w: %w: %s", ErrPermanent, ErrFormatNotAllowed, format)
+		}
+	}
+	if err := p.decoders.Check(format, header.Width, header.Height, int64(len(data))); err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrPermanent, err)
+	}
+
+	// Decoding is CPU-bound, so it waits for a slot shared with the transforms
+	if p.decodeSlots != nil {
+		waitStart := time.Now()
+		select {
+		case p.decodeSlots <- struct{}{}:
+			defer func() { <-p.decodeSlots }()
+			decodeSlotWait.Observe(time.Since(waitStart).Seconds())
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w: %w", ErrPermanent, ErrUndecodable, err)
+	}
+
+	return img, format, nil
+}
+
+// ReadObjectsFrom lets DownloadImage read minio://bucket/key sources from r
+// instead of downloading them over HTTP
+func (p *ImageProcessor) ReadObjectsFrom(r ObjectReader) {
+	p.objects = r
+}
+
+// LimitDecodes makes DownloadImage hold one of slots while it decodes, so
+// decodes share a CPU budget with whatever else takes from slots
+func (p *ImageProcessor) LimitDecodes(slots chan struct{}) {
+	p.decodeSlots = slots
+}
+
+// normalizeFormat maps format aliases to the names image.Decode reports
+func normalizeFormat(f string) string {
+	f = strings.ToLower(strings.TrimSpace(f))
+	if f == "jpg" {
+		return "jpeg"
+	}
+	return f
+}
+
+// fetch performs a single download attempt and reports whether a failure is
+// worth retrying
+func (p *ImageProcessor) fetch(ctx context.Context, url string) ([]byte, bool, error) {
+	if bucket, key, ok := config.ParseObjectSource(url); ok {
+		return p.fetchObject(ctx, bucket, key)
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create request: %w", err)
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
 	}
+	if err := checkScheme(req.URL.Scheme); err != nil {
+		return nil, false, err
+	}
+	if err := p.hostPolicy.CheckHost(req.URL.Hostname()); err != nil {
+		return nil, false, err
+	}
+
+	// Wait for a per-host slot; it is held for this attempt only, not across retries
+	release, err := p.hosts.acquire(ctx, req.URL.Host)
+	if err != nil {
+		return nil, false, fmt.Errorf("waiting for download slot: %w", err)
+	}
+	defer release()
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to download image: %w", err)
+		retryable := !errors.Is(err, hostpolicy.ErrHostNotAllowed) && !errors.Is(err, ErrSchemeNotAllowed)
+		return nil, retryable, fmt.Errorf("failed to download image: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("HTTP error: %d", resp.StatusCode)
+		// Drain a little of the error body so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retryable, fmt.Errorf("HTTP error: %d", resp.StatusCode)
 	}
 
-	img, format, err := image.Decode(resp.Body)
+	if resp.ContentLength > p.maxDownloadBytes {
+		return nil, false, fmt.Errorf("%w: %d bytes", ErrImageTooLarge, resp.ContentLength)
+	}
+	// Content-Length may be missing or wrong, so also bound the read
+	data, err := io.ReadAll(io.LimitReader(resp.Body, p.maxDownloadBytes+1))
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+		return nil, true, fmt.Errorf("failed to read image: %w", err)
+	}
+	if int64(len(data)) > p.maxDownloadBytes {
+		return nil, false, fmt.Errorf("%w: more than %d bytes", ErrImageTooLarge, p.maxDownloadBytes)
 	}
+	return data, false, nil
+}
 
-	return img, format, nil
+// checkScheme returns ErrSchemeNotAllowed unless scheme is http or https, the
+// only schemes downloaded over HTTP, also after a redirect
+func checkScheme(scheme string) error {
+	if scheme != "http" && scheme != "https" {
+		return fmt.Errorf("%w: %q", ErrSchemeNotAllowed, scheme)
+	}
+	return nil
+}
+
+// fetchObject reads a minio:// source straight from object storage. Missing
+// objects and buckets outside the configured list are permanent failures.
+func (p *ImageProcessor) fetchObject(ctx context.Context, bucket, key string) ([]byte, bool, error) {
+	if p.objects == nil {
+		return nil, false, fmt.Errorf("%s:// sources need the minio storage backend", config.ObjectSourceScheme)
+	}
+	if key == "" || !p.sources.BucketAllowed(bucket) {
+		return nil, false, fmt.Errorf("%w: %s", ErrBucketNotAllowed, bucket)
+	}
+
+	data, err := p.objects.ReadObject(ctx, bucket, key, p.maxDownloadBytes)
+	if err != nil {
+		return nil, !errors.Is(err, storage.ErrObjectNotFound), err
+	}
+	if int64(len(data)) > p.maxDownloadBytes {
+		return nil, false, fmt.Errorf("%w: more than %d bytes", ErrImageTooLarge, p.maxDownloadBytes)
+	}
+	return data, false, nil
 }
 
 // Grayscale converts an image to grayscale
@@ -1,9 +1,31 @@
 package processor
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/service/storage"
+	"image-processing-system/pkg/hostpolicy"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestGrayscale(t *testing.T) {
@@ -37,17 +59,77 @@ func TestGrayscale(t *testing.T) {
 func TestDownloadImage(t *testing.T) {
 	processor := NewImageProcessor()
 
-	// Test with a valid image URL (you might want to use a test image)
-	// This test requires internet connection and might fail in CI
-	// In a real test environment, you'd mock the HTTP client
-
-	// For now, let's test the error case with an invalid URL
+	// Test the error case with an invalid URL
 	_, _, err := processor.DownloadImage(nil, "invalid-url")
 	if err == nil {
 		t.Error("Expected error for invalid URL, got nil")
 	}
 }
 
+func TestDownloadImageFixtures(t *testing.T) {
+	srv := newFixtureServer(t)
+
+	tests := []struct {
+		path       string
+		wantFormat string
+		wantErr    bool
+	}{
+		{"/image.jpg", "jpeg", false},
+		{"/image.png", "png", false},
+		{"/image.gif", "gif", false},
+		{"/error", "", true},
+		{"/missing", "", true},
+		{"/text", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			img, format, err := newTestProcessor().DownloadImage(context.Background(), srv.URL+tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got format %q", format)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("format = %q, want %q", format, tt.wantFormat)
+			}
+			if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 12 {
+				t.Errorf("size = %dx%d, want 16x12", b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestDownloadImageHTTPError(t *testing.T) {
+	srv := newFixtureServer(t)
+
+	_, _, err := newTestProcessor().DownloadImage(context.Background(), srv.URL+"/error")
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected an HTTP 500 error, got %v", err)
+	}
+}
+
+func TestDownloadImageSizeLimit(t *testing.T) {
+	srv := newFixtureServer(t)
+
+	processor := newTestProcessor()
+	processor.maxDownloadBytes = 1024
+
+	_, _, err := processor.DownloadImage(context.Background(), srv.URL+"/large")
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected ErrImageTooLarge, got %v", err)
+	}
+
+	// Images under the limit still decode
+	if _, _, err := processor.DownloadImage(context.Background(), srv.URL+"/image.png"); err != nil {
+		t.Errorf("unexpected error for a small image: %v", err)
+	}
+}
+
 func TestImageProcessingPipeline(t *testing.T) {
 	// Create a test image
 	img := image.NewRGBA(image.Rect(0, 0, 50, 50))
@@ -80,3 +162,477 @@ func TestImageProcessingPipeline(t *testing.T) {
 		}
 	}
 }
+
+func TestDownloadImageRetriesTransientErrors(t *testing.T) {
+	var hits atomic.Int32
+	png := newFixtureServer(t).URL + "/image.png"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, png, http.StatusFound)
+	}))
+	defer srv.Close()
+
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{MaxBytes: DefaultMaxDownloadBytes, MaxRetries: 2, RetryBackoff: time.Millisecond})
+	if _, _, err := processor.DownloadImage(context.Background(), srv.URL); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if got := hits.Load(); got != 3 {
+		t.Errorf("expected 3 attempts, got %d", got)
+	}
+}
+
+func TestDownloadImageDoesNotRetryClientErrors(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{MaxBytes: DefaultMaxDownloadBytes, MaxRetries: 2, RetryBackoff: time.Millisecond})
+	if _, _, err := processor.DownloadImage(context.Background(), srv.URL); err == nil {
+		t.Fatal("expected an error for a 404")
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("expected a single attempt, got %d", got)
+	}
+}
+
+func TestDownloadImageCancelledMidRetry(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// A long backoff means only cancellation can end the call quickly
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{MaxBytes: DefaultMaxDownloadBytes, MaxRetries: 5, RetryBackoff: 10 * time.Second})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, _, err := processor.DownloadImage(ctx, srv.URL)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("expected a prompt return after cancellation, took %v", elapsed)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("expected no retry after cancellation, got %d attempts", got)
+	}
+}
+
+func TestDownloadImageAllowedFormats(t *testing.T) {
+	srv := newFixtureServer(t)
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{
+		MaxBytes:       DefaultMaxDownloadBytes,
+		AllowedFormats: []string{"jpg", "png"},
+	})
+
+	for _, path := range []string{"/image.jpg", "/image.png"} {
+		if _, _, err := processor.DownloadImage(context.Background(), srv.URL+path); err != nil {
+			t.Errorf("%s: unexpected error: %v", path, err)
+		}
+	}
+
+	_, _, err := processor.DownloadImage(context.Background(), srv.URL+"/image.gif")
+	if !errors.Is(err, ErrFormatNotAllowed) {
+		t.Errorf("expected ErrFormatNotAllowed for gif, got %v", err)
+	}
+}
+
+func TestDownloadImagePerHostLimit(t *testing.T) {
+	pngURL := newFixtureServer(t).URL + "/image.png"
+
+	var inFlight, maxInFlight atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		http.Redirect(w, r, pngURL, http.StatusFound)
+	}))
+	defer srv.Close()
+
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{MaxBytes: DefaultMaxDownloadBytes, MaxPerHost: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := processor.DownloadImage(context.Background(), srv.URL); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := maxInFlight.Load(); got > 2 {
+		t.Errorf("expected at most 2 concurrent requests to the host, saw %d", got)
+	}
+	if len(processor.hosts.hosts) != 0 {
+		t.Errorf("expected idle hosts to be released, got %d", len(processor.hosts.hosts))
+	}
+}
+
+func TestDominantColors(t *testing.T) {
+	// Three quarters red, one quarter blue
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{200, 10, 10, 255}
+			if x >= 20 && y >= 20 {
+				c = color.RGBA{10, 10, 200, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	palette := NewImageProcessor().DominantColors(img, 2)
+	if len(palette) != 2 {
+		t.Fatalf("expected 2 colors, got %v", palette)
+	}
+	if got := HexColor(palette[0]); got != "#c80a0a" {
+		t.Errorf("expected red to dominate, got %s", got)
+	}
+	if got := HexColor(palette[1]); got != "#0a0ac8" {
+		t.Errorf("expected blue second, got %s", got)
+	}
+
+	if got := NewImageProcessor().DominantColors(img, 5); len(got) != 2 {
+		t.Errorf("expected only the colors present, got %v", got)
+	}
+}
+
+func TestBlurHash(t *testing.T) {
+	solid := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			solid.Set(x, y, color.RGBA{0, 128, 255, 255})
+		}
+	}
+	// "L" for 4x3 components, then after the AC scale the average color
+	// 0080ff as four base83 digits
+	if got := NewImageProcessor().BlurHash(solid); len(got) != 28 || got[0] != 'L' || got[2:6] != "04*=" {
+		t.Errorf("BlurHash(solid) = %q, want L?04*= and 22 AC digits", got)
+	}
+
+	// Left half dark, right half light: the first horizontal component dominates
+	split := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			c := color.RGBA{20, 20, 20, 255}
+			if x >= 10 {
+				c = color.RGBA{235, 235, 235, 255}
+			}
+			split.Set(x, y, c)
+		}
+	}
+	got := NewImageProcessor().BlurHash(split)
+	if len(got) != 28 || got == NewImageProcessor().BlurHash(solid) {
+		t.Errorf("BlurHash(split) = %q, want 28 characters differing from a solid image", got)
+	}
+
+	if got := NewImageProcessor().BlurHash(nil); got != "" {
+		t.Errorf("BlurHash(nil) = %q, want empty", got)
+	}
+}
+
+// recordSpans installs a TracerProvider that records spans for the rest of
+// the test, restoring the previous provider afterwards
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}
+
+func TestDownloadImageSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	srv := newFixtureServer(t)
+
+	if _, _, err := newTestProcessor().DownloadImage(context.Background(), srv.URL+"/image.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := newTestProcessor().DownloadImage(context.Background(), srv.URL+"/missing"); err == nil {
+		t.Fatal("expected an error for a missing image")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 DownloadImage spans, got %d", len(spans))
+	}
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if got := attrs["url.full"].AsString(); got != srv.URL+"/image.png" {
+		t.Errorf("url.full = %q, want %q", got, srv.URL+"/image.png")
+	}
+	if got := attrs["image.bytes"].AsInt64(); got <= 0 {
+		t.Errorf("expected a positive image.bytes, got %d", got)
+	}
+	if got := attrs["image.format"].AsString(); got != "png" {
+		t.Errorf("image.format = %q, want png", got)
+	}
+
+	if spans[1].Status().Code != codes.Error {
+		t.Errorf("expected the failed download span to have error status, got %v", spans[1].Status())
+	}
+}
+
+func TestDownloadImageSourceHostPolicy(t *testing.T) {
+	srv := newFixtureServer(t)
+	redirect := httptest.NewServer(http.RedirectHandler(srv.URL+"/image.png", http.StatusFound))
+	t.Cleanup(redirect.Close)
+
+	// Both servers listen on 127.0.0.1, so route the redirect through "localhost"
+	redirectURL := strings.Replace(redirect.URL, "127.0.0.1", "localhost", 1)
+
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{
+		MaxBytes:    DefaultMaxDownloadBytes,
+		MaxRetries:  2,
+		SourceHosts: config.SourceHostsConfig{Allow: []string{"localhost"}},
+	})
+
+	if _, _, err := processor.DownloadImage(context.Background(), srv.URL+"/image.png"); !errors.Is(err, hostpolicy.ErrHostNotAllowed) || !errors.Is(err, ErrPermanent) {
+		t.Errorf("expected a permanent ErrHostNotAllowed for a direct download, got %v", err)
+	}
+	if _, _, err := processor.DownloadImage(context.Background(), redirectURL); !errors.Is(err, hostpolicy.ErrHostNotAllowed) || !errors.Is(err, ErrPermanent) {
+		t.Errorf("expected a permanent ErrHostNotAllowed for a redirect, got %v", err)
+	}
+}
+
+func TestDownloadImageRejectsOtherSchemes(t *testing.T) {
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{MaxBytes: DefaultMaxDownloadBytes, MaxRetries: 2, RetryBackoff: time.Millisecond})
+
+	for _, url := range []string{"file:///etc/passwd", "ftp://example.com/a.png", "gopher://example.com/a.png", "data:image/png;base64,AAAA", "example.com/a.png"} {
+		if _, _, err := processor.DownloadImage(context.Background(), url); !errors.Is(err, ErrSchemeNotAllowed) || !errors.Is(err, ErrPermanent) {
+			t.Errorf("%s: expected a permanent ErrSchemeNotAllowed, got %v", url, err)
+		}
+	}
+
+	// Nor may a redirect switch to another scheme
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	}))
+	t.Cleanup(srv.Close)
+	if _, _, err := processor.DownloadImage(context.Background(), srv.URL); !errors.Is(err, ErrSchemeNotAllowed) || !errors.Is(err, ErrPermanent) {
+		t.Errorf("expected a permanent ErrSchemeNotAllowed for a redirect, got %v", err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("expected a single attempt, got %d", got)
+	}
+}
+
+// fakeObjects serves objects from memory, keyed by "bucket/key"
+type fakeObjects map[string][]byte
+
+func (f fakeObjects) ReadObject(ctx context.Context, bucket, key string, maxBytes int64) ([]byte, error) {
+	data, ok := f[bucket+"/"+key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%s", storage.ErrObjectNotFound, bucket, key)
+	}
+	return data, nil
+}
+
+func TestDownloadImageObjectSource(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, fixtureImage(16, 12)); err != nil {
+		t.Fatal(err)
+	}
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{
+		MaxBytes:    DefaultMaxDownloadBytes,
+		SourceHosts: config.SourceHostsConfig{Buckets: []string{"uploads"}},
+	})
+
+	if _, _, err := processor.DownloadImage(context.Background(), "minio://uploads/a.png"); err == nil {
+		t.Error("expected minio:// sources to fail without an object reader")
+	}
+
+	processor.ReadObjectsFrom(fakeObjects{"uploads/a.png": buf.Bytes(), "private/a.png": buf.Bytes()})
+	img, format, err := processor.DownloadImage(context.Background(), "minio://uploads/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || img.Bounds().Dx() != 16 {
+		t.Errorf("unexpected image: format %s, bounds %v", format, img.Bounds())
+	}
+
+	if _, _, err := processor.DownloadImage(context.Background(), "minio://private/a.png"); !errors.Is(err, ErrBucketNotAllowed) || !errors.Is(err, ErrPermanent) {
+		t.Errorf("expected a permanent ErrBucketNotAllowed, got %v", err)
+	}
+	if _, _, err := processor.DownloadImage(context.Background(), "minio://uploads/missing.png"); !errors.Is(err, storage.ErrObjectNotFound) || !errors.Is(err, ErrPermanent) {
+		t.Errorf("expected a permanent not-found error, got %v", err)
+	}
+}
+
+func TestReadStoredImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, fixtureImage(16, 12)); err != nil {
+		t.Fatal(err)
+	}
+	// The stored bucket isn't a source bucket, and needn't be
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{MaxBytes: DefaultMaxDownloadBytes})
+	processor.ReadObjectsFrom(fakeObjects{"images/original/a.png": buf.Bytes()})
+
+	img, format, err := processor.ReadStoredImage(context.Background(), "images", "original/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || img.Bounds().Dx() != 16 {
+		t.Errorf("unexpected image: format %s, bounds %v", format, img.Bounds())
+	}
+	if _, _, err := processor.ReadStoredImage(context.Background(), "images", "original/gone.png"); !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("expected a not-found error, got %v", err)
+	}
+}
+
+func TestDownloadImageFormatLimits(t *testing.T) {
+	srv := newFixtureServer(t)
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{
+		MaxBytes:     DefaultMaxDownloadBytes,
+		FormatLimits: "gif=8:0",
+	})
+
+	if _, _, err := processor.DownloadImage(context.Background(), srv.URL+"/image.gif"); !errors.Is(err, ErrImageTooLarge) || !errors.Is(err, ErrPermanent) {
+		t.Errorf("expected the 16x12 GIF to exceed its 8px limit permanently, got %v", err)
+	}
+	if _, _, err := processor.DownloadImage(context.Background(), srv.URL+"/image.png"); err != nil {
+		t.Errorf("expected other formats to keep their limits, got %v", err)
+	}
+}
+
+func TestDecoderRegistry(t *testing.T) {
+	r := NewDecoderRegistry(config.FormatLimit{Format: "jpg", MaxBytes: 100})
+
+	if l, ok := r.Limits("jpeg"); !ok || l.MaxBytes != 100 || l.MaxSide != 0 {
+		t.Errorf("expected the override to replace the jpeg defaults, got %+v", l)
+	}
+	if err := r.Check("gif", 5000, 10, 1); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected the default GIF side limit to apply, got %v", err)
+	}
+	if err := r.Check("jpeg", 100000, 100000, 101); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected the jpeg size limit to apply, got %v", err)
+	}
+	if err := r.Check("webp", 100000, 100000, 1<<30); err != nil {
+		t.Errorf("expected unregistered formats to pass, got %v", err)
+	}
+}
+
+func TestDownloadImageRetrySpanEvents(t *testing.T) {
+	recorder := recordSpans(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	processor := NewImageProcessorWithConfig(config.DownloadConfig{MaxBytes: DefaultMaxDownloadBytes, MaxRetries: 2, RetryBackoff: time.Millisecond})
+	if _, _, err := processor.DownloadImage(context.Background(), srv.URL); err == nil {
+		t.Fatal("expected the download to fail")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 DownloadImage span, got %d", len(spans))
+	}
+	var events []sdktrace.Event
+	for _, ev := range spans[0].Events() {
+		if ev.Name == "download.attempt_failed" {
+			events = append(events, ev)
+		}
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected an event per attempt, got %d", len(events))
+	}
+	for i, ev := range events {
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, kv := range ev.Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		if attrs["download.attempt"].AsInt64() != int64(i+1) {
+			t.Errorf("event %d: unexpected attempt %v", i, attrs["download.attempt"])
+		}
+		if !strings.Contains(attrs["error.message"].AsString(), "503") {
+			t.Errorf("event %d: expected the HTTP error, got %q", i, attrs["error.message"].AsString())
+		}
+		wantDelay := int64(1 << i)
+		if i == len(events)-1 {
+			wantDelay = 0 // out of retries
+		}
+		if got := attrs["download.retry_delay_ms"].AsInt64(); got != wantDelay {
+			t.Errorf("event %d: retry_delay_ms = %d, want %d", i, got, wantDelay)
+		}
+	}
+}
+
+func TestDownloadImageDecodeSlots(t *testing.T) {
+	srv := newFixtureServer(t)
+	processor := newTestProcessor()
+	slots := make(chan struct{}, 1)
+	processor.LimitDecodes(slots)
+	waitCount := func() uint64 {
+		var m dto.Metric
+		if err := decodeSlotWait.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetHistogram().GetSampleCount()
+	}
+	before := waitCount()
+
+	// With the only slot taken, the download finishes but the decode queues
+	slots <- struct{}{}
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := processor.DownloadImage(context.Background(), srv.URL+"/image.png")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("decode ran without a free slot: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	<-slots
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("DownloadImage failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("decode didn't run once the slot was freed")
+	}
+	if len(slots) != 0 {
+		t.Error("decode kept its slot after finishing")
+	}
+	if got := waitCount() - before; got != 1 {
+		t.Errorf("recorded %d slot waits, want 1", got)
+	}
+
+	// A job given up on while queued stops waiting
+	slots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := processor.DownloadImage(ctx, srv.URL+"/image.png"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the queued decode to be abandoned, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package processor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"image-processing-system/internal/config"
+)
+
+// benchDownloadConfig is the default download config without the per-host
+// cap, so the benchmark measures the transport rather than the limiter
+var benchDownloadConfig = config.DownloadConfig{
+	MaxBytes:            DefaultMaxDownloadBytes,
+	MaxIdleConns:        DefaultMaxIdleConns,
+	MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+	IdleConnTimeout:     DefaultIdleConnTimeout,
+}
+
+// newCountingServer serves the fixtures over HTTPS and counts accepted
+// connections. Without http2 the server only speaks HTTP/1.1.
+func newCountingServer(t testing.TB, http2 bool) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var conns atomic.Int64
+	srv := httptest.NewUnstartedServer(fixtureHandler(t))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.EnableHTTP2 = http2
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+// trustServer makes the processor trust the test server's certificate
+func trustServer(p *ImageProcessor, srv *httptest.Server) {
+	p.client.Transport.(*http.Transport).TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+}
+
+func TestDownloadTransportUsesHTTP2(t *testing.T) {
+	var proto atomic.Value
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto.Store(r.Proto)
+		fixtureHandler(t).ServeHTTP(w, r)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	p := NewImageProcessorWithConfig(benchDownloadConfig)
+	trustServer(p, srv)
+
+	if _, _, err := p.DownloadImage(context.Background(), srv.URL+"/image.png"); err != nil {
+		t.Fatal(err)
+	}
+	if got := proto.Load(); got != "HTTP/2.0" {
+		t.Errorf("expected an HTTP/2 request, got %v", got)
+	}
+}
+
+func TestDownloadTransportReusesConnections(t *testing.T) {
+	srv, conns := newCountingServer(t, false)
+	p := NewImageProcessorWithConfig(benchDownloadConfig)
+	trustServer(p, srv)
+
+	for i := 0; i < 5; i++ {
+		if _, _, err := p.DownloadImage(context.Background(), srv.URL+"/image.png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Error responses are drained, so they don't cost a connection either
+	p.DownloadImage(context.Background(), srv.URL+"/error")
+	p.DownloadImage(context.Background(), srv.URL+"/image.png")
+
+	if got := conns.Load(); got != 1 {
+		t.Errorf("expected sequential downloads to share one connection, got %d", got)
+	}
+}
+
+// burstSize is how many downloads each benchmark operation starts at once,
+// like a worker picking up a batch of jobs for the same CDN
+const burstSize = 16
+
+// BenchmarkDownloadBurst compares bursts of concurrent downloads from one
+// HTTPS origin with http.DefaultTransport (the previous client) and the tuned
+// transport. The default transport keeps only 2 idle connections per host, so
+// every burst re-dials and re-handshakes most of its connections; conns/op
+// shows how many new connections each burst needed.
+func BenchmarkDownloadBurst(b *testing.B) {
+	cases := []struct {
+		name  string
+		http2 bool
+		tuned bool
+	}{
+		{"http1/default-transport", false, false},
+		{"http1/tuned-transport", false, true},
+		{"http2/default-transport", true, false},
+		{"http2/tuned-transport", true, true},
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			srv, conns := newCountingServer(b, c.http2)
+			p := NewImageProcessorWithConfig(benchDownloadConfig)
+			if !c.tuned {
+				p.client.Transport = http.DefaultTransport.(*http.Transport).Clone()
+			}
+			trustServer(p, srv)
+			url := srv.URL + "/image.jpg"
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for j := 0; j < burstSize; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if _, _, err := p.DownloadImage(context.Background(), url); err != nil {
+							b.Error(err)
+						}
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Store reads the bytes each owner has stored, as image-metadata totals them
+// in PostgreSQL
+type Store struct {
+	db *gorm.DB
+}
+
+// NewStore connects to the database and ensures the usage table exists
+func NewStore(cfg config.DatabaseConfig) (*Store, error) {
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get underlying sql.DB: %w", err)
+	}
+	sqlDB.SetMaxIdleConns(2)
+	sqlDB.SetMaxOpenConns(10)
+	sqlDB.SetConnMaxLifetime(time.Hour)
+
+	if cfg.AutoMigrate {
+		log.Printf("Auto-migrating the owner usage table (DB_AUTO_MIGRATE=true)")
+		if err := db.AutoMigrate(&models.OwnerUsage{}); err != nil {
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+	} else {
+		log.Printf("Skipping auto-migration of the owner usage table (DB_AUTO_MIGRATE=false); the schema must be managed externally")
+	}
+
+	return &Store{db: db}, nil
+}
+
+// BytesUsed returns the bytes stored by an owner, 0 when it has stored
+// nothing yet
+func (s *Store) BytesUsed(ctx context.Context, ownerID string) (int64, error) {
+	var usage models.OwnerUsage
+	err := s.db.WithContext(ctx).Where("owner_id = ?", ownerID).Take(&usage).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return usage.BytesUsed, err
+}
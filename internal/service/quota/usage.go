@@ -0,0 +1,29 @@
+package quota
+
+import "image-processing-system/internal/models"
+
+// Charge returns the bytes a result adds to its owner's usage: the size of
+// an output it newly stored. Skipped outputs add nothing, since derived keys
+// are per owner and the owner was charged when the output was first stored;
+// failed and unowned outputs add nothing either.
+func Charge(ownerID string, payload models.ImageProcessedPayload) int64 {
+	if ownerID == "" || payload.Status != "success" || payload.Skipped || payload.FileSize <= 0 {
+		return 0
+	}
+	return payload.FileSize
+}
+
+// Exceeded reports whether used has reached limit; a limit of 0 or less is
+// unlimited
+func Exceeded(used, limit int64) bool {
+	return limit > 0 && used >= limit
+}
+
+// Remaining returns the bytes left under limit, 0 once it is used up, and
+// false for unlimited owners
+func Remaining(used, limit int64) (int64, bool) {
+	if limit <= 0 {
+		return 0, false
+	}
+	return max(limit-used, 0), true
+}
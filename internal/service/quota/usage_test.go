@@ -0,0 +1,55 @@
+package quota
+
+import (
+	"testing"
+
+	"image-processing-system/internal/models"
+)
+
+func TestCharge(t *testing.T) {
+	stored := models.ImageProcessedPayload{Status: "success", FileSize: 1200}
+	tests := []struct {
+		name    string
+		owner   string
+		payload models.ImageProcessedPayload
+		want    int64
+	}{
+		{"stored output", "acme", stored, 1200},
+		{"unowned", "", stored, 0},
+		{"skipped", "acme", models.ImageProcessedPayload{Status: "success", FileSize: 1200, Skipped: true}, 0},
+		{"failed", "acme", models.ImageProcessedPayload{Status: "error", FileSize: 1200}, 0},
+		{"metadata only", "acme", models.ImageProcessedPayload{Status: "success"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Charge(tt.owner, tt.payload); got != tt.want {
+				t.Errorf("Charge() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		used, limit   int64
+		exceeded      bool
+		remaining     int64
+		wantRemaining bool
+	}{
+		{used: 0, limit: 1000, remaining: 1000, wantRemaining: true},
+		{used: 999, limit: 1000, remaining: 1, wantRemaining: true},
+		{used: 1000, limit: 1000, exceeded: true, remaining: 0, wantRemaining: true},
+		// Results queued while under the quota can take an owner over it
+		{used: 1500, limit: 1000, exceeded: true, remaining: 0, wantRemaining: true},
+		{used: 1500, limit: 0},
+	}
+	for _, tt := range tests {
+		if got := Exceeded(tt.used, tt.limit); got != tt.exceeded {
+			t.Errorf("Exceeded(%d, %d) = %v, want %v", tt.used, tt.limit, got, tt.exceeded)
+		}
+		remaining, ok := Remaining(tt.used, tt.limit)
+		if remaining != tt.remaining || ok != tt.wantRemaining {
+			t.Errorf("Remaining(%d, %d) = %d, %v, want %d, %v", tt.used, tt.limit, remaining, ok, tt.remaining, tt.wantRemaining)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// ErrCannotFit is returned when an image can't be encoded under a size limit
+var ErrCannotFit = errors.New("image can't be compressed to the target size")
+
+const (
+	// minFitQuality and maxFitQuality bound the JPEG qualities FitJPEG tries
+	minFitQuality = 10
+	maxFitQuality = 95
+	// maxFitEncodes bounds the encodes FitJPEG spends on one image
+	maxFitEncodes = 24
+)
+
+// Fit is the encoding FitJPEG settled on
+type Fit struct {
+	// Image is the image to encode, downscaled if that was needed
+	Image image.Image
+	// Quality is the highest JPEG quality Image fits at
+	Quality int
+	// Size is the encoded size in bytes
+	Size int
+}
+
+// FitJPEG finds the highest JPEG quality at which img encodes in at most
+// maxBytes, by binary search between minFitQuality and maxFitQuality. When
+// even the lowest quality is too large and downscale is set, it shrinks img
+// to three quarters per side with resize and searches again. It gives up
+// with ErrCannotFit once maxFitEncodes encodes haven't found a fit.
+func FitJPEG(img image.Image, maxBytes int, downscale, progressive bool, resize func(image.Image, int, int) image.Image) (Fit, error) {
+	encodes := 0
+	size := func(img image.Image, quality int) (int, error) {
+		encodes++
+		buf, err := encodeJPEG(img, quality, progressive)
+		if err != nil {
+			return 0, err
+		}
+		return buf.Len(), nil
+	}
+
+	for encodes < maxFitEncodes {
+		n, err := size(img, minFitQuality)
+		if err != nil {
+			return Fit{}, err
+		}
+		if n > maxBytes {
+			b := img.Bounds()
+			w, h := b.Dx()*3/4, b.Dy()*3/4
+			if !downscale || w < 1 || h < 1 {
+				break
+			}
+			img = resize(img, w, h)
+			continue
+		}
+
+		// lo always fits; stop early if the budget runs out mid-search
+		fit := Fit{Image: img, Quality: minFitQuality, Size: n}
+		hi := maxFitQuality
+		for fit.Quality < hi && encodes < maxFitEncodes {
+			mid := (fit.Quality + hi + 1) / 2
+			n, err := size(img, mid)
+			if err != nil {
+				return Fit{}, err
+			}
+			if n <= maxBytes {
+				fit.Quality, fit.Size = mid, n
+			} else {
+				hi = mid - 1
+			}
+		}
+		return fit, nil
+	}
+	b := img.Bounds()
+	return Fit{}, fmt.Errorf("%w: %d bytes at %dx%d", ErrCannotFit, maxBytes, b.Dx(), b.Dy())
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// noisyImage returns an image that compresses poorly, so its encoded size
+// depends strongly on quality and dimensions
+func noisyImage(w, h int) image.Image {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255})
+		}
+	}
+	return img
+}
+
+// nearestResize stands in for the processor's resize
+func nearestResize(img image.Image, w, h int) image.Image {
+	src := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.Set(x, y, img.At(src.Min.X+x*src.Dx()/w, src.Min.Y+y*src.Dy()/h))
+		}
+	}
+	return out
+}
+
+func TestFitJPEG(t *testing.T) {
+	img := noisyImage(200, 200)
+	full, err := encodeJPEG(img, maxFitQuality, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	low, err := encodeJPEG(img, minFitQuality, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("lowers quality", func(t *testing.T) {
+		target := (full.Len() + low.Len()) / 2
+		fit, err := FitJPEG(img, target, false, false, nearestResize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fit.Size > target || fit.Quality <= minFitQuality || fit.Quality >= maxFitQuality {
+			t.Errorf("fit = quality %d, %d bytes; want a middle quality under %d bytes", fit.Quality, fit.Size, target)
+		}
+		if fit.Image.Bounds() != img.Bounds() {
+			t.Errorf("downscaled to %v without downscale", fit.Image.Bounds())
+		}
+		// One quality higher no longer fits
+		if above, _ := encodeJPEG(img, fit.Quality+1, false); above.Len() <= target {
+			t.Errorf("quality %d also fits (%d bytes)", fit.Quality+1, above.Len())
+		}
+	})
+
+	t.Run("keeps full quality when it fits", func(t *testing.T) {
+		fit, err := FitJPEG(img, full.Len(), false, false, nearestResize)
+		if err != nil || fit.Quality != maxFitQuality {
+			t.Errorf("got quality %d, %v; want %d", fit.Quality, err, maxFitQuality)
+		}
+	})
+
+	t.Run("downscales", func(t *testing.T) {
+		target := low.Len() / 3
+		fit, err := FitJPEG(img, target, true, false, nearestResize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fit.Size > target || fit.Image.Bounds().Dx() >= 200 {
+			t.Errorf("fit = %v, %d bytes; want a smaller image under %d bytes", fit.Image.Bounds(), fit.Size, target)
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		if _, err := FitJPEG(img, low.Len()/3, false, false, nearestResize); !errors.Is(err, ErrCannotFit) {
+			t.Errorf("without downscale: got %v, want ErrCannotFit", err)
+		}
+		if _, err := FitJPEG(img, 10, true, false, nearestResize); !errors.Is(err, ErrCannotFit) {
+			t.Errorf("impossible target: got %v, want ErrCannotFit", err)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maxDownloadNameLength bounds the source name used in download filenames
+const maxDownloadNameLength = 100
+
+// downloadFilename expands a filename template for a processed image.
+// {name} is the source URL's file name without its extension, {type} the
+// processing type, {variant} "-" plus the variant (empty without one) and
+// {ext} the stored file extension without the dot.
+func downloadFilename(template, sourceURL, processingType, variant, ext string) string {
+	if variant != "" {
+		variant = "-" + variant
+	}
+	return strings.NewReplacer(
+		"{name}", sourceName(sourceURL),
+		"{type}", processingType,
+		"{variant}", variant,
+		"{ext}", strings.TrimPrefix(ext, "."),
+	).Replace(template)
+}
+
+// sourceName derives a filesystem-safe base name from a source URL, falling
+// back to "image" when the URL has no usable file name
+func sourceName(sourceURL string) string {
+	name := ""
+	if u, err := url.Parse(sourceURL); err == nil {
+		name = path.Base(u.Path)
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	safe = strings.Trim(safe, "._")
+	if len(safe) > maxDownloadNameLength {
+		safe = safe[:maxDownloadNameLength]
+	}
+	if safe == "" {
+		return "image"
+	}
+	return safe
+}
+
+// contentDisposition returns an attachment Content-Disposition naming the
+// file, or "" when no template is configured
+func contentDisposition(template, sourceURL, processingType, variant, ext string) string {
+	if template == "" {
+		return ""
+	}
+	filename := downloadFilename(template, sourceURL, processingType, variant, ext)
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"image-processing-system/internal/config"
+)
+
+// Output formats selectable per upload
+const (
+	FormatJPEG = "jpeg"
+	FormatAVIF = "avif"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+)
+
+// ErrEncoderUnavailable is returned when an output format's encoder isn't installed
+var ErrEncoderUnavailable = errors.New("encoder unavailable")
+
+// formatInfo describes how an image format is stored
+type formatInfo struct {
+	contentType string
+	ext         string
+}
+
+// formats maps the format names image.Decode reports, and the output formats,
+// to their content type and extension. JPEG, AVIF, PNG and WebP are encoded;
+// the rest label source images.
+var formats = map[string]formatInfo{
+	FormatJPEG: {contentType: "image/jpeg", ext: ".jpg"},
+	FormatAVIF: {contentType: "image/avif", ext: ".avif"},
+	FormatPNG:  {contentType: "image/png", ext: ".png"},
+	"gif":      {contentType: "image/gif", ext: ".gif"},
+	"bmp":      {contentType: "image/bmp", ext: ".bmp"},
+	"tiff":     {contentType: "image/tiff", ext: ".tiff"},
+	FormatWebP: {contentType: "image/webp", ext: ".webp"},
+}
+
+// fallbackExt is the extension of formats missing from the table
+const fallbackExt = ".bin"
+
+// NormalizeFormat returns the table name for a detected or requested format,
+// e.g. "jpeg" for "JPG", or "" if the format isn't known
+func NormalizeFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "jpg":
+		return FormatJPEG
+	case "tif":
+		return "tiff"
+	}
+	if _, ok := formats[format]; !ok {
+		return ""
+	}
+	return format
+}
+
+// MediaType returns the content type and extension to store an image of
+// format with. Unknown formats are application/octet-stream rather than
+// being labelled JPEG.
+func MediaType(format string) (contentType, ext string) {
+	if info, ok := formats[NormalizeFormat(format)]; ok {
+		return info.contentType, info.ext
+	}
+	return "application/octet-stream", fallbackExt
+}
+
+// avifencPath locates the avifenc binary (libavif) once. AVIF encoding shells
+// out to it so the worker needs neither cgo nor a pure-Go AV1 encoder.
+var avifencPath = sync.OnceValue(func() string {
+	path, err := exec.LookPath("avifenc")
+	if err != nil {
+		log.Printf("avifenc not found, AVIF output will fall back to JPEG")
+		return ""
+	}
+	return path
+})
+
+// cwebpPath locates the cwebp binary (libwebp) once, for the same reason
+var cwebpPath = sync.OnceValue(func() string {
+	path, err := exec.LookPath("cwebp")
+	if err != nil {
+		log.Printf("cwebp not found, WebP output will fall back to JPEG")
+		return ""
+	}
+	return path
+})
+
+// resolveFormat returns the format an upload will actually be encoded in:
+// JPEG by default, or when the requested encoder is unavailable
+func resolveFormat(format string) string {
+	switch {
+	case format == FormatPNG:
+		return FormatPNG
+	case format == FormatAVIF && avifencPath() != "":
+		return FormatAVIF
+	case format == FormatWebP && cwebpPath() != "":
+		return FormatWebP
+	}
+	return FormatJPEG
+}
+
+// EncoderAvailable reports whether outputs can be encoded as format, rather
+// than falling back to JPEG because its encoder isn't installed
+func EncoderAvailable(format string) bool {
+	return resolveFormat(format) == format
+}
+
+// encodeImage encodes img in a format returned by resolveFormat, using the
+// encoding settings for processingType unless opts overrides them
+func encodeImage(ctx context.Context, img image.Image, format string, cfg config.EncodingConfig, processingType string, opts UploadOptions) ([]byte, error) {
+	quality := qualityFor(cfg, processingType, opts)
+	switch format {
+	case FormatAVIF:
+		return encodeAVIF(ctx, img, quality)
+	case FormatWebP:
+		return encodeWebP(ctx, img, quality)
+	case FormatPNG:
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+	buf, err := encodeJPEG(img, quality, cfg.Progressive)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeAVIF encodes img with avifenc at a JPEG-like 0-100 quality. It is
+// far slower than JPEG, which is why AVIF is opt-in per job.
+func encodeAVIF(ctx context.Context, img image.Image, quality int) ([]byte, error) {
+	bin := avifencPath()
+	if bin == "" {
+		return nil, ErrEncoderUnavailable
+	}
+	return encodeExternal(ctx, img, "avif", func(in, out string) *exec.Cmd {
+		return exec.CommandContext(ctx, bin, "-q", strconv.Itoa(quality), "--speed", "6", in, out)
+	})
+}
+
+// encodeWebP encodes img with cwebp at a JPEG-like 0-100 quality
+func encodeWebP(ctx context.Context, img image.Image, quality int) ([]byte, error) {
+	bin := cwebpPath()
+	if bin == "" {
+		return nil, ErrEncoderUnavailable
+	}
+	return encodeExternal(ctx, img, "webp", func(in, out string) *exec.Cmd {
+		return exec.CommandContext(ctx, bin, "-quiet", "-q", strconv.Itoa(quality), in, "-o", out)
+	})
+}
+
+// encodeExternal hands img to an encoder binary as a PNG file and returns the
+// file it writes. command builds the encoder invocation from the input and
+// output paths; ext names the output file and the encoder in errors.
+func encodeExternal(ctx context.Context, img image.Image, ext string, command func(in, out string) *exec.Cmd) ([]byte, error) {
+	dir, err := os.MkdirTemp("", ext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var src bytes.Buffer
+	if err := png.Encode(&src, img); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out."+ext)
+	if err := os.WriteFile(in, src.Bytes(), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write temp image: %w", err)
+	}
+
+	cmd := command(in, out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", filepath.Base(cmd.Path), err, bytes.TrimSpace(output))
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encoded image: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"image-processing-system/internal/config"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// FilesystemService stores processed images in a local directory. It is meant
+// for local development and air-gapped deployments without MinIO.
+type FilesystemService struct {
+	root     string
+	encoding config.EncodingConfig
+}
+
+// NewFilesystemService creates a filesystem storage rooted at root
+func NewFilesystemService(root string, encoding config.EncodingConfig) (*FilesystemService, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve storage root: %w", err)
+	}
+	if err := os.MkdirAll(absRoot, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage root: %w", err)
+	}
+	log.Printf("Using filesystem storage at %s", absRoot)
+
+	return &FilesystemService{root: absRoot, encoding: encoding}, nil
+}
+
+// UploadImageWithType writes an image to the storage directory with a type-specific filename
+func (f *FilesystemService) UploadImageWithType(ctx context.Context, img image.Image, processingType, variant string, opts UploadOptions) (filename string, err error) {
+	ctx, span := startUploadSpan(ctx, "fs", processingType)
+	defer func() { endUploadSpan(span, filename, err) }()
+
+	format := resolveFormat(opts.Format)
+	filename, skip, err := resolveKey(ctx, f, "", processingType, variant, format, opts)
+	if err != nil || skip {
+		span.SetAttributes(attribute.Bool("storage.skipped", skip))
+		return filename, err
+	}
+
+	data, err := encodeImage(ctx, img, format, f.encoding, processingType, opts)
+	if err != nil {
+		return "", err
+	}
+	span.SetAttributes(attribute.Int("image.bytes", len(data)), attribute.String("image.format", format))
+
+	if err := os.WriteFile(f.path(filename), data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write image: %w", err)
+	}
+
+	return filename, nil
+}
+
+// GetImageURL returns the file URL for an image
+func (f *FilesystemService) GetImageURL(filename string) string {
+	return "file://" + filepath.ToSlash(f.path(filename))
+}
+
+// GetFileSize returns the size of the file in bytes for a given filename
+func (f *FilesystemService) GetFileSize(ctx context.Context, filename string) (int64, error) {
+	info, err := os.Stat(f.path(filename))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, fmt.Errorf("%w: %s", ErrObjectNotFound, filename)
+		}
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// ObjectExists reports whether a file exists in the storage directory
+func (f *FilesystemService) ObjectExists(ctx context.Context, filename string) (bool, error) {
+	_, err := os.Stat(f.path(filename))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to stat file: %w", err)
+}
+
+// DeleteObject removes a file from the storage directory
+func (f *FilesystemService) DeleteObject(ctx context.Context, filename string) error {
+	if err := os.Remove(f.path(filename)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove file: %w", err)
+	}
+	return nil
+}
+
+// PresignURL returns the file URL; local files have no expiring access
+func (f *FilesystemService) PresignURL(ctx context.Context, filename string, expiry time.Duration) (string, error) {
+	return f.GetImageURL(filename), nil
+}
+
+// path resolves a key inside the storage root
+func (f *FilesystemService) path(filename string) string {
+	return filepath.Join(f.root, filepath.Base(filename))
+}
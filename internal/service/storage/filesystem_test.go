@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"image-processing-system/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestFilesystemGetFileSize(t *testing.T) {
+	fsStorage, err := NewFilesystemService(t.TempDir(), config.EncodingConfig{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	key, err := fsStorage.UploadImageWithType(ctx, image.NewRGBA(image.Rect(0, 0, 10, 10)), "original", "", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := fsStorage.GetFileSize(ctx, key)
+	if err != nil {
+		t.Fatalf("expected size for uploaded object, got error: %v", err)
+	}
+	if size <= 0 {
+		t.Errorf("expected a positive size, got %d", size)
+	}
+
+	_, err = fsStorage.GetFileSize(ctx, "missing.jpg")
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("expected ErrObjectNotFound for a missing object, got %v", err)
+	}
+}
+
+func TestFilesystemDeleteObject(t *testing.T) {
+	fsStorage, err := NewFilesystemService(t.TempDir(), config.EncodingConfig{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	key, err := fsStorage.UploadImageWithType(ctx, image.NewRGBA(image.Rect(0, 0, 10, 10)), "original", "", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsStorage.DeleteObject(ctx, key); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if exists, _ := fsStorage.ObjectExists(ctx, key); exists {
+		t.Error("expected the object to be removed")
+	}
+	if err := fsStorage.DeleteObject(ctx, key); err != nil {
+		t.Errorf("expected removing a missing object to succeed, got %v", err)
+	}
+}
+
+func TestFilesystemUploadExistsPolicy(t *testing.T) {
+	fsStorage, err := NewFilesystemService(t.TempDir(), config.EncodingConfig{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+
+	key, err := fsStorage.UploadImageWithType(ctx, img, "original", "", UploadOptions{Key: "fixed.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := fsStorage.ObjectExists(ctx, key); err != nil || !exists {
+		t.Fatalf("expected %s to exist, got exists=%v err=%v", key, exists, err)
+	}
+
+	if _, err := fsStorage.UploadImageWithType(ctx, img, "original", "", UploadOptions{Key: key, IfExists: FailIfExists}); !errors.Is(err, ErrObjectExists) {
+		t.Errorf("expected ErrObjectExists, got %v", err)
+	}
+	if got, err := fsStorage.UploadImageWithType(ctx, img, "original", "", UploadOptions{Key: key, IfExists: SkipIfExists}); err != nil || got != key {
+		t.Errorf("expected skip to return existing key %s, got %s (err=%v)", key, got, err)
+	}
+	if _, err := fsStorage.UploadImageWithType(ctx, img, "original", "", UploadOptions{Key: key}); err != nil {
+		t.Errorf("expected overwrite to succeed, got %v", err)
+	}
+}
+
+func TestFilesystemUploadFormat(t *testing.T) {
+	fsStorage, err := NewFilesystemService(t.TempDir(), config.EncodingConfig{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+
+	// AVIF and WebP need avifenc and cwebp on PATH; without them the upload
+	// falls back to JPEG
+	wantAVIFExt, wantWebPExt := ".jpg", ".jpg"
+	if avifencPath() != "" {
+		wantAVIFExt = ".avif"
+	}
+	if cwebpPath() != "" {
+		wantWebPExt = ".webp"
+	}
+
+	tests := []struct {
+		format  string
+		wantExt string
+	}{
+		{"", ".jpg"},
+		{FormatJPEG, ".jpg"},
+		{FormatAVIF, wantAVIFExt},
+		{FormatPNG, ".png"},
+		{FormatWebP, wantWebPExt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			key, err := fsStorage.UploadImageWithType(context.Background(), img, "original", "", UploadOptions{Format: tt.format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filepath.Ext(key) != tt.wantExt {
+				t.Errorf("key %q, want extension %s", key, tt.wantExt)
+			}
+		})
+	}
+}
+
+func TestFilesystemUploadProgressiveJPEG(t *testing.T) {
+	fsStorage, err := NewFilesystemService(t.TempDir(), config.EncodingConfig{Quality: 90, Progressive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Odd sizes so edge blocks are padded
+	gradient := image.NewRGBA(image.Rect(0, 0, 37, 21))
+	gray := image.NewGray(image.Rect(0, 0, 19, 9))
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			gradient.Set(x, y, color.RGBA{uint8(x * 6), uint8(y * 12), 200, 255})
+			gray.Set(x, y, color.Gray{uint8(x*10 + y)})
+		}
+	}
+
+	for name, img := range map[string]image.Image{"color": gradient, "gray": gray} {
+		t.Run(name, func(t *testing.T) {
+			key, err := fsStorage.UploadImageWithType(context.Background(), img, "original", "", UploadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(fsStorage.path(key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// SOF2 marks a progressive JPEG, SOF0 a baseline one
+			if !bytes.Contains(data, []byte{0xff, 0xc2}) || bytes.Contains(data, []byte{0xff, 0xc0}) {
+				t.Fatal("expected a progressive (SOF2) JPEG")
+			}
+
+			decoded, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("progressive output doesn't decode: %v", err)
+			}
+			if decoded.Bounds() != img.Bounds() {
+				t.Fatalf("decoded bounds %v, want %v", decoded.Bounds(), img.Bounds())
+			}
+			b := img.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					r1, g1, b1, _ := img.At(x, y).RGBA()
+					r2, g2, b2, _ := decoded.At(x, y).RGBA()
+					if diff := max(absDiff(r1, r2), absDiff(g1, g2), absDiff(b1, b2)) >> 8; diff > 12 {
+						t.Fatalf("pixel (%d,%d) off by %d", x, y, diff)
+					}
+				}
+			}
+		})
+	}
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestFilesystemUploadSpan(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	fsStorage, err := NewFilesystemService(t.TempDir(), config.EncodingConfig{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := fsStorage.UploadImageWithType(context.Background(), image.NewRGBA(image.Rect(0, 0, 10, 10)), "thumbnail", "", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "UploadImage" {
+		t.Fatalf("expected one UploadImage span, got %v", spans)
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if got := attrs["storage.key"].AsString(); got != key {
+		t.Errorf("storage.key = %q, want %q", got, key)
+	}
+	if got := attrs["processing_type"].AsString(); got != "thumbnail" {
+		t.Errorf("processing_type = %q, want thumbnail", got)
+	}
+	if got := attrs["image.bytes"].AsInt64(); got <= 0 {
+		t.Errorf("expected a positive image.bytes, got %d", got)
+	}
+}
+
+func TestResolveKeyPrefix(t *testing.T) {
+	if got := expandKeyPrefix("{yyyy}/{mm}/{dd}/{hh}/", time.Date(2024, 1, 15, 9, 30, 0, 0, time.FixedZone("", 3600))); got != "2024/01/15/08/" {
+		t.Errorf("expandKeyPrefix() = %q, want 2024/01/15/08/", got)
+	}
+
+	fsStorage, err := NewFilesystemService(t.TempDir(), config.EncodingConfig{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	key, _, err := resolveKey(ctx, fsStorage, "{yyyy}/{mm}/{dd}/", "grayscale", "", FormatJPEG, UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Now().UTC().Format("2006/01/02/"); !strings.HasPrefix(key, want) || !strings.HasSuffix(key, "_grayscale.jpg") {
+		t.Errorf("expected a generated key under %s, got %s", want, key)
+	}
+	if bucket, parsed, ok := ParseImageURL("s3://images/" + key); !ok || bucket != "images" || parsed != key {
+		t.Errorf("expected the prefixed key to survive the s3 location, got %q %q", bucket, parsed)
+	}
+
+	// Derived keys must not move with the date
+	derived := DerivedKey("", "http://example.com/a.jpg", "grayscale", "", "", FormatJPEG)
+	key, _, err = resolveKey(ctx, fsStorage, "{yyyy}/{mm}/{dd}/", "grayscale", "", FormatJPEG, UploadOptions{Key: derived})
+	if err != nil || key != derived {
+		t.Errorf("expected a given key to be used as is, got %q (%v)", key, err)
+	}
+}
+
+func TestDerivedKey(t *testing.T) {
+	key := DerivedKey("", "http://example.com/a.jpg", "resize", "thumb", "10x5", FormatJPEG)
+	if again := DerivedKey("", "http://example.com/a.jpg", "resize", "thumb", "10x5", ""); again != key {
+		t.Errorf("expected the same output to get the same key, got %s and %s", key, again)
+	}
+	if filepath.Ext(key) != ".jpg" {
+		t.Errorf("expected a .jpg key, got %s", key)
+	}
+	for _, other := range []string{
+		DerivedKey("", "http://example.com/b.jpg", "resize", "thumb", "10x5", FormatJPEG),
+		DerivedKey("", "http://example.com/a.jpg", "resize", "thumb", "20x10", FormatJPEG),
+		DerivedKey("", "http://example.com/a.jpg", "blur", "", "", FormatJPEG),
+		DerivedKey("acme", "http://example.com/a.jpg", "resize", "thumb", "10x5", FormatJPEG),
+	} {
+		if other == key {
+			t.Errorf("expected a different output to get a different key than %s", key)
+		}
+	}
+}
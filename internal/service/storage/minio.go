@@ -3,18 +3,34 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
-	"image/jpeg"
+	"io"
 	"log"
+	"strings"
 	"time"
 
 	"image-processing-system/internal/config"
+	"image-processing-system/pkg/metrics"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var uploadRetries = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "minio_upload_retries_total",
+		Help: "Total number of MinIO upload retries after transient errors",
+	},
+)
+
+func init() {
+	metrics.Add(uploadRetries)
+}
+
 // MinioService handles MinIO operations
 type MinioService struct {
 	client *minio.Client
@@ -31,10 +47,15 @@ func NewMinioService(cfg config.MinioConfig) (*MinioService, error) {
 		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
 	}
 
-	// Ensure bucket exists
-	ctx := context.Background()
+	// Ensure bucket exists, bounded so an unreachable MinIO fails startup fast
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.StartupTimeout)
+	defer cancel()
+
 	exists, err := client.BucketExists(ctx, cfg.Bucket)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("MinIO at %s unreachable after %s: %w", cfg.Endpoint, cfg.StartupTimeout, err)
+		}
 		return nil, fmt.Errorf("failed to check bucket existence: %w", err)
 	}
 
@@ -54,20 +75,13 @@ func NewMinioService(cfg config.MinioConfig) (*MinioService, error) {
 
 // UploadImage uploads an image to MinIO
 func (m *MinioService) UploadImage(ctx context.Context, img image.Image) (string, error) {
-	buf := new(bytes.Buffer)
-	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90}); err != nil {
-		return "", fmt.Errorf("failed to encode image: %w", err)
-	}
-
-	filename := time.Now().Format("20060102150405") + ".jpg"
-	_, err := m.client.PutObject(
-		ctx,
-		m.config.Bucket,
-		filename,
-		bytes.NewReader(buf.Bytes()),
-		int64(buf.Len()),
-		minio.PutObjectOptions{ContentType: "image/jpeg"},
-	)
+	buf, err := encodeJPEG(img, qualityFor(m.config.EncodingConfig, "", UploadOptions{}), m.config.Progressive)
+	if err != nil {
+		return "", err
+	}
+
+	filename := timestampedName(time.Now()) + ".jpg"
+	err = m.putObjectWithRetry(ctx, filename, buf.Bytes(), m.putOptions("image/jpeg"))
 	if err != nil {
 		return "", fmt.Errorf("failed to upload image: %w", err)
 	}
@@ -75,23 +89,37 @@ func (m *MinioService) UploadImage(ctx context.Context, img image.Image) (string
 	return filename, nil
 }
 
-// UploadImageWithType uploads an image to MinIO with a type-specific filename
-func (m *MinioService) UploadImageWithType(ctx context.Context, img image.Image, processingType string) (string, error) {
-	buf := new(bytes.Buffer)
-	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90}); err != nil {
-		return "", fmt.Errorf("failed to encode image: %w", err)
+// InBucket returns a MinioService sharing this one's client that stores
+// objects in bucket. The bucket must already exist.
+func (m *MinioService) InBucket(bucket string) Storage {
+	scoped := *m
+	scoped.config.Bucket = bucket
+	return &scoped
+}
+
+// UploadImageWithType uploads an image to MinIO with a type-specific filename.
+// A non-empty variant (e.g. a resize preset name) is appended to the filename.
+func (m *MinioService) UploadImageWithType(ctx context.Context, img image.Image, processingType, variant string, opts UploadOptions) (filename string, err error) {
+	ctx, span := startUploadSpan(ctx, "minio", processingType, attribute.String("storage.bucket", m.config.Bucket))
+	defer func() { endUploadSpan(span, filename, err) }()
+
+	format := resolveFormat(opts.Format)
+	filename, skip, err := resolveKey(ctx, m, m.config.KeyPrefix, processingType, variant, format, opts)
+	if err != nil || skip {
+		span.SetAttributes(attribute.Bool("storage.skipped", skip))
+		return filename, err
+	}
+
+	data, err := encodeImage(ctx, img, format, m.config.EncodingConfig, processingType, opts)
+	if err != nil {
+		return "", err
 	}
+	span.SetAttributes(attribute.Int("image.bytes", len(data)), attribute.String("image.format", format))
 
-	timestamp := time.Now().Format("20060102150405")
-	filename := fmt.Sprintf("%s_%s.jpg", timestamp, processingType)
-	_, err := m.client.PutObject(
-		ctx,
-		m.config.Bucket,
-		filename,
-		bytes.NewReader(buf.Bytes()),
-		int64(buf.Len()),
-		minio.PutObjectOptions{ContentType: "image/jpeg"},
-	)
+	contentType, ext := MediaType(format)
+	putOpts := m.putOptions(contentType)
+	putOpts.ContentDisposition = contentDisposition(m.config.DownloadFilename, opts.SourceURL, processingType, variant, ext)
+	err = m.putObjectWithRetry(ctx, filename, data, putOpts)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload image: %w", err)
 	}
@@ -99,16 +127,174 @@ func (m *MinioService) UploadImageWithType(ctx context.Context, img image.Image,
 	return filename, nil
 }
 
+// putObjectWithRetry uploads an object, retrying transient failures with
+// exponential backoff up to the configured number of retries
+func (m *MinioService) putObjectWithRetry(ctx context.Context, filename string, data []byte, opts minio.PutObjectOptions) error {
+	backoff := m.config.UploadRetryBackoff
+	for attempt := 0; ; attempt++ {
+		_, err := m.client.PutObject(
+			ctx,
+			m.config.Bucket,
+			filename,
+			bytes.NewReader(data),
+			int64(len(data)),
+			opts,
+		)
+		if err == nil {
+			return nil
+		}
+		if attempt >= m.config.UploadMaxRetries || !isRetryableError(err) {
+			return err
+		}
+
+		uploadRetries.Inc()
+		log.Printf("Upload of %s failed (attempt %d/%d), retrying in %s: %v", filename, attempt+1, m.config.UploadMaxRetries+1, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// putOptions builds the upload options, applying the configured multipart
+// tuning
+func (m *MinioService) putOptions(contentType string) minio.PutObjectOptions {
+	return minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    m.config.UploadPartSize,
+		NumThreads:  m.config.UploadThreads,
+	}
+}
+
+// isRetryableError reports whether a MinIO error is transient (network
+// failures, throttling, 5xx) rather than permanent (auth, missing bucket)
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	resp := minio.ToErrorResponse(err)
+	switch resp.Code {
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "NoSuchBucket", "InvalidBucketName", "EntityTooLarge":
+		return false
+	case "SlowDown", "RequestTimeout", "InternalError", "ServiceUnavailable":
+		return true
+	}
+	if resp.StatusCode >= 500 {
+		return true
+	}
+	if resp.StatusCode >= 400 {
+		return false
+	}
+
+	// No S3 response at all means the request never completed (connection
+	// refused/reset, DNS, timeouts), which is worth retrying
+	return true
+}
+
 // GetImageURL returns the full URL for an image
 func (m *MinioService) GetImageURL(filename string) string {
 	return fmt.Sprintf("s3://%s/%s", m.config.Bucket, filename)
 }
 
+// ParseImageURL splits an s3://bucket/key location returned by GetImageURL,
+// reporting false for locations of other backends
+func ParseImageURL(location string) (bucket, key string, ok bool) {
+	rest, ok := strings.CutPrefix(location, "s3://")
+	if !ok {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	return bucket, key, bucket != "" && key != ""
+}
+
+// StoredObject is an object opened for reading. It is seekable, so it can
+// serve range requests without reading what comes before the range.
+type StoredObject struct {
+	io.ReadSeekCloser
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// OpenObject opens an object in any bucket the credentials can read,
+// returning an error wrapping ErrObjectNotFound when it does not exist
+func (m *MinioService) OpenObject(ctx context.Context, bucket, key string) (*StoredObject, error) {
+	obj, err := m.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%w: %s/%s", ErrObjectNotFound, bucket, key)
+		}
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+	return &StoredObject{ReadSeekCloser: obj, Size: info.Size, ContentType: info.ContentType, ModTime: info.LastModified}, nil
+}
+
 // GetFileSize returns the size of the file in bytes for a given filename
 func (m *MinioService) GetFileSize(ctx context.Context, filename string) (int64, error) {
 	objInfo, err := m.client.StatObject(ctx, m.config.Bucket, filename, minio.StatObjectOptions{})
 	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return 0, fmt.Errorf("%w: %s/%s", ErrObjectNotFound, m.config.Bucket, filename)
+		}
 		return 0, fmt.Errorf("failed to stat object: %w", err)
 	}
 	return objInfo.Size, nil
 }
+
+// ObjectExists reports whether an object exists in the bucket
+func (m *MinioService) ObjectExists(ctx context.Context, filename string) (bool, error) {
+	_, err := m.client.StatObject(ctx, m.config.Bucket, filename, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat object: %w", err)
+	}
+	return true, nil
+}
+
+// DeleteObject removes an object from the bucket; MinIO treats removing a
+// missing object as success
+func (m *MinioService) DeleteObject(ctx context.Context, filename string) error {
+	if err := m.client.RemoveObject(ctx, m.config.Bucket, filename, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to remove object: %w", err)
+	}
+	return nil
+}
+
+// ReadObject reads up to maxBytes+1 bytes of an object in any bucket the
+// credentials can read, so callers can tell an oversized object from one at
+// the limit
+func (m *MinioService) ReadObject(ctx context.Context, bucket, key string, maxBytes int64) ([]byte, error) {
+	obj, err := m.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(io.LimitReader(obj, maxBytes+1))
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%w: %s/%s", ErrObjectNotFound, bucket, key)
+		}
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	return data, nil
+}
+
+// PresignURL returns a presigned GET URL for an object
+func (m *MinioService) PresignURL(ctx context.Context, filename string, expiry time.Duration) (string, error) {
+	u, err := m.client.PresignedGetObject(ctx, m.config.Bucket, filename, expiry, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object: %w", err)
+	}
+	return u.String(), nil
+}
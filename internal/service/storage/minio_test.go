@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"image-processing-system/internal/config"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", errors.New("dial tcp: connection refused"), true},
+		{"slow down", minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}, true},
+		{"internal error", minio.ErrorResponse{Code: "InternalError", StatusCode: http.StatusInternalServerError}, true},
+		{"bad gateway", minio.ErrorResponse{StatusCode: http.StatusBadGateway}, true},
+		{"access denied", minio.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden}, false},
+		{"invalid key", minio.ErrorResponse{Code: "InvalidAccessKeyId", StatusCode: http.StatusForbidden}, false},
+		{"missing bucket", minio.ErrorResponse{Code: "NoSuchBucket", StatusCode: http.StatusNotFound}, false},
+		{"context cancelled", context.Canceled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableError(tt.err); got != tt.want {
+				t.Errorf("isRetryableError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPutOptionsMultipartTuning(t *testing.T) {
+	m := &MinioService{config: config.MinioConfig{UploadPartSize: 32 << 20, UploadThreads: 8}}
+
+	opts := m.putOptions("image/jpeg")
+	if opts.ContentType != "image/jpeg" || opts.PartSize != 32<<20 || opts.NumThreads != 8 {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+
+	// Unset tuning leaves minio-go's defaults in place
+	opts = (&MinioService{}).putOptions("image/avif")
+	if opts.PartSize != 0 || opts.NumThreads != 0 {
+		t.Errorf("expected client defaults, got part size %d, threads %d", opts.PartSize, opts.NumThreads)
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	const template = "{name}-{type}{variant}.{ext}"
+	tests := []struct {
+		name      string
+		template  string
+		sourceURL string
+		variant   string
+		want      string
+	}{
+		{"source name", template, "https://cdn.example.com/photos/beach.png?w=1", "", `attachment; filename=beach-grayscale.jpg`},
+		{"with variant", template, "https://cdn.example.com/beach.png", "sm", `attachment; filename=beach-grayscale-sm.jpg`},
+		{"no file name", template, "https://cdn.example.com/", "", `attachment; filename=image-grayscale.jpg`},
+		{"unsafe characters", template, "https://cdn.example.com/a%20b%22c.png", "", `attachment; filename=a_b_c-grayscale.jpg`},
+		{"disabled", "", "https://cdn.example.com/beach.png", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contentDisposition(tt.template, tt.sourceURL, "grayscale", tt.variant, ".jpg"); got != tt.want {
+				t.Errorf("contentDisposition() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForBucket(t *testing.T) {
+	m := &MinioService{config: config.MinioConfig{Bucket: "images"}}
+
+	scoped, err := ForBucket(m, "tenant-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scoped.GetImageURL("a.jpg"); got != "s3://tenant-a/a.jpg" {
+		t.Errorf("scoped URL = %q, want s3://tenant-a/a.jpg", got)
+	}
+	if got := m.GetImageURL("a.jpg"); got != "s3://images/a.jpg" {
+		t.Errorf("expected the original bucket to be unchanged, got %q", got)
+	}
+
+	if s, err := ForBucket(m, ""); err != nil || s != Storage(m) {
+		t.Errorf("expected no bucket to return the storage itself, got %v, %v", s, err)
+	}
+	if _, err := ForBucket(&FilesystemService{}, "tenant-a"); !errors.Is(err, ErrBucketsUnsupported) {
+		t.Errorf("expected ErrBucketsUnsupported for the filesystem backend, got %v", err)
+	}
+}
+
+func TestMediaType(t *testing.T) {
+	tests := []struct {
+		format   string
+		wantType string
+		wantExt  string
+	}{
+		{"jpeg", "image/jpeg", ".jpg"},
+		{"JPG", "image/jpeg", ".jpg"},
+		{"png", "image/png", ".png"},
+		{"tif", "image/tiff", ".tiff"},
+		{"avif", "image/avif", ".avif"},
+		{"", "application/octet-stream", ".bin"},
+		{"heic", "application/octet-stream", ".bin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			gotType, gotExt := MediaType(tt.format)
+			if gotType != tt.wantType || gotExt != tt.wantExt {
+				t.Errorf("MediaType(%q) = %q, %q, want %q, %q", tt.format, gotType, gotExt, tt.wantType, tt.wantExt)
+			}
+		})
+	}
+}
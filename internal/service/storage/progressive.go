@@ -0,0 +1,372 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+)
+
+// encodeProgressiveJPEG encodes img as a progressive JPEG at the given
+// quality. image/jpeg only writes baseline JPEGs, which browsers paint top to
+// bottom; a progressive one shows the whole image at low detail first. The
+// scans split coefficients by frequency (spectral selection): the DC terms of
+// every component, the lowest luma frequencies, the chroma, then the rest of
+// the luma. Chroma isn't subsampled.
+func encodeProgressiveJPEG(img image.Image, quality int) ([]byte, error) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width > 65535 || height > 65535 {
+		return nil, errors.New("jpeg: image dimensions out of range")
+	}
+
+	quant := [2][64]int{scaleQuant(lumaQuant, quality), scaleQuant(chromaQuant, quality)}
+	_, gray := img.(*image.Gray)
+	comps := []jpegComponent{{id: 1, table: 0}}
+	if !gray {
+		comps = append(comps, jpegComponent{id: 2, table: 1}, jpegComponent{id: 3, table: 1})
+	}
+
+	// Transform and quantize every block up front, since each scan walks
+	// the blocks again
+	blocksX, blocksY := (width+7)/8, (height+7)/8
+	for i := range comps {
+		comps[i].blocks = make([][64]int32, blocksX*blocksY)
+	}
+	var samples [3][64]float64
+	for by := 0; by < blocksY; by++ {
+		for bx := 0; bx < blocksX; bx++ {
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					// Edge blocks repeat the last row and column
+					px := b.Min.X + min(bx*8+x, width-1)
+					py := b.Min.Y + min(by*8+y, height-1)
+					if gray {
+						samples[0][y*8+x] = float64(img.(*image.Gray).GrayAt(px, py).Y)
+						continue
+					}
+					r, g, bl, _ := img.At(px, py).RGBA()
+					yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+					samples[0][y*8+x], samples[1][y*8+x], samples[2][y*8+x] = float64(yy), float64(cb), float64(cr)
+				}
+			}
+			for i := range comps {
+				comps[i].blocks[by*blocksX+bx] = fdctQuantize(&samples[i], &quant[comps[i].table])
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write([]byte{0xff, 0xd8}) // SOI
+	for t := range quant {
+		if t > 0 && gray {
+			break
+		}
+		seg := []byte{byte(t)}
+		for _, n := range zigzag {
+			seg = append(seg, byte(quant[t][n]))
+		}
+		writeSegment(&out, 0xdb, seg) // DQT
+	}
+
+	sof := []byte{8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(len(comps))}
+	for _, c := range comps {
+		sof = append(sof, c.id, 0x11, byte(c.table))
+	}
+	writeSegment(&out, 0xc2, sof) // SOF2: progressive DCT
+
+	for i, spec := range huffmanSpecs {
+		if i >= 2 && gray {
+			break
+		}
+		// Tables 0 and 1 are luma and chroma; class 0 is DC, 1 is AC
+		seg := append([]byte{byte(spec.class<<4 | i/2)}, spec.counts[:]...)
+		writeSegment(&out, 0xc4, append(seg, spec.values...)) // DHT
+	}
+
+	// DC of every component, interleaved
+	w := &bitWriter{out: &out}
+	writeScan(&out, comps, 0, 0)
+	preds := make([]int32, len(comps))
+	for n := 0; n < blocksX*blocksY; n++ {
+		for i, c := range comps {
+			dc := c.blocks[n][0]
+			w.emitValue(&huffmanCodes[c.table*2], dc-preds[i])
+			preds[i] = dc
+		}
+	}
+	w.flush()
+
+	// Then the AC bands one component at a time
+	bands := []struct{ comp, start, end int }{{0, 1, 5}, {1, 1, 63}, {2, 1, 63}, {0, 6, 63}}
+	for _, band := range bands {
+		if band.comp >= len(comps) {
+			continue
+		}
+		c := comps[band.comp]
+		writeScan(&out, []jpegComponent{c}, band.start, band.end)
+		codes := &huffmanCodes[c.table*2+1]
+		for n := range c.blocks {
+			run := 0
+			for k := band.start; k <= band.end; k++ {
+				v := c.blocks[n][zigzag[k]]
+				if v == 0 {
+					run++
+					continue
+				}
+				for ; run > 15; run -= 16 {
+					w.emit(codes[0xf0]) // ZRL: sixteen zeros
+				}
+				size := bitLength(v)
+				w.emit(codes[run<<4|size])
+				w.emitBits(v, size)
+				run = 0
+			}
+			if run > 0 {
+				w.emit(codes[0x00]) // EOB: the rest of the band is zero
+			}
+		}
+		w.flush()
+	}
+
+	out.Write([]byte{0xff, 0xd9}) // EOI
+	return out.Bytes(), nil
+}
+
+// jpegComponent is one color channel being encoded
+type jpegComponent struct {
+	id    byte
+	table int // quantization and Huffman table: 0 luma, 1 chroma
+	// blocks holds the quantized DCT coefficients of every block, in
+	// natural order
+	blocks [][64]int32
+}
+
+// writeScan starts a scan (SOS) of comps covering coefficients start..end
+// in zigzag order
+func writeScan(out *bytes.Buffer, comps []jpegComponent, start, end int) {
+	seg := []byte{byte(len(comps))}
+	for _, c := range comps {
+		seg = append(seg, c.id, byte(c.table<<4|c.table))
+	}
+	writeSegment(out, 0xda, append(seg, byte(start), byte(end), 0))
+}
+
+// writeSegment writes a marker segment with its length
+func writeSegment(out *bytes.Buffer, marker byte, data []byte) {
+	n := len(data) + 2
+	out.Write([]byte{0xff, marker, byte(n >> 8), byte(n)})
+	out.Write(data)
+}
+
+// fdctQuantize returns the quantized 2D DCT of an 8x8 block of samples
+func fdctQuantize(samples *[64]float64, quant *[64]int) [64]int32 {
+	var rows, coefs [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < 8; x++ {
+				sum += (samples[y*8+x] - 128) * dctCos[x][u]
+			}
+			rows[y*8+u] = sum
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			var sum float64
+			for y := 0; y < 8; y++ {
+				sum += rows[y*8+u] * dctCos[y][v]
+			}
+			coefs[v*8+u] = sum / 4
+		}
+	}
+
+	var out [64]int32
+	for i, c := range coefs {
+		out[i] = int32(math.Round(c / float64(quant[i])))
+	}
+	return out
+}
+
+// dctCos[x][u] is C(u) cos((2x+1)uπ/16), the DCT basis with its
+// normalization folded in
+var dctCos = func() (t [8][8]float64) {
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			c := 1.0
+			if u == 0 {
+				c = 1 / math.Sqrt2
+			}
+			t[x][u] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+// scaleQuant scales a base quantization table to a 1-100 quality the way
+// libjpeg does
+func scaleQuant(base [64]int, quality int) [64]int {
+	quality = max(1, min(100, quality))
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var t [64]int
+	for i, q := range base {
+		t[i] = max(1, min(255, (q*scale+50)/100))
+	}
+	return t
+}
+
+// bitLength is the JPEG size category of v: how many bits its magnitude takes
+func bitLength(v int32) int {
+	if v < 0 {
+		v = -v
+	}
+	return bits.Len32(uint32(v))
+}
+
+// huffmanCode is a code and its length in bits
+type huffmanCode struct {
+	code uint32
+	size int
+}
+
+// bitWriter writes entropy-coded data, stuffing a zero after every 0xff
+type bitWriter struct {
+	out  *bytes.Buffer
+	acc  uint64
+	nacc int
+}
+
+func (w *bitWriter) emit(c huffmanCode) {
+	w.write(c.code, c.size)
+}
+
+// emitBits writes the low size bits of v, in JPEG's ones' complement form
+// for negative values
+func (w *bitWriter) emitBits(v int32, size int) {
+	if v < 0 {
+		v--
+	}
+	w.write(uint32(v)&(1<<size-1), size)
+}
+
+// emitValue writes a DC difference: its size category, then its bits
+func (w *bitWriter) emitValue(codes *[256]huffmanCode, v int32) {
+	size := bitLength(v)
+	w.emit(codes[size])
+	w.emitBits(v, size)
+}
+
+func (w *bitWriter) write(code uint32, size int) {
+	w.acc = w.acc<<size | uint64(code)
+	w.nacc += size
+	for w.nacc >= 8 {
+		b := byte(w.acc >> (w.nacc - 8))
+		w.out.WriteByte(b)
+		if b == 0xff {
+			w.out.WriteByte(0)
+		}
+		w.nacc -= 8
+	}
+	w.acc &= 1<<w.nacc - 1
+}
+
+// flush pads the last byte of a scan with ones
+func (w *bitWriter) flush() {
+	if w.nacc > 0 {
+		w.write(1<<(8-w.nacc)-1, 8-w.nacc)
+	}
+}
+
+// zigzag maps a coefficient's position in zigzag order to its natural index
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// lumaQuant and chromaQuant are the JPEG standard's example quantization
+// tables (Annex K), in natural order
+var lumaQuant = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+var chromaQuant = [64]int{
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}
+
+// huffmanSpec is a Huffman table as stored in a DHT segment: how many codes
+// there are of each length 1-16, and the symbols they encode
+type huffmanSpec struct {
+	class  int // 0 DC, 1 AC
+	counts [16]byte
+	values []byte
+}
+
+// huffmanSpecs are the JPEG standard's example tables (Annex K): luma DC,
+// luma AC, chroma DC, chroma AC
+var huffmanSpecs = [4]huffmanSpec{
+	{0, [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0}, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	{1, [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125}, []byte{
+		0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+		0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+		0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+		0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+		0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+		0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+		0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+		0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+		0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+		0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	}},
+	{0, [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0}, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	{1, [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119}, []byte{
+		0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+		0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+		0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+		0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+		0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+		0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+		0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+		0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+		0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+		0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	}},
+}
+
+// huffmanCodes holds each of huffmanSpecs' codes indexed by symbol
+var huffmanCodes = func() (t [4][256]huffmanCode) {
+	for i, spec := range huffmanSpecs {
+		code, k := uint32(0), 0
+		for length, n := range spec.counts {
+			for j := 0; j < int(n); j++ {
+				t[i][spec.values[k]] = huffmanCode{code: code, size: length + 1}
+				code++
+				k++
+			}
+			code <<= 1
+		}
+	}
+	return t
+}()
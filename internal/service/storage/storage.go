@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strings"
+	"time"
+
+	"image-processing-system/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrObjectNotFound is returned when a requested object does not exist
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrObjectExists is returned when an upload would overwrite an existing
+	// object and the upload options forbid it
+	ErrObjectExists = errors.New("object already exists")
+	// ErrBucketsUnsupported is returned for bucket overrides on backends
+	// without buckets
+	ErrBucketsUnsupported = errors.New("storage backend does not support bucket overrides")
+)
+
+// ExistsPolicy controls what an upload does when its key already exists
+type ExistsPolicy int
+
+const (
+	// Overwrite replaces the existing object (default)
+	Overwrite ExistsPolicy = iota
+	// FailIfExists rejects the upload with ErrObjectExists
+	FailIfExists
+	// SkipIfExists keeps the existing object and returns its key
+	SkipIfExists
+)
+
+// UploadOptions customizes an upload
+type UploadOptions struct {
+	// Key overrides the generated timestamped key, e.g. for deterministic names
+	Key string
+	// IfExists decides what happens when Key already exists. The check and
+	// the write are not atomic, so concurrent uploads can still race.
+	IfExists ExistsPolicy
+	// Format selects the output encoding (FormatJPEG by default). Formats
+	// whose encoder is unavailable fall back to JPEG.
+	Format string
+	// SourceURL is the URL the image was downloaded from, used to name the
+	// file browsers save it as
+	SourceURL string
+	// Quality overrides the configured encoding quality when non-zero
+	Quality int
+}
+
+// Storage is the object store processed images are uploaded to
+type Storage interface {
+	// UploadImageWithType encodes and uploads an image, returning its object key
+	UploadImageWithType(ctx context.Context, img image.Image, processingType, variant string, opts UploadOptions) (string, error)
+	// ObjectExists reports whether an object exists
+	ObjectExists(ctx context.Context, key string) (bool, error)
+	// GetImageURL returns the canonical location of an object
+	GetImageURL(key string) string
+	// GetFileSize returns the stored size of an object in bytes, or an error
+	// wrapping ErrObjectNotFound when the object does not exist
+	GetFileSize(ctx context.Context, key string) (int64, error)
+	// PresignURL returns a URL that grants temporary read access to an object
+	PresignURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// DeleteObject removes an object. Removing one that doesn't exist is not
+	// an error.
+	DeleteObject(ctx context.Context, key string) error
+}
+
+// BucketStorage is implemented by backends that can store objects in a bucket
+// other than the configured one
+type BucketStorage interface {
+	// InBucket returns a view of the storage that uses bucket instead
+	InBucket(bucket string) Storage
+}
+
+// ForBucket returns s scoped to bucket, or s itself when bucket is empty
+func ForBucket(s Storage, bucket string) (Storage, error) {
+	if bucket == "" {
+		return s, nil
+	}
+	b, ok := s.(BucketStorage)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBucketsUnsupported, bucket)
+	}
+	return b.InBucket(bucket), nil
+}
+
+// New creates the storage backend selected by cfg.Backend
+func New(cfg config.StorageConfig, minioCfg config.MinioConfig) (Storage, error) {
+	switch cfg.Backend {
+	case "", "minio":
+		return NewMinioService(minioCfg)
+	case "fs":
+		return NewFilesystemService(cfg.FSRoot, minioCfg.EncodingConfig)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+	}
+}
+
+// timestampedName names an upload made at t. The time has nanosecond
+// precision and is followed by a random suffix, so uploads in the same
+// second, on one host or several, never share a key.
+func timestampedName(t time.Time) string {
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	return fmt.Sprintf("%s%09d_%s", t.Format("20060102150405"), t.Nanosecond(), hex.EncodeToString(suffix[:]))
+}
+
+// objectKey builds a timestamped key for a processed image. A non-empty
+// variant (e.g. a resize preset name) is appended after the processing type.
+func objectKey(processingType, variant, ext string) string {
+	timestamp := timestampedName(time.Now())
+	if variant != "" {
+		return fmt.Sprintf("%s_%s_%s%s", timestamp, processingType, variant, ext)
+	}
+	return fmt.Sprintf("%s_%s%s", timestamp, processingType, ext)
+}
+
+// expandKeyPrefix expands an object key prefix template for an upload at t.
+// {yyyy}, {mm}, {dd} and {hh} are its UTC year, month, day and hour.
+func expandKeyPrefix(template string, t time.Time) string {
+	if template == "" {
+		return ""
+	}
+	t = t.UTC()
+	return strings.NewReplacer(
+		"{yyyy}", t.Format("2006"),
+		"{mm}", t.Format("01"),
+		"{dd}", t.Format("02"),
+		"{hh}", t.Format("15"),
+	).Replace(template)
+}
+
+// DerivedKey returns a key determined by the owner, the source URL and the
+// output made from it, so producing the same output again lands on the same
+// key. params distinguishes outputs that share a variant name, e.g. a
+// preset's size. Owners get keys of their own, so one can't reuse, and skip
+// paying for, another's output; unowned keys are unchanged.
+func DerivedKey(owner, sourceURL, processingType, variant, params, format string) string {
+	parts := []string{sourceURL, processingType, variant, params}
+	if owner != "" {
+		parts = append(parts, "owner="+owner)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	name := hex.EncodeToString(sum[:12]) + "_" + processingType
+	if variant != "" {
+		name += "_" + variant
+	}
+	return name + formats[resolveFormat(format)].ext
+}
+
+// resolveKey returns the key to upload to and whether the upload should be
+// skipped because the key already exists. Generated keys start with
+// prefixTemplate expanded for now; keys given in opts are used as they are,
+// so derived keys stay the same whenever they are produced.
+func resolveKey(ctx context.Context, s Storage, prefixTemplate, processingType, variant, format string, opts UploadOptions) (string, bool, error) {
+	key := opts.Key
+	if key == "" {
+		key = expandKeyPrefix(prefixTemplate, time.Now()) + objectKey(processingType, variant, formats[format].ext)
+	}
+	if opts.IfExists == Overwrite {
+		return key, false, nil
+	}
+
+	exists, err := s.ObjectExists(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	if !exists {
+		return key, false, nil
+	}
+	if opts.IfExists == SkipIfExists {
+		return key, true, nil
+	}
+	return "", false, fmt.Errorf("%w: %s", ErrObjectExists, key)
+}
+
+// startUploadSpan starts the span covering one UploadImageWithType call
+func startUploadSpan(ctx context.Context, backend, processingType string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer("storage").Start(ctx, "UploadImage")
+	span.SetAttributes(
+		attribute.String("storage.backend", backend),
+		attribute.String("processing_type", processingType),
+	)
+	span.SetAttributes(attrs...)
+	return ctx, span
+}
+
+// endUploadSpan records the upload's key or error and ends the span
+func endUploadSpan(span trace.Span, key string, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.String("storage.key", key))
+	}
+	span.End()
+}
+
+// qualityFor returns the JPEG quality for an upload: the one opts asks for,
+// else the processing type's override, else the global quality. It is kept
+// within 1-100 so an unvalidated setting can't reach the encoder.
+func qualityFor(cfg config.EncodingConfig, processingType string, opts UploadOptions) int {
+	quality := cfg.Quality
+	if q, ok := cfg.QualityByType[processingType]; ok {
+		quality = q
+	}
+	if opts.Quality > 0 {
+		quality = opts.Quality
+	}
+	return min(max(quality, 1), 100)
+}
+
+// encodeJPEG encodes an image as JPEG at the given quality, progressive or
+// baseline
+func encodeJPEG(img image.Image, quality int, progressive bool) (*bytes.Buffer, error) {
+	if progressive {
+		data, err := encodeProgressiveJPEG(img, quality)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		return bytes.NewBuffer(data), nil
+	}
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf, nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"image-processing-system/internal/config"
+)
+
+func TestQualityFor(t *testing.T) {
+	cfg := config.EncodingConfig{Quality: 90, QualityByType: map[string]int{"resize": 60, "blur": 150}}
+
+	tests := []struct {
+		name           string
+		processingType string
+		opts           UploadOptions
+		want           int
+	}{
+		{"global", "grayscale", UploadOptions{}, 90},
+		{"per type", "resize", UploadOptions{}, 60},
+		{"upload override", "resize", UploadOptions{Quality: 42}, 42},
+		{"too high", "blur", UploadOptions{}, 100},
+		{"override too high", "grayscale", UploadOptions{Quality: 101}, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := qualityFor(cfg, tt.processingType, tt.opts); got != tt.want {
+				t.Errorf("qualityFor() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if got := qualityFor(config.EncodingConfig{Quality: -5}, "", UploadOptions{}); got != 1 {
+		t.Errorf("qualityFor() with quality -5 = %d, want 1", got)
+	}
+}
+
+func TestObjectKeyUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		key := objectKey("resize", "thumb", ".jpg")
+		if seen[key] {
+			t.Fatalf("key %s generated twice", key)
+		}
+		seen[key] = true
+		if !strings.HasSuffix(key, "_resize_thumb.jpg") {
+			t.Errorf("unexpected key %s", key)
+		}
+	}
+
+	// Uploads at the same instant still get distinct names
+	now := time.Now()
+	if a, b := timestampedName(now), timestampedName(now); a == b {
+		t.Errorf("expected distinct names for the same time, got %s twice", a)
+	}
+}
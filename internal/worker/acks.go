@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ackBatcher acknowledges finished jobs in batches. A multiple-ack covers
+// every earlier delivery tag on the channel, and jobs finish out of order, so
+// it only ever acks up to the highest tag below which every delivery is
+// settled. Deliveries are tagged 1, 2, 3... per channel, which is what lets
+// it tell which tags are still being processed.
+type ackBatcher struct {
+	mu   sync.Mutex
+	size int
+	// acked is the highest tag covered by an ack or otherwise settled, with
+	// every tag below it settled too
+	acked uint64
+	// pending holds the finished deliveries not yet acknowledged
+	pending map[uint64]amqp.Delivery
+	// settled holds tags above acked that were already rejected or acked
+	// on their own
+	settled map[uint64]struct{}
+}
+
+// newAckBatcher returns a batcher that acknowledges once size deliveries
+// are waiting
+func newAckBatcher(size int) *ackBatcher {
+	return &ackBatcher{
+		size:    size,
+		pending: make(map[uint64]amqp.Delivery),
+		settled: make(map[uint64]struct{}),
+	}
+}
+
+// Ack queues m's acknowledgment, flushing when the batch is full
+func (b *ackBatcher) Ack(m amqp.Delivery) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending[m.DeliveryTag] = m
+	if len(b.pending) >= b.size {
+		b.flushLocked(false)
+	}
+}
+
+// Rejected records that tag was settled by a nack, so later multiple-acks
+// can pass over it
+func (b *ackBatcher) Rejected(tag uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if tag > b.acked {
+		b.settled[tag] = struct{}{}
+	}
+}
+
+// flush acknowledges every finished delivery it can with one multiple-ack.
+// With force, the ones held back behind a job still in flight are acked
+// individually, so none waits longer than one flush interval.
+func (b *ackBatcher) flush(force bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked(force)
+}
+
+func (b *ackBatcher) flushLocked(force bool) {
+	var last *amqp.Delivery
+	for next := b.acked + 1; ; next++ {
+		if m, ok := b.pending[next]; ok {
+			last = &m
+			delete(b.pending, next)
+		} else if _, ok := b.settled[next]; ok {
+			delete(b.settled, next)
+		} else {
+			break
+		}
+		b.acked = next
+	}
+	if last != nil {
+		if err := last.Ack(true); err != nil {
+			log.Printf("Failed to ack messages up to %d: %v", last.DeliveryTag, err)
+		}
+	}
+
+	if !force {
+		return
+	}
+	for tag, m := range b.pending {
+		if err := m.Ack(false); err != nil {
+			log.Printf("Failed to ack message: %v", err)
+		}
+		delete(b.pending, tag)
+		b.settled[tag] = struct{}{}
+	}
+}
+
+// run flushes every interval until stop is closed, then flushes once more
+func (b *ackBatcher) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			b.flush(true)
+			return
+		case <-ticker.C:
+			b.flush(true)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ackCall is one acknowledgment sent to the broker
+type ackCall struct {
+	tag      uint64
+	multiple bool
+}
+
+// recordingAcknowledger records the acks sent for a channel's deliveries
+type recordingAcknowledger struct {
+	acks []ackCall
+}
+
+func (r *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	r.acks = append(r.acks, ackCall{tag, multiple})
+	return nil
+}
+
+func (r *recordingAcknowledger) Nack(tag uint64, multiple, requeue bool) error { return nil }
+
+func (r *recordingAcknowledger) Reject(tag uint64, requeue bool) error { return nil }
+
+func TestAckBatcher(t *testing.T) {
+	ch := &recordingAcknowledger{}
+	delivery := func(tag uint64) amqp.Delivery {
+		return amqp.Delivery{Acknowledger: ch, DeliveryTag: tag}
+	}
+	b := newAckBatcher(3)
+
+	b.Ack(delivery(2))
+	b.Ack(delivery(3))
+	b.Rejected(1)
+	if len(ch.acks) != 0 {
+		t.Fatalf("acked before the batch filled: %v", ch.acks)
+	}
+
+	// Tag 4 is still in flight, so the batch is acked up to 3 and 5 waits
+	b.Ack(delivery(5))
+	if want := []ackCall{{3, true}}; !reflect.DeepEqual(ch.acks, want) {
+		t.Fatalf("acks = %v, want %v", ch.acks, want)
+	}
+
+	// The interval flush doesn't hold 5 back any longer
+	b.flush(true)
+	if want := []ackCall{{3, true}, {5, false}}; !reflect.DeepEqual(ch.acks, want) {
+		t.Fatalf("acks = %v, want %v", ch.acks, want)
+	}
+
+	// Once 4 finishes the multiple-ack passes over the already acked 5
+	b.Ack(delivery(4))
+	b.Ack(delivery(6))
+	b.flush(false)
+	if want := []ackCall{{3, true}, {5, false}, {6, true}}; !reflect.DeepEqual(ch.acks, want) {
+		t.Fatalf("acks = %v, want %v", ch.acks, want)
+	}
+	if len(b.pending) != 0 || len(b.settled) != 0 || b.acked != 6 {
+		t.Errorf("batcher not drained: acked=%d pending=%v settled=%v", b.acked, b.pending, b.settled)
+	}
+}
@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/middleware"
+	"image-processing-system/internal/models"
+	"image-processing-system/internal/service/storage"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// discardTimeout bounds removing a failed job's outputs, which happens after
+// the job's own deadline may have passed
+const discardTimeout = 30 * time.Second
+
+// publishedHeader lists the outputs of a job whose results were already
+// published, by outputID, so a retry of the job doesn't produce them again
+const publishedHeader = "x-published-outputs"
+
+// outputBatch tracks the outputs of one run of a job. With hold set it keeps
+// the job's results back until all of its outputs are stored, so a job
+// failing partway publishes nothing and removes what it stored rather than
+// leaving metadata for only some outputs. Otherwise each result is published
+// as soon as its output is stored.
+type outputBatch struct {
+	hold    bool
+	results []batchedResult
+	// stored are the objects uploaded for the job that a failure removes
+	stored []storedOutput
+	// published lists the outputs whose results were sent, by outputID
+	published []string
+}
+
+// batchedResult is a result waiting for the rest of its job
+type batchedResult struct {
+	task   imageTask
+	result models.ImageProcessedPayload
+}
+
+// storedOutput is an object uploaded for a job in a batch
+type storedOutput struct {
+	key            string
+	processingType string
+	// id is the output's outputID
+	id string
+}
+
+// newOutputBatch returns the batch for a run of a job, holding results back
+// when atomic outputs are enabled
+func (w *ImageWorker) newOutputBatch() *outputBatch {
+	return &outputBatch{hold: w.config.Worker.AtomicOutputs}
+}
+
+// emitResult publishes result, or holds it in batch until the job's other
+// outputs are stored
+func (w *ImageWorker) emitResult(ctx context.Context, batch *outputBatch, task imageTask, result models.ImageProcessedPayload) error {
+	result.OwnerID = task.OwnerID
+	if !batch.hold {
+		if err := w.publishResult(ctx, task, result); err != nil {
+			return err
+		}
+		batch.published = append(batch.published, outputID(task))
+		return nil
+	}
+	batch.results = append(batch.results, batchedResult{task: task, result: result})
+	return nil
+}
+
+// outputID names an output within its job: the processing type, and the
+// preset for resize outputs made from one
+func outputID(task imageTask) string {
+	if task.Preset != nil {
+		return task.ProcessingType + ":" + task.Preset.Name
+	}
+	return task.ProcessingType
+}
+
+// publishedOutputs reads the outputs an earlier run of a job published from
+// its headers
+func publishedOutputs(headers amqp.Table) map[string]bool {
+	ids, _ := headers[publishedHeader].([]interface{})
+	published := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if s, ok := id.(string); ok {
+			published[s] = true
+		}
+	}
+	return published
+}
+
+// withoutPublished drops the tasks whose outputs were already published
+func withoutPublished(tasks []imageTask, published map[string]bool) []imageTask {
+	if len(published) == 0 {
+		return tasks
+	}
+	remaining := tasks[:0:0]
+	for _, task := range tasks {
+		if !published[outputID(task)] {
+			remaining = append(remaining, task)
+		}
+	}
+	return remaining
+}
+
+// partialFailure is a job failure after some of its results were published
+type partialFailure struct {
+	err       error
+	published []string
+}
+
+func (e *partialFailure) Error() string { return e.err.Error() }
+func (e *partialFailure) Unwrap() error { return e.err }
+
+// failed returns err, carrying the outputs this run published when there
+// are any so a retry skips them
+func (b *outputBatch) failed(err error) error {
+	if err == nil || len(b.published) == 0 {
+		return err
+	}
+	return &partialFailure{err: err, published: b.published}
+}
+
+// markPublished adds the outputs a failed run published to a retry's headers
+func markPublished(headers amqp.Table, jobErr error) {
+	var partial *partialFailure
+	if !errors.As(jobErr, &partial) {
+		return
+	}
+	ids, _ := headers[publishedHeader].([]interface{})
+	for _, id := range partial.published {
+		ids = append(ids, id)
+	}
+	headers[publishedHeader] = ids
+}
+
+// track records an object uploaded for task. Objects under a skip_existing
+// key may be shared with other jobs and are reused by a retry, so they are
+// never removed.
+func (b *outputBatch) track(task imageTask, key string) {
+	if !b.hold {
+		return
+	}
+	if _, derived := outputKey(task); derived {
+		return
+	}
+	b.stored = append(b.stored, storedOutput{key: key, processingType: task.ProcessingType, id: outputID(task)})
+}
+
+// publishBatch publishes the results held in batch. If a publish fails, the
+// outputs whose results were published are kept, since their records refer to
+// them, and the rest are removed; the retry produces only those again.
+func (w *ImageWorker) publishBatch(ctx context.Context, store storage.Storage, batch *outputBatch) error {
+	if !batch.hold {
+		return nil
+	}
+	for _, r := range batch.results {
+		if err := w.publishResult(ctx, r.task, r.result); err != nil {
+			batch.discard(ctx, store)
+			return err
+		}
+		batch.published = append(batch.published, outputID(r.task))
+	}
+	return nil
+}
+
+// discard removes the objects stored for a failed job, except those of
+// outputs whose results were published
+func (b *outputBatch) discard(ctx context.Context, store storage.Storage) {
+	if len(b.stored) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), discardTimeout)
+	defer cancel()
+
+	published := make(map[string]bool, len(b.published))
+	for _, id := range b.published {
+		published[id] = true
+	}
+	for _, out := range b.stored {
+		if published[out.id] {
+			continue
+		}
+		if err := store.DeleteObject(ctx, out.key); err != nil {
+			log.Printf("Failed to remove output %s of a failed job: %v", out.key, err)
+			continue
+		}
+		log.Printf("Removed output %s [%s] of a failed job", out.key, out.processingType)
+		middleware.OutputsDiscarded.WithLabelValues(out.processingType, config.ImageFetcherService).Inc()
+	}
+	b.stored = nil
+}
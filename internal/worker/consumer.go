@@ -2,193 +2,885 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"log"
-	"sync"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"image-processing-system/internal/config"
 	"image-processing-system/internal/middleware"
 	"image-processing-system/internal/models"
-	"image-processing-system/internal/service/metadata"
 	"image-processing-system/internal/service/processor"
 	"image-processing-system/internal/service/storage"
 	"image-processing-system/pkg/message"
+	"image-processing-system/pkg/rabbitmq"
 
-	"net/http"
-
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
+var (
+	// errInvalidJob marks jobs that can never succeed, so they skip retries
+	errInvalidJob = errors.New("invalid job")
+	// errUnsupportedType marks invalid jobs requesting an unknown processing type
+	errUnsupportedType = errors.New("unsupported processing type")
+	// errDownload and errUpload tag the pipeline step a job failed in
+	errDownload = errors.New("download failed")
+	errUpload   = errors.New("upload failed")
+	// errPanicked marks jobs whose processing panicked. They aren't retried
+	// since the same input would most likely panic again.
+	errPanicked = errors.New("job panicked")
+)
+
+// ImageDownloader fetches and decodes a source image, returning its format
+type ImageDownloader interface {
+	DownloadImage(ctx context.Context, url string) (image.Image, string, error)
+}
+
+// ImageTransformer applies the supported processing types to an image
+type ImageTransformer interface {
+	Grayscale(img image.Image) image.Image
+	Resize(img image.Image, width, height int) image.Image
+	Blur(img image.Image, sigma float64) image.Image
+	Sharpen(img image.Image, sigma float64) image.Image
+	DominantColors(img image.Image, n int) []color.RGBA
+	BlurHash(img image.Image) string
+}
+
+// CancellationChecker reports whether an owner's jobs for a trace ID were
+// cancelled
+type CancellationChecker interface {
+	IsCancelled(ctx context.Context, traceID, ownerID string) (bool, error)
+}
+
+// StoredImageReader is implemented by downloaders that can read back an
+// image the pipeline stored, such as a source's stored original
+type StoredImageReader interface {
+	ReadStoredImage(ctx context.Context, bucket, key string) (image.Image, string, error)
+}
+
+// decodeLimiter is implemented by downloaders that can run their decode step
+// under the worker's CPU slots
+type decodeLimiter interface {
+	LimitDecodes(slots chan struct{})
+}
+
+// Channel is the subset of *amqp.Channel the worker uses
+type Channel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
 // ImageWorker handles image processing jobs
 type ImageWorker struct {
 	config           *config.ImageFetcherConfig
-	processor        *processor.ImageProcessor
-	storage          *storage.MinioService
-	metadata         *metadata.MetadataService
-	channel          *amqp.Channel
+	downloader       ImageDownloader
+	transformer      ImageTransformer
+	storage          storage.Storage
+	cancellations    CancellationChecker
+	channel          Channel
+	publisher        *rabbitmq.Publisher
 	concurrencyLimit int
-	metricsServer    *http.Server
+	stats            *scalerStats
+	autoRules        []config.AutoRule
+	defaultParams    map[string]models.ProcessingParams
+	paramLimits      config.ParamLimitsConfig
+	// cpuSlots bounds the decodes and transforms running at once, separately
+	// from concurrencyLimit so CPU-heavy work doesn't hold download slots
+	cpuSlots chan struct{}
+	// failures drives /ready; nil when the check is disabled
+	failures *failureTracker
+	// acks batches acknowledgments; nil acks each job as it finishes
+	acks *ackBatcher
 }
 
-// NewImageWorker creates a new image worker instance
-func NewImageWorker(cfg *config.ImageFetcherConfig, ch *amqp.Channel) (*ImageWorker, error) {
-	proc := processor.NewImageProcessor()
+// imageTask describes a single output to produce from a source image
+type imageTask struct {
+	URL            string
+	ProcessingType string
+	Preset         *models.ResizePreset
+	// Params tunes the processing type; zero fields take its defaults
+	Params models.ProcessingParams
+	// Clamped marks tasks whose Params or Preset were lowered to the limits
+	Clamped bool
+	TraceID string
+	// SubmittedAt is when the job entered the pipeline, zero if unknown
+	SubmittedAt time.Time
+	// Format is the requested output encoding, empty for the default
+	Format string
+	// Bucket overrides the storage bucket outputs are uploaded to
+	Bucket string
+	// SkipExisting stores the output under its derived key, skipping it when
+	// that key already exists
+	SkipExisting bool
+	// Original is the s3:// location of the source's stored original, read
+	// instead of downloading URL when set
+	Original string
+	// OwnerID names the owner the job was submitted by
+	OwnerID string
+	// Reply addresses a waiting submitter; results are also published there
+	// when set
+	Reply message.Reply
+}
 
-	storageSvc, err := storage.NewMinioService(cfg.Minio)
-	if err != nil {
-		return nil, err
+// NewImageWorker creates a new image worker instance. cancellations may be
+// nil, in which case no job is treated as cancelled.
+func NewImageWorker(cfg *config.ImageFetcherConfig, ch Channel, downloader ImageDownloader, transformer ImageTransformer, store storage.Storage, cancellations CancellationChecker) *ImageWorker {
+	// Configs built without validation fall back to the defaults
+	concurrency, decodeConcurrency := cfg.Worker.Concurrency, cfg.Worker.DecodeConcurrency
+	if concurrency <= 0 {
+		concurrency = 5
 	}
-
-	metadataSvc, err := metadata.NewMetadataService(cfg.Database)
-	if err != nil {
-		return nil, err
+	if decodeConcurrency <= 0 {
+		decodeConcurrency = runtime.GOMAXPROCS(0)
 	}
 
-	// Start metrics server if enabled
-	var metricsServer *http.Server
-	if cfg.Metrics.Enabled {
-		mux := http.NewServeMux()
-		mux.Handle(cfg.Metrics.Path, promhttp.Handler())
-		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte(`{"status":"healthy","service":"image-fetcher"}`))
-		})
+	cpuSlots := make(chan struct{}, decodeConcurrency)
+	if d, ok := downloader.(decodeLimiter); ok {
+		d.LimitDecodes(cpuSlots)
+	}
 
-		metricsServer = &http.Server{
-			Addr:    ":" + cfg.Metrics.Port,
-			Handler: mux,
-		}
+	paramLimits := cfg.Params
+	if paramLimits.MaxSigma <= 0 {
+		paramLimits.MaxSigma = 50
+	}
+	if paramLimits.MaxDimension <= 0 {
+		paramLimits.MaxDimension = 8192
+	}
 
-		go func() {
-			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Printf("Metrics server error: %v", err)
-			}
-		}()
+	var acks *ackBatcher
+	if cfg.Worker.AckMode == config.AckModeBatch {
+		acks = newAckBatcher(cfg.Worker.AckBatchSize)
 	}
 
 	return &ImageWorker{
 		config:           cfg,
-		processor:        proc,
-		storage:          storageSvc,
-		metadata:         metadataSvc,
+		downloader:       downloader,
+		transformer:      transformer,
+		storage:          store,
+		cancellations:    cancellations,
 		channel:          ch,
-		concurrencyLimit: 5, // Can be made configurable
-		metricsServer:    metricsServer,
-	}, nil
+		publisher:        rabbitmq.NewPublisher(ch),
+		concurrencyLimit: concurrency,
+		cpuSlots:         cpuSlots,
+		failures:         newFailureTracker(cfg.Worker),
+		stats:            newScalerStats(),
+		autoRules:        loadAutoRules(cfg.Worker.AutoRules),
+		defaultParams:    loadDefaultParams(cfg.Worker.DefaultParams),
+		paramLimits:      paramLimits,
+		acks:             acks,
+	}
+}
+
+// loadAutoRules parses the auto rules, which config validation has already
+// checked; invalid rules leave auto jobs with only the original
+func loadAutoRules(spec string) []config.AutoRule {
+	rules, err := config.ParseAutoRules(spec)
+	if err != nil {
+		log.Printf("Ignoring invalid auto rules: %v", err)
+	}
+	return rules
+}
+
+// loadDefaultParams parses the server-side default params, which config
+// validation has already checked; invalid ones leave the built-in defaults
+func loadDefaultParams(spec string) map[string]models.ProcessingParams {
+	defaults, err := config.ParseDefaultParams(spec)
+	if err != nil {
+		log.Printf("Ignoring invalid default params: %v", err)
+	}
+	return defaults
 }
 
 // Start begins consuming and processing image jobs
 func (w *ImageWorker) Start() {
-	msgs, err := w.channel.Consume("image.urls", "", true, false, false, false, nil)
+	// Bound prefetch to the concurrency limit so queued jobs stay in the broker,
+	// where they are delivered in priority order. Finished jobs waiting for a
+	// batch ack still count against it, so batches get room of their own.
+	prefetch := w.concurrencyLimit
+	if w.acks != nil {
+		prefetch += w.config.Worker.AckBatchSize
+	}
+	if err := w.channel.Qos(prefetch, 0, false); err != nil {
+		log.Printf("Failed to set prefetch: %v", err)
+		return
+	}
+	if w.acks != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go w.acks.run(w.config.Worker.AckFlushInterval, stop)
+		log.Printf("Acknowledging jobs in batches of %d, at least every %s", w.config.Worker.AckBatchSize, w.config.Worker.AckFlushInterval)
+	}
+
+	consumerTag := w.config.RabbitMQ.ConsumerTagFor(config.ImageFetcherService)
+	lanes, err := w.consumeLanes(consumerTag)
 	if err != nil {
 		log.Printf("Failed to consume messages: %v", err)
 		return
 	}
+	log.Printf("Consuming %s as %s", strings.Join(w.config.RabbitMQ.JobQueueNames(), ", "), consumerTag)
+	msgs := mergeLanes(lanes)
 
-	sem := make(chan struct{}, w.concurrencyLimit)
-	var wg sync.WaitGroup
+	if !w.newConsumer().Run(msgs) {
+		log.Printf("Deliveries stopped but %d jobs were still in flight after %s, exiting without them; RabbitMQ redelivers their messages",
+			w.stats.inFlight.Load(), w.config.Worker.ShutdownGrace)
+	}
+}
 
-	for msg := range msgs {
-		sem <- struct{}{}
-		wg.Add(1)
-		middleware.ActiveWorkers.WithLabelValues("image-fetcher").Inc()
+// newConsumer returns the consumer that runs jobs through handleDelivery,
+// up to the concurrency limit at once
+func (w *ImageWorker) newConsumer() *rabbitmq.Consumer {
+	opts := rabbitmq.ConsumerOptions{
+		Concurrency:   w.concurrencyLimit,
+		ShutdownGrace: w.config.Worker.ShutdownGrace,
+	}
+	if w.acks != nil {
+		opts.Acker = w.acks
+	}
+	return rabbitmq.NewConsumer(config.ImageFetcherService, w.handleDelivery, opts)
+}
 
-		go func(m amqp.Delivery) {
-			defer wg.Done()
-			defer func() {
-				<-sem
-				middleware.ActiveWorkers.WithLabelValues("image-fetcher").Dec()
-			}()
+// handleDelivery processes a delivery and decides how it is settled.
+// Transient failures are requeued through the delay queue with backoff and
+// acked; terminal failures and jobs out of retries are dead-lettered.
+func (w *ImageWorker) handleDelivery(ctx context.Context, m amqp.Delivery) rabbitmq.Decision {
+	middleware.ActiveWorkers.WithLabelValues(config.ImageFetcherService).Inc()
+	w.stats.inFlight.Add(1)
+	defer func() {
+		middleware.ActiveWorkers.WithLabelValues(config.ImageFetcherService).Dec()
+		w.stats.inFlight.Add(-1)
+		w.stats.throughput.mark(time.Now())
+	}()
 
-			w.processJob(m)
-		}(msg)
+	err := w.runJob(ctx, m)
+	w.failures.record(err, time.Now())
+	if err == nil || w.requeueForRetry(m, err) {
+		return rabbitmq.Ack
 	}
-	wg.Wait()
+	middleware.JobsDeadLettered.WithLabelValues(failureReason(err), config.ImageFetcherService).Inc()
+	w.replyFailure(m, err)
+	return rabbitmq.DeadLetter
 }
 
-// processJob processes a single image job
-func (w *ImageWorker) processJob(msg amqp.Delivery) {
-	start := time.Now()
+// runJob is processJob that turns a panic, such as a nil dereference hit by
+// one odd image, into an errPanicked failure of that job alone
+func (w *ImageWorker) runJob(ctx context.Context, m amqp.Delivery) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		stack := debug.Stack()
+		if p, ok := r.(transformPanic); ok {
+			r, stack = p.value, p.stack
+		}
+		log.Printf("Job [%s] panicked, dead-lettering it: %v\n%s", message.TraceID(m.Body), r, stack)
+		err = fmt.Errorf("%w: %v", errPanicked, r)
+	}()
+	return w.processJob(ctx, m)
+}
 
-	env, job, err := message.Decode[models.ImageJob](msg.Body)
+// requeueForRetry republishes a failed job to its lane's delay queue with its
+// attempt header incremented. It reports false when the job should be dead-lettered
+// instead.
+func (w *ImageWorker) requeueForRetry(m amqp.Delivery, jobErr error) bool {
+	if !isRetryable(jobErr) {
+		return false
+	}
+	attempt := message.Attempt(m.Headers)
+	if attempt >= w.config.Worker.MaxRetries {
+		log.Printf("Job failed after %d retries, dead-lettering: %v", attempt, jobErr)
+		return false
+	}
+
+	backoff := w.config.Worker.RetryBackoff << attempt
+	pub := rabbitmq.Republish(m, attempt+1)
+	markPublished(pub.Headers, jobErr)
+	pub.Expiration = strconv.FormatInt(backoff.Milliseconds(), 10)
+	if err := w.channel.Publish("", rabbitmq.DelayedQueue(laneQueue(w.config.RabbitMQ, m)), false, false, pub); err != nil {
+		log.Printf("Failed to requeue job for retry: %v", err)
+		return false
+	}
+
+	log.Printf("Requeued job for attempt %d in %s", attempt+1, backoff)
+	middleware.JobRetries.WithLabelValues(config.ImageFetcherService).Inc()
+	return true
+}
+
+// isRetryable reports whether a job failure may succeed on another attempt
+func isRetryable(err error) bool {
+	return !errors.Is(err, errInvalidJob) &&
+		!errors.Is(err, errPanicked) &&
+		!errors.Is(err, processor.ErrPermanent) &&
+		!errors.Is(err, storage.ErrObjectExists)
+}
+
+// replyAddress returns where a job's results should also be sent: the AMQP
+// reply_to and correlation_id properties, or the envelope's copy of them
+func replyAddress(msg amqp.Delivery, env *message.Envelope) message.Reply {
+	if msg.ReplyTo != "" {
+		return message.Reply{To: msg.ReplyTo, CorrelationID: msg.CorrelationId}
+	}
+	return env.Reply
+}
+
+// replyFailure tells a waiting submitter that its job failed for good, so it
+// doesn't wait until its timeout
+func (w *ImageWorker) replyFailure(m amqp.Delivery, jobErr error) {
+	env, job, err := message.Decode[models.ImageJob](m.Body)
 	if err != nil {
-		log.Printf("Failed to decode job: %v", err)
-		middleware.JobsProcessed.WithLabelValues("decode_error", "image-fetcher").Inc()
 		return
 	}
+	reply := replyAddress(m, env)
+	if reply.To == "" {
+		return
+	}
+	result := models.ImageProcessedPayload{
+		Status:         "error",
+		ErrorMsg:       jobErr.Error(),
+		TraceID:        env.TraceID,
+		ProcessingType: strings.Join(job.ProcessingTypes, ","),
+	}
+	if len(job.URLs) > 0 {
+		result.SourceURL = job.URLs[0]
+	}
+	w.reply(context.Background(), reply, env.TraceID, result, time.Now().UTC())
+}
 
-	// Extract trace context from AMQP headers
-	prop := propagation.TraceContext{}
-	headers := make(map[string]string)
-	for k, v := range msg.Headers {
-		if s, ok := v.(string); ok {
-			headers[k] = s
-		} else if b, ok := v.([]byte); ok {
-			headers[k] = string(b)
-		}
+// reply publishes a result to a submitter's reply queue. Failures are only
+// logged: the submitter times out and the result queue still has the result.
+func (w *ImageWorker) reply(ctx context.Context, reply message.Reply, traceID string, result models.ImageProcessedPayload, submittedAt time.Time) {
+	opts := rabbitmq.PublishOptions{SubmittedAt: submittedAt, CorrelationID: reply.CorrelationID}
+	err := w.publisher.PublishWith(ctx, "", reply.To, traceID, config.ImageFetcherService, result, opts)
+	if err != nil {
+		log.Printf("Failed to reply to %s: %v", reply.To, err)
 	}
-	if tp, ok := headers["traceparent"]; ok {
-		log.Printf("[fetcher] Consumed traceparent: %s", tp)
+}
+
+// failureReason classifies a job failure for the dead-letter metric
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, errUnsupportedType):
+		return "unsupported_type"
+	case errors.Is(err, errPanicked):
+		return "panic"
+	case errors.Is(err, errInvalidJob):
+		return "invalid_job"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, processor.ErrUndecodable), errors.Is(err, processor.ErrFormatNotAllowed):
+		return "decode_error"
+	case errors.Is(err, errDownload):
+		return "download_error"
+	case errors.Is(err, errUpload):
+		return "upload_error"
+	default:
+		return "other"
+	}
+}
+
+// processJob processes a single image job in the trace propagated to ctx. A
+// returned error means the job failed and should be dead-lettered.
+func (w *ImageWorker) processJob(ctx context.Context, msg amqp.Delivery) error {
+	start := time.Now()
+
+	env, job, err := message.Decode[models.ImageJob](msg.Body)
+	if err != nil {
+		log.Printf("Failed to decode job: %v", err)
+		middleware.JobsProcessed.WithLabelValues("decode_error", config.ImageFetcherService).Inc()
+		return fmt.Errorf("%w: %w", errInvalidJob, err)
 	}
-	ctx := context.Background()
-	ctx = prop.Extract(ctx, propagation.MapCarrier(headers))
 
 	tracer := otel.Tracer("worker")
 	ctx, span := tracer.Start(ctx, "processJob", trace.WithSpanKind(trace.SpanKindConsumer))
+	defer span.End()
+
+	// Each job contains a single URL and one or more processing types
+	if len(job.URLs) == 0 || len(job.ProcessingTypes) == 0 {
+		err := fmt.Errorf("%w: missing URL or processing type", errInvalidJob)
+		log.Printf("Invalid job [%s]: %v", env.TraceID, err)
+		span.SetAttributes(attribute.String("trace_id", env.TraceID), attribute.String("status", "error"))
+		span.RecordError(err)
+		middleware.JobsProcessed.WithLabelValues("invalid_job", config.ImageFetcherService).Inc()
+		return err
+	}
+	url := job.URLs[0]
+
+	// Owners only read object sources under their own prefix
+	if _, key, ok := config.ParseObjectSource(url); ok && !config.ObjectKeyOwned(key, jobOwner(env, job)) {
+		err := fmt.Errorf("%w: %s is outside owner %s's prefix", errInvalidJob, url, jobOwner(env, job))
+		log.Printf("Invalid job [%s]: %v", env.TraceID, err)
+		span.SetAttributes(attribute.String("trace_id", env.TraceID), attribute.String("status", "error"))
+		span.RecordError(err)
+		middleware.JobsProcessed.WithLabelValues("invalid_job", config.ImageFetcherService).Inc()
+		return err
+	}
+
+	tasks := jobTasks(env, job, w.defaultParams)
+	reply := replyAddress(msg, env)
+	for i := range tasks {
+		tasks[i].Reply = reply
+		if tasks[i] = clampTask(tasks[i], w.paramLimits); tasks[i].Clamped {
+			log.Printf("Clamped %s params of %s to the limits (sigma %g, dimension %d) [%s]", tasks[i].ProcessingType, url, w.paramLimits.MaxSigma, w.paramLimits.MaxDimension, env.TraceID)
+			middleware.ParamsClamped.WithLabelValues(tasks[i].ProcessingType, config.ImageFetcherService).Inc()
+		}
+	}
+	processingType := tasksLabel(tasks)
+
 	span.SetAttributes(
 		attribute.String("trace_id", env.TraceID),
-		attribute.String("processing_type", job.ProcessingTypes[0]),
-		attribute.String("source_url", job.URLs[0]),
+		attribute.String("processing_type", processingType),
+		attribute.Int("outputs", len(tasks)),
+		attribute.String("source_url", url),
 		attribute.String("messaging.system", "rabbitmq"),
-		attribute.String("messaging.destination.name", "image.urls"),
+		attribute.String("messaging.destination.name", laneQueue(w.config.RabbitMQ, msg)),
 		attribute.String("messaging.operation", "process"),
 	)
-	defer span.End()
-
-	successCount := 0
-	errorCount := 0
 
-	// Each job now contains a single URL and a single processing type
-	if len(job.URLs) == 0 || len(job.ProcessingTypes) == 0 {
-		log.Printf("Job missing URL or processing type")
-		return
+	// Cancelled jobs are acknowledged without processing
+	if w.isCancelled(ctx, env.TraceID, jobOwner(env, job)) {
+		log.Printf("Skipping cancelled job %s [%s]", url, env.TraceID)
+		span.SetAttributes(attribute.String("status", "cancelled"))
+		middleware.JobsProcessed.WithLabelValues("cancelled", config.ImageFetcherService).Inc()
+		return nil
 	}
-	url := job.URLs[0]
-	processingType := job.ProcessingTypes[0]
 
-	if err := w.processImage(ctx, url, processingType, env.TraceID); err != nil {
+	// Bound the whole job so a pathological image can't hold a worker slot forever
+	jobCtx, cancel := context.WithTimeout(ctx, w.config.Worker.JobTimeout)
+	defer cancel()
+
+	err = w.processImage(jobCtx, tasks, publishedOutputs(msg.Headers))
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("job timed out after %s: %w", w.config.Worker.JobTimeout, err)
+			middleware.JobTimeouts.WithLabelValues(config.ImageFetcherService).Inc()
+		}
 		log.Printf("Failed to process image %s [%s]: %v", url, processingType, err)
-		errorCount++
 		span.SetAttributes(attribute.String("status", "error"))
 		span.RecordError(err)
+		middleware.ImagesProcessed.WithLabelValues("error", config.ImageFetcherService).Inc()
 	} else {
-		successCount++
 		span.SetAttributes(attribute.String("status", "success"))
+		middleware.ImagesProcessed.WithLabelValues("success", config.ImageFetcherService).Add(float64(len(tasks)))
 	}
 
-	// Record metrics
-	middleware.ImagesProcessed.WithLabelValues("success", "image-fetcher").Add(float64(successCount))
-	middleware.ImagesProcessed.WithLabelValues("error", "image-fetcher").Add(float64(errorCount))
-	middleware.JobProcessingDuration.WithLabelValues("image-fetcher").Observe(time.Since(start).Seconds())
+	middleware.JobProcessingDuration.WithLabelValues(config.ImageFetcherService).Observe(time.Since(start).Seconds())
+	return err
 }
 
-// processImage processes a single image with the given processing type
-func (w *ImageWorker) processImage(ctx context.Context, url, processingType, traceID string) error {
-	// Download image
-	downloadStart := time.Now()
-	img, format, err := w.processor.DownloadImage(ctx, url)
+// isCancelled checks whether the job's trace ID was cancelled for its owner.
+// Jobs without a trace ID can't be cancelled, and lookup failures let the job
+// run.
+func (w *ImageWorker) isCancelled(ctx context.Context, traceID, ownerID string) bool {
+	if w.cancellations == nil || traceID == "" {
+		return false
+	}
+	cancelled, err := w.cancellations.IsCancelled(ctx, traceID, ownerID)
+	if err != nil {
+		log.Printf("Failed to check cancellation for %s: %v", traceID, err)
+		return false
+	}
+	return cancelled
+}
+
+// jobOwner is the owner of a job, falling back to its envelope's
+func jobOwner(env *message.Envelope, job *models.ImageJob) string {
+	if job.OwnerID != "" {
+		return job.OwnerID
+	}
+	return env.OwnerID
+}
+
+// jobTasks expands a job into the outputs to produce from its URL, one per
+// processing type. Resize produces one output per preset when presets are
+// given. Types the job gives no params use defaults' instead.
+func jobTasks(env *message.Envelope, job *models.ImageJob, defaults map[string]models.ProcessingParams) []imageTask {
+	base := imageTask{URL: job.URLs[0], TraceID: env.TraceID, Format: job.Format, Bucket: job.Bucket, SkipExisting: job.SkipExisting, Original: job.Original, OwnerID: jobOwner(env, job)}
+	if env.SubmittedAt != nil {
+		base.SubmittedAt = *env.SubmittedAt
+	}
+
+	var tasks []imageTask
+	for _, t := range job.ProcessingTypes {
+		task := base
+		task.ProcessingType = models.NormalizeProcessingType(t)
+		params, ok := job.Params[task.ProcessingType]
+		if !ok {
+			params = defaults[task.ProcessingType]
+		}
+		task.Params = params
+		if task.ProcessingType == "resize" && len(job.Resize) > 0 {
+			for i := range job.Resize {
+				task.Preset = &job.Resize[i]
+				tasks = append(tasks, task)
+			}
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// clampTask lowers a task's sigma and resize dimensions to limits, so a job
+// that got past submit validation can't tie up a worker. Width and height
+// are scaled together, keeping the requested aspect ratio.
+func clampTask(task imageTask, limits config.ParamLimitsConfig) imageTask {
+	if task.Params.Sigma > limits.MaxSigma {
+		task.Params.Sigma = limits.MaxSigma
+		task.Clamped = true
+	}
+	if task.ProcessingType != "resize" {
+		return task
+	}
+	if w, h, ok := clampDimensions(task.Params.Width, task.Params.Height, limits.MaxDimension); ok {
+		task.Params.Width, task.Params.Height = w, h
+		task.Clamped = true
+	}
+	if task.Preset != nil {
+		if w, h, ok := clampDimensions(task.Preset.Width, task.Preset.Height, limits.MaxDimension); ok {
+			// Presets are shared by the job's tasks, so clamp a copy
+			preset := *task.Preset
+			preset.Width, preset.Height = w, h
+			task.Preset = &preset
+			task.Clamped = true
+		}
+	}
+	return task
+}
+
+// clampDimensions scales width and height down together until neither
+// exceeds limit, reporting whether they had to be
+func clampDimensions(width, height, limit int) (int, int, bool) {
+	longest := max(width, height)
+	if longest <= limit {
+		return width, height, false
+	}
+	scale := func(n int) int {
+		if n == 0 {
+			return 0
+		}
+		return max(1, int(math.Round(float64(n)*float64(limit)/float64(longest))))
+	}
+	return scale(width), scale(height), true
+}
+
+// appliedParams returns the params a task's output was produced with, its
+// preset's size for preset resizes, or nil when it had none
+func appliedParams(task imageTask) *models.ProcessingParams {
+	if task.Preset != nil {
+		return &models.ProcessingParams{Width: task.Preset.Width, Height: task.Preset.Height}
+	}
+	if task.Params == (models.ProcessingParams{}) {
+		return nil
+	}
+	params := task.Params
+	return &params
+}
+
+// tasksLabel names a job's processing for logs, spans and step metrics:
+// the processing type of a single-output job, "multi" otherwise
+func tasksLabel(tasks []imageTask) string {
+	if len(tasks) == 1 {
+		return tasks[0].ProcessingType
+	}
+	return "multi"
+}
+
+// processImage downloads the tasks' source image once and produces each
+// output in order, stopping at the first failure. All tasks share a URL.
+// Outputs in published were published by an earlier run of the job and are
+// skipped. With WORKER_ATOMIC_OUTPUTS the results are only published once
+// every output is stored, and a failure removes the outputs already stored.
+func (w *ImageWorker) processImage(ctx context.Context, tasks []imageTask, published map[string]bool) error {
+	// Reject unsupported types before spending a download on them
+	for _, task := range tasks {
+		if _, err := w.transformFor(task); err != nil {
+			return err
+		}
+	}
+	store, err := storage.ForBucket(w.storage, tasks[0].Bucket)
 	if err != nil {
-		middleware.ProcessingDuration.WithLabelValues("download", "image-fetcher").Observe(time.Since(downloadStart).Seconds())
+		return fmt.Errorf("%w: %w", errInvalidJob, err)
+	}
+
+	batch := w.newOutputBatch()
+	if err := w.produceOutputs(ctx, store, tasks, published, batch); err != nil {
+		batch.discard(ctx, store)
+		return batch.failed(err)
+	}
+	return batch.failed(w.publishBatch(ctx, store, batch))
+}
+
+// produceOutputs downloads a job's source image and produces its outputs,
+// publishing each result as it goes or holding them in batch
+func (w *ImageWorker) produceOutputs(ctx context.Context, store storage.Storage, tasks []imageTask, published map[string]bool, batch *outputBatch) error {
+	// Outputs that are already published or stored don't need the download
+	// at all
+	tasks = withoutPublished(tasks, published)
+	tasks, err := w.skipExisting(ctx, store, tasks, batch)
+	if err != nil || len(tasks) == 0 {
 		return err
 	}
-	middleware.ProcessingDuration.WithLabelValues("download", "image-fetcher").Observe(time.Since(downloadStart).Seconds())
+
+	// Download image, or read back its stored original
+	downloadStart := time.Now()
+	img, format, err := w.fetchSource(ctx, tasks[0])
+	observeStep("download", tasksLabel(tasks), downloadStart)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errDownload, err)
+	}
+	// Formats missing from the storage table keep the decoder's name rather
+	// than being reported as something they aren't
+	if known := storage.NormalizeFormat(format); known != "" {
+		format = known
+	}
+	recordSourceFormat(tasks[0].URL, format, img.Bounds())
+
+	for _, task := range withoutPublished(w.resolveAuto(tasks, img.Bounds()), published) {
+		transform, err := w.transformFor(task)
+		if err != nil {
+			return err
+		}
+		if err := w.produceOutput(ctx, store, task, img, format, transform, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchSource returns a task's source image: its stored original when the job
+// names one, otherwise downloaded from its URL. An original that is gone or
+// can't be read falls back to the download.
+func (w *ImageWorker) fetchSource(ctx context.Context, task imageTask) (image.Image, string, error) {
+	if task.Original != "" {
+		img, format, err := w.readOriginal(ctx, task)
+		if err == nil {
+			middleware.StoredOriginalReads.WithLabelValues("read", config.ImageFetcherService).Inc()
+			return img, format, nil
+		}
+		log.Printf("Stored original %s unavailable, downloading %s instead [%s]: %v", task.Original, task.URL, task.TraceID, err)
+		middleware.StoredOriginalReads.WithLabelValues("fallback", config.ImageFetcherService).Inc()
+	}
+	return w.downloader.DownloadImage(ctx, task.URL)
+}
+
+// readOriginal reads a task's stored original. Only the buckets the worker
+// stores to are read, so a job can't use it to reach other buckets.
+func (w *ImageWorker) readOriginal(ctx context.Context, task imageTask) (image.Image, string, error) {
+	reader, ok := w.downloader.(StoredImageReader)
+	if !ok {
+		return nil, "", errors.New("stored originals can't be read by this downloader")
+	}
+	bucket, key, ok := storage.ParseImageURL(task.Original)
+	if !ok {
+		return nil, "", fmt.Errorf("%q is not an s3:// location", task.Original)
+	}
+	if bucket != w.config.Minio.Bucket && bucket != task.Bucket {
+		return nil, "", fmt.Errorf("bucket %s isn't one outputs are stored in", bucket)
+	}
+	return reader.ReadStoredImage(ctx, bucket, key)
+}
+
+// outputKey returns the derived key a skip_existing task is stored under.
+// Tasks whose output isn't known before the download (auto) or isn't stored
+// (palette, blurhash) have none.
+func outputKey(task imageTask) (string, bool) {
+	if !task.SkipExisting || isMetadataOnly(task.ProcessingType) || task.ProcessingType == "auto" {
+		return "", false
+	}
+	variant, params := "", ""
+	switch {
+	case task.Preset != nil:
+		variant = task.Preset.Name
+		params = fmt.Sprintf("%dx%d", task.Preset.Width, task.Preset.Height)
+	case task.Params != (models.ProcessingParams{}):
+		p := task.Params
+		params = fmt.Sprintf("%dx%d,%g", p.Width, p.Height, p.Sigma)
+		if p.Format != "" {
+			params += "," + p.Format
+		}
+		if p.MaxBytes != 0 {
+			params += fmt.Sprintf(",%d,%t", p.MaxBytes, p.Downscale)
+		}
+	}
+	return storage.DerivedKey(task.OwnerID, task.URL, task.ProcessingType, variant, params, outputFormat(task)), true
+}
+
+// outputFormat returns the format a task's output is encoded in: convert's
+// target format, JPEG for compress_to, or the job's format for every other
+// type
+func outputFormat(task imageTask) string {
+	switch task.ProcessingType {
+	case "convert":
+		return task.Params.Format
+	case "compress_to":
+		return storage.FormatJPEG
+	}
+	return task.Format
+}
+
+// skipExisting emits a skipped result for each task whose derived key is
+// already stored and returns the tasks that still need producing
+func (w *ImageWorker) skipExisting(ctx context.Context, store storage.Storage, tasks []imageTask, batch *outputBatch) ([]imageTask, error) {
+	remaining := tasks[:0:0]
+	for _, task := range tasks {
+		key, ok := outputKey(task)
+		if !ok {
+			remaining = append(remaining, task)
+			continue
+		}
+		exists, err := store.ObjectExists(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errUpload, err)
+		}
+		if !exists {
+			remaining = append(remaining, task)
+			continue
+		}
+
+		fileSize, err := store.GetFileSize(ctx, key)
+		if err != nil {
+			log.Printf("Failed to get file size for %s: %v", key, err)
+		}
+		preset := ""
+		if task.Preset != nil {
+			preset = task.Preset.Name
+		}
+		result := models.ImageProcessedPayload{
+			SourceURL:      task.URL,
+			S3Path:         store.GetImageURL(key),
+			Status:         "success",
+			TraceID:        task.TraceID,
+			FileSize:       fileSize,
+			ProcessingType: task.ProcessingType,
+			Preset:         preset,
+			Params:         appliedParams(task),
+			Clamped:        task.Clamped,
+			Skipped:        true,
+		}
+		if err := w.emitResult(ctx, batch, task, result); err != nil {
+			return nil, err
+		}
+		middleware.OutputsSkipped.WithLabelValues(task.ProcessingType, config.ImageFetcherService).Inc()
+		log.Printf("Skipped existing output: %s [%s%s] -> %s", task.URL, task.ProcessingType, presetSuffix(preset), result.S3Path)
+	}
+	return remaining, nil
+}
+
+// resolveAuto replaces auto tasks with the resize outputs of the first rule
+// the image's longest side reaches. Rules without presets, and images no rule
+// matches, produce nothing beyond the stored original.
+func (w *ImageWorker) resolveAuto(tasks []imageTask, bounds image.Rectangle) []imageTask {
+	longest := max(bounds.Dx(), bounds.Dy())
+	resolved := make([]imageTask, 0, len(tasks))
+	for _, task := range tasks {
+		if task.ProcessingType != "auto" {
+			resolved = append(resolved, task)
+			continue
+		}
+		for _, rule := range w.autoRules {
+			if longest < rule.MinSide {
+				continue
+			}
+			for i := range rule.Presets {
+				derived := task
+				derived.ProcessingType = "resize"
+				derived.Preset = &rule.Presets[i]
+				resolved = append(resolved, derived)
+			}
+			break
+		}
+	}
+	return resolved
+}
+
+// transformFor returns the transform for a task's processing type. Palette
+// and blurhash tasks have no transform since they don't store an image.
+func (w *ImageWorker) transformFor(task imageTask) (func(image.Image) image.Image, error) {
+	switch task.ProcessingType {
+	case "original":
+		return func(img image.Image) image.Image { return img }, nil // store as-is
+	case "grayscale":
+		return w.transformer.Grayscale, nil
+	case "resize":
+		width, height := 100, 100
+		if task.Params.Width != 0 || task.Params.Height != 0 {
+			width, height = task.Params.Width, task.Params.Height
+		}
+		if task.Preset != nil {
+			width, height = task.Preset.Width, task.Preset.Height
+		}
+		return func(img image.Image) image.Image { return w.transformer.Resize(img, width, height) }, nil
+	case "blur":
+		sigma := sigmaOr(task.Params, 2.0)
+		return func(img image.Image) image.Image { return w.transformer.Blur(img, sigma) }, nil
+	case "sharpen":
+		sigma := sigmaOr(task.Params, 2.0)
+		return func(img image.Image) image.Image { return w.transformer.Sharpen(img, sigma) }, nil
+	case "convert":
+		if task.Params.Format == "" {
+			return nil, fmt.Errorf("%w: convert needs a target format", errInvalidJob)
+		}
+		// Unlike the job-wide format, an explicit conversion doesn't fall
+		// back to JPEG
+		if !storage.EncoderAvailable(task.Params.Format) {
+			return nil, fmt.Errorf("%w: convert to %s: %w", errInvalidJob, task.Params.Format, storage.ErrEncoderUnavailable)
+		}
+		return func(img image.Image) image.Image { return img }, nil // re-encoded on upload
+	case "compress_to":
+		if task.Params.MaxBytes <= 0 {
+			return nil, fmt.Errorf("%w: compress_to needs a positive max_bytes", errInvalidJob)
+		}
+		return func(img image.Image) image.Image { return img }, nil // fitted in produceOutput
+	case "palette", "blurhash":
+		return nil, nil
+	case "auto":
+		return nil, nil // resolved into resize tasks once the image size is known
+	default:
+		return nil, fmt.Errorf("%w: %w: %s", errInvalidJob, errUnsupportedType, task.ProcessingType)
+	}
+}
+
+// isMetadataOnly reports whether a processing type only reports metadata
+// about the image instead of storing one
+func isMetadataOnly(processingType string) bool {
+	return processingType == "palette" || processingType == "blurhash"
+}
+
+// recordSourceFormat counts a decoded source image by format, so dashboards
+// show the mix of formats actually received
+func recordSourceFormat(url, format string, bounds image.Rectangle) {
+	label := format
+	if label == "" {
+		label = "unknown"
+	}
+	middleware.SourceFormats.WithLabelValues(label, config.ImageFetcherService).Inc()
+	log.Printf("Decoded %s source %s (%dx%d)", label, url, bounds.Dx(), bounds.Dy())
+}
+
+// sigmaOr returns the sigma params set, or def when they set none
+func sigmaOr(params models.ProcessingParams, def float64) float64 {
+	if params.Sigma > 0 {
+		return params.Sigma
+	}
+	return def
+}
+
+// produceOutput applies one task's transform to the downloaded image, stores
+// the result in store and emits its metadata
+func (w *ImageWorker) produceOutput(ctx context.Context, store storage.Storage, task imageTask, img image.Image, format string, transform func(image.Image) image.Image, batch *outputBatch) error {
+	url, processingType, traceID := task.URL, task.ProcessingType, task.TraceID
 
 	// Extract image dimensions
 	width := 0
@@ -198,40 +890,69 @@ func (w *ImageWorker) processImage(ctx context.Context, url, processingType, tra
 		height = img.Bounds().Dy()
 	}
 
-	// Process image according to processingType
+	// Palette and blurhash jobs only report metadata, so nothing is stored
+	if isMetadataOnly(processingType) {
+		result := models.ImageProcessedPayload{
+			SourceURL:      url,
+			Status:         "success",
+			TraceID:        traceID,
+			Width:          width,
+			Height:         height,
+			Format:         format,
+			ProcessingType: processingType,
+		}
+		if processingType == "palette" {
+			palette := w.transformer.DominantColors(img, w.config.Worker.PaletteSize)
+			result.Palette = make([]string, len(palette))
+			for i, c := range palette {
+				result.Palette[i] = processor.HexColor(c)
+			}
+		} else {
+			result.BlurHash = w.transformer.BlurHash(img)
+		}
+		return w.emitResult(ctx, batch, task, result)
+	}
+
+	// compress_to searches for the quality (and size) that fits its target
+	// as part of the transform, so the encodes count against the CPU slots
+	var fit storage.Fit
+	var fitErr error
+	if processingType == "compress_to" {
+		transform = func(img image.Image) image.Image {
+			fit, fitErr = storage.FitJPEG(img, task.Params.MaxBytes, task.Params.Downscale, w.config.Minio.Progressive, w.transformer.Resize)
+			return fit.Image
+		}
+	}
+
 	processStart := time.Now()
-	var processedImg image.Image
-	switch processingType {
-	case "original":
-		processedImg = img // store as-is
-		middleware.ProcessingDuration.WithLabelValues("original", "image-fetcher").Observe(time.Since(processStart).Seconds())
-	case "grayscale":
-		processedImg = w.processor.Grayscale(img)
-		middleware.ProcessingDuration.WithLabelValues("grayscale", "image-fetcher").Observe(time.Since(processStart).Seconds())
-	case "resize":
-		processedImg = w.processor.Resize(img, 100, 100)
-		middleware.ProcessingDuration.WithLabelValues("resize", "image-fetcher").Observe(time.Since(processStart).Seconds())
-	case "blur":
-		processedImg = w.processor.Blur(img, 2.0)
-		middleware.ProcessingDuration.WithLabelValues("blur", "image-fetcher").Observe(time.Since(processStart).Seconds())
-	case "sharpen":
-		processedImg = w.processor.Sharpen(img, 2.0)
-		middleware.ProcessingDuration.WithLabelValues("sharpen", "image-fetcher").Observe(time.Since(processStart).Seconds())
-	default:
-		return fmt.Errorf("unsupported processing type: %s", processingType)
+	processedImg, err := transformWithContext(ctx, w.cpuSlots, img, transform)
+	observeStep("transform", processingType, processStart)
+	if err != nil {
+		return err
+	}
+	if fitErr != nil {
+		return fmt.Errorf("%w: %w", errInvalidJob, fitErr)
 	}
 
-	// Upload to storage (pass processingType for filename)
+	// Upload to storage (processingType and preset name go into the filename)
+	preset := ""
+	if task.Preset != nil {
+		preset = task.Preset.Name
+	}
+	opts := storage.UploadOptions{Format: outputFormat(task), SourceURL: url, Quality: fit.Quality}
+	if key, ok := outputKey(task); ok {
+		opts.Key, opts.IfExists = key, storage.SkipIfExists
+	}
 	uploadStart := time.Now()
-	filename, err := w.storage.UploadImageWithType(ctx, processedImg, processingType)
+	filename, err := store.UploadImageWithType(ctx, processedImg, processingType, preset, opts)
+	observeStep("upload", processingType, uploadStart)
 	if err != nil {
-		middleware.ProcessingDuration.WithLabelValues("upload", "image-fetcher").Observe(time.Since(uploadStart).Seconds())
-		return err
+		return fmt.Errorf("%w: %w", errUpload, err)
 	}
-	middleware.ProcessingDuration.WithLabelValues("upload", "image-fetcher").Observe(time.Since(uploadStart).Seconds())
+	batch.track(task, filename)
 
-	// Get file size from MinIO
-	fileSize, err := w.storage.GetFileSize(ctx, filename)
+	// Get file size from storage
+	fileSize, err := store.GetFileSize(ctx, filename)
 	if err != nil {
 		log.Printf("Failed to get file size for %s: %v", filename, err)
 		fileSize = 0
@@ -240,7 +961,7 @@ func (w *ImageWorker) processImage(ctx context.Context, url, processingType, tra
 	// Create result payload
 	result := models.ImageProcessedPayload{
 		SourceURL:      url,
-		S3Path:         w.storage.GetImageURL(filename),
+		S3Path:         store.GetImageURL(filename),
 		Status:         "success",
 		TraceID:        traceID,
 		Width:          width,
@@ -248,47 +969,118 @@ func (w *ImageWorker) processImage(ctx context.Context, url, processingType, tra
 		Format:         format,
 		FileSize:       fileSize,
 		ProcessingType: processingType,
+		Preset:         preset,
+		Params:         appliedParams(task),
+		Clamped:        task.Clamped,
 	}
-
-	// Publish result
-	encoded, err := message.Encode(traceID, "image-fetcher", result)
-	if err != nil {
+	if fit.Image != nil {
+		result.Quality = fit.Quality
+		result.OutputWidth, result.OutputHeight = processedImg.Bounds().Dx(), processedImg.Bounds().Dy()
+	}
+	if err := w.emitResult(ctx, batch, task, result); err != nil {
 		return err
 	}
 
+	log.Printf("Successfully processed image: %s [%s%s] -> %s", url, processingType, presetSuffix(preset), result.S3Path)
+	return nil
+}
+
+// publishResult sends a processed image's metadata to the result queue
+func (w *ImageWorker) publishResult(ctx context.Context, task imageTask, result models.ImageProcessedPayload) error {
 	// Start a child span for publishing
 	tracer := otel.Tracer("worker")
 	pubCtx, pubSpan := tracer.Start(ctx, "PublishResult", trace.WithSpanKind(trace.SpanKindProducer))
 	pubSpan.SetAttributes(
 		attribute.String("messaging.system", "rabbitmq"),
-		attribute.String("messaging.destination.name", "image.processed"),
+		attribute.String("messaging.destination.name", w.config.RabbitMQ.ResultQueue),
 		attribute.String("messaging.operation", "send"),
 	)
 	defer pubSpan.End()
 
-	// Inject trace context into headers
-	prop := propagation.TraceContext{}
-	headers := make(map[string]string)
-	prop.Inject(pubCtx, propagation.MapCarrier(headers))
-	if tp, ok := headers["traceparent"]; ok {
-		log.Printf("[fetcher] Injecting traceparent: %s", tp)
-	}
-
-	amqpHeaders := amqp.Table{}
-	for k, v := range headers {
-		amqpHeaders[k] = v
-	}
-
-	err = w.channel.Publish("", "image.processed", false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        encoded,
-		Headers:     amqpHeaders,
-	})
+	opts := rabbitmq.PublishOptions{SubmittedAt: task.SubmittedAt, OwnerID: task.OwnerID}
+	err := w.publisher.PublishWith(pubCtx, "", w.config.RabbitMQ.ResultQueue, task.TraceID, config.ImageFetcherService, result, opts)
 	if err != nil {
 		pubSpan.RecordError(err)
 		return err
 	}
 
-	log.Printf("Successfully processed image: %s [%s] -> %s", url, processingType, result.S3Path)
+	if task.Reply.To != "" {
+		w.reply(pubCtx, task.Reply, task.TraceID, result, task.SubmittedAt)
+	}
 	return nil
 }
+
+// transformPanic carries a panic out of a transform's goroutine along with
+// the stack it was raised on
+type transformPanic struct {
+	value any
+	stack []byte
+}
+
+// transformWithContext runs a CPU-bound transform under one of slots and
+// returns early once ctx is done. The imaging operations can't be
+// interrupted, so an abandoned transform finishes in the background, holding
+// its slot, but its result is discarded and the job is released. Holding the
+// slot bounds abandoned transforms to cap(slots); the abandoned_transforms
+// gauge counts the ones still running.
+func transformWithContext(ctx context.Context, slots chan struct{}, img image.Image, fn func(image.Image) image.Image) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// abandoned is set once by whichever of the job and the transform
+	// finishes first, so the gauge is only decremented for an increment
+	var abandoned atomic.Bool
+	gauge := middleware.AbandonedTransforms.WithLabelValues(config.ImageFetcherService)
+	done := make(chan image.Image, 1)
+	panicked := make(chan transformPanic, 1)
+	go func() {
+		defer func() {
+			if !abandoned.CompareAndSwap(false, true) {
+				gauge.Dec()
+			}
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				<-slots
+				panicked <- transformPanic{value: r, stack: debug.Stack()}
+			}
+		}()
+		out := fn(img)
+		<-slots
+		done <- out
+	}()
+
+	select {
+	case out := <-done:
+		return out, nil
+	case p := <-panicked:
+		// Raise it again on the job's goroutine, where runJob recovers it
+		panic(p)
+	case <-ctx.Done():
+		gauge.Inc()
+		if !abandoned.CompareAndSwap(false, true) {
+			// The transform finished in the meantime
+			gauge.Dec()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// observeStep records how long a pipeline step took for a processing type
+func observeStep(step, processingType string, start time.Time) {
+	middleware.ProcessingDuration.WithLabelValues(step, processingType, config.ImageFetcherService).Observe(time.Since(start).Seconds())
+}
+
+// presetSuffix formats a preset name for log output
+func presetSuffix(preset string) string {
+	if preset == "" {
+		return ""
+	}
+	return "/" + preset
+}
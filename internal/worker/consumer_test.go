@@ -0,0 +1,1384 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/middleware"
+	"image-processing-system/internal/models"
+	"image-processing-system/internal/service/processor"
+	"image-processing-system/internal/service/storage"
+	"image-processing-system/pkg/message"
+	"image-processing-system/pkg/rabbitmq"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a TracerProvider that records spans for the rest of
+// the test, restoring the previous provider afterwards
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}
+
+func TestProcessJobWithEmptySlices(t *testing.T) {
+	recorder := recordSpans(t)
+
+	jobs := map[string]models.ImageJob{
+		"no urls":             {ProcessingTypes: []string{"grayscale"}},
+		"no processing types": {URLs: []string{"http://example.com/image.jpg"}},
+		"empty":               {},
+	}
+
+	for name, job := range jobs {
+		t.Run(name, func(t *testing.T) {
+			body, err := message.Encode("test-trace", "test", job)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			invalidJobs := middleware.JobsProcessed.WithLabelValues("invalid_job", "image-fetcher")
+			before := testutil.ToFloat64(invalidJobs)
+
+			w := &ImageWorker{}
+			if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err == nil {
+				t.Error("expected an error for an invalid job")
+			}
+
+			if got := testutil.ToFloat64(invalidJobs) - before; got != 1 {
+				t.Errorf("expected invalid_job counter to increase by 1, got %v", got)
+			}
+
+			spans := recorder.Ended()
+			if len(spans) == 0 {
+				t.Fatal("expected a processJob span to be recorded")
+			}
+			span := spans[len(spans)-1]
+			if len(span.Events()) == 0 || span.Events()[0].Name != "exception" {
+				t.Errorf("expected the span to record an error, got events %v", span.Events())
+			}
+		})
+	}
+}
+
+// fakeCancellations marks a fixed set of trace IDs as cancelled for an owner,
+// or for everyone under the empty owner
+type fakeCancellations map[[2]string]bool
+
+func (f fakeCancellations) IsCancelled(ctx context.Context, traceID, ownerID string) (bool, error) {
+	return f[[2]string{traceID, ""}] || f[[2]string{traceID, ownerID}], nil
+}
+
+func TestProcessJobSkipsCancelledJobs(t *testing.T) {
+	body, err := message.Encode("cancelled-trace", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.jpg"},
+		ProcessingTypes: []string{"grayscale"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancelledJobs := middleware.JobsProcessed.WithLabelValues("cancelled", "image-fetcher")
+	before := testutil.ToFloat64(cancelledJobs)
+
+	w := &ImageWorker{
+		config:        &config.ImageFetcherConfig{},
+		cancellations: fakeCancellations{{"cancelled-trace", ""}: true},
+	}
+	// No processor or storage is set, so reaching processing would panic
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Errorf("expected cancelled job to be acknowledged, got %v", err)
+	}
+
+	if got := testutil.ToFloat64(cancelledJobs) - before; got != 1 {
+		t.Errorf("expected cancelled counter to increase by 1, got %v", got)
+	}
+}
+
+func TestProcessJobOnlySkipsTheCancellingOwnersJobs(t *testing.T) {
+	for owner, wantSkipped := range map[string]bool{"globex": true, "acme": false, "": false} {
+		t.Run(owner, func(t *testing.T) {
+			body, err := message.Encode("shared-trace", "test", models.ImageJob{
+				URLs:            []string{"http://example.com/image.jpg"},
+				ProcessingTypes: []string{"grayscale"},
+				OwnerID:         owner,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 4, 4))})
+			w.cancellations = fakeCancellations{{"shared-trace", "globex"}: true}
+
+			if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+				t.Fatal(err)
+			}
+			if skipped := len(ch.published) == 0; skipped != wantSkipped {
+				t.Errorf("expected skipped %v for owner %q, got %v", wantSkipped, owner, skipped)
+			}
+		})
+	}
+}
+
+// fakeDownloader serves a fixed image instead of fetching the URL
+type fakeDownloader struct {
+	img image.Image
+	err error
+}
+
+func (f fakeDownloader) DownloadImage(ctx context.Context, url string) (image.Image, string, error) {
+	return f.img, "png", f.err
+}
+
+// fakeChannel records publishes
+type fakeChannel struct {
+	mu        sync.Mutex
+	published []amqp.Publishing
+	keys      []string
+	// failOn makes the nth publish (from 1) fail; 0 never fails
+	failOn int
+	calls  int
+}
+
+func (f *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error { return nil }
+
+func (f *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls++; f.calls == f.failOn {
+		return errors.New("channel closed")
+	}
+	f.keys = append(f.keys, key)
+	f.published = append(f.published, msg)
+	return nil
+}
+
+// newTestWorker builds a worker with an in-memory source image, real
+// transforms and filesystem storage under a temp dir
+func newTestWorker(t *testing.T, downloader ImageDownloader) (*ImageWorker, *fakeChannel) {
+	t.Helper()
+	store, err := storage.NewFilesystemService(t.TempDir(), config.EncodingConfig{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ImageFetcherConfig{
+		RabbitMQ: config.RabbitMQConfig{JobQueue: "jobs", ResultQueue: "results"},
+		Worker:   config.WorkerConfig{JobTimeout: time.Minute},
+	}
+	ch := &fakeChannel{}
+	return NewImageWorker(cfg, ch, downloader, processor.NewImageProcessor(), store, nil), ch
+}
+
+func TestProcessJobPublishesResult(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 40, 20))})
+	decodedPNG := middleware.SourceFormats.WithLabelValues("png", "image-fetcher")
+	before := testutil.ToFloat64(decodedPNG)
+
+	body, err := message.Encode("trace-1", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"resize"},
+		Resize:          []models.ResizePreset{{Name: "thumb", Width: 10, Height: 5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("processJob failed: %v", err)
+	}
+
+	if len(ch.published) != 1 || ch.keys[0] != "results" {
+		t.Fatalf("expected one result on the result queue, got keys %v", ch.keys)
+	}
+	env, result, err := message.Decode[models.ImageProcessedPayload](ch.published[0].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.TraceID != "trace-1" || result.Status != "success" || result.ProcessingType != "resize" || result.Preset != "thumb" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.FileSize == 0 {
+		t.Error("expected the stored file size to be reported")
+	}
+	if got := testutil.ToFloat64(decodedPNG) - before; got != 1 {
+		t.Errorf("expected the png source to be counted once, got %v", got)
+	}
+	jobEnv, _, _ := message.Decode[models.ImageJob](body)
+	if env.SubmittedAt == nil || !env.SubmittedAt.Equal(*jobEnv.SubmittedAt) {
+		t.Errorf("expected the job's submit time to be carried forward, got %v", env.SubmittedAt)
+	}
+}
+
+func TestProcessJobDownloadFailure(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{err: errors.New("connection refused")})
+
+	body, err := message.Encode("trace-2", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"grayscale"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := middleware.ImagesProcessed.WithLabelValues("error", "image-fetcher")
+	before := testutil.ToFloat64(failed)
+
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err == nil {
+		t.Fatal("expected the download error to fail the job")
+	}
+	if len(ch.published) != 0 {
+		t.Errorf("expected no result to be published, got %d", len(ch.published))
+	}
+	if got := testutil.ToFloat64(failed) - before; got != 1 {
+		t.Errorf("expected error counter to increase by 1, got %v", got)
+	}
+}
+
+// fakeAcknowledger records how a delivery was settled
+type fakeAcknowledger struct {
+	acked, nacked bool
+}
+
+func (f *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	f.acked = true
+	return nil
+}
+
+func (f *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	f.nacked = true
+	return nil
+}
+
+func (f *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	f.nacked = true
+	return nil
+}
+
+func TestHandleDeliveryRetries(t *testing.T) {
+	body, err := message.Encode("trace-3", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"grayscale"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		err         error
+		attempt     int32
+		wantRequeue bool
+	}{
+		{"transient failure is requeued", errors.New("connection reset"), 0, true},
+		{"later attempt is requeued", errors.New("connection reset"), 2, true},
+		{"out of retries is dead-lettered", errors.New("connection reset"), 3, false},
+		{"permanent failure is dead-lettered", processor.ErrPermanent, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, ch := newTestWorker(t, fakeDownloader{err: tt.err})
+			w.config.Worker.MaxRetries = 3
+			w.config.Worker.RetryBackoff = time.Second
+
+			ack := &fakeAcknowledger{}
+			w.newConsumer().Handle(amqp.Delivery{
+				Acknowledger: ack,
+				Headers:      amqp.Table{message.AttemptHeader: tt.attempt},
+				Priority:     4,
+				Body:         body,
+			})
+
+			if !tt.wantRequeue {
+				if !ack.nacked || len(ch.published) != 0 {
+					t.Fatalf("expected the job to be dead-lettered, nacked=%v published=%d", ack.nacked, len(ch.published))
+				}
+				return
+			}
+
+			if !ack.acked || len(ch.published) != 1 {
+				t.Fatalf("expected the job to be requeued and acked, acked=%v published=%d", ack.acked, len(ch.published))
+			}
+			if ch.keys[0] != "jobs.delayed" {
+				t.Errorf("expected requeue to the delay queue, got %q", ch.keys[0])
+			}
+			pub := ch.published[0]
+			if got := message.Attempt(pub.Headers); got != int(tt.attempt)+1 {
+				t.Errorf("attempt header = %d, want %d", got, tt.attempt+1)
+			}
+			wantExpiration := strconv.FormatInt((time.Second << tt.attempt).Milliseconds(), 10)
+			if pub.Expiration != wantExpiration {
+				t.Errorf("expiration = %q, want %q", pub.Expiration, wantExpiration)
+			}
+			if pub.Priority != 4 {
+				t.Errorf("expected priority to be preserved, got %d", pub.Priority)
+			}
+		})
+	}
+}
+
+func TestHandleDeliveryRetriesThroughItsLane(t *testing.T) {
+	body, err := message.Encode("trace-lane", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"grayscale"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, ch := newTestWorker(t, fakeDownloader{err: errors.New("connection reset")})
+	w.config.Worker.MaxRetries = 3
+	w.config.RabbitMQ.Lanes = "image.urls.fast:5:3,image.urls.bulk:0:1"
+
+	w.newConsumer().Handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, RoutingKey: "image.urls.fast", Body: body})
+	if len(ch.keys) != 1 || ch.keys[0] != rabbitmq.DelayedQueue("image.urls.fast") {
+		t.Errorf("expected the retry to go through the fast lane's delay queue, got %v", ch.keys)
+	}
+}
+
+func TestProcessJobPalette(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			img.Set(x, y, color.RGBA{0, 128, 255, 255})
+		}
+	}
+	w, ch := newTestWorker(t, fakeDownloader{img: img})
+	w.config.Worker.PaletteSize = 3
+
+	body, err := message.Encode("trace-4", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"palette"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("processJob failed: %v", err)
+	}
+
+	if len(ch.published) != 1 {
+		t.Fatalf("expected one result, got %d", len(ch.published))
+	}
+	_, result, err := message.Decode[models.ImageProcessedPayload](ch.published[0].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.S3Path != "" || result.FileSize != 0 {
+		t.Errorf("expected no stored image for a palette job, got %+v", result)
+	}
+	if len(result.Palette) != 1 || result.Palette[0] != "#0080ff" {
+		t.Errorf("palette = %v, want [#0080ff]", result.Palette)
+	}
+}
+
+func TestProcessJobBlurHash(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	w, ch := newTestWorker(t, fakeDownloader{img: img})
+
+	body, err := message.Encode("trace-bh", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"blurhash"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("processJob failed: %v", err)
+	}
+
+	if len(ch.published) != 1 {
+		t.Fatalf("expected one result, got %d", len(ch.published))
+	}
+	_, result, err := message.Decode[models.ImageProcessedPayload](ch.published[0].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.S3Path != "" || result.FileSize != 0 {
+		t.Errorf("expected no stored image for a blurhash job, got %+v", result)
+	}
+	if want := processor.NewImageProcessor().BlurHash(img); result.BlurHash == "" || result.BlurHash != want {
+		t.Errorf("blurhash = %q, want %q", result.BlurHash, want)
+	}
+}
+
+func TestProcessJobConvert(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 16, 8))})
+
+	body, err := message.Encode("trace-convert", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.jpg"},
+		ProcessingTypes: []string{"convert"},
+		Params:          map[string]models.ProcessingParams{"convert": {Format: "png"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("processJob failed: %v", err)
+	}
+
+	if len(ch.published) != 1 {
+		t.Fatalf("expected one result, got %d", len(ch.published))
+	}
+	_, result, err := message.Decode[models.ImageProcessedPayload](ch.published[0].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(result.S3Path, ".png") || result.ProcessingType != "convert" {
+		t.Errorf("expected a png convert output, got %+v", result)
+	}
+
+	// Without a target format the job is rejected before downloading
+	body, err = message.Encode("trace-convert", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.jpg"},
+		ProcessingTypes: []string{"convert"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); !errors.Is(err, errInvalidJob) {
+		t.Errorf("expected errInvalidJob without a format, got %v", err)
+	}
+}
+
+func TestProcessJobCompressTo(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7919 % 251) // noise, so size tracks quality
+	}
+	w, ch := newTestWorker(t, fakeDownloader{img: img})
+
+	body, err := message.Encode("trace-compress", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"compress_to"},
+		Params:          map[string]models.ProcessingParams{"compress_to": {MaxBytes: 8000, Downscale: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("processJob failed: %v", err)
+	}
+
+	_, result, err := message.Decode[models.ImageProcessedPayload](ch.published[0].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FileSize == 0 || result.FileSize > 8000 || !strings.HasSuffix(result.S3Path, ".jpg") {
+		t.Errorf("expected a JPEG of at most 8000 bytes, got %d bytes at %s", result.FileSize, result.S3Path)
+	}
+	if result.Quality == 0 || result.OutputWidth == 0 || result.OutputWidth >= 300 || result.Width != 300 {
+		t.Errorf("expected the fitted quality and a downscaled size, got %+v", result)
+	}
+}
+
+func TestJobTasksDefaultParams(t *testing.T) {
+	defaults := map[string]models.ProcessingParams{
+		"resize": {Width: 256, Height: 256},
+		"blur":   {Sigma: 3},
+	}
+	job := &models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"resize", "Blur", "grayscale"},
+		Params:          map[string]models.ProcessingParams{"blur": {Sigma: 1}},
+	}
+
+	tasks := jobTasks(&message.Envelope{TraceID: "trace-defaults"}, job, defaults)
+	if len(tasks) != 3 {
+		t.Fatalf("expected 3 tasks, got %d", len(tasks))
+	}
+	if got := tasks[0].Params; got != defaults["resize"] {
+		t.Errorf("resize params = %+v, want the server default", got)
+	}
+	if got := tasks[1].Params; got.Sigma != 1 {
+		t.Errorf("blur params = %+v, want the job's sigma 1 to win", got)
+	}
+	if got := tasks[2].Params; got != (models.ProcessingParams{}) {
+		t.Errorf("grayscale params = %+v, want none", got)
+	}
+}
+
+// countingDownloader counts downloads of a fixed image
+type countingDownloader struct {
+	img       image.Image
+	downloads int
+}
+
+func (c *countingDownloader) DownloadImage(ctx context.Context, url string) (image.Image, string, error) {
+	c.downloads++
+	return c.img, "png", nil
+}
+
+func TestProcessJobMultipleOutputs(t *testing.T) {
+	downloader := &countingDownloader{img: image.NewRGBA(image.Rect(0, 0, 40, 20))}
+	w, ch := newTestWorker(t, downloader)
+
+	body, err := message.Encode("trace-5", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"original", "grayscale", "resize"},
+		Resize:          []models.ResizePreset{{Name: "sm", Width: 10}, {Name: "lg", Width: 30}},
+		Combine:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("processJob failed: %v", err)
+	}
+
+	if downloader.downloads != 1 {
+		t.Errorf("expected a single download, got %d", downloader.downloads)
+	}
+	var got []string
+	for _, pub := range ch.published {
+		_, result, err := message.Decode[models.ImageProcessedPayload](pub.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != "success" || result.S3Path == "" {
+			t.Errorf("unexpected result: %+v", result)
+		}
+		got = append(got, result.ProcessingType+presetSuffix(result.Preset))
+	}
+	if want := "original,grayscale,resize/sm,resize/lg"; strings.Join(got, ",") != want {
+		t.Errorf("outputs = %v, want %s", got, want)
+	}
+}
+
+func TestProcessJobClampsParams(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 40, 20))})
+	w.paramLimits = config.ParamLimitsConfig{MaxSigma: 5, MaxDimension: 20}
+
+	body, err := message.Encode("trace-clamp", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"blur", "sharpen", "resize"},
+		Params:          map[string]models.ProcessingParams{"blur": {Sigma: 10000}, "sharpen": {Sigma: 2}},
+		Resize:          []models.ResizePreset{{Name: "lg", Width: 100, Height: 50}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("processJob failed: %v", err)
+	}
+
+	want := map[string]struct {
+		params  models.ProcessingParams
+		clamped bool
+	}{
+		"blur":    {models.ProcessingParams{Sigma: 5}, true},
+		"sharpen": {models.ProcessingParams{Sigma: 2}, false},
+		"resize":  {models.ProcessingParams{Width: 20, Height: 10}, true},
+	}
+	if len(ch.published) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(ch.published))
+	}
+	for _, pub := range ch.published {
+		_, result, err := message.Decode[models.ImageProcessedPayload](pub.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := want[result.ProcessingType]
+		if result.Params == nil || *result.Params != w.params || result.Clamped != w.clamped {
+			t.Errorf("%s recorded params %+v clamped=%v, want %+v clamped=%v", result.ProcessingType, result.Params, result.Clamped, w.params, w.clamped)
+		}
+	}
+}
+
+// originalDownloader serves stored originals from objects, keyed by
+// bucket/key, and records which sources it was asked for
+type originalDownloader struct {
+	objects map[string]image.Image
+	fetched []string
+}
+
+func (o *originalDownloader) DownloadImage(ctx context.Context, url string) (image.Image, string, error) {
+	o.fetched = append(o.fetched, url)
+	return image.NewRGBA(image.Rect(0, 0, 40, 20)), "png", nil
+}
+
+func (o *originalDownloader) ReadStoredImage(ctx context.Context, bucket, key string) (image.Image, string, error) {
+	o.fetched = append(o.fetched, bucket+"/"+key)
+	if img, ok := o.objects[bucket+"/"+key]; ok {
+		return img, "jpeg", nil
+	}
+	return nil, "", storage.ErrObjectNotFound
+}
+
+func TestProcessJobReadsStoredOriginal(t *testing.T) {
+	tests := []struct {
+		name, original, want string
+	}{
+		{"stored original", "s3://images/original/a.jpg", "images/original/a.jpg"},
+		{"missing original falls back to the URL", "s3://images/original/gone.jpg", "images/original/gone.jpg,http://example.com/a.png"},
+		{"other bucket is not read", "s3://private/original/a.jpg", "http://example.com/a.png"},
+		{"no original", "", "http://example.com/a.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			downloader := &originalDownloader{objects: map[string]image.Image{
+				"images/original/a.jpg":  image.NewRGBA(image.Rect(0, 0, 30, 30)),
+				"private/original/a.jpg": image.NewRGBA(image.Rect(0, 0, 30, 30)),
+			}}
+			w, ch := newTestWorker(t, downloader)
+			w.config.Minio.Bucket = "images"
+
+			body, err := message.Encode("trace-original", "test", models.ImageJob{
+				URLs:            []string{"http://example.com/a.png"},
+				ProcessingTypes: []string{"grayscale"},
+				Original:        tt.original,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+				t.Fatalf("processJob failed: %v", err)
+			}
+
+			if got := strings.Join(downloader.fetched, ","); got != tt.want {
+				t.Errorf("fetched %s, want %s", got, tt.want)
+			}
+			_, result, err := message.Decode[models.ImageProcessedPayload](ch.published[0].Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.SourceURL != "http://example.com/a.png" {
+				t.Errorf("result source_url = %q, want the job's URL", result.SourceURL)
+			}
+		})
+	}
+}
+
+func TestProcessJobCarriesOwner(t *testing.T) {
+	tests := []struct {
+		name, jobOwner, envOwner, want string
+	}{
+		{"from the job", "acme", "", "acme"},
+		{"from the envelope", "", "acme", "acme"},
+		{"unowned", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 8, 8))})
+
+			body, err := message.EncodeEnvelope(message.Envelope{TraceID: "trace-owner", Source: "test", OwnerID: tt.envOwner}, models.ImageJob{
+				URLs:            []string{"http://example.com/a.png"},
+				ProcessingTypes: []string{"grayscale"},
+				OwnerID:         tt.jobOwner,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+				t.Fatalf("processJob failed: %v", err)
+			}
+
+			env, result, err := message.Decode[models.ImageProcessedPayload](ch.published[0].Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.OwnerID != tt.want || env.OwnerID != tt.want {
+				t.Errorf("result owner %q, envelope owner %q, want %q", result.OwnerID, env.OwnerID, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessJobRejectsUnsupportedTypeBeforeDownload(t *testing.T) {
+	downloader := &countingDownloader{img: image.NewRGBA(image.Rect(0, 0, 4, 4))}
+	w, ch := newTestWorker(t, downloader)
+
+	body, err := message.Encode("trace-6", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"original", "sepia"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); !errors.Is(err, errInvalidJob) {
+		t.Fatalf("expected errInvalidJob, got %v", err)
+	}
+	if downloader.downloads != 0 || len(ch.published) != 0 {
+		t.Errorf("expected no download or results, got %d downloads and %d results", downloader.downloads, len(ch.published))
+	}
+}
+
+func TestHandleDeliveryCountsDeadLetterReasons(t *testing.T) {
+	tests := []struct {
+		name       string
+		job        models.ImageJob
+		downloader ImageDownloader
+		wantReason string
+	}{
+		{
+			name:       "download failure",
+			job:        models.ImageJob{URLs: []string{"http://example.com/a.png"}, ProcessingTypes: []string{"grayscale"}},
+			downloader: fakeDownloader{err: fmt.Errorf("%w: HTTP error: 404", processor.ErrPermanent)},
+			wantReason: "download_error",
+		},
+		{
+			name:       "undecodable image",
+			job:        models.ImageJob{URLs: []string{"http://example.com/a.png"}, ProcessingTypes: []string{"grayscale"}},
+			downloader: fakeDownloader{err: fmt.Errorf("%w: %w", processor.ErrPermanent, processor.ErrUndecodable)},
+			wantReason: "decode_error",
+		},
+		{
+			name:       "unsupported type",
+			job:        models.ImageJob{URLs: []string{"http://example.com/a.png"}, ProcessingTypes: []string{"sepia"}},
+			downloader: fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 4, 4))},
+			wantReason: "unsupported_type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := newTestWorker(t, tt.downloader)
+			body, err := message.Encode("trace-dlq", "test", tt.job)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			counter := middleware.JobsDeadLettered.WithLabelValues(tt.wantReason, "image-fetcher")
+			before := testutil.ToFloat64(counter)
+
+			ack := &fakeAcknowledger{}
+			w.newConsumer().Handle(amqp.Delivery{Acknowledger: ack, Body: body})
+
+			if !ack.nacked {
+				t.Fatal("expected the job to be dead-lettered")
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("expected %s counter to increase by 1, got %v", tt.wantReason, got)
+			}
+		})
+	}
+}
+
+func TestFailureReasonUpload(t *testing.T) {
+	err := fmt.Errorf("%w: %w", errUpload, errors.New("bucket not found"))
+	if got := failureReason(err); got != "upload_error" {
+		t.Errorf("failureReason() = %q, want upload_error", got)
+	}
+}
+
+func TestProcessJobRepliesToWaitingSubmitter(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 8, 8))})
+
+	body, err := message.Encode("trace-7", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"grayscale"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body, ReplyTo: "amq.gen-replies", CorrelationId: "corr-1"}); err != nil {
+		t.Fatalf("processJob failed: %v", err)
+	}
+
+	if strings.Join(ch.keys, ",") != "results,amq.gen-replies" {
+		t.Fatalf("expected the result on the result queue and the reply queue, got %v", ch.keys)
+	}
+	if got := ch.published[1].CorrelationId; got != "corr-1" {
+		t.Errorf("reply correlation ID = %q, want corr-1", got)
+	}
+}
+
+func TestHandleDeliveryRepliesWithFailure(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{err: processor.ErrPermanent})
+
+	body, err := message.Encode("trace-8", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"grayscale"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ack := &fakeAcknowledger{}
+	w.newConsumer().Handle(amqp.Delivery{Acknowledger: ack, Body: body, ReplyTo: "amq.gen-replies", CorrelationId: "corr-2"})
+
+	if !ack.nacked || len(ch.published) != 1 || ch.keys[0] != "amq.gen-replies" {
+		t.Fatalf("expected a dead-lettered job with one failure reply, nacked=%v keys=%v", ack.nacked, ch.keys)
+	}
+	_, result, err := message.Decode[models.ImageProcessedPayload](ch.published[0].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != "error" || result.ErrorMsg == "" || result.SourceURL != "http://example.com/image.png" {
+		t.Errorf("unexpected failure reply: %+v", result)
+	}
+}
+
+func TestProcessJobRepliesToEnvelopeAddress(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 8, 8))})
+
+	// Without AMQP properties the envelope's reply address is used
+	body, err := message.EncodeWithReply("trace-9", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"original"},
+	}, message.Reply{To: "amq.gen-replies", CorrelationID: "corr-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("processJob failed: %v", err)
+	}
+	if len(ch.keys) != 2 || ch.keys[1] != "amq.gen-replies" || ch.published[1].CorrelationId != "corr-3" {
+		t.Fatalf("expected a reply tagged corr-3, got keys %v", ch.keys)
+	}
+}
+
+func TestProcessJobRejectsBucketOnBackendWithoutBuckets(t *testing.T) {
+	downloader := &countingDownloader{img: image.NewRGBA(image.Rect(0, 0, 4, 4))}
+	w, ch := newTestWorker(t, downloader)
+
+	body, err := message.Encode("trace-10", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"original"},
+		Bucket:          "tenant-a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.processJob(context.Background(), amqp.Delivery{Body: body})
+	if !errors.Is(err, errInvalidJob) || !errors.Is(err, storage.ErrBucketsUnsupported) {
+		t.Fatalf("expected an invalid job for the unsupported bucket, got %v", err)
+	}
+	if downloader.downloads != 0 || len(ch.published) != 0 {
+		t.Errorf("expected no download or results, got %d downloads and %d results", downloader.downloads, len(ch.published))
+	}
+}
+
+func TestProcessJobRejectsObjectOutsideOwnerPrefix(t *testing.T) {
+	downloader := &countingDownloader{img: image.NewRGBA(image.Rect(0, 0, 4, 4))}
+	w, ch := newTestWorker(t, downloader)
+
+	body, err := message.Encode("trace-10b", "test", models.ImageJob{
+		URLs:            []string{"minio://uploads/globex/a.png"},
+		ProcessingTypes: []string{"original"},
+		OwnerID:         "acme",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); !errors.Is(err, errInvalidJob) {
+		t.Fatalf("expected errInvalidJob, got %v", err)
+	}
+	if downloader.downloads != 0 || len(ch.published) != 0 {
+		t.Errorf("expected no download or results, got %d downloads and %d results", downloader.downloads, len(ch.published))
+	}
+}
+
+func TestPublishedMessagesCarryServiceSource(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 8, 8))})
+
+	body, err := message.EncodeWithReply("trace-11", config.URLIngestorService, models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"original"},
+	}, message.Reply{To: "amq.gen-replies", CorrelationID: "corr-4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("processJob failed: %v", err)
+	}
+	if len(ch.published) != 2 {
+		t.Fatalf("expected a result and a reply, got %d messages", len(ch.published))
+	}
+	for i, msg := range ch.published {
+		env, _, err := message.Decode[models.ImageProcessedPayload](msg.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Source != config.ImageFetcherService {
+			t.Errorf("message %d to %s has source %q, want %q", i, ch.keys[i], env.Source, config.ImageFetcherService)
+		}
+	}
+}
+
+func TestProcessJobAutoPicksDerivativesBySize(t *testing.T) {
+	rules, err := config.ParseAutoRules("100=thumb:10x0|medium:50x0,0=original")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		size        int
+		wantPresets []string
+	}{
+		{"large image", 200, []string{"thumb", "medium"}},
+		{"small image", 80, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, tt.size, tt.size/2))})
+			w.autoRules = rules
+
+			body, err := message.Encode("trace-12", "test", models.ImageJob{
+				URLs:            []string{"http://example.com/image.png"},
+				ProcessingTypes: []string{"auto"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+				t.Fatalf("processJob failed: %v", err)
+			}
+
+			var presets []string
+			for _, msg := range ch.published {
+				_, result, err := message.Decode[models.ImageProcessedPayload](msg.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.ProcessingType != "resize" {
+					t.Errorf("expected auto to produce resize outputs, got %s", result.ProcessingType)
+				}
+				presets = append(presets, result.Preset)
+			}
+			if strings.Join(presets, ",") != strings.Join(tt.wantPresets, ",") {
+				t.Errorf("presets = %v, want %v", presets, tt.wantPresets)
+			}
+		})
+	}
+}
+
+func TestTransformWithContextWaitsForCPUSlot(t *testing.T) {
+	slots := make(chan struct{}, 1)
+	slots <- struct{}{} // another transform holds the only slot
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	ran := false
+	_, err := transformWithContext(ctx, slots, image.NewRGBA(image.Rect(0, 0, 1, 1)), func(img image.Image) image.Image {
+		ran = true
+		return img
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || ran {
+		t.Fatalf("expected the transform to wait for a slot until the deadline, err=%v ran=%v", err, ran)
+	}
+
+	<-slots
+	if _, err := transformWithContext(context.Background(), slots, image.NewRGBA(image.Rect(0, 0, 1, 1)), func(img image.Image) image.Image { return img }); err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 0 {
+		t.Errorf("expected the slot to be released after the transform, %d held", len(slots))
+	}
+}
+
+func TestTransformWithContextAbandoned(t *testing.T) {
+	gauge := middleware.AbandonedTransforms.WithLabelValues(config.ImageFetcherService)
+	before := testutil.ToFloat64(gauge)
+	slots := make(chan struct{}, 1)
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := transformWithContext(ctx, slots, image.NewRGBA(image.Rect(0, 0, 1, 1)), func(img image.Image) image.Image {
+		defer close(finished)
+		<-release
+		return img
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline error, got %v", err)
+	}
+	if len(slots) != 1 {
+		t.Fatalf("expected the abandoned transform to hold its slot, %d held", len(slots))
+	}
+	if got := testutil.ToFloat64(gauge) - before; got != 1 {
+		t.Fatalf("expected one abandoned transform, got %v", got)
+	}
+
+	close(release)
+	<-finished
+	deadline := time.Now().Add(time.Second)
+	for (len(slots) != 0 || testutil.ToFloat64(gauge) != before) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if len(slots) != 0 {
+		t.Errorf("expected the slot to be released once the transform finished, %d held", len(slots))
+	}
+	if got := testutil.ToFloat64(gauge) - before; got != 0 {
+		t.Errorf("expected no abandoned transforms left, got %v", got)
+	}
+}
+
+func TestProcessJobSkipExisting(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 40, 20))})
+
+	body, err := message.Encode("trace-skip", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"resize"},
+		Resize:          []models.ResizePreset{{Name: "thumb", Width: 10, Height: 5}},
+		SkipExisting:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	_, first, err := message.Decode[models.ImageProcessedPayload](ch.published[0].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Skipped {
+		t.Fatal("expected the first run to produce the output")
+	}
+
+	// The rerun must not download: the output is already stored
+	w.downloader = fakeDownloader{err: errors.New("unexpected download")}
+	if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("rerun failed: %v", err)
+	}
+	_, second, err := message.Decode[models.ImageProcessedPayload](ch.published[1].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Skipped || second.Status != "success" || second.S3Path != first.S3Path || second.FileSize != first.FileSize {
+		t.Errorf("expected a skipped result for the stored output %s, got %+v", first.S3Path, second)
+	}
+}
+
+func TestSkipExistingIsPerOwner(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 40, 20))})
+
+	run := func(owner string) models.ImageProcessedPayload {
+		t.Helper()
+		body, err := message.Encode("trace-"+owner, "test", models.ImageJob{
+			URLs:            []string{"http://example.com/image.png"},
+			ProcessingTypes: []string{"grayscale"},
+			SkipExisting:    true,
+			OwnerID:         owner,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); err != nil {
+			t.Fatalf("%s: %v", owner, err)
+		}
+		_, result, err := message.Decode[models.ImageProcessedPayload](ch.published[len(ch.published)-1].Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *result
+	}
+
+	// Another owner's identical job stores, and is charged for, its own
+	// output instead of skipping to the first owner's
+	acme, other := run("acme"), run("globex")
+	if other.Skipped || other.S3Path == acme.S3Path {
+		t.Errorf("expected globex to get its own output, got %+v", other)
+	}
+	if again := run("acme"); !again.Skipped || again.S3Path != acme.S3Path {
+		t.Errorf("expected acme's rerun to skip to its output %s, got %+v", acme.S3Path, again)
+	}
+}
+
+func TestTransformForParams(t *testing.T) {
+	w, _ := newTestWorker(t, fakeDownloader{})
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+
+	tests := []struct {
+		name string
+		task imageTask
+		want image.Point
+	}{
+		{"resize default", imageTask{ProcessingType: "resize"}, image.Pt(100, 100)},
+		{"resize params", imageTask{ProcessingType: "resize", Params: models.ProcessingParams{Width: 20}}, image.Pt(20, 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transform, err := w.transformFor(tt.task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := transform(src).Bounds().Size(); got != tt.want {
+				t.Errorf("output size = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessJobRejectsConvertWithoutEncoder(t *testing.T) {
+	if storage.EncoderAvailable(storage.FormatWebP) {
+		t.Skip("cwebp is installed")
+	}
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 40, 20))})
+
+	body, err := message.Encode("trace-convert", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"convert"},
+		Params:          map[string]models.ProcessingParams{"convert": {Format: storage.FormatWebP}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.processJob(context.Background(), amqp.Delivery{Body: body})
+	if !errors.Is(err, errInvalidJob) || !errors.Is(err, storage.ErrEncoderUnavailable) {
+		t.Errorf("expected an invalid job for the missing encoder, got %v", err)
+	}
+	if len(ch.published) != 0 {
+		t.Errorf("expected no results, got %d", len(ch.published))
+	}
+}
+
+// panickingTransformer panics in Grayscale, like a transform hitting a bug
+type panickingTransformer struct {
+	ImageTransformer
+}
+
+func (panickingTransformer) Grayscale(img image.Image) image.Image {
+	var out *image.RGBA
+	_ = out.Pix[0]
+	return out
+}
+
+func TestHandleDeliveryRecoversPanics(t *testing.T) {
+	body, err := message.Encode("trace-panic", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"grayscale"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(w *ImageWorker)
+	}{
+		// A downloader returning neither an image nor an error makes the
+		// job dereference a nil image on its own goroutine
+		{"nil image", func(w *ImageWorker) { w.downloader = fakeDownloader{} }},
+		// Transforms run on a goroutine of their own under the CPU slots
+		{"transform", func(w *ImageWorker) { w.transformer = panickingTransformer{w.transformer} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 8, 8))})
+			healthy := w.downloader
+			tt.setup(w)
+			counter := middleware.JobsDeadLettered.WithLabelValues("panic", "image-fetcher")
+			before := testutil.ToFloat64(counter)
+
+			ack := &fakeAcknowledger{}
+			w.newConsumer().Handle(amqp.Delivery{Acknowledger: ack, Body: body})
+			if !ack.nacked || len(ch.published) != 0 {
+				t.Fatalf("expected the panicking job to be dead-lettered without a retry, nacked=%v published=%d", ack.nacked, len(ch.published))
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("expected the panic counter to increase by 1, got %v", got)
+			}
+			if len(w.cpuSlots) != 0 {
+				t.Errorf("expected the CPU slot to be released, %d still held", len(w.cpuSlots))
+			}
+
+			// The worker carries on with the next job
+			w.downloader, w.transformer = healthy, processor.NewImageProcessor()
+			ack = &fakeAcknowledger{}
+			w.newConsumer().Handle(amqp.Delivery{Acknowledger: ack, Body: body})
+			if !ack.acked || len(ch.published) != 1 {
+				t.Errorf("expected the next job to succeed, acked=%v published=%d", ack.acked, len(ch.published))
+			}
+		})
+	}
+}
+
+// flakyStorage fails uploads of one processing type with a transient error
+type flakyStorage struct {
+	storage.Storage
+	failType string
+}
+
+func (f *flakyStorage) UploadImageWithType(ctx context.Context, img image.Image, processingType, variant string, opts storage.UploadOptions) (string, error) {
+	if processingType == f.failType {
+		return "", errors.New("connection reset")
+	}
+	return f.Storage.UploadImageWithType(ctx, img, processingType, variant, opts)
+}
+
+func TestRetrySkipsPublishedOutputs(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 40, 20))})
+	w.config.Worker.MaxRetries = 3
+	flaky := &flakyStorage{Storage: w.storage, failType: "blur"}
+	w.storage = flaky
+
+	body, err := message.Encode("trace-retry", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"grayscale", "blur"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// grayscale is published, then blur fails and the job is retried
+	w.newConsumer().Handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+	if len(ch.keys) != 2 || ch.keys[0] != "results" || ch.keys[1] != "jobs.delayed" {
+		t.Fatalf("expected a result and a retry, got %v", ch.keys)
+	}
+	retry := ch.published[1]
+	if published := publishedOutputs(retry.Headers); len(published) != 1 || !published["grayscale"] {
+		t.Fatalf("expected the retry to list grayscale as published, got %v", retry.Headers[publishedHeader])
+	}
+
+	// The retry only produces blur, so grayscale isn't stored or recorded twice
+	flaky.failType = ""
+	w.newConsumer().Handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Headers: retry.Headers, Body: retry.Body})
+	if len(ch.keys) != 3 || ch.keys[2] != "results" {
+		t.Fatalf("expected one more result, got %v", ch.keys)
+	}
+	_, result, err := message.Decode[models.ImageProcessedPayload](ch.published[2].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ProcessingType != "blur" {
+		t.Errorf("expected the retry to publish blur only, got %s", result.ProcessingType)
+	}
+}
+
+func TestAtomicOutputsPublishFailure(t *testing.T) {
+	w, ch := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 40, 20))})
+	w.config.Worker.AtomicOutputs = true
+	w.config.Worker.MaxRetries = 3
+	dir := t.TempDir()
+	store, err := storage.NewFilesystemService(dir, config.EncodingConfig{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.storage = store
+	// Both outputs are stored, then the second result fails to publish
+	ch.failOn = 2
+
+	body, err := message.Encode("trace-atomic-publish", "test", models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"grayscale", "blur"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.newConsumer().Handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+
+	if len(ch.keys) != 2 || ch.keys[0] != "results" || ch.keys[1] != "jobs.delayed" {
+		t.Fatalf("expected the grayscale result and a retry, got %v", ch.keys)
+	}
+	// The published grayscale output is kept and blur's is removed
+	stored, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || !strings.Contains(stored[0].Name(), "grayscale") {
+		t.Fatalf("expected only the grayscale output to be kept, got %v", stored)
+	}
+
+	// The retry produces and publishes blur alone
+	retry := ch.published[1]
+	w.newConsumer().Handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Headers: retry.Headers, Body: retry.Body})
+	if len(ch.keys) != 3 || ch.keys[2] != "results" {
+		t.Fatalf("expected one more result, got %v", ch.keys)
+	}
+	_, result, err := message.Decode[models.ImageProcessedPayload](ch.published[2].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ProcessingType != "blur" {
+		t.Errorf("expected the retry to publish blur only, got %s", result.ProcessingType)
+	}
+	if stored, _ = os.ReadDir(dir); len(stored) != 2 {
+		t.Errorf("expected grayscale and blur stored once each, got %d objects", len(stored))
+	}
+}
+
+func TestProcessJobAtomicOutputs(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7919 % 251)
+	}
+	// Noise can't be squeezed into 1 KiB without downscaling, so compress_to
+	// fails after grayscale is stored
+	failing := models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"grayscale", "compress_to"},
+		Params:          map[string]models.ProcessingParams{"compress_to": {MaxBytes: 1024}},
+	}
+	passing := models.ImageJob{
+		URLs:            []string{"http://example.com/image.png"},
+		ProcessingTypes: []string{"grayscale", "blur"},
+	}
+
+	tests := []struct {
+		name          string
+		atomic        bool
+		job           models.ImageJob
+		wantErr       bool
+		wantPublished int
+		wantStored    int
+	}{
+		{"partial failure keeps earlier outputs", false, failing, true, 1, 1},
+		{"atomic partial failure publishes and keeps nothing", true, failing, true, 0, 0},
+		{"atomic success publishes every result", true, passing, false, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, ch := newTestWorker(t, fakeDownloader{img: img})
+			w.config.Worker.AtomicOutputs = tt.atomic
+			dir := t.TempDir()
+			store, err := storage.NewFilesystemService(dir, config.EncodingConfig{Quality: 90})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.storage = store
+
+			body, err := message.Encode("trace-atomic", "test", tt.job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.processJob(context.Background(), amqp.Delivery{Body: body}); (err != nil) != tt.wantErr {
+				t.Fatalf("processJob error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if len(ch.published) != tt.wantPublished {
+				t.Errorf("published %d results, want %d", len(ch.published), tt.wantPublished)
+			}
+			stored, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored) != tt.wantStored {
+				t.Errorf("stored %d objects, want %d", len(stored), tt.wantStored)
+			}
+		})
+	}
+}
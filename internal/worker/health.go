@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/service/processor"
+)
+
+// recoverySuccesses is how many jobs in a row must succeed for an unhealthy
+// worker to forget its failures instead of waiting for them to age out
+const recoverySuccesses = 5
+
+// failureTracker watches the share of recent jobs that failed for reasons
+// outside the submitter's control, such as rotated storage credentials, so a
+// worker that can't process anything stops reporting itself ready
+type failureTracker struct {
+	jobs      *throughputMeter
+	failures  *throughputMeter
+	threshold float64
+	minJobs   int64
+
+	mu        sync.Mutex
+	succeeded int
+}
+
+// newFailureTracker returns nil when cfg disables the check
+func newFailureTracker(cfg config.WorkerConfig) *failureTracker {
+	if cfg.FailureThreshold <= 0 || cfg.FailureWindow < time.Second {
+		return nil
+	}
+	return &failureTracker{
+		jobs:      newThroughputMeter(cfg.FailureWindow),
+		failures:  newThroughputMeter(cfg.FailureWindow),
+		threshold: float64(cfg.FailureThreshold) / 100,
+		minJobs:   int64(cfg.FailureMinJobs),
+	}
+}
+
+// countsAsFailure reports whether a job error says something about the
+// worker. Invalid jobs and sources that can't be processed are the
+// submitter's problem and would make a bad batch look like an outage.
+func countsAsFailure(err error) bool {
+	return err != nil && !errors.Is(err, errInvalidJob) && !errors.Is(err, processor.ErrPermanent)
+}
+
+// record notes the outcome of a job finished at now
+func (f *failureTracker) record(err error, now time.Time) {
+	if f == nil {
+		return
+	}
+	f.jobs.mark(now)
+	if countsAsFailure(err) {
+		f.failures.mark(now)
+		f.mu.Lock()
+		f.succeeded = 0
+		f.mu.Unlock()
+		return
+	}
+
+	f.mu.Lock()
+	f.succeeded++
+	recovered := f.succeeded >= recoverySuccesses
+	f.mu.Unlock()
+	if recovered && !f.healthy(now) {
+		f.jobs.reset()
+		f.failures.reset()
+	}
+}
+
+// ratio returns the failed share of the jobs in the window ending at now,
+// and how many jobs that is
+func (f *failureTracker) ratio(now time.Time) (float64, int64) {
+	jobs := f.jobs.total(now)
+	if jobs == 0 {
+		return 0, 0
+	}
+	return float64(f.failures.total(now)) / float64(jobs), jobs
+}
+
+// healthy reports whether the failure ratio is under the threshold, or too
+// few jobs have run to tell
+func (f *failureTracker) healthy(now time.Time) bool {
+	if f == nil {
+		return true
+	}
+	ratio, jobs := f.ratio(now)
+	return jobs < f.minJobs || ratio < f.threshold
+}
+
+// ReadyHandler serves GET /ready: 200 while the worker's recent jobs mostly
+// succeed, 503 once the failure ratio reaches WORKER_FAILURE_THRESHOLD
+func (w *ImageWorker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		body := map[string]interface{}{"status": "ready"}
+		status := http.StatusOK
+		if w.failures != nil {
+			ratio, jobs := w.failures.ratio(now)
+			body["failure_ratio"] = ratio
+			body["recent_jobs"] = jobs
+			if !w.failures.healthy(now) {
+				body["status"] = "failing"
+				status = http.StatusServiceUnavailable
+			}
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		json.NewEncoder(rw).Encode(body)
+	})
+}
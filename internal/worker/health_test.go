@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/service/processor"
+)
+
+func TestFailureTracker(t *testing.T) {
+	f := newFailureTracker(config.WorkerConfig{FailureThreshold: 50, FailureWindow: time.Minute, FailureMinJobs: 4})
+	now := time.Now()
+	uploadErr := fmt.Errorf("%w: access denied", errUpload)
+
+	for i := 0; i < 3; i++ {
+		f.record(uploadErr, now)
+	}
+	if !f.healthy(now) {
+		t.Error("expected too few jobs to keep the worker healthy")
+	}
+	f.record(uploadErr, now)
+	if f.healthy(now) {
+		t.Error("expected every job failing to make the worker unhealthy")
+	}
+	if !f.healthy(now.Add(2 * time.Minute)) {
+		t.Error("expected failures to age out of the window")
+	}
+
+	for i := 0; i < recoverySuccesses; i++ {
+		f.record(nil, now)
+	}
+	if !f.healthy(now) {
+		t.Error("expected a run of successes to reset the tracker")
+	}
+
+	// Bad submissions don't say anything about the worker
+	for i := 0; i < 10; i++ {
+		f.record(fmt.Errorf("%w: %w", errDownload, processor.ErrPermanent), now)
+		f.record(fmt.Errorf("%w: bad type", errInvalidJob), now)
+	}
+	if !f.healthy(now) {
+		t.Error("expected permanent source failures not to count")
+	}
+
+	if newFailureTracker(config.WorkerConfig{}) != nil {
+		t.Error("expected a zero threshold to disable the tracker")
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	w, _ := newTestWorker(t, fakeDownloader{})
+	w.failures = newFailureTracker(config.WorkerConfig{FailureThreshold: 50, FailureWindow: time.Minute, FailureMinJobs: 1})
+
+	serve := func() int {
+		rr := httptest.NewRecorder()
+		w.ReadyHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+		return rr.Code
+	}
+	if got := serve(); got != http.StatusOK {
+		t.Errorf("expected a fresh worker to be ready, got %d", got)
+	}
+	w.failures.record(errors.New("connection refused"), time.Now())
+	if got := serve(); got != http.StatusServiceUnavailable {
+		t.Errorf("expected a failing worker to be unready, got %d", got)
+	}
+}
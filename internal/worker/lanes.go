@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"reflect"
+
+	"image-processing-system/internal/config"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// lane is one job queue's delivery stream while it is being merged
+type lane struct {
+	queue  string
+	weight int
+	msgs   <-chan amqp.Delivery
+	// current is the lane's smooth weighted round-robin credit
+	current int
+}
+
+// consumeLanes starts a consumer on every job lane. With several lanes each
+// consumer tag gets its queue appended, since tags must be unique per channel.
+func (w *ImageWorker) consumeLanes(consumerTag string) ([]*lane, error) {
+	jobLanes := w.config.RabbitMQ.JobLanes()
+	lanes := make([]*lane, 0, len(jobLanes))
+	for _, jl := range jobLanes {
+		tag := consumerTag
+		if len(jobLanes) > 1 {
+			tag = consumerTag + "/" + jl.Queue
+		}
+		// Manual acks so failed or timed out jobs can be dead-lettered
+		msgs, err := w.channel.Consume(jl.Queue, tag, false, false, false, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		lanes = append(lanes, &lane{queue: jl.Queue, weight: jl.Weight, msgs: msgs})
+	}
+	return lanes, nil
+}
+
+// mergeLanes feeds the deliveries of every lane into one channel, closed once
+// all lanes are. While several lanes have a delivery ready, smooth weighted
+// round-robin picks between them, so higher-weight lanes are preferred without
+// starving the rest.
+func mergeLanes(lanes []*lane) <-chan amqp.Delivery {
+	if len(lanes) == 1 {
+		return lanes[0].msgs
+	}
+
+	out := make(chan amqp.Delivery)
+	go func() {
+		defer close(out)
+		pending := make([]*amqp.Delivery, len(lanes))
+		open := make([]bool, len(lanes))
+		for i := range open {
+			open[i] = true
+		}
+
+		for {
+			// Take whatever is ready without waiting
+			for i, l := range lanes {
+				if pending[i] != nil || !open[i] {
+					continue
+				}
+				select {
+				case msg, ok := <-l.msgs:
+					if ok {
+						pending[i] = &msg
+					} else {
+						open[i] = false
+					}
+				default:
+				}
+			}
+
+			ready := make([]bool, len(lanes))
+			anyReady, anyOpen := false, false
+			for i := range lanes {
+				ready[i] = pending[i] != nil
+				anyReady = anyReady || ready[i]
+				anyOpen = anyOpen || open[i]
+			}
+
+			if anyReady {
+				i := pickLane(lanes, ready)
+				out <- *pending[i]
+				pending[i] = nil
+				continue
+			}
+			if !anyOpen {
+				return
+			}
+
+			// Nothing is ready: wait for the first delivery on any open lane
+			var cases []reflect.SelectCase
+			var index []int
+			for i, l := range lanes {
+				if open[i] {
+					cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(l.msgs)})
+					index = append(index, i)
+				}
+			}
+			chosen, value, ok := reflect.Select(cases)
+			if !ok {
+				open[index[chosen]] = false
+				continue
+			}
+			msg := value.Interface().(amqp.Delivery)
+			pending[index[chosen]] = &msg
+		}
+	}()
+	return out
+}
+
+// pickLane chooses among the ready lanes by smooth weighted round-robin:
+// lanes with weights 3 and 1 that both stay ready are picked 3:1, interleaved
+func pickLane(lanes []*lane, ready []bool) int {
+	best, total := -1, 0
+	for i, l := range lanes {
+		if !ready[i] {
+			continue
+		}
+		l.current += l.weight
+		total += l.weight
+		if best < 0 || l.current > lanes[best].current {
+			best = i
+		}
+	}
+	lanes[best].current -= total
+	return best
+}
+
+// laneQueue returns the job queue a delivery came from, which its retries go
+// back through. Deliveries from a draining lane are retried in the lane their
+// priority routes to, and those routed by another key count as the default
+// lane.
+func laneQueue(cfg config.RabbitMQConfig, m amqp.Delivery) string {
+	for _, lane := range cfg.JobLanes() {
+		if m.RoutingKey == lane.Queue {
+			if lane.Draining {
+				return cfg.QueueForPriority(int(m.Priority))
+			}
+			return lane.Queue
+		}
+	}
+	return cfg.QueueForPriority(0)
+}
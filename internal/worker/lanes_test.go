@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"testing"
+
+	"image-processing-system/internal/config"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestPickLaneWeighted(t *testing.T) {
+	lanes := []*lane{{queue: "fast", weight: 3}, {queue: "bulk", weight: 1}}
+	ready := []bool{true, true}
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[lanes[pickLane(lanes, ready)].queue]++
+	}
+	if counts["fast"] != 6 || counts["bulk"] != 2 {
+		t.Errorf("expected a 3:1 split, got %v", counts)
+	}
+
+	if got := lanes[pickLane(lanes, []bool{false, true})].queue; got != "bulk" {
+		t.Errorf("expected the only ready lane to be picked, got %s", got)
+	}
+}
+
+func TestMergeLanes(t *testing.T) {
+	fast := make(chan amqp.Delivery, 4)
+	bulk := make(chan amqp.Delivery, 4)
+	for i := 0; i < 2; i++ {
+		fast <- amqp.Delivery{RoutingKey: "fast"}
+		bulk <- amqp.Delivery{RoutingKey: "bulk"}
+	}
+	close(fast)
+	close(bulk)
+
+	msgs := mergeLanes([]*lane{{queue: "fast", weight: 3, msgs: fast}, {queue: "bulk", weight: 1, msgs: bulk}})
+	var got []string
+	for m := range msgs {
+		got = append(got, m.RoutingKey)
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected every delivery once, got %v", got)
+	}
+	if got[0] != "fast" {
+		t.Errorf("expected the fast lane to be preferred, got %v", got)
+	}
+}
+
+func TestLaneQueue(t *testing.T) {
+	cfg := config.RabbitMQConfig{JobQueue: "image.urls", Lanes: "image.urls.fast:5:3,image.urls.bulk:0:1"}
+
+	tests := []struct {
+		routingKey string
+		priority   uint8
+		want       string
+	}{
+		{"image.urls.fast", 7, "image.urls.fast"},
+		{"image.urls.bulk", 7, "image.urls.bulk"},
+		// Jobs drained from the queue used before the lanes retry in a lane
+		{"image.urls", 7, "image.urls.fast"},
+		{"image.urls", 0, "image.urls.bulk"},
+		{"other", 7, "image.urls.bulk"},
+	}
+	for _, tt := range tests {
+		if got := laneQueue(cfg, amqp.Delivery{RoutingKey: tt.routingKey, Priority: tt.priority}); got != tt.want {
+			t.Errorf("%s priority %d: expected %s, got %s", tt.routingKey, tt.priority, tt.want, got)
+		}
+	}
+}
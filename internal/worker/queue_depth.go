@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/middleware"
+)
+
+// QueueInspector reads the number of messages waiting in a queue
+type QueueInspector interface {
+	QueueDepth(queue string) (int, error)
+}
+
+// MonitorQueueDepths exports the depth of each queue to the queue_size gauge
+// every interval until ctx is done. Failed reads keep the last value.
+func MonitorQueueDepths(ctx context.Context, inspector QueueInspector, queues []string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		recordQueueDepths(inspector, queues)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordQueueDepths reads each queue's depth once into the queue_size gauge
+func recordQueueDepths(inspector QueueInspector, queues []string) {
+	for _, queue := range queues {
+		depth, err := inspector.QueueDepth(queue)
+		if err != nil {
+			log.Printf("Failed to read depth of %s: %v", queue, err)
+			continue
+		}
+		middleware.QueueSize.WithLabelValues(queue, config.ImageFetcherService).Set(float64(depth))
+	}
+}
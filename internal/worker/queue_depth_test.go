@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+
+	"image-processing-system/internal/middleware"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeInspector reports fixed queue depths; unknown queues fail
+type fakeInspector map[string]int
+
+func (f fakeInspector) QueueDepth(queue string) (int, error) {
+	depth, ok := f[queue]
+	if !ok {
+		return 0, errors.New("no such queue")
+	}
+	return depth, nil
+}
+
+func TestRecordQueueDepths(t *testing.T) {
+	dlq := middleware.QueueSize.WithLabelValues("test.jobs.dlq", "image-fetcher")
+	dlq.Set(7)
+
+	recordQueueDepths(fakeInspector{"test.jobs": 12}, []string{"test.jobs", "test.jobs.dlq"})
+
+	if got := testutil.ToFloat64(middleware.QueueSize.WithLabelValues("test.jobs", "image-fetcher")); got != 12 {
+		t.Errorf("queue_size{test.jobs} = %v, want 12", got)
+	}
+	if got := testutil.ToFloat64(dlq); got != 7 {
+		t.Errorf("expected a failed read to keep the last value 7, got %v", got)
+	}
+}
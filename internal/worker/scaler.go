@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// throughputWindow is how far back /scaler averages completed jobs
+const throughputWindow = time.Minute
+
+// ScalerState is the body of GET /scaler: what an external autoscaler such as
+// KEDA needs to size the image-fetcher deployment
+type ScalerState struct {
+	// Concurrency is how many jobs this instance runs at once
+	Concurrency int `json:"concurrency"`
+	// InFlight is how many jobs it is running now
+	InFlight int64 `json:"in_flight"`
+	// QueueDepth is the number of jobs waiting across the job queues, or -1 if
+	// it couldn't be read
+	QueueDepth int `json:"queue_depth"`
+	// Throughput is the jobs completed per second over the last WindowSeconds
+	Throughput    float64 `json:"throughput_per_second"`
+	WindowSeconds int     `json:"window_seconds"`
+}
+
+// throughputMeter counts completed jobs in one-second buckets so a recent rate
+// can be read without keeping every timestamp
+type throughputMeter struct {
+	mu      sync.Mutex
+	counts  []int64
+	seconds []int64
+}
+
+func newThroughputMeter(window time.Duration) *throughputMeter {
+	n := int(window / time.Second)
+	return &throughputMeter{counts: make([]int64, n), seconds: make([]int64, n)}
+}
+
+// mark records a job completed at now
+func (t *throughputMeter) mark(now time.Time) {
+	sec := now.Unix()
+	i := int(sec % int64(len(t.counts)))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seconds[i] != sec {
+		t.seconds[i], t.counts[i] = sec, 0
+	}
+	t.counts[i]++
+}
+
+// rate returns the jobs completed per second over the window ending at now
+func (t *throughputMeter) rate(now time.Time) float64 {
+	return float64(t.total(now)) / float64(len(t.counts))
+}
+
+// total returns the jobs counted over the window ending at now
+func (t *throughputMeter) total(now time.Time) int64 {
+	oldest := now.Unix() - int64(len(t.counts)) + 1
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var total int64
+	for i, sec := range t.seconds {
+		if sec >= oldest {
+			total += t.counts[i]
+		}
+	}
+	return total
+}
+
+// reset forgets every count
+func (t *throughputMeter) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.counts)
+	clear(t.seconds)
+}
+
+// scalerStats tracks the worker's in-flight and completed jobs
+type scalerStats struct {
+	inFlight   atomic.Int64
+	throughput *throughputMeter
+}
+
+func newScalerStats() *scalerStats {
+	return &scalerStats{throughput: newThroughputMeter(throughputWindow)}
+}
+
+// ScalerHandler serves the worker's current ScalerState. inspector may be
+// nil, in which case the queue depth is reported as -1.
+func (w *ImageWorker) ScalerHandler(inspector QueueInspector) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		state := ScalerState{
+			Concurrency:   w.concurrencyLimit,
+			InFlight:      w.stats.inFlight.Load(),
+			QueueDepth:    -1,
+			Throughput:    w.stats.throughput.rate(time.Now()),
+			WindowSeconds: int(throughputWindow / time.Second),
+		}
+		if inspector != nil {
+			state.QueueDepth = 0
+			for _, q := range w.config.RabbitMQ.JobQueueNames() {
+				depth, err := inspector.QueueDepth(q)
+				if err != nil {
+					log.Printf("Failed to read %s depth for /scaler: %v", q, err)
+					state.QueueDepth = -1
+					break
+				}
+				state.QueueDepth += depth
+			}
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(state)
+	})
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThroughputMeter(t *testing.T) {
+	m := newThroughputMeter(10 * time.Second)
+	start := time.Unix(1000, 0)
+
+	for i := 0; i < 20; i++ {
+		m.mark(start.Add(time.Duration(i%5) * time.Second))
+	}
+	if got := m.rate(start.Add(4 * time.Second)); got != 2 {
+		t.Errorf("rate = %v, want 2 jobs/s", got)
+	}
+	// Buckets older than the window no longer count
+	if got := m.rate(start.Add(12 * time.Second)); got != 0.8 {
+		t.Errorf("rate after 12s = %v, want 0.8 jobs/s", got)
+	}
+	if got := m.rate(start.Add(time.Minute)); got != 0 {
+		t.Errorf("rate after the window = %v, want 0", got)
+	}
+}
+
+func TestScalerHandler(t *testing.T) {
+	w, _ := newTestWorker(t, fakeDownloader{})
+	w.stats.inFlight.Add(3)
+	w.stats.throughput.mark(time.Now())
+
+	tests := []struct {
+		name      string
+		inspector QueueInspector
+		wantDepth int
+	}{
+		{"with queue depth", fakeInspector{"jobs": 42}, 42},
+		{"unreadable queue", fakeInspector{}, -1},
+		{"no inspector", nil, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			w.ScalerHandler(tt.inspector).ServeHTTP(rr, httptest.NewRequest("GET", "/scaler", nil))
+
+			var state ScalerState
+			if err := json.NewDecoder(rr.Body).Decode(&state); err != nil {
+				t.Fatal(err)
+			}
+			if state.Concurrency != w.concurrencyLimit || state.InFlight != 3 || state.QueueDepth != tt.wantDepth {
+				t.Errorf("unexpected state %+v", state)
+			}
+			if state.Throughput <= 0 || state.WindowSeconds != 60 {
+				t.Errorf("expected the completed job in the throughput, got %+v", state)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"image-processing-system/internal/config"
+	"image-processing-system/internal/handler"
+	"image-processing-system/internal/handler/testutil"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// TestTraceContextRoundTrip follows a trace from the traceparent a client
+// sends to /submit, through the AMQP headers url-ingestor publishes, into the
+// worker's spans and the result it publishes for image-metadata
+func TestTraceContextRoundTrip(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+
+	recorder := recordSpans(t)
+
+	// Submit a job the way a traced client would
+	ch := &testutil.Channel{}
+	router := handler.NewRouter(ch, config.LoadURLIngestorConfig())
+	req := httptest.NewRequest("POST", "/submit", bytes.NewBufferString(`{"urls":["http://example.com/a.png"],"processing_types":["grayscale"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", traceparent)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("submit returned %d: %s", rr.Code, rr.Body)
+	}
+
+	// Every job published for the submission (original and grayscale)
+	// carries the client's trace
+	published := ch.Published()
+	if len(published) == 0 {
+		t.Fatal("expected published jobs")
+	}
+	for _, p := range published {
+		if tp, _ := p.Msg.Headers["traceparent"].(string); !strings.Contains(tp, traceID) {
+			t.Fatalf("published traceparent = %v, want one in trace %s", p.Msg.Headers["traceparent"], traceID)
+		}
+	}
+	job := published[0].Msg
+	injected := job.Headers["traceparent"].(string)
+
+	// Brokers may hand the header back as a string or as raw bytes
+	headers := map[string]amqp.Table{
+		"string": {"traceparent": injected},
+		"bytes":  {"traceparent": []byte(injected)},
+	}
+	for name, h := range headers {
+		t.Run(name, func(t *testing.T) {
+			recorder.Reset()
+			w, results := newTestWorker(t, fakeDownloader{img: image.NewRGBA(image.Rect(0, 0, 8, 8))})
+			ack := &fakeAcknowledger{}
+			w.newConsumer().Handle(amqp.Delivery{Acknowledger: ack, Body: job.Body, Headers: h})
+			if !ack.acked {
+				t.Fatal("expected the job to be acked")
+			}
+
+			var consumed bool
+			for _, span := range recorder.Ended() {
+				if span.Name() != "processJob" {
+					continue
+				}
+				consumed = true
+				if got := span.SpanContext().TraceID().String(); got != traceID {
+					t.Errorf("processJob span in trace %s, want %s", got, traceID)
+				}
+			}
+			if !consumed {
+				t.Fatal("expected a processJob span")
+			}
+
+			if len(results.published) != 1 {
+				t.Fatalf("expected one result, got %d", len(results.published))
+			}
+			if tp, _ := results.published[0].Headers["traceparent"].(string); !strings.Contains(tp, traceID) {
+				t.Errorf("result traceparent = %q, want one in trace %s", tp, traceID)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package hostpolicy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrHostNotAllowed is returned for URLs whose host the policy rejects
+var ErrHostNotAllowed = errors.New("source host not allowed")
+
+// Policy decides which hosts source images may be fetched from. Patterns are
+// either an exact hostname ("cdn.example.com") or a wildcard matching any
+// subdomain ("*.example.com", which doesn't match example.com itself).
+type Policy struct {
+	allow []string
+	deny  []string
+}
+
+// New returns a policy that permits hosts matching an allow pattern (any host
+// when allow is empty) unless they match a deny pattern. It returns nil when
+// both lists are empty; a nil policy permits every host.
+func New(allow, deny []string) *Policy {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	return &Policy{allow: normalize(allow), deny: normalize(deny)}
+}
+
+// normalize lowercases patterns and drops empty ones
+func normalize(patterns []string) []string {
+	var out []string
+	for _, p := range patterns {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Allows reports whether host (without a port) may be fetched
+func (p *Policy) Allows(host string) bool {
+	if p == nil {
+		return true
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if matchAny(p.deny, host) {
+		return false
+	}
+	return len(p.allow) == 0 || matchAny(p.allow, host)
+}
+
+// CheckURL returns ErrHostNotAllowed if rawURL's host isn't allowed
+func (p *Policy) CheckURL(rawURL string) error {
+	if p == nil {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	return p.CheckHost(u.Hostname())
+}
+
+// CheckHost returns ErrHostNotAllowed if host isn't allowed
+func (p *Policy) CheckHost(host string) error {
+	if !p.Allows(host) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+	}
+	return nil
+}
+
+// matchAny reports whether host matches one of the patterns
+func matchAny(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if suffix, ok := strings.CutPrefix(p, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidPattern reports whether p is a hostname or a "*." wildcard
+func ValidPattern(p string) bool {
+	p = strings.TrimPrefix(p, "*.")
+	return p != "" && !strings.ContainsAny(p, "/:*@ ")
+}
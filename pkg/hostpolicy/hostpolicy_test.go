@@ -0,0 +1,47 @@
+package hostpolicy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		host  string
+		want  bool
+	}{
+		{"no policy", nil, nil, "example.com", true},
+		{"exact allow", []string{"cdn.example.com"}, nil, "cdn.example.com", true},
+		{"not in allow-list", []string{"cdn.example.com"}, nil, "evil.com", false},
+		{"case insensitive", []string{"CDN.Example.com"}, nil, "cdn.EXAMPLE.com", true},
+		{"wildcard subdomain", []string{"*.example.com"}, nil, "img.cdn.example.com", true},
+		{"wildcard excludes apex", []string{"*.example.com"}, nil, "example.com", false},
+		{"wildcard needs a dot boundary", []string{"*.example.com"}, nil, "badexample.com", false},
+		{"deny only", nil, []string{"internal.example.com"}, "internal.example.com", false},
+		{"deny only allows others", nil, []string{"internal.example.com"}, "cdn.example.com", true},
+		{"deny beats allow", []string{"*.example.com"}, []string{"private.example.com"}, "private.example.com", false},
+		{"trailing dot", []string{"cdn.example.com"}, nil, "cdn.example.com.", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New(tt.allow, tt.deny).Allows(tt.host); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckURL(t *testing.T) {
+	p := New([]string{"cdn.example.com"}, nil)
+
+	if err := p.CheckURL("https://cdn.example.com:8443/a.jpg"); err != nil {
+		t.Errorf("expected the allowed host to pass regardless of port, got %v", err)
+	}
+	if err := p.CheckURL("https://evil.com/a.jpg"); !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("expected ErrHostNotAllowed, got %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package logging
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// debugEnabled is initialized from LOG_LEVEL and checked on hot paths
+var debugEnabled atomic.Bool
+
+func init() {
+	SetLevel(os.Getenv("LOG_LEVEL"))
+}
+
+// SetLevel enables debug logging when level is "debug"
+func SetLevel(level string) {
+	debugEnabled.Store(strings.EqualFold(strings.TrimSpace(level), "debug"))
+}
+
+// DebugEnabled reports whether debug logs are written
+func DebugEnabled() bool {
+	return debugEnabled.Load()
+}
+
+// Debugf logs only when debug logging is enabled
+func Debugf(format string, v ...any) {
+	if debugEnabled.Load() {
+		log.Printf(format, v...)
+	}
+}
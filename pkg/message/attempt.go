@@ -0,0 +1,58 @@
+package message
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AttemptHeader counts how many times a message has been requeued by a
+// consumer after failing. Consumers read it to decide when to dead-letter
+// instead of requeueing again.
+const AttemptHeader = "x-attempt"
+
+// ReplayHeader counts how many times a message has been replayed from its
+// dead-letter queue. It is kept apart from AttemptHeader so a replayed
+// message gets a fresh set of retries.
+const ReplayHeader = "x-replay"
+
+// Attempt returns the attempt recorded in the headers, 0 if none
+func Attempt(headers amqp.Table) int {
+	return intHeader(headers, AttemptHeader)
+}
+
+// Replays returns how many times the message was replayed, 0 if never
+func Replays(headers amqp.Table) int {
+	return intHeader(headers, ReplayHeader)
+}
+
+// SetReplays records replays in headers, allocating them when nil, and
+// returns them
+func SetReplays(headers amqp.Table, replays int) amqp.Table {
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[ReplayHeader] = int32(replays)
+	return headers
+}
+
+// intHeader reads a counter header, 0 if it is missing or not an integer
+func intHeader(headers amqp.Table, name string) int {
+	switch v := headers[name].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
+
+// SetAttempt records attempt in headers, allocating them when nil, and
+// returns them
+func SetAttempt(headers amqp.Table, attempt int) amqp.Table {
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[AttemptHeader] = int32(attempt)
+	return headers
+}
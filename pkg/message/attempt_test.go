@@ -0,0 +1,49 @@
+package message
+
+import (
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestAttempt(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int
+	}{
+		{"missing", amqp.Table{}, 0},
+		{"nil headers", nil, 0},
+		{"int32", amqp.Table{AttemptHeader: int32(2)}, 2},
+		{"int64", amqp.Table{AttemptHeader: int64(3)}, 3},
+		{"wrong type", amqp.Table{AttemptHeader: "4"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Attempt(tt.headers); got != tt.want {
+				t.Errorf("Attempt() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetAttemptAndReplays(t *testing.T) {
+	headers := SetAttempt(nil, 1)
+	if Attempt(headers) != 1 || Replays(headers) != 0 {
+		t.Fatalf("got attempt %d and replays %d, want 1 and 0", Attempt(headers), Replays(headers))
+	}
+
+	headers["traceparent"] = "00-abc-def-01"
+	headers = SetReplays(headers, 2)
+	if Attempt(headers) != 1 || Replays(headers) != 2 || headers["traceparent"] != "00-abc-def-01" {
+		t.Errorf("got %v, want attempt 1 and replays 2 with other headers kept", headers)
+	}
+	// Stored as int32, the integer type AMQP tables round-trip
+	if _, ok := headers[AttemptHeader].(int32); !ok {
+		t.Errorf("attempt stored as %T, want int32", headers[AttemptHeader])
+	}
+	if _, ok := headers[ReplayHeader].(int32); !ok {
+		t.Errorf("replays stored as %T, want int32", headers[ReplayHeader])
+	}
+}
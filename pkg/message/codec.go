@@ -6,25 +6,94 @@ import (
 )
 
 type Envelope struct {
-	TraceID   string          `json:"trace_id"`
-	Source    string          `json:"source"`
-	Timestamp time.Time       `json:"timestamp"`
-	Payload   json.RawMessage `json:"payload"`
+	TraceID   string    `json:"trace_id"`
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+	// SubmittedAt is when the pipeline this message belongs to was started
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+	// Reply is where a waiting caller expects the results, if anywhere
+	Reply
+	// OwnerID names who submitted the pipeline, if anyone
+	OwnerID string `json:"owner_id,omitempty"`
+	// ReceivedAt is when the consumer decoded the message, stamped by
+	// DecodeReceived. It comes from the consumer's clock, unlike Timestamp.
+	ReceivedAt *time.Time      `json:"received_at,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
 }
 
+// ClockSkew returns how far the producer's Timestamp is ahead of ReceivedAt,
+// which includes the time the message spent queued. It is zero when the
+// receive time wasn't stamped.
+func (e *Envelope) ClockSkew() time.Duration {
+	if e.ReceivedAt == nil {
+		return 0
+	}
+	return e.Timestamp.Sub(*e.ReceivedAt)
+}
+
+// Reply addresses a caller waiting for a job's results: results are also
+// published to the To queue, tagged with CorrelationID. It mirrors the AMQP
+// reply_to and correlation_id properties so the address survives paths that
+// drop message properties.
+type Reply struct {
+	To            string `json:"reply_to,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// Encode wraps payload in an envelope for a message that starts a pipeline,
+// so its submit time is now
 func Encode(traceID, source string, payload any) ([]byte, error) {
+	return EncodeSubmitted(traceID, source, payload, time.Now().UTC())
+}
+
+// EncodeWithReply is Encode for a message whose caller waits for the results
+// at reply
+func EncodeWithReply(traceID, source string, payload any, reply Reply) ([]byte, error) {
+	return encode(traceID, source, payload, time.Now().UTC(), reply)
+}
+
+// EncodeSubmitted wraps payload in an envelope that carries the pipeline's
+// submit time forward, so later stages can measure end-to-end latency. A zero
+// submittedAt leaves it unset.
+func EncodeSubmitted(traceID, source string, payload any, submittedAt time.Time) ([]byte, error) {
+	return encode(traceID, source, payload, submittedAt, Reply{})
+}
+
+// EncodeSubmittedWithReply is EncodeSubmitted for a message whose caller
+// waits for the results at reply
+func EncodeSubmittedWithReply(traceID, source string, payload any, submittedAt time.Time, reply Reply) ([]byte, error) {
+	return encode(traceID, source, payload, submittedAt, reply)
+}
+
+// encode builds and marshals an envelope around payload
+func encode(traceID, source string, payload any, submittedAt time.Time, reply Reply) ([]byte, error) {
+	env := Envelope{TraceID: traceID, Source: source, Reply: reply}
+	if !submittedAt.IsZero() {
+		env.SubmittedAt = &submittedAt
+	}
+	return EncodeEnvelope(env, payload)
+}
+
+// EncodeEnvelope marshals env around payload, stamping its Timestamp. It is
+// for producers that set envelope fields the other encoders don't take.
+func EncodeEnvelope(env Envelope, payload any) ([]byte, error) {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
+	env.Timestamp = time.Now().UTC()
+	env.Payload = body
+	return json.Marshal(env)
+}
 
-	env := Envelope{
-		TraceID:   traceID,
-		Source:    source,
-		Timestamp: time.Now().UTC(),
-		Payload:   body,
+// DecodeReceived is Decode that also stamps the envelope with receivedAt, the
+// consumer-side time the message arrived
+func DecodeReceived[T any](data []byte, receivedAt time.Time) (*Envelope, *T, error) {
+	env, payload, err := Decode[T](data)
+	if env != nil {
+		env.ReceivedAt = &receivedAt
 	}
-	return json.Marshal(env)
+	return env, payload, err
 }
 
 func Decode[T any](data []byte) (*Envelope, *T, error) {
@@ -38,3 +107,15 @@ func Decode[T any](data []byte) (*Envelope, *T, error) {
 	}
 	return &env, &payload, nil
 }
+
+// TraceID returns the trace ID of an encoded envelope, or "" when data isn't
+// one. It is for logging about messages that may not decode.
+func TraceID(data []byte) string {
+	var env struct {
+		TraceID string `json:"trace_id"`
+	}
+	if json.Unmarshal(data, &env) != nil {
+		return ""
+	}
+	return env.TraceID
+}
@@ -0,0 +1,68 @@
+package message
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPayload struct {
+	URL string `json:"url"`
+}
+
+func TestEncodeWithReplyRoundTrip(t *testing.T) {
+	reply := Reply{To: "amq.gen-replies", CorrelationID: "corr-1"}
+	data, err := EncodeWithReply("trace-1", "url-ingestor", testPayload{URL: "http://example.com/a.jpg"}, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, payload, err := Decode[testPayload](data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Reply != reply {
+		t.Errorf("reply = %+v, want %+v", env.Reply, reply)
+	}
+	if env.TraceID != "trace-1" || env.SubmittedAt == nil || payload.URL != "http://example.com/a.jpg" {
+		t.Errorf("unexpected envelope %+v with payload %+v", env, payload)
+	}
+}
+
+func TestEncodeOmitsEmptyReply(t *testing.T) {
+	data, err := Encode("trace-1", "url-ingestor", testPayload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "reply_to") || strings.Contains(string(data), "correlation_id") {
+		t.Errorf("expected no reply fields, got %s", data)
+	}
+}
+
+func TestDecodeReceivedStampsReceiveTime(t *testing.T) {
+	data, err := Encode("trace-1", "image-fetcher", testPayload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, _, err := Decode[testPayload](data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.ReceivedAt != nil || env.ClockSkew() != 0 {
+		t.Errorf("expected Decode to leave the receive time unset, got %v", env.ReceivedAt)
+	}
+
+	// A consumer whose clock is a minute behind the producer's
+	receivedAt := env.Timestamp.Add(-time.Minute)
+	env, _, err = DecodeReceived[testPayload](data, receivedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.ReceivedAt == nil || !env.ReceivedAt.Equal(receivedAt) {
+		t.Fatalf("ReceivedAt = %v, want %v", env.ReceivedAt, receivedAt)
+	}
+	if skew := env.ClockSkew(); skew != time.Minute {
+		t.Errorf("ClockSkew() = %s, want 1m", skew)
+	}
+}
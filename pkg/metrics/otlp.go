@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// InitOTLP periodically exports the metrics registered with the default
+// Prometheus registry to an OTLP/HTTP endpoint. The Prometheus /metrics
+// endpoint keeps working unchanged; this only adds a push path.
+func InitOTLP(serviceName, endpoint string, interval time.Duration) (*sdkmetric.MeterProvider, error) {
+	exp, err := otlpmetrichttp.New(context.Background(),
+		otlpmetrichttp.WithEndpointURL(endpoint),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP metrics exporter: %w", err)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(
+			semconv.ServiceNameKey.String(serviceName),
+			semconv.ServiceVersionKey.String("1.0.0"),
+		),
+	)
+	if err != nil {
+		log.Printf("Failed to create resource: %v", err)
+		res = resource.Default()
+	}
+
+	reader := sdkmetric.NewPeriodicReader(exp,
+		sdkmetric.WithInterval(interval),
+		sdkmetric.WithProducer(newPrometheusProducer(prometheus.DefaultGatherer)),
+	)
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(res),
+	)
+
+	log.Printf("OTLP metrics export initialized for service %s -> %s", serviceName, endpoint)
+	return provider, nil
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// prometheusProducer mirrors counters, gauges and histograms from a
+// Prometheus gatherer as OpenTelemetry metric data, so existing
+// instrumentation doesn't have to record every measurement twice
+type prometheusProducer struct {
+	gatherer prometheus.Gatherer
+	start    time.Time
+}
+
+func newPrometheusProducer(g prometheus.Gatherer) *prometheusProducer {
+	return &prometheusProducer{gatherer: g, start: time.Now()}
+}
+
+// Produce gathers the current Prometheus metrics and converts them.
+// Summaries and untyped metrics are skipped.
+func (p *prometheusProducer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	metrics := make([]metricdata.Metrics, 0, len(families))
+	for _, mf := range families {
+		m := metricdata.Metrics{Name: mf.GetName(), Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+			for _, pm := range mf.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: labelSet(pm.GetLabel()),
+					StartTime:  p.start,
+					Time:       now,
+					Value:      pm.GetCounter().GetValue(),
+				})
+			}
+			m.Data = sum
+		case dto.MetricType_GAUGE:
+			gauge := metricdata.Gauge[float64]{}
+			for _, pm := range mf.GetMetric() {
+				gauge.DataPoints = append(gauge.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: labelSet(pm.GetLabel()),
+					Time:       now,
+					Value:      pm.GetGauge().GetValue(),
+				})
+			}
+			m.Data = gauge
+		case dto.MetricType_HISTOGRAM:
+			hist := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+			for _, pm := range mf.GetMetric() {
+				hist.DataPoints = append(hist.DataPoints, histogramDataPoint(pm, p.start, now))
+			}
+			m.Data = hist
+		default:
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+
+	return []metricdata.ScopeMetrics{{
+		Scope:   instrumentation.Scope{Name: "image-processing-system/pkg/metrics"},
+		Metrics: metrics,
+	}}, nil
+}
+
+// histogramDataPoint converts Prometheus cumulative buckets into per-bucket counts
+func histogramDataPoint(pm *dto.Metric, start, now time.Time) metricdata.HistogramDataPoint[float64] {
+	h := pm.GetHistogram()
+	var bounds []float64
+	var counts []uint64
+	var prev uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+		bounds = append(bounds, b.GetUpperBound())
+		counts = append(counts, b.GetCumulativeCount()-prev)
+		prev = b.GetCumulativeCount()
+	}
+	// Overflow bucket for observations above the highest bound
+	counts = append(counts, h.GetSampleCount()-prev)
+
+	return metricdata.HistogramDataPoint[float64]{
+		Attributes:   labelSet(pm.GetLabel()),
+		StartTime:    start,
+		Time:         now,
+		Count:        h.GetSampleCount(),
+		Bounds:       bounds,
+		BucketCounts: counts,
+		Sum:          h.GetSampleSum(),
+	}
+}
+
+func labelSet(labels []*dto.LabelPair) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(labels))
+	for _, l := range labels {
+		kvs = append(kvs, attribute.String(l.GetName(), l.GetValue()))
+	}
+	return attribute.NewSet(kvs...)
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestPrometheusProducer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "jobs_total", Help: "jobs"}, []string{"status"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "in_flight", Help: "in flight"})
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration_seconds", Help: "duration", Buckets: []float64{1, 5}})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "skipped", Help: "summaries aren't mirrored"})
+	reg.MustRegister(counter, gauge, hist, summary)
+
+	counter.WithLabelValues("success").Add(3)
+	gauge.Set(2)
+	for _, v := range []float64{0.5, 2, 3, 10} {
+		hist.Observe(v)
+	}
+	summary.Observe(1)
+
+	scopes, err := newPrometheusProducer(reg).Produce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]metricdata.Aggregation)
+	for _, m := range scopes[0].Metrics {
+		byName[m.Name] = m.Data
+	}
+
+	tests := []struct {
+		name  string
+		check func(metricdata.Aggregation) bool
+	}{
+		{"jobs_total", func(a metricdata.Aggregation) bool {
+			sum, ok := a.(metricdata.Sum[float64])
+			if !ok || !sum.IsMonotonic || len(sum.DataPoints) != 1 {
+				return false
+			}
+			status, _ := sum.DataPoints[0].Attributes.Value(attribute.Key("status"))
+			return sum.DataPoints[0].Value == 3 && status.AsString() == "success"
+		}},
+		{"in_flight", func(a metricdata.Aggregation) bool {
+			g, ok := a.(metricdata.Gauge[float64])
+			return ok && len(g.DataPoints) == 1 && g.DataPoints[0].Value == 2
+		}},
+		{"duration_seconds", func(a metricdata.Aggregation) bool {
+			h, ok := a.(metricdata.Histogram[float64])
+			if !ok || len(h.DataPoints) != 1 {
+				return false
+			}
+			dp := h.DataPoints[0]
+			// Per-bucket counts, with the overflow bucket last
+			return dp.Count == 4 && dp.Sum == 15.5 &&
+				slices.Equal(dp.Bounds, []float64{1, 5}) && slices.Equal(dp.BucketCounts, []uint64{1, 2, 1})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, ok := byName[tt.name]
+			if !ok {
+				t.Fatalf("%s was not mirrored", tt.name)
+			}
+			if !tt.check(data) {
+				t.Errorf("unexpected data for %s: %+v", tt.name, data)
+			}
+		})
+	}
+	if _, ok := byName["skipped"]; ok {
+		t.Error("expected summaries to be skipped")
+	}
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pending holds the metrics packages have added until Register names them
+var (
+	mu      sync.Mutex
+	pending []prometheus.Collector
+)
+
+// Add queues collectors for Register. Packages call it from init instead of
+// prometheus.MustRegister, since the service's namespace isn't known until
+// its config is loaded.
+func Add(cs ...prometheus.Collector) {
+	mu.Lock()
+	defer mu.Unlock()
+	pending = append(pending, cs...)
+}
+
+// Register registers the added metrics with reg, their names prefixed with
+// namespace and subsystem the way prometheus.Opts would prefix them, e.g.
+// "image_fetcher_images_processed_total". Empty parts are left out.
+func Register(reg prometheus.Registerer, namespace, subsystem string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if prefix := Prefix(namespace, subsystem); prefix != "" {
+		reg = prometheus.WrapRegistererWithPrefix(prefix, reg)
+	}
+	for len(pending) > 0 {
+		if err := reg.Register(pending[0]); err != nil {
+			return err
+		}
+		pending = pending[1:]
+	}
+	return nil
+}
+
+// Prefix returns what Register puts in front of metric names
+func Prefix(namespace, subsystem string) string {
+	var parts []string
+	for _, p := range []string{namespace, subsystem} {
+		if p != "" {
+			parts = append(parts, p+"_")
+		}
+	}
+	return strings.Join(parts, "")
+}
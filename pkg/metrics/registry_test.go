@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterPrefixesNames(t *testing.T) {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "jobs_total", Help: "test"})
+	Add(counter)
+
+	reg := prometheus.NewRegistry()
+	if err := Register(reg, "image_fetcher", "worker"); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetName() != "image_fetcher_worker_jobs_total" {
+		t.Errorf("expected image_fetcher_worker_jobs_total, got %v", families)
+	}
+
+	// Metrics are only registered once
+	if err := Register(prometheus.NewRegistry(), "", ""); err != nil || len(pending) != 0 {
+		t.Errorf("expected nothing left to register, got %d (%v)", len(pending), err)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	tests := []struct{ namespace, subsystem, want string }{
+		{"url_ingestor", "", "url_ingestor_"},
+		{"url_ingestor", "http", "url_ingestor_http_"},
+		{"", "http", "http_"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := Prefix(tt.namespace, tt.subsystem); got != tt.want {
+			t.Errorf("Prefix(%q, %q) = %q, want %q", tt.namespace, tt.subsystem, got, tt.want)
+		}
+	}
+}
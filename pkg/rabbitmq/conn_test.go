@@ -0,0 +1,35 @@
+package rabbitmq
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDeclareErrorNamesTheConflictingArgument(t *testing.T) {
+	args := amqp.Table{"x-dead-letter-exchange": "", "x-dead-letter-routing-key": "image.urls.dlq", "x-max-priority": 10}
+	cause := &amqp.Error{
+		Code:   amqp.PreconditionFailed,
+		Reason: "PRECONDITION_FAILED - inequivalent arg 'x-max-priority' for queue 'image.urls' in vhost '/': received the value '10' of type 'signedint' but current is none",
+	}
+
+	err := declareError("image.urls", args, cause)
+
+	for _, want := range []string{"image.urls", "different x-max-priority", "x-dead-letter-exchange, x-dead-letter-routing-key, x-max-priority", "delete or migrate"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %q", want, err)
+		}
+	}
+	if !errors.Is(err, cause) {
+		t.Error("expected the broker's error to be wrapped")
+	}
+}
+
+func TestDeclareErrorKeepsOtherErrors(t *testing.T) {
+	cause := &amqp.Error{Code: amqp.ChannelError, Reason: "CHANNEL_ERROR"}
+	if err := declareError("image.urls", nil, cause); err != cause {
+		t.Errorf("expected %v unchanged, got %v", cause, err)
+	}
+}
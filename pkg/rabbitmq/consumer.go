@@ -0,0 +1,221 @@
+package rabbitmq
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"image-processing-system/pkg/logging"
+	"image-processing-system/pkg/message"
+	"image-processing-system/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var (
+	messagesConsumed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "messages_consumed_total",
+			Help: "Total number of deliveries handled, by consumer and how they were settled",
+		},
+		[]string{"consumer", "decision"},
+	)
+
+	messageHandleDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "message_handle_duration_seconds",
+			Help:    "Time to handle and settle a delivery in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+		},
+		[]string{"consumer"},
+	)
+
+	handlerPanics = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "consumer_handler_panics_total",
+			Help: "Total number of deliveries whose handler panicked and was dead-lettered",
+		},
+		[]string{"consumer"},
+	)
+)
+
+func init() {
+	metrics.Add(messagesConsumed, messageHandleDuration, handlerPanics)
+}
+
+// Decision is how a Consumer settles a delivery once its handler returns
+type Decision int
+
+const (
+	// Ack acknowledges the delivery
+	Ack Decision = iota
+	// Requeue returns the delivery to its queue for redelivery
+	Requeue
+	// DeadLetter rejects the delivery so the broker routes it to the DLQ
+	DeadLetter
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Ack:
+		return "ack"
+	case Requeue:
+		return "requeue"
+	default:
+		return "dead_letter"
+	}
+}
+
+// Handler processes a delivery and decides how it is settled. ctx carries
+// the trace context propagated in the delivery's headers.
+type Handler func(ctx context.Context, msg amqp.Delivery) Decision
+
+// Acker acknowledges deliveries on a Consumer's behalf, e.g. in batches
+type Acker interface {
+	// Ack acknowledges m, now or later
+	Ack(m amqp.Delivery)
+	// Rejected records that tag was settled by a nack
+	Rejected(tag uint64)
+}
+
+// ConsumerOptions tune how a Consumer runs its handler
+type ConsumerOptions struct {
+	// Concurrency is how many deliveries are handled at once; 0 or 1
+	// handles them one at a time, in order
+	Concurrency int
+	// Acker acknowledges deliveries; nil acks each one as it is handled
+	Acker Acker
+	// ShutdownGrace bounds how long Run waits for the deliveries in flight
+	// once the stream closes; 0 waits for them indefinitely
+	ShutdownGrace time.Duration
+}
+
+// Consumer runs a Handler over a stream of deliveries and settles each one
+// by the handler's Decision. It extracts the propagated trace context,
+// records metrics, and turns a handler panic into a dead-lettered delivery
+// instead of a crashed process.
+type Consumer struct {
+	name    string
+	handler Handler
+	opts    ConsumerOptions
+}
+
+// NewConsumer returns a Consumer that runs handler. name labels its logs
+// and metrics, usually the service name.
+func NewConsumer(name string, handler Handler, opts ConsumerOptions) *Consumer {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	return &Consumer{name: name, handler: handler, opts: opts}
+}
+
+// Run handles deliveries from msgs until it is closed, then waits for the
+// ones in flight. It reports false when some were still running after
+// ShutdownGrace.
+func (c *Consumer) Run(msgs <-chan amqp.Delivery) bool {
+	if c.opts.Concurrency == 1 {
+		for msg := range msgs {
+			c.Handle(msg)
+		}
+		return true
+	}
+
+	sem := make(chan struct{}, c.opts.Concurrency)
+	var wg sync.WaitGroup
+	for msg := range msgs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(m amqp.Delivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			c.Handle(m)
+		}(msg)
+	}
+	return waitInFlight(&wg, c.opts.ShutdownGrace)
+}
+
+// Handle runs the handler for one delivery and settles it
+func (c *Consumer) Handle(m amqp.Delivery) {
+	start := time.Now()
+	decision := c.call(extractTrace(context.Background(), m.Headers, c.name), m)
+	c.settle(m, decision)
+	messagesConsumed.WithLabelValues(c.name, decision.String()).Inc()
+	messageHandleDuration.WithLabelValues(c.name).Observe(time.Since(start).Seconds())
+}
+
+// call runs the handler, dead-lettering the delivery if it panics so one
+// bad message can't take the consumer down
+func (c *Consumer) call(ctx context.Context, m amqp.Delivery) (decision Decision) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[%s] Handler panicked on trace %q, dead-lettering the message: %v\n%s", c.name, message.TraceID(m.Body), r, debug.Stack())
+			handlerPanics.WithLabelValues(c.name).Inc()
+			decision = DeadLetter
+		}
+	}()
+	return c.handler(ctx, m)
+}
+
+// settle acknowledges or rejects m by decision
+func (c *Consumer) settle(m amqp.Delivery, decision Decision) {
+	if decision == Ack {
+		if c.opts.Acker != nil {
+			c.opts.Acker.Ack(m)
+			return
+		}
+		if err := m.Ack(false); err != nil {
+			log.Printf("Failed to ack message: %v", err)
+		}
+		return
+	}
+
+	// The acker may only pass over the tag once the nack has been sent
+	if c.opts.Acker != nil {
+		defer c.opts.Acker.Rejected(m.DeliveryTag)
+	}
+	if err := m.Nack(false, decision == Requeue); err != nil {
+		log.Printf("Failed to nack message: %v", err)
+	}
+}
+
+// extractTrace returns ctx with the trace context propagated in headers,
+// which brokers may hand back as strings or raw bytes
+func extractTrace(ctx context.Context, headers amqp.Table, name string) context.Context {
+	carrier := propagation.MapCarrier{}
+	for k, v := range headers {
+		switch val := v.(type) {
+		case string:
+			carrier[k] = val
+		case []byte:
+			carrier[k] = string(val)
+		}
+	}
+	if tp, ok := carrier["traceparent"]; ok {
+		logging.Debugf("[%s] Consumed traceparent: %s", name, tp)
+	}
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
+
+// waitInFlight waits for the deliveries in wg for at most grace, or
+// indefinitely when grace is 0, and reports whether they all finished
+func waitInFlight(wg *sync.WaitGroup, grace time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if grace <= 0 {
+		<-done
+		return true
+	}
+	select {
+	case <-done:
+		return true
+	case <-time.After(grace):
+		return false
+	}
+}
@@ -0,0 +1,168 @@
+package rabbitmq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// settlement is how a delivery was settled with the broker
+type settlement struct {
+	acked, nacked, requeued bool
+}
+
+func (s *settlement) Ack(tag uint64, multiple bool) error {
+	s.acked = true
+	return nil
+}
+
+func (s *settlement) Nack(tag uint64, multiple, requeue bool) error {
+	s.nacked, s.requeued = true, requeue
+	return nil
+}
+
+func (s *settlement) Reject(tag uint64, requeue bool) error {
+	return s.Nack(tag, false, requeue)
+}
+
+// recordingAcker records the deliveries it is handed
+type recordingAcker struct {
+	acked, rejected []uint64
+}
+
+func (a *recordingAcker) Ack(m amqp.Delivery) { a.acked = append(a.acked, m.DeliveryTag) }
+
+func (a *recordingAcker) Rejected(tag uint64) { a.rejected = append(a.rejected, tag) }
+
+func TestConsumerSettlesByDecision(t *testing.T) {
+	tests := []struct {
+		decision Decision
+		want     settlement
+	}{
+		{Ack, settlement{acked: true}},
+		{Requeue, settlement{nacked: true, requeued: true}},
+		{DeadLetter, settlement{nacked: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.decision.String(), func(t *testing.T) {
+			c := NewConsumer("test", func(context.Context, amqp.Delivery) Decision { return tt.decision }, ConsumerOptions{})
+			s := &settlement{}
+			c.Handle(amqp.Delivery{Acknowledger: s})
+			if *s != tt.want {
+				t.Errorf("settled %+v, want %+v", *s, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsumerAcker(t *testing.T) {
+	decisions := map[uint64]Decision{1: Ack, 2: DeadLetter}
+	acker := &recordingAcker{}
+	c := NewConsumer("test", func(_ context.Context, m amqp.Delivery) Decision {
+		return decisions[m.DeliveryTag]
+	}, ConsumerOptions{Acker: acker})
+
+	first, second := &settlement{}, &settlement{}
+	c.Handle(amqp.Delivery{Acknowledger: first, DeliveryTag: 1})
+	c.Handle(amqp.Delivery{Acknowledger: second, DeliveryTag: 2})
+
+	if first.acked || len(acker.acked) != 1 || acker.acked[0] != 1 {
+		t.Errorf("expected the ack to go through the acker, acked=%v acker=%v", first.acked, acker.acked)
+	}
+	if !second.nacked || len(acker.rejected) != 1 || acker.rejected[0] != 2 {
+		t.Errorf("expected the nack to be sent and reported, nacked=%v rejected=%v", second.nacked, acker.rejected)
+	}
+}
+
+func TestConsumerRecoversPanics(t *testing.T) {
+	c := NewConsumer("panicky", func(context.Context, amqp.Delivery) Decision {
+		var img *struct{ width int }
+		_ = img.width
+		return Ack
+	}, ConsumerOptions{})
+	before := testutil.ToFloat64(handlerPanics.WithLabelValues("panicky"))
+
+	s := &settlement{}
+	c.Handle(amqp.Delivery{Acknowledger: s})
+
+	if !s.nacked || s.requeued {
+		t.Errorf("expected the panicking delivery to be dead-lettered, got %+v", *s)
+	}
+	if got := testutil.ToFloat64(handlerPanics.WithLabelValues("panicky")) - before; got != 1 {
+		t.Errorf("panics counter increased by %v, want 1", got)
+	}
+}
+
+func TestConsumerExtractsTrace(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+
+	for name, value := range map[string]any{"string": traceparent, "bytes": []byte(traceparent)} {
+		t.Run(name, func(t *testing.T) {
+			var got string
+			c := NewConsumer("test", func(ctx context.Context, _ amqp.Delivery) Decision {
+				got = trace.SpanContextFromContext(ctx).TraceID().String()
+				return Ack
+			}, ConsumerOptions{})
+			c.Handle(amqp.Delivery{Acknowledger: &settlement{}, Headers: amqp.Table{"traceparent": value}})
+			if got != traceID {
+				t.Errorf("handler saw trace %q, want %s", got, traceID)
+			}
+		})
+	}
+}
+
+func TestConsumerRunConcurrently(t *testing.T) {
+	const n = 4
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(n)
+	c := NewConsumer("test", func(context.Context, amqp.Delivery) Decision {
+		started.Done()
+		<-release
+		return Ack
+	}, ConsumerOptions{Concurrency: n})
+
+	msgs := make(chan amqp.Delivery, n)
+	acks := make([]*settlement, n)
+	for i := range acks {
+		acks[i] = &settlement{}
+		msgs <- amqp.Delivery{Acknowledger: acks[i]}
+	}
+	close(msgs)
+
+	done := make(chan bool)
+	go func() { done <- c.Run(msgs) }()
+	// Every delivery is in its handler at once, or this never returns
+	started.Wait()
+	close(release)
+	if !<-done {
+		t.Fatal("expected Run to wait for every delivery")
+	}
+	for i, s := range acks {
+		if !s.acked {
+			t.Errorf("delivery %d was not acked", i)
+		}
+	}
+}
+
+func TestWaitInFlightGivesUpAfterGrace(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	if waitInFlight(&wg, 10*time.Millisecond) {
+		t.Fatal("expected a stuck delivery to outlast the grace period")
+	}
+
+	wg.Done()
+	if !waitInFlight(&wg, time.Second) {
+		t.Error("expected finished deliveries to be waited for")
+	}
+	if !waitInFlight(&wg, 0) {
+		t.Error("expected no grace period to wait until the deliveries finish")
+	}
+}
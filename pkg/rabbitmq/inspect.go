@@ -0,0 +1,43 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Inspector reads queue depths over its own channel. A passive declare of a
+// missing queue closes the channel it runs on, so inspections are kept off
+// the channels used for publishing and consuming; the channel is reopened
+// after such a failure.
+type Inspector struct {
+	conn *amqp.Connection
+	mu   sync.Mutex
+	ch   *amqp.Channel
+}
+
+// NewInspector creates an inspector on conn
+func NewInspector(conn *amqp.Connection) *Inspector {
+	return &Inspector{conn: conn}
+}
+
+// QueueDepth returns the number of ready messages in queue
+func (i *Inspector) QueueDepth(queue string) (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.ch == nil || i.ch.IsClosed() {
+		ch, err := i.conn.Channel()
+		if err != nil {
+			return 0, fmt.Errorf("open inspection channel: %w", err)
+		}
+		i.ch = ch
+	}
+
+	q, err := i.ch.QueueDeclarePassive(queue, false, false, false, false, nil)
+	if err != nil {
+		return 0, fmt.Errorf("inspect %s: %w", queue, err)
+	}
+	return q.Messages, nil
+}
@@ -0,0 +1,93 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	"image-processing-system/pkg/logging"
+	"image-processing-system/pkg/message"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// PublishChannel is the subset of *amqp.Channel a Publisher uses
+type PublishChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// PublishOptions are the envelope fields and message properties a publish
+// sets beyond its payload
+type PublishOptions struct {
+	// SubmittedAt is carried in the envelope; zero leaves it unset
+	SubmittedAt time.Time
+	// Reply is where the consumer should send the results. It goes in the
+	// envelope and in the reply_to and correlation_id properties.
+	Reply message.Reply
+	// OwnerID is carried in the envelope; empty leaves it unset
+	OwnerID string
+	// CorrelationID tags a message that answers a caller's reply address
+	CorrelationID string
+	Priority      uint8
+	// Expiration is the per-message TTL in milliseconds, as AMQP expects it
+	Expiration string
+}
+
+// Publisher wraps payloads in the message envelope and publishes them with
+// the trace context of the caller's ctx in the AMQP headers, so every service
+// encodes and propagates traces the same way
+type Publisher struct {
+	ch PublishChannel
+}
+
+// NewPublisher returns a Publisher that publishes on ch
+func NewPublisher(ch PublishChannel) *Publisher {
+	return &Publisher{ch: ch}
+}
+
+// Publish sends payload to key on exchange in an envelope for a message that
+// starts a pipeline, so its submit time is now
+func (p *Publisher) Publish(ctx context.Context, exchange, key string, traceID, source string, payload any) error {
+	return p.PublishWith(ctx, exchange, key, traceID, source, payload, PublishOptions{SubmittedAt: time.Now().UTC()})
+}
+
+// PublishWith is Publish with the envelope fields and properties in opts
+func (p *Publisher) PublishWith(ctx context.Context, exchange, key string, traceID, source string, payload any, opts PublishOptions) error {
+	env := message.Envelope{TraceID: traceID, Source: source, Reply: opts.Reply, OwnerID: opts.OwnerID}
+	if !opts.SubmittedAt.IsZero() {
+		env.SubmittedAt = &opts.SubmittedAt
+	}
+	encoded, err := message.EncodeEnvelope(env, payload)
+	if err != nil {
+		return err
+	}
+
+	correlationID := opts.Reply.CorrelationID
+	if opts.CorrelationID != "" {
+		correlationID = opts.CorrelationID
+	}
+	return p.ch.Publish(exchange, key, false, false, amqp.Publishing{
+		ContentType:   "application/json",
+		Body:          encoded,
+		Headers:       traceHeaders(ctx, source),
+		Priority:      opts.Priority,
+		Expiration:    opts.Expiration,
+		ReplyTo:       opts.Reply.To,
+		CorrelationId: correlationID,
+	})
+}
+
+// traceHeaders injects ctx's trace context into a table of AMQP headers
+func traceHeaders(ctx context.Context, source string) amqp.Table {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if tp, ok := carrier["traceparent"]; ok {
+		logging.Debugf("[%s] Injecting traceparent: %s", source, tp)
+	}
+
+	headers := amqp.Table{}
+	for k, v := range carrier {
+		headers[k] = v
+	}
+	return headers
+}
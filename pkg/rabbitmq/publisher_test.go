@@ -0,0 +1,85 @@
+package rabbitmq
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"image-processing-system/pkg/message"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordingChannel records the messages published on it
+type recordingChannel struct {
+	keys      []string
+	published []amqp.Publishing
+}
+
+func (c *recordingChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.keys = append(c.keys, key)
+	c.published = append(c.published, msg)
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	ch := &recordingChannel{}
+	p := NewPublisher(ch)
+	submittedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	reply := message.Reply{To: "amq.gen-replies", CorrelationID: "corr-1"}
+	opts := PublishOptions{SubmittedAt: submittedAt, Reply: reply, Priority: 7, Expiration: "1500"}
+	if err := p.PublishWith(ctx, "", "jobs", "trace-1", "test", map[string]string{"k": "v"}, opts); err != nil {
+		t.Fatalf("PublishWith failed: %v", err)
+	}
+	if err := p.Publish(context.Background(), "", "results", "trace-2", "test", nil); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if len(ch.published) != 2 || ch.keys[0] != "jobs" || ch.keys[1] != "results" {
+		t.Fatalf("published to %v, want [jobs results]", ch.keys)
+	}
+
+	msg := ch.published[0]
+	if tp, _ := msg.Headers["traceparent"].(string); !strings.Contains(tp, traceID.String()) {
+		t.Errorf("traceparent = %q, want one in trace %s", tp, traceID)
+	}
+	if msg.ContentType != "application/json" || msg.Priority != 7 || msg.Expiration != "1500" {
+		t.Errorf("unexpected properties: %+v", msg)
+	}
+	if msg.ReplyTo != reply.To || msg.CorrelationId != reply.CorrelationID {
+		t.Errorf("reply properties = %q/%q, want %+v", msg.ReplyTo, msg.CorrelationId, reply)
+	}
+	env, payload, err := message.Decode[map[string]string](msg.Body)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if env.TraceID != "trace-1" || env.Source != "test" || env.Reply != reply || (*payload)["k"] != "v" {
+		t.Errorf("unexpected envelope %+v with payload %v", env, payload)
+	}
+	if env.SubmittedAt == nil || !env.SubmittedAt.Equal(submittedAt) {
+		t.Errorf("submitted_at = %v, want %v", env.SubmittedAt, submittedAt)
+	}
+
+	// Without a span there is nothing to propagate, and Publish stamps the
+	// submit time itself
+	msg = ch.published[1]
+	if _, ok := msg.Headers["traceparent"]; ok {
+		t.Errorf("expected no traceparent without a span, got %v", msg.Headers)
+	}
+	env, _, err = message.Decode[any](msg.Body)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if env.SubmittedAt == nil {
+		t.Error("expected Publish to set submitted_at")
+	}
+}
@@ -0,0 +1,108 @@
+package rabbitmq
+
+import (
+	"fmt"
+
+	"image-processing-system/pkg/message"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ReplayResult summarises a dead-letter replay
+type ReplayResult struct {
+	// Replayed messages were republished to the main queue
+	Replayed int
+	// Skipped messages reached the replay limit and stay in the DLQ
+	Skipped int
+}
+
+// ReplayDeadLetters moves the messages currently in queue's DLQ back onto
+// queue, incrementing their replay header and resetting their attempts.
+// Messages already replayed maxReplays times are left in the DLQ;
+// maxReplays <= 0 disables the limit.
+func ReplayDeadLetters(ch *amqp.Channel, queue string, maxReplays int) (ReplayResult, error) {
+	var result ReplayResult
+	dlq := DeadLetterQueue(queue)
+
+	q, err := ch.QueueDeclarePassive(dlq, false, false, false, false, nil)
+	if err != nil {
+		return result, fmt.Errorf("inspect %s: %w", dlq, err)
+	}
+
+	// Skipped messages stay unacked until the end so Get doesn't return them again
+	var skipped []amqp.Delivery
+	defer func() {
+		for _, msg := range skipped {
+			msg.Nack(false, true)
+		}
+	}()
+
+	// Only drain what was there at the start, so messages that fail again
+	// while we run aren't replayed in a loop
+	for i := 0; i < q.Messages; i++ {
+		msg, ok, err := ch.Get(dlq, false)
+		if err != nil {
+			return result, fmt.Errorf("get from %s: %w", dlq, err)
+		}
+		if !ok {
+			break
+		}
+
+		pub, ok := replayPublishing(msg, maxReplays)
+		if !ok {
+			skipped = append(skipped, msg)
+			result.Skipped++
+			continue
+		}
+
+		if err := ch.Publish("", queue, false, false, pub); err != nil {
+			msg.Nack(false, true)
+			return result, fmt.Errorf("republish to %s: %w", queue, err)
+		}
+		if err := msg.Ack(false); err != nil {
+			return result, fmt.Errorf("ack %s: %w", dlq, err)
+		}
+		result.Replayed++
+	}
+
+	return result, nil
+}
+
+// replayPublishing copies a dead-lettered delivery for replay, counting the
+// replay and resetting its attempts so consumers retry it afresh. It reports
+// false when the message was already replayed maxReplays times.
+func replayPublishing(msg amqp.Delivery, maxReplays int) (amqp.Publishing, bool) {
+	replays := message.Replays(msg.Headers)
+	if maxReplays > 0 && replays >= maxReplays {
+		return amqp.Publishing{}, false
+	}
+	pub := Republish(msg, 0)
+	pub.Headers = message.SetReplays(pub.Headers, replays+1)
+	return pub, true
+}
+
+// Republish copies a delivery into a new publishing with the attempt header
+// set. The broker-managed x-death history is dropped.
+func Republish(msg amqp.Delivery, attempt int) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		if k == "x-death" || k == "x-first-death-exchange" || k == "x-first-death-queue" || k == "x-first-death-reason" {
+			continue
+		}
+		headers[k] = v
+	}
+
+	return amqp.Publishing{
+		Headers:         message.SetAttempt(headers, attempt),
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		Body:            msg.Body,
+	}
+}
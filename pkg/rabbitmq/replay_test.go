@@ -0,0 +1,72 @@
+package rabbitmq
+
+import (
+	"testing"
+
+	"image-processing-system/pkg/message"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRepublish(t *testing.T) {
+	msg := amqp.Delivery{
+		Headers: amqp.Table{
+			"traceparent":         "00-abc-def-01",
+			"x-death":             []interface{}{amqp.Table{"count": int64(1)}},
+			message.AttemptHeader: int32(1),
+		},
+		ContentType: "application/json",
+		Priority:    5,
+		Body:        []byte(`{}`),
+	}
+
+	pub := Republish(msg, 2)
+
+	if got := message.Attempt(pub.Headers); got != 2 {
+		t.Errorf("attempt header = %d, want 2", got)
+	}
+	if _, ok := pub.Headers["x-death"]; ok {
+		t.Error("expected x-death to be dropped")
+	}
+	if pub.Headers["traceparent"] != "00-abc-def-01" {
+		t.Error("expected traceparent to be preserved")
+	}
+	if pub.Priority != 5 || pub.ContentType != "application/json" || string(pub.Body) != "{}" {
+		t.Errorf("expected message properties to be preserved, got %+v", pub)
+	}
+}
+
+func TestReplayPublishingAfterExhaustedRetries(t *testing.T) {
+	// A job dead-lettered by the worker after using up its retries
+	msg := amqp.Delivery{
+		Headers: amqp.Table{message.AttemptHeader: int32(3)},
+		Body:    []byte(`{}`),
+	}
+
+	pub, ok := replayPublishing(msg, 3)
+	if !ok {
+		t.Fatal("expected a job that exhausted its retries to be replayed")
+	}
+	if got := message.Replays(pub.Headers); got != 1 {
+		t.Errorf("replay header = %d, want 1", got)
+	}
+	if got := message.Attempt(pub.Headers); got != 0 {
+		t.Errorf("attempt header = %d, want it reset to 0", got)
+	}
+
+	// Its retries don't count towards the replay limit, its replays do
+	msg.Headers = pub.Headers
+	msg.Headers[message.AttemptHeader] = int32(3)
+	for replays := 1; replays < 3; replays++ {
+		if pub, ok = replayPublishing(msg, 3); !ok {
+			t.Fatalf("replay %d: expected the message to be replayed", replays+1)
+		}
+		msg.Headers = pub.Headers
+	}
+	if _, ok := replayPublishing(msg, 3); ok {
+		t.Error("expected a message replayed 3 times to stay in the DLQ")
+	}
+	if _, ok := replayPublishing(msg, 0); !ok {
+		t.Error("expected no limit with maxReplays 0")
+	}
+}
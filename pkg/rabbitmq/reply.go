@@ -0,0 +1,76 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Replies consumes a private reply queue and hands each message to whoever
+// registered its correlation ID. Messages nobody is waiting for, e.g. replies
+// that arrive after a timeout, are dropped.
+type Replies struct {
+	queue   string
+	mu      sync.Mutex
+	waiters map[string]chan amqp.Delivery
+}
+
+// ConsumeReplies declares an exclusive, server-named reply queue on ch and
+// starts dispatching its messages. The queue is deleted when ch closes.
+func ConsumeReplies(ch *amqp.Channel, consumerTag string) (*Replies, error) {
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("declare reply queue: %w", err)
+	}
+	msgs, err := ch.Consume(q.Name, consumerTag, true, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("consume reply queue: %w", err)
+	}
+
+	r := newReplies(q.Name)
+	go r.dispatch(msgs)
+	return r, nil
+}
+
+func newReplies(queue string) *Replies {
+	return &Replies{queue: queue, waiters: make(map[string]chan amqp.Delivery)}
+}
+
+// Queue returns the name replies should be published to
+func (r *Replies) Queue() string {
+	return r.queue
+}
+
+// Register returns a channel receiving up to buffer replies for
+// correlationID, and a function that stops delivery once the caller is done
+func (r *Replies) Register(correlationID string, buffer int) (<-chan amqp.Delivery, func()) {
+	ch := make(chan amqp.Delivery, buffer)
+	r.mu.Lock()
+	r.waiters[correlationID] = ch
+	r.mu.Unlock()
+
+	return ch, func() {
+		r.mu.Lock()
+		delete(r.waiters, correlationID)
+		r.mu.Unlock()
+	}
+}
+
+// dispatch routes each message to its waiter without blocking on slow ones
+func (r *Replies) dispatch(msgs <-chan amqp.Delivery) {
+	for msg := range msgs {
+		r.mu.Lock()
+		ch, ok := r.waiters[msg.CorrelationId]
+		if ok {
+			select {
+			case ch <- msg:
+			default:
+				log.Printf("Dropping reply for %s: waiter is full", msg.CorrelationId)
+			}
+		}
+		r.mu.Unlock()
+	}
+	log.Printf("Reply queue %s closed", r.queue)
+}
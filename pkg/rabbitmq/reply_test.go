@@ -0,0 +1,36 @@
+package rabbitmq
+
+import (
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRepliesDispatch(t *testing.T) {
+	r := newReplies("amq.gen-test")
+	first, doneFirst := r.Register("a", 2)
+	second, doneSecond := r.Register("b", 1)
+	defer doneSecond()
+
+	msgs := make(chan amqp.Delivery, 4)
+	msgs <- amqp.Delivery{CorrelationId: "a", Body: []byte("1")}
+	msgs <- amqp.Delivery{CorrelationId: "b", Body: []byte("2")}
+	msgs <- amqp.Delivery{CorrelationId: "unknown", Body: []byte("3")}
+	msgs <- amqp.Delivery{CorrelationId: "a", Body: []byte("4")}
+	close(msgs)
+	r.dispatch(msgs)
+	doneFirst()
+
+	if got := len(first); got != 2 {
+		t.Fatalf("expected 2 replies for a, got %d", got)
+	}
+	if msg := <-first; string(msg.Body) != "1" {
+		t.Errorf("expected replies in order, got %q first", msg.Body)
+	}
+	if msg := <-second; string(msg.Body) != "2" {
+		t.Errorf("expected reply 2 for b, got %q", msg.Body)
+	}
+	if _, ok := r.waiters["a"]; ok {
+		t.Error("expected the done function to unregister the waiter")
+	}
+}
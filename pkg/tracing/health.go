@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"image-processing-system/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+var exportFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "tracing_export_failures_total",
+		Help: "Total number of span batches the OTLP exporter failed to send",
+	},
+	[]string{"service"},
+)
+
+func init() {
+	metrics.Add(exportFailures)
+}
+
+// exporterHealth tracks whether span exports are reaching the collector
+type exporterHealth struct {
+	mu          sync.Mutex
+	endpoint    string
+	failing     bool
+	lastError   string
+	lastFailure time.Time
+	failures    int64
+}
+
+// health is the state of the exporter Init installed
+var health = &exporterHealth{}
+
+// record notes the outcome of an export, logging when exports start failing
+// and when they recover rather than on every batch
+func (h *exporterHealth) record(service string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		if h.failing {
+			log.Printf("Tracing export to %s recovered", h.endpoint)
+		}
+		h.failing = false
+		return
+	}
+
+	exportFailures.WithLabelValues(service).Inc()
+	if !h.failing {
+		log.Printf("WARNING: tracing export to %s failing, spans are being dropped: %v", h.endpoint, err)
+	}
+	h.failing = true
+	h.lastError = err.Error()
+	h.lastFailure = time.Now().UTC()
+	h.failures++
+}
+
+// Status reports the exporter's connectivity for health checks: "ok" while
+// exports succeed, "degraded" while they fail, with the last error
+func Status() map[string]interface{} {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	status := map[string]interface{}{"status": "ok", "endpoint": health.endpoint, "failed_exports": health.failures}
+	if health.failing {
+		status["status"] = "degraded"
+		status["last_error"] = health.lastError
+		status["last_failure"] = health.lastFailure
+	}
+	return status
+}
+
+// monitoredExporter records every export's outcome in health
+type monitoredExporter struct {
+	trace.SpanExporter
+	service string
+	health  *exporterHealth
+}
+
+// ExportSpans exports spans and records whether that worked
+func (e *monitoredExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	e.health.record(e.service, err)
+	return err
+}
@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// flakyExporter fails exports while err is set
+type flakyExporter struct {
+	tracetest.InMemoryExporter
+	err error
+}
+
+func (e *flakyExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	return e.err
+}
+
+func TestMonitoredExporterReportsFailures(t *testing.T) {
+	saved := health
+	health = &exporterHealth{endpoint: "jaeger:4318"}
+	defer func() { health = saved }()
+
+	inner := &flakyExporter{err: errors.New("connection refused")}
+	exp := &monitoredExporter{SpanExporter: inner, service: "test-service", health: health}
+	failures := exportFailures.WithLabelValues("test-service")
+	before := testutil.ToFloat64(failures)
+
+	if got := Status()["status"]; got != "ok" {
+		t.Fatalf("initial status = %v, want ok", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := exp.ExportSpans(context.Background(), nil); err == nil {
+			t.Fatal("expected the export error to be returned")
+		}
+	}
+	status := Status()
+	if status["status"] != "degraded" || status["last_error"] != "connection refused" || status["failed_exports"] != int64(2) {
+		t.Errorf("status while failing = %v", status)
+	}
+	if got := testutil.ToFloat64(failures) - before; got != 2 {
+		t.Errorf("tracing_export_failures_total grew by %v, want 2", got)
+	}
+
+	inner.err = nil
+	if err := exp.ExportSpans(context.Background(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status = Status()
+	if status["status"] != "ok" || status["failed_exports"] != int64(2) {
+		t.Errorf("status after recovery = %v", status)
+	}
+	if _, ok := status["last_error"]; ok {
+		t.Error("last_error should be omitted once exports succeed")
+	}
+}
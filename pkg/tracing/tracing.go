@@ -3,6 +3,9 @@ package tracing
 import (
 	"context"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
@@ -11,14 +14,17 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 )
 
+// otlpEndpoint is the Jaeger collector spans are exported to
+const otlpEndpoint = "jaeger:4318"
+
 func Init(serviceName string) *trace.TracerProvider {
 	// Create OTLP exporter for Jaeger
 	exp, err := otlptracehttp.New(context.Background(),
-		otlptracehttp.WithEndpoint("jaeger:4318"),
+		otlptracehttp.WithEndpoint(otlpEndpoint),
 		otlptracehttp.WithInsecure(),
 	)
 	if err != nil {
-		log.Printf("Failed to create OTLP exporter: %v", err)
+		log.Printf("WARNING: failed to create OTLP exporter for %s, falling back to localhost: %v", otlpEndpoint, err)
 		// Fallback to stdout exporter for development
 		return initStdoutTracer(serviceName)
 	}
@@ -35,10 +41,12 @@ func Init(serviceName string) *trace.TracerProvider {
 		res = resource.Default()
 	}
 
-	// Create tracer provider
+	// Create tracer provider; export failures show up in Status
+	health.endpoint = otlpEndpoint
 	provider := trace.NewTracerProvider(
-		trace.WithBatcher(exp),
+		trace.WithBatcher(&monitoredExporter{SpanExporter: exp, service: serviceName, health: health}),
 		trace.WithResource(res),
+		trace.WithSampler(samplerFromEnv()),
 	)
 
 	// Set global tracer provider
@@ -62,9 +70,11 @@ func initStdoutTracer(serviceName string) *trace.TracerProvider {
 		),
 	)
 
+	health.endpoint = "localhost:4318"
 	provider := trace.NewTracerProvider(
-		trace.WithBatcher(exp),
+		trace.WithBatcher(&monitoredExporter{SpanExporter: exp, service: serviceName, health: health}),
 		trace.WithResource(res),
+		trace.WithSampler(samplerFromEnv()),
 	)
 
 	otel.SetTracerProvider(provider)
@@ -72,6 +82,42 @@ func initStdoutTracer(serviceName string) *trace.TracerProvider {
 	return provider
 }
 
+// defaultProductionSampleRatio is used in production when no ratio is configured
+const defaultProductionSampleRatio = 0.1
+
+// samplerFromEnv builds a sampler from OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG.
+// Supported samplers: always_on, always_off, traceidratio, parentbased_always_on,
+// parentbased_always_off and parentbased_traceidratio. When unset, production
+// (APP_ENV=production) samples a ratio of root traces and other environments sample everything.
+func samplerFromEnv() trace.Sampler {
+	ratio := 1.0
+	if strings.EqualFold(os.Getenv("APP_ENV"), "production") {
+		ratio = defaultProductionSampleRatio
+	}
+	if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+		if r, err := strconv.ParseFloat(arg, 64); err == nil && r >= 0 && r <= 1 {
+			ratio = r
+		} else {
+			log.Printf("Invalid OTEL_TRACES_SAMPLER_ARG %q, using ratio %v", arg, ratio)
+		}
+	}
+
+	switch strings.ToLower(os.Getenv("OTEL_TRACES_SAMPLER")) {
+	case "always_on":
+		return trace.AlwaysSample()
+	case "always_off":
+		return trace.NeverSample()
+	case "traceidratio":
+		return trace.TraceIDRatioBased(ratio)
+	case "parentbased_always_on":
+		return trace.ParentBased(trace.AlwaysSample())
+	case "parentbased_always_off":
+		return trace.ParentBased(trace.NeverSample())
+	default:
+		return trace.ParentBased(trace.TraceIDRatioBased(ratio))
+	}
+}
+
 func Shutdown(provider *trace.TracerProvider) {
 	if provider != nil {
 		if err := provider.Shutdown(context.Background()); err != nil {
@@ -0,0 +1,32 @@
+package tracing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSamplerFromEnv(t *testing.T) {
+	tests := []struct {
+		name, env, sampler, arg string
+		want                    string
+	}{
+		{"development default samples everything", "", "", "", "ParentBased{root:AlwaysOnSampler"},
+		{"production default", "production", "", "", "ParentBased{root:TraceIDRatioBased{0.1}"},
+		{"ratio", "", "traceidratio", "0.25", "TraceIDRatioBased{0.25}"},
+		{"out of range ratio keeps the default", "production", "traceidratio", "1.5", "TraceIDRatioBased{0.1}"},
+		{"unparsable ratio keeps the default", "", "traceidratio", "half", "AlwaysOnSampler"},
+		{"always on", "", "always_on", "", "AlwaysOnSampler"},
+		{"always off", "", "ALWAYS_OFF", "", "AlwaysOffSampler"},
+		{"parent based off", "", "parentbased_always_off", "", "ParentBased{root:AlwaysOffSampler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APP_ENV", tt.env)
+			t.Setenv("OTEL_TRACES_SAMPLER", tt.sampler)
+			t.Setenv("OTEL_TRACES_SAMPLER_ARG", tt.arg)
+			if got := samplerFromEnv().Description(); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("sampler = %s, want %s…", got, tt.want)
+			}
+		})
+	}
+}